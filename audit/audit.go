@@ -0,0 +1,101 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+// Entry records a single admin API request and how it was answered.
+type Entry struct {
+	Time   int64  `json:"time"`
+	Caller string `json:"caller"`
+	Role   string `json:"role,omitempty"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+type Log interface {
+	Record(entry Entry)
+}
+
+type noopLog struct{}
+
+func NewNoopLog() Log {
+	return noopLog{}
+}
+
+func (noopLog) Record(Entry) {}
+
+type fileLog struct {
+	logger lager.Logger
+	clock  clock.Clock
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileLog returns a Log appending entries to path, one JSON object per
+// line, stamped with the time they are recorded.
+func NewFileLog(logger lager.Logger, path string, clock clock.Clock) (Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileLog{
+		logger: logger.Session("audit-log"),
+		clock:  clock,
+		file:   file,
+	}, nil
+}
+
+func (l *fileLog) Record(entry Entry) {
+	entry.Time = l.clock.Now().UnixNano()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Error("failed-to-marshal-entry", err)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	_, err = l.file.Write(append(line, '\n'))
+	if err != nil {
+		l.logger.Error("failed-to-write-entry", err)
+	}
+}
+
+// Recent returns up to the last n entries of the audit log at path, oldest
+// first. Lines that aren't entries, e.g. one cut short by a crash, are
+// skipped.
+func Recent(path string, n int) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package audit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/audit"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit log", func() {
+	var (
+		dir       string
+		path      string
+		fakeClock *fakeclock.FakeClock
+		auditLog  audit.Log
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "audit.log")
+
+		fakeClock = fakeclock.NewFakeClock(time.Unix(0, 100))
+		auditLog, err = audit.NewFileLog(lagertest.NewTestLogger("test"), path, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("appends timestamped entries that Recent reads back", func() {
+		auditLog.Record(audit.Entry{Caller: "client-certificate:sre", Role: "operator", Method: "PUT", Path: "/v1/restage_campaigns/c", Status: 202})
+		fakeClock.Increment(time.Nanosecond)
+		auditLog.Record(audit.Entry{Caller: "anonymous", Method: "GET", Path: "/v1/lifecycle_changes", Status: 401})
+
+		entries, err := audit.Recent(path, 10)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]audit.Entry{
+			{Time: 100, Caller: "client-certificate:sre", Role: "operator", Method: "PUT", Path: "/v1/restage_campaigns/c", Status: 202},
+			{Time: 101, Caller: "anonymous", Method: "GET", Path: "/v1/lifecycle_changes", Status: 401},
+		}))
+	})
+
+	Describe("Recent", func() {
+		It("returns only the last n entries", func() {
+			for _, status := range []int{200, 201, 202} {
+				auditLog.Record(audit.Entry{Status: status})
+			}
+
+			entries, err := audit.Recent(path, 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Status).To(Equal(201))
+			Expect(entries[1].Status).To(Equal(202))
+		})
+
+		It("skips lines that aren't entries", func() {
+			auditLog.Record(audit.Entry{Status: 200})
+			Expect(ioutil.WriteFile(path, []byte("{\"status\":200}\n{\"stat"), 0600)).To(Succeed())
+
+			entries, err := audit.Recent(path, 10)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(Equal([]audit.Entry{{Status: 200}}))
+		})
+
+		It("fails when the log can't be read", func() {
+			_, err := audit.Recent(filepath.Join(dir, "missing.log"), 10)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,175 @@
+package authz
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stager/audit"
+	"github.com/pivotal-golang/lager"
+)
+
+type Role string
+
+const (
+	// RoleViewer may read admin state such as restage campaign progress.
+	RoleViewer Role = "viewer"
+	// RoleOperator may additionally perform operations that change what the
+	// stager is doing, such as submitting restage campaigns.
+	RoleOperator Role = "operator"
+
+	bearerPrefix = "Bearer "
+)
+
+var ErrInvalidRole = errors.New("role must be one of: viewer, operator")
+
+// Policy maps caller identities to roles. Callers are identified either by a
+// bearer token or, when the stager is served over TLS with a client CA, by
+// the common name of their verified client certificate.
+type Policy struct {
+	Tokens             map[string]Role `json:"tokens"`
+	ClientCertificates map[string]Role `json:"client_certificates"`
+}
+
+func LoadPolicy(path string) (Policy, error) {
+	var policy Policy
+
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	err = json.Unmarshal(payload, &policy)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	err = policy.Validate()
+	if err != nil {
+		return Policy{}, err
+	}
+
+	return policy, nil
+}
+
+func (p Policy) Validate() error {
+	for _, roles := range []map[string]Role{p.Tokens, p.ClientCertificates} {
+		for _, role := range roles {
+			if role != RoleViewer && role != RoleOperator {
+				return ErrInvalidRole
+			}
+		}
+	}
+	return nil
+}
+
+func (p Policy) empty() bool {
+	return len(p.Tokens) == 0 && len(p.ClientCertificates) == 0
+}
+
+func (r Role) allows(required Role) bool {
+	return r == RoleOperator || r == required
+}
+
+type Authorizer interface {
+	// Require wraps handler so that it is only served to callers holding the
+	// required role.
+	Require(role Role, handler http.Handler) http.Handler
+}
+
+type authorizer struct {
+	logger   lager.Logger
+	policy   Policy
+	auditLog audit.Log
+}
+
+// NewAuthorizer returns an Authorizer enforcing policy. An empty policy
+// grants no roles, so the admin API is closed until a policy is configured.
+// Every admin request, allowed or not, is recorded in auditLog.
+func NewAuthorizer(logger lager.Logger, policy Policy, auditLog audit.Log) Authorizer {
+	return &authorizer{
+		logger:   logger.Session("authz"),
+		policy:   policy,
+		auditLog: auditLog,
+	}
+}
+
+func (a *authorizer) Require(required Role, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		role, caller, identified := a.role(req)
+
+		recorder := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+		defer func() {
+			a.auditLog.Record(audit.Entry{
+				Caller: caller,
+				Role:   string(role),
+				Method: req.Method,
+				Path:   req.URL.Path,
+				Status: recorder.status,
+			})
+		}()
+
+		if a.policy.empty() {
+			a.logger.Info("no-admin-policy", lager.Data{"method": req.Method, "path": req.URL.Path})
+			recorder.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !identified {
+			recorder.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !role.allows(required) {
+			a.logger.Info("forbidden", lager.Data{"method": req.Method, "path": req.URL.Path, "role": role, "required": required})
+			recorder.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(recorder, req)
+	})
+}
+
+// role returns the caller's role and how they identified themselves. Tokens
+// are identified by a fingerprint so that the audit log doesn't hold them.
+func (a *authorizer) role(req *http.Request) (Role, string, bool) {
+	if req.TLS != nil {
+		for _, chain := range req.TLS.VerifiedChains {
+			if len(chain) == 0 {
+				continue
+			}
+			commonName := chain[0].Subject.CommonName
+			if role, ok := a.policy.ClientCertificates[commonName]; ok {
+				return role, "client-certificate:" + commonName, true
+			}
+		}
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, bearerPrefix) {
+		token := strings.TrimPrefix(authorization, bearerPrefix)
+		role, ok := a.policy.Tokens[token]
+		return role, "token:" + fingerprint(token), ok
+	}
+
+	return "", "anonymous", false
+}
+
+func fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,195 @@
+package authz_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/cloudfoundry-incubator/stager/audit"
+	"github.com/cloudfoundry-incubator/stager/authz"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authorizer", func() {
+	var (
+		policy   authz.Policy
+		required authz.Role
+		request  *http.Request
+		recorder *httptest.ResponseRecorder
+		served   bool
+		auditLog *recordingAuditLog
+	)
+
+	BeforeEach(func() {
+		policy = authz.Policy{
+			Tokens: map[string]authz.Role{
+				"view-token":    authz.RoleViewer,
+				"operate-token": authz.RoleOperator,
+			},
+			ClientCertificates: map[string]authz.Role{
+				"sre": authz.RoleOperator,
+			},
+		}
+		required = authz.RoleOperator
+
+		var err error
+		request, err = http.NewRequest("PUT", "/v1/restage_campaigns/c", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder = httptest.NewRecorder()
+		served = false
+		auditLog = &recordingAuditLog{}
+	})
+
+	JustBeforeEach(func() {
+		handler := http.HandlerFunc(func(resp http.ResponseWriter, _ *http.Request) {
+			served = true
+			resp.WriteHeader(http.StatusAccepted)
+		})
+		authorizer := authz.NewAuthorizer(lagertest.NewTestLogger("test"), policy, auditLog)
+		authorizer.Require(required, handler).ServeHTTP(recorder, request)
+	})
+
+	Context("when the policy is empty", func() {
+		BeforeEach(func() {
+			policy = authz.Policy{}
+		})
+
+		BeforeEach(func() {
+			request.Header.Set("Authorization", "Bearer operate-token")
+		})
+
+		It("forbids every request", func() {
+			Expect(served).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Context("when no credentials are presented", func() {
+		It("responds with 401", func() {
+			Expect(served).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("audits the request", func() {
+			Expect(auditLog.entries).To(Equal([]audit.Entry{
+				{Caller: "anonymous", Method: "PUT", Path: "/v1/restage_campaigns/c", Status: http.StatusUnauthorized},
+			}))
+		})
+	})
+
+	Context("when an unknown token is presented", func() {
+		BeforeEach(func() {
+			request.Header.Set("Authorization", "Bearer nope")
+		})
+
+		It("responds with 401", func() {
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("when a viewer token is presented", func() {
+		BeforeEach(func() {
+			request.Header.Set("Authorization", "Bearer view-token")
+		})
+
+		It("forbids operator routes", func() {
+			Expect(served).To(BeFalse())
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("audits the caller by a fingerprint of their token", func() {
+			Expect(auditLog.entries).To(HaveLen(1))
+			Expect(auditLog.entries[0].Caller).To(MatchRegexp(`^token:[0-9a-f]{8}$`))
+			Expect(auditLog.entries[0].Caller).NotTo(ContainSubstring("view-token"))
+			Expect(auditLog.entries[0].Role).To(Equal("viewer"))
+			Expect(auditLog.entries[0].Status).To(Equal(http.StatusForbidden))
+		})
+
+		Context("for a viewer route", func() {
+			BeforeEach(func() {
+				required = authz.RoleViewer
+			})
+
+			It("serves the request", func() {
+				Expect(served).To(BeTrue())
+			})
+		})
+	})
+
+	Context("when an operator token is presented", func() {
+		BeforeEach(func() {
+			request.Header.Set("Authorization", "Bearer operate-token")
+			required = authz.RoleViewer
+		})
+
+		It("serves viewer routes too", func() {
+			Expect(served).To(BeTrue())
+		})
+	})
+
+	Context("when a verified client certificate is presented", func() {
+		BeforeEach(func() {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "sre"}}
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		})
+
+		It("uses the role mapped to its common name", func() {
+			Expect(served).To(BeTrue())
+		})
+
+		It("audits the request with the handler's status", func() {
+			Expect(auditLog.entries).To(Equal([]audit.Entry{
+				{Caller: "client-certificate:sre", Role: "operator", Method: "PUT", Path: "/v1/restage_campaigns/c", Status: http.StatusAccepted},
+			}))
+		})
+	})
+})
+
+type recordingAuditLog struct {
+	entries []audit.Entry
+}
+
+func (l *recordingAuditLog) Record(entry audit.Entry) {
+	l.entries = append(l.entries, entry)
+}
+
+var _ = Describe("LoadPolicy", func() {
+	var policyFile string
+
+	writePolicy := func(contents string) {
+		file, err := ioutil.TempFile("", "policy")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteString(contents)
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+		policyFile = file.Name()
+	}
+
+	AfterEach(func() {
+		os.Remove(policyFile)
+	})
+
+	It("loads tokens and client certificates", func() {
+		writePolicy(`{"tokens": {"t": "viewer"}, "client_certificates": {"sre": "operator"}}`)
+
+		policy, err := authz.LoadPolicy(policyFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Tokens).To(Equal(map[string]authz.Role{"t": authz.RoleViewer}))
+		Expect(policy.ClientCertificates).To(Equal(map[string]authz.Role{"sre": authz.RoleOperator}))
+	})
+
+	It("rejects unknown roles", func() {
+		writePolicy(`{"tokens": {"t": "admin"}}`)
+
+		_, err := authz.LoadPolicy(policyFile)
+		Expect(err).To(Equal(authz.ErrInvalidRole))
+	})
+})
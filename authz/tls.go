@@ -0,0 +1,57 @@
+package authz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+var (
+	ErrIncompleteServerCertificate = errors.New("server certificate and key must be given together")
+	ErrClientCANeedsTLS            = errors.New("a client CA certificate needs a server certificate and key")
+	ErrNoClientCACertificates      = errors.New("client CA certificate file contains no PEM certificates")
+)
+
+// NewServerTLSConfig builds the TLS configuration the stager serves with, or
+// returns nil when certFile and keyFile are empty. When clientCACertFile is
+// given, client certificates signed by it are verified, so that admin API
+// callers can be identified by them. Client certificates stay optional, as
+// the BBS and the CC don't present one.
+func NewServerTLSConfig(certFile, keyFile, clientCACertFile string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, ErrIncompleteServerCertificate
+	}
+
+	if certFile == "" {
+		if clientCACertFile != "" {
+			return nil, ErrClientCANeedsTLS
+		}
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS10,
+	}
+
+	if clientCACertFile != "" {
+		caCerts, err := ioutil.ReadFile(clientCACertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caCerts) {
+			return nil, ErrNoClientCACertificates
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
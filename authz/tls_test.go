@@ -0,0 +1,104 @@
+package authz_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/authz"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewServerTLSConfig", func() {
+	var (
+		tmpDir            string
+		certFile, keyFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "authz-tls")
+		Expect(err).NotTo(HaveOccurred())
+
+		certFile, keyFile = writeServerCertificate(tmpDir)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("returns nil when no certificate is given", func() {
+		config, err := authz.NewServerTLSConfig("", "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(BeNil())
+	})
+
+	It("serves the certificate without asking for client certificates", func() {
+		config, err := authz.NewServerTLSConfig(certFile, keyFile, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.Certificates).To(HaveLen(1))
+		Expect(config.ClientAuth).To(Equal(tls.NoClientCert))
+	})
+
+	It("verifies client certificates signed by the client CA", func() {
+		config, err := authz.NewServerTLSConfig(certFile, keyFile, certFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.ClientAuth).To(Equal(tls.VerifyClientCertIfGiven))
+		Expect(config.ClientCAs.Subjects()).To(HaveLen(1))
+	})
+
+	It("fails when only one of the certificate and key is given", func() {
+		_, err := authz.NewServerTLSConfig(certFile, "", "")
+		Expect(err).To(Equal(authz.ErrIncompleteServerCertificate))
+	})
+
+	It("fails when a client CA is given without a certificate", func() {
+		_, err := authz.NewServerTLSConfig("", "", certFile)
+		Expect(err).To(Equal(authz.ErrClientCANeedsTLS))
+	})
+
+	It("fails when the client CA file holds no certificates", func() {
+		_, err := authz.NewServerTLSConfig(certFile, keyFile, keyFile)
+		Expect(err).To(Equal(authz.ErrNoClientCACertificates))
+	})
+})
+
+func writeServerCertificate(dir string) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stager"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	Expect(ioutil.WriteFile(certFile, certPEM, 0644)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+
+	return certFile, keyFile
+}
@@ -1,8 +1,10 @@
 package backend
 
 import (
-	"errors"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -12,6 +14,9 @@ import (
 	"github.com/cloudfoundry-incubator/buildpack_app_lifecycle"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 	"github.com/cloudfoundry-incubator/runtime-schema/diego_errors"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
 )
 
 const (
@@ -27,10 +32,10 @@ type Backend interface {
 	BuildStagingResponse(*models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error)
 }
 
-var ErrNoCompilerDefined = errors.New(diego_errors.NO_COMPILER_DEFINED_MESSAGE)
-var ErrMissingAppId = errors.New(diego_errors.MISSING_APP_ID_MESSAGE)
-var ErrMissingAppBitsDownloadUri = errors.New(diego_errors.MISSING_APP_BITS_DOWNLOAD_URI_MESSAGE)
-var ErrMissingLifecycleData = errors.New(diego_errors.MISSING_LIFECYCLE_DATA_MESSAGE)
+var ErrNoCompilerDefined = NewConfigurationError(NoCompilerDefinedErrorId, diego_errors.NO_COMPILER_DEFINED_MESSAGE)
+var ErrMissingAppId = NewValidationError(MissingAppIdErrorId, diego_errors.MISSING_APP_ID_MESSAGE)
+var ErrMissingAppBitsDownloadUri = NewValidationError(MissingAppBitsDownloadUriErrorId, diego_errors.MISSING_APP_BITS_DOWNLOAD_URI_MESSAGE)
+var ErrMissingLifecycleData = NewValidationError(MissingLifecycleDataErrorId, diego_errors.MISSING_LIFECYCLE_DATA_MESSAGE)
 
 type Config struct {
 	TaskDomain             string
@@ -41,13 +46,372 @@ type Config struct {
 	DockerRegistryAddress  string
 	InsecureDockerRegistry bool
 	ConsulCluster          string
+	ConsulProxy            func(*http.Request) (*url.URL, error)
 	SkipCertVerify         bool
 	Sanitizer              FailureReasonSanitizer
 	DockerStagingStack     string
+	NetworkProperties      map[string]string
+
+	// ConsulTLSConfig and ConsulACLToken let docker registry discovery reach
+	// consul agents that require HTTPS or ACLs.
+	ConsulTLSConfig *tls.Config
+	ConsulACLToken  string
+
+	// ConsulTimeout bounds each docker registry discovery request to consul,
+	// DefaultConsulTimeout when unset. Failed requests are retried up to
+	// ConsulRetryAttempts times.
+	ConsulTimeout       time.Duration
+	ConsulRetryAttempts int
+
+	// DockerRegistryDiscovery is how docker staging finds registry instances,
+	// DockerRegistryDiscoveryConsul (the default) or DockerRegistryDiscoveryDNS.
+	// HostResolver defaults to the system resolver.
+	DockerRegistryDiscovery string
+	HostResolver            HostResolver
+
+	// DockerRegistryCacheTTL is how long discovered registry instances are
+	// reused before looking them up again. When a lookup fails, instances up
+	// to DockerRegistryCacheMaxStale past their TTL are used instead. Zero
+	// disables the cache.
+	DockerRegistryCacheTTL      time.Duration
+	DockerRegistryCacheMaxStale time.Duration
+
+	// DockerCredentialHelpers, ECRAuthenticator and GCRServiceAccounts
+	// authenticate docker staging to registries.
+	DockerCredentialHelpers DockerCredentialHelpers
+	ECRAuthenticator        *ECRAuthenticator
+	GCRServiceAccounts      GCRServiceAccounts
+
+	// AllowInlineDockerCredentials has docker staging accept docker
+	// passwords given inline in a staging request. The builder's arguments
+	// and environment are part of the task definition, so inline passwords
+	// are stored in the BBS; CredHub references keep them out of it.
+	AllowInlineDockerCredentials bool
+
+	// DockerRegistryCAs are the PEM CA certificates of registries signed by
+	// private CAs, by registry host.
+	DockerRegistryCAs map[string]string
+
+	// Docker staging rejects images from DeniedDockerRegistries and, when
+	// AllowedDockerRegistries is set, from any registry not in it.
+	AllowedDockerRegistries []string
+	DeniedDockerRegistries  []string
+
+	// DockerRegistryMirrors are pull-through mirrors of Docker Hub that docker
+	// staging pulls through.
+	DockerRegistryMirrors []string
+
+	// DockerImageCaching has docker staging cache images in the docker
+	// registry, unless the staging request's org or space overrides it.
+	DockerImageCaching bool
+
+	// DockerMetadataOnly has docker staging tasks that don't cache the image
+	// fetch only its manifest and config, and run unprivileged.
+	DockerMetadataOnly bool
+
+	// DockerStagingPlatform, e.g. linux/amd64, is the platform of the
+	// DockerStagingStack's cells, whose variant of manifest list images is
+	// staged.
+	DockerStagingPlatform string
+
+	// WindowsDockerStagingStack is the Windows stack that docker staging
+	// requests naming it as their stack run on, with the docker/<stack>
+	// lifecycle bundle.
+	WindowsDockerStagingStack string
+
+	// MaxDockerImageSizeMB fails docker staging of images whose compressed
+	// layers are larger. Zero means no limit.
+	MaxDockerImageSizeMB int
+
+	// DockerBuilderLayouts are the builder paths of docker lifecycle bundles
+	// that differ from the docker app lifecycle's.
+	DockerBuilderLayouts DockerBuilderLayouts
+
+	// LifecycleTaskDomains puts lifecycles' staging tasks in their own task
+	// domains instead of TaskDomain.
+	LifecycleTaskDomains LifecycleTaskDomains
+
+	// Staging tasks get at least these resources, or those configured for
+	// their stack in StackResourceMinimums.
+	MinMemoryMB           int
+	MinDiskMB             int
+	MinFileDescriptors    int
+	StackResourceMinimums StackResourceMinimums
+
+	// PackageDiskMultiplier sizes a staging task's disk as this many times
+	// its app package, when the request gives the package's size. Zero keeps
+	// the requested disk.
+	PackageDiskMultiplier float64
+
+	// MaxStagingPids limits the processes in a staging container, so that a
+	// runaway buildpack can't fork-bomb a cell. Zero means no limit.
+	MaxStagingPids int
+
+	// StackRootFSes overrides the preloaded rootfs of stacks.
+	StackRootFSes StackRootFSes
+
+	// DeprecatedStacks warn developers whose apps stage on them.
+	DeprecatedStacks DeprecatedStacks
+
+	// MaxStagingTimeout caps the timeout that staging requests ask for, so
+	// that one app can't hold a cell's resources for hours. Zero means no cap.
+	MaxStagingTimeout time.Duration
+
+	// StagingEnvironment is added to every staging container's environment,
+	// under any variables the staging request sets.
+	StagingEnvironment StagingEnvironment
+
+	// LifecycleChecksums are the expected checksums of lifecycle bundles,
+	// keyed like Lifecycles.
+	LifecycleChecksums LifecycleChecksums
+
+	// BuildpackDownloadBatchSize limits how many buildpacks a staging task
+	// downloads at once, so that foundations with dozens of buildpacks don't
+	// saturate the file server and blobstore. Zero means no limit.
+	BuildpackDownloadBatchSize int
+
+	// URLSigningKeys sign buildpack download URLs for artifact stores that
+	// require it. Clock, the system clock when unset, is used for the
+	// signatures' expiry and to wait between consul retries.
+	URLSigningKeys URLSigningKeys
+	Clock          clock.Clock
+
+	// SkipBuildArtifactsCacheUpload stops buildpack staging tasks from
+	// uploading their build artifacts cache.
+	SkipBuildArtifactsCacheUpload bool
+
+	// LogRateLimitBytesPerSecond limits how fast staging tasks can log, so
+	// that chatty buildpacks can't flood Loggregator. Zero means no limit.
+	LogRateLimitBytesPerSecond int64
+
+	// DefaultEgressRules are added to every staging task's egress rules.
+	DefaultEgressRules []*models.SecurityGroupRule
+
+	// UnprivilegedStaging runs staging tasks unprivileged, for operators
+	// whose apps already run unprivileged. LifecyclePrivileges overrides it
+	// per lifecycle.
+	UnprivilegedStaging bool
+	LifecyclePrivileges LifecyclePrivileges
+
+	// TrustedCertsBundle is a .tgz of CA certificates, given like a lifecycle
+	// bundle, that staging containers trust. See TrustedCertsDir.
+	TrustedCertsBundle string
+
+	// PlacementTags are given to staging tasks whose request doesn't name an
+	// isolation segment.
+	PlacementTags []string
+
+	// CompletionAPI is the CC API that staging completion is reported to
+	// unless a staging request asks otherwise. Empty means v2.
+	CompletionAPI string
+
+	// DisabledLifecycles, e.g. docker, are turned off on this deployment. See
+	// NewDisabledBackend.
+	DisabledLifecycles []string
 }
 
+// Callback URL query parameters that record where a task's completion is
+// reported: the CC API, and the CC target when the stager serves several.
+const (
+	CompletionAPIParam = "completion_api"
+	CCTargetParam      = "cc_target"
+)
+
 func (c Config) CallbackURL(stagingGuid string) string {
-	return fmt.Sprintf("%s/v1/staging/%s/completed", c.StagerURL, stagingGuid)
+	callbackURL := fmt.Sprintf("%s/v1/staging/%s/completed", c.StagerURL, stagingGuid)
+	if c.CompletionAPI == "" || c.CompletionAPI == cc_client.APIVersionV2 {
+		return callbackURL
+	}
+	return WithCompletionAPI(callbackURL, c.CompletionAPI)
+}
+
+// WithCompletionAPI returns callbackURL with the CC API that the task's
+// completion should be reported to.
+func WithCompletionAPI(callbackURL, api string) string {
+	return withQueryParam(callbackURL, CompletionAPIParam, api)
+}
+
+// CompletionAPIFor returns the CC API recorded in a task's callback URL,
+// defaulting to v2 for tasks desired before it was recorded.
+func CompletionAPIFor(callbackURL string) string {
+	if api := queryParam(callbackURL, CompletionAPIParam); api != "" {
+		return api
+	}
+	return cc_client.APIVersionV2
+}
+
+// WithCCTarget returns callbackURL with the CC target that the task's
+// completion should be reported to.
+func WithCCTarget(callbackURL, target string) string {
+	return withQueryParam(callbackURL, CCTargetParam, target)
+}
+
+// CCTargetFor returns the CC target recorded in a task's callback URL, or
+// "" for the default CC.
+func CCTargetFor(callbackURL string) string {
+	return queryParam(callbackURL, CCTargetParam)
+}
+
+func withQueryParam(rawURL, key, value string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := parsed.Query()
+	query.Set(key, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func queryParam(rawURL, key string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Query().Get(key)
+}
+
+// taskScheduling is where a staging task runs and what it may do there.
+type taskScheduling struct {
+	Network       *models.Network
+	PlacementTags []string
+	LogRateLimit  *models.LogRateLimit
+}
+
+func (c Config) schedulingFor(request cc_messages.StagingRequestFromCC) (taskScheduling, error) {
+	network, err := c.Network(request)
+	if err != nil {
+		return taskScheduling{}, err
+	}
+
+	placementTags, err := c.PlacementTagsFor(request)
+	if err != nil {
+		return taskScheduling{}, err
+	}
+
+	logRateLimit, err := c.LogRateLimitFor(request)
+	if err != nil {
+		return taskScheduling{}, err
+	}
+
+	return taskScheduling{Network: network, PlacementTags: placementTags, LogRateLimit: logRateLimit}, nil
+}
+
+// Network returns the container networking properties for a staging task so
+// that network policies applied to the app also apply while it stages. The
+// task joins the policy group named by policy_group_id in the lifecycle
+// data, if any.
+func (c Config) Network(request cc_messages.StagingRequestFromCC) (*models.Network, error) {
+	var options struct {
+		PolicyGroupId string `json:"policy_group_id"`
+	}
+	err := unmarshalLifecycleData(request, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	properties := make(map[string]string, len(c.NetworkProperties)+2)
+	for key, value := range c.NetworkProperties {
+		properties[key] = value
+	}
+	properties["app_id"] = request.AppId
+	if options.PolicyGroupId != "" {
+		properties["policy_group_id"] = options.PolicyGroupId
+	}
+
+	return &models.Network{Properties: properties}, nil
+}
+
+// PlacementTagsFor returns the placement tags of a staging task. The task
+// runs on the cells of the isolation segment that the lifecycle data names,
+// which are the cells the app will run on. Other tasks get PlacementTags.
+func (c Config) PlacementTagsFor(request cc_messages.StagingRequestFromCC) ([]string, error) {
+	var segment struct {
+		IsolationSegment string `json:"isolation_segment"`
+	}
+	err := unmarshalLifecycleData(request, &segment)
+	if err != nil {
+		return nil, err
+	}
+
+	if segment.IsolationSegment != "" {
+		return []string{segment.IsolationSegment}, nil
+	}
+	return c.PlacementTags, nil
+}
+
+// LogRateLimitFor returns the log rate limit of a staging task. Requests can
+// set a lower limit than LogRateLimitBytesPerSecond with
+// log_rate_limit_bytes_per_second in their lifecycle data. It returns nil when
+// neither sets a limit.
+func (c Config) LogRateLimitFor(request cc_messages.StagingRequestFromCC) (*models.LogRateLimit, error) {
+	var options struct {
+		LogRateLimitBytesPerSecond int64 `json:"log_rate_limit_bytes_per_second"`
+	}
+	err := unmarshalLifecycleData(request, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := c.LogRateLimitBytesPerSecond
+	requested := options.LogRateLimitBytesPerSecond
+	if requested > 0 && (limit <= 0 || requested < limit) {
+		limit = requested
+	}
+
+	if limit <= 0 {
+		return nil, nil
+	}
+	return &models.LogRateLimit{BytesPerSecond: limit}, nil
+}
+
+// unmarshalLifecycleData decodes the request's lifecycle data, if any, into
+// v.
+func unmarshalLifecycleData(request cc_messages.StagingRequestFromCC, v interface{}) error {
+	if request.LifecycleData == nil {
+		return nil
+	}
+
+	err := json.Unmarshal(*request.LifecycleData, v)
+	if err != nil {
+		return NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+	return nil
+}
+
+// batchedDownloads returns downloads to run in parallel with the staging
+// task's other downloads. When there are more than BuildpackDownloadBatchSize,
+// they are split into batches that run one after another.
+func (c Config) batchedDownloads(downloads []models.ActionInterface) []models.ActionInterface {
+	batchSize := c.BuildpackDownloadBatchSize
+	if batchSize <= 0 || len(downloads) <= batchSize {
+		return downloads
+	}
+
+	batches := []models.ActionInterface{}
+	for start := 0; start < len(downloads); start += batchSize {
+		end := start + batchSize
+		if end > len(downloads) {
+			end = len(downloads)
+		}
+		batches = append(batches, models.Parallel(downloads[start:end]...))
+	}
+	return []models.ActionInterface{models.Serial(batches...)}
+}
+
+// cappedTimeout limits a staging task's timeout to MaxStagingTimeout.
+func (c Config) cappedTimeout(timeout time.Duration, request cc_messages.StagingRequestFromCC, logger lager.Logger) time.Duration {
+	if c.MaxStagingTimeout <= 0 || timeout <= c.MaxStagingTimeout {
+		return timeout
+	}
+
+	logger.Info("capping-requested-timeout", lager.Data{
+		"requested-timeout": timeout.String(),
+		"max-timeout":       c.MaxStagingTimeout.String(),
+		"app-id":            request.AppId,
+	})
+	return c.MaxStagingTimeout
 }
 
 func max(x, y uint64) uint64 {
@@ -65,6 +429,10 @@ func addTimeoutParamToURL(u url.URL, timeout time.Duration) *url.URL {
 	return &u
 }
 
+// checksumFailedPrefix starts the failure reason the executor gives a task
+// whose download didn't match the checksum it was desired with.
+const checksumFailedPrefix = "Checksum failed"
+
 func SanitizeErrorMessage(message string) *cc_messages.StagingError {
 	const staging_failed = "staging failed"
 	id := cc_messages.STAGING_ERROR
@@ -78,14 +446,30 @@ func SanitizeErrorMessage(message string) *cc_messages.StagingError {
 	case strings.HasSuffix(message, strconv.Itoa(buildpack_app_lifecycle.RELEASE_FAIL_CODE)):
 		id = cc_messages.BUILDPACK_RELEASE_FAILED
 		message = staging_failed
+	case strings.HasSuffix(message, strconv.Itoa(DockerImageTooLargeExitCode)):
+		id = DockerImageTooLargeErrorId
+		message = "the docker image is larger than allowed"
+	case strings.HasSuffix(message, strconv.Itoa(DockerPlatformMismatchExitCode)):
+		id = DockerPlatformMismatchErrorId
+		message = "the docker image has no variant for the staging platform"
 	case message == diego_errors.INSUFFICIENT_RESOURCES_MESSAGE:
 		id = cc_messages.INSUFFICIENT_RESOURCES
 	case message == diego_errors.CELL_MISMATCH_MESSAGE:
 		id = cc_messages.NO_COMPATIBLE_CELL
 	case message == diego_errors.MISSING_DOCKER_IMAGE_URL:
+		id = MissingDockerImageUrlErrorId
 	case message == diego_errors.MISSING_DOCKER_REGISTRY:
+		id = MissingDockerRegistryErrorId
 	case message == diego_errors.MISSING_DOCKER_CREDENTIALS:
+		id = MissingDockerCredentialsErrorId
 	case message == diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS:
+		id = InvalidDockerRegistryAddressErrorId
+	case strings.HasPrefix(message, checksumFailedPrefix):
+		id = ChecksumMismatchErrorId
+		message = "a downloaded artifact did not match its checksum"
+	case strings.HasPrefix(message, "exceeded ") && strings.HasSuffix(message, " timeout"):
+		id = StagingTimedOutErrorId
+		message = "staging timed out"
 	default:
 		message = "staging failed"
 	}
@@ -95,3 +479,10 @@ func SanitizeErrorMessage(message string) *cc_messages.StagingError {
 		Message: message,
 	}
 }
+
+func (c Config) clock() clock.Clock {
+	if c.Clock == nil {
+		return clock.NewClock()
+	}
+	return c.Clock
+}
@@ -0,0 +1,208 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Config", func() {
+	Describe("CallbackURL", func() {
+		It("reports to the v2 API by default", func() {
+			config := backend.Config{StagerURL: "http://stager.example.com"}
+			callbackURL := config.CallbackURL("the-guid")
+
+			Expect(callbackURL).To(Equal("http://stager.example.com/v1/staging/the-guid/completed"))
+			Expect(backend.CompletionAPIFor(callbackURL)).To(Equal(cc_client.APIVersionV2))
+		})
+
+		It("records a v3 completion API", func() {
+			config := backend.Config{StagerURL: "http://stager.example.com", CompletionAPI: cc_client.APIVersionV3}
+			callbackURL := config.CallbackURL("the-guid")
+
+			Expect(callbackURL).To(Equal("http://stager.example.com/v1/staging/the-guid/completed?completion_api=v3"))
+			Expect(backend.CompletionAPIFor(callbackURL)).To(Equal(cc_client.APIVersionV3))
+		})
+	})
+
+	Describe("WithCompletionAPI", func() {
+		It("overrides the recorded completion API", func() {
+			callbackURL := backend.WithCompletionAPI("http://stager.example.com/v1/staging/the-guid/completed?completion_api=v3", cc_client.APIVersionV2)
+			Expect(backend.CompletionAPIFor(callbackURL)).To(Equal(cc_client.APIVersionV2))
+		})
+	})
+
+	Describe("WithCCTarget", func() {
+		It("records the CC target alongside the completion API", func() {
+			callbackURL := backend.WithCCTarget("http://stager.example.com/v1/staging/the-guid/completed?completion_api=v3", "other-cc")
+
+			Expect(backend.CCTargetFor(callbackURL)).To(Equal("other-cc"))
+			Expect(backend.CompletionAPIFor(callbackURL)).To(Equal(cc_client.APIVersionV3))
+		})
+
+		It("defaults to the default CC", func() {
+			Expect(backend.CCTargetFor("http://stager.example.com/v1/staging/the-guid/completed")).To(BeEmpty())
+		})
+	})
+
+	Describe("BuildpackDownloadBatchSize", func() {
+		var config backend.Config
+
+		BeforeEach(func() {
+			config = backend.Config{
+				FileServerURL: "http://file-server.com",
+				CCUploaderURL: "http://cc-uploader.com",
+				Lifecycles: map[string]string{
+					"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+				},
+			}
+		})
+
+		buildpackDownloads := func() []*models.Action {
+			buildpacks := []cc_messages.Buildpack{}
+			for i := 0; i < 5; i++ {
+				buildpacks = append(buildpacks, cc_messages.Buildpack{
+					Name: fmt.Sprintf("buildpack-%d", i),
+					Key:  fmt.Sprintf("buildpack-%d-key", i),
+					Url:  fmt.Sprintf("http://example.com/buildpack-%d", i),
+				})
+			}
+
+			lifecycleDataJSON, err := json.Marshal(cc_messages.BuildpackStagingData{
+				AppBitsDownloadUri: "http://example-uri.com/bunny",
+				Buildpacks:         buildpacks,
+				Stack:              "cflinuxfs2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			lifecycleData := json.RawMessage(lifecycleDataJSON)
+
+			taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+				AppId:         "bunny",
+				Lifecycle:     "buildpack",
+				LifecycleData: &lifecycleData,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			downloads := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetParallelAction()
+			Expect(downloads).NotTo(BeNil())
+			return downloads.Actions
+		}
+
+		It("downloads every buildpack at once by default", func() {
+			Expect(buildpackDownloads()).To(HaveLen(6))
+		})
+
+		It("downloads buildpacks in batches", func() {
+			config.BuildpackDownloadBatchSize = 2
+
+			downloads := buildpackDownloads()
+			Expect(downloads).To(HaveLen(2))
+
+			batches := downloads[1].GetSerialAction()
+			Expect(batches).NotTo(BeNil())
+			Expect(batches.Actions).To(HaveLen(3))
+			Expect(batches.Actions[0].GetParallelAction().Actions).To(HaveLen(2))
+			Expect(batches.Actions[1].GetParallelAction().Actions).To(HaveLen(2))
+			Expect(batches.Actions[2].GetParallelAction().Actions).To(HaveLen(1))
+			Expect(batches.Actions[2].GetParallelAction().Actions[0].GetDownloadAction().Artifact).To(Equal("buildpack-4"))
+		})
+	})
+
+	Describe("Network", func() {
+		var config backend.Config
+
+		BeforeEach(func() {
+			config = backend.Config{NetworkProperties: map[string]string{"space_id": "the-space"}}
+		})
+
+		It("joins the policy group named in the lifecycle data", func() {
+			lifecycleData := json.RawMessage(`{"policy_group_id": "the-policy-group"}`)
+			network, err := config.Network(cc_messages.StagingRequestFromCC{AppId: "the-app", LifecycleData: &lifecycleData})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(network.Properties).To(Equal(map[string]string{
+				"space_id":        "the-space",
+				"app_id":          "the-app",
+				"policy_group_id": "the-policy-group",
+			}))
+		})
+
+		It("leaves out the policy group when the lifecycle data doesn't name one", func() {
+			network, err := config.Network(cc_messages.StagingRequestFromCC{AppId: "the-app"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(network.Properties).To(Equal(map[string]string{
+				"space_id": "the-space",
+				"app_id":   "the-app",
+			}))
+		})
+
+		It("fails when the lifecycle data is invalid", func() {
+			lifecycleData := json.RawMessage(`{"policy_group_id": 42}`)
+			_, err := config.Network(cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData})
+			Expect(err).To(BeAssignableToTypeOf(&backend.ValidationError{}))
+		})
+	})
+
+	Describe("PlacementTagsFor", func() {
+		var config backend.Config
+
+		BeforeEach(func() {
+			config = backend.Config{PlacementTags: []string{"staging-segment"}}
+		})
+
+		It("places tasks on the isolation segment named in the lifecycle data", func() {
+			lifecycleData := json.RawMessage(`{"isolation_segment": "secure-segment"}`)
+			Expect(config.PlacementTagsFor(cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData})).To(Equal([]string{"secure-segment"}))
+		})
+
+		It("falls back to the configured placement tags", func() {
+			lifecycleData := json.RawMessage(`{"stack": "cflinuxfs2"}`)
+			Expect(config.PlacementTagsFor(cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData})).To(Equal([]string{"staging-segment"}))
+			Expect(config.PlacementTagsFor(cc_messages.StagingRequestFromCC{})).To(Equal([]string{"staging-segment"}))
+		})
+
+		It("fails when the lifecycle data is invalid", func() {
+			lifecycleData := json.RawMessage(`{"isolation_segment": ["secure-segment"]}`)
+			_, err := config.PlacementTagsFor(cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData})
+			Expect(err).To(BeAssignableToTypeOf(&backend.ValidationError{}))
+		})
+	})
+
+	Describe("LogRateLimitFor", func() {
+		requestWithLimit := func(bytesPerSecond int) cc_messages.StagingRequestFromCC {
+			lifecycleData := json.RawMessage(fmt.Sprintf(`{"log_rate_limit_bytes_per_second": %d}`, bytesPerSecond))
+			return cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData}
+		}
+
+		It("doesn't limit logs by default", func() {
+			Expect(backend.Config{}.LogRateLimitFor(cc_messages.StagingRequestFromCC{})).To(BeNil())
+		})
+
+		It("uses the configured limit", func() {
+			config := backend.Config{LogRateLimitBytesPerSecond: 16384}
+			Expect(config.LogRateLimitFor(cc_messages.StagingRequestFromCC{})).To(Equal(&models.LogRateLimit{BytesPerSecond: 16384}))
+		})
+
+		It("uses a requested limit below the configured limit", func() {
+			config := backend.Config{LogRateLimitBytesPerSecond: 16384}
+			Expect(config.LogRateLimitFor(requestWithLimit(1024))).To(Equal(&models.LogRateLimit{BytesPerSecond: 1024}))
+			Expect(config.LogRateLimitFor(requestWithLimit(65536))).To(Equal(&models.LogRateLimit{BytesPerSecond: 16384}))
+		})
+
+		It("uses a requested limit when none is configured", func() {
+			Expect(backend.Config{}.LogRateLimitFor(requestWithLimit(1024))).To(Equal(&models.LogRateLimit{BytesPerSecond: 1024}))
+		})
+
+		It("fails when the lifecycle data is invalid", func() {
+			lifecycleData := json.RawMessage(`{"log_rate_limit_bytes_per_second": "fast"}`)
+			_, err := backend.Config{}.LogRateLimitFor(cc_messages.StagingRequestFromCC{LifecycleData: &lifecycleData})
+			Expect(err).To(BeAssignableToTypeOf(&backend.ValidationError{}))
+		})
+	})
+})
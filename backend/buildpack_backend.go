@@ -2,7 +2,6 @@ package backend
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/url"
 	"path"
@@ -49,7 +48,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	var lifecycleData cc_messages.BuildpackStagingData
 	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
 	if err != nil {
-		return &models.TaskDefinition{}, "", "", err
+		return &models.TaskDefinition{}, "", "", NewValidationError(InvalidLifecycleDataErrorId, err.Error())
 	}
 
 	err = backend.validateRequest(request, lifecycleData)
@@ -57,31 +56,52 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		return &models.TaskDefinition{}, "", "", err
 	}
 
+	request = backend.config.withResourceMinimums(lifecycleData.Stack, request)
+
 	compilerURL, err := backend.compilerDownloadURL(request, lifecycleData)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
 
+	appBitsChecksum, buildpackChecksums, err := parseRequestChecksums(*request.LifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	buildpackCredhubRefsEnv, err := buildpackCredhubRefs(*request.LifecycleData, lifecycleData.Buildpacks)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	trustedCertsDownload, trustedCertsEnv, err := backend.config.trustedCerts()
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
 	buildpacksOrder := []string{}
 	for _, buildpack := range lifecycleData.Buildpacks {
 		buildpacksOrder = append(buildpacksOrder, buildpack.Key)
 	}
 
-	skipDetect := len(lifecycleData.Buildpacks) == 1 && lifecycleData.Buildpacks[0].SkipDetect
+	skipDetect := skipsDetect(lifecycleData.Buildpacks)
+	buildpackPhasesEnv, err := buildpackPhasesEnv(lifecycleData.Buildpacks)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
 
 	builderConfig := buildpack_app_lifecycle.NewLifecycleBuilderConfig(buildpacksOrder, skipDetect, backend.config.SkipCertVerify)
 
-	timeout := traditionalTimeout(request, backend.logger)
+	timeout := backend.config.cappedTimeout(traditionalTimeout(request, backend.logger), request, backend.logger)
 
 	actions := []models.ActionInterface{}
 
 	//Download app package
-	appDownloadAction := &models.DownloadAction{
+	appDownloadAction := withChecksum(&models.DownloadAction{
 		Artifact: "app package",
 		From:     lifecycleData.AppBitsDownloadUri,
 		To:       builderConfig.BuildDir(),
 		User:     "vcap",
-	}
+	}, appBitsChecksum)
 
 	actions = append(actions, appDownloadAction)
 
@@ -92,12 +112,12 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	downloadActions = append(
 		downloadActions,
 		models.EmitProgressFor(
-			&models.DownloadAction{
+			withChecksum(&models.DownloadAction{
 				From:     compilerURL.String(),
 				To:       path.Dir(builderConfig.ExecutablePath),
 				CacheKey: fmt.Sprintf("buildpack-%s-lifecycle", lifecycleData.Stack),
 				User:     "vcap",
-			},
+			}, backend.config.lifecycleChecksum(request.Lifecycle+"/"+lifecycleData.Stack)),
 			"",
 			"",
 			"Failed to set up staging environment",
@@ -106,6 +126,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 
 	//Download buildpacks
 	buildpackNames := []string{}
+	buildpackDownloads := []models.ActionInterface{}
 	downloadMsgPrefix := ""
 	if !skipDetect {
 		downloadMsgPrefix = "No buildpack specified; fetching standard buildpacks to detect and build your application.\n"
@@ -114,20 +135,29 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		if buildpack.Name == cc_messages.CUSTOM_BUILDPACK {
 			buildpackNames = append(buildpackNames, buildpack.Url)
 		} else {
+			buildpackURL, err := backend.config.signedDownloadURL(buildpack.Url, timeout)
+			if err != nil {
+				return &models.TaskDefinition{}, "", "", err
+			}
+
 			buildpackNames = append(buildpackNames, buildpack.Name)
-			downloadActions = append(
-				downloadActions,
-				&models.DownloadAction{
+			buildpackDownloads = append(
+				buildpackDownloads,
+				withChecksum(&models.DownloadAction{
 					Artifact: buildpack.Name,
-					From:     buildpack.Url,
+					From:     buildpackURL,
 					To:       builderConfig.BuildpackPath(buildpack.Key),
 					CacheKey: buildpack.Key,
 					User:     "vcap",
-				},
+				}, buildpackChecksums[buildpack.Key]),
 			)
 		}
 	}
 
+	downloadActions = append(downloadActions, backend.config.batchedDownloads(buildpackDownloads)...)
+	if trustedCertsDownload != nil {
+		downloadActions = append(downloadActions, trustedCertsDownload)
+	}
 	downloadNames = append(downloadNames, fmt.Sprintf("buildpacks (%s)", strings.Join(buildpackNames, ", ")))
 
 	//Download buildpack artifacts cache
@@ -151,11 +181,17 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		downloadNames = append(downloadNames, "build artifacts cache")
 	}
 
-	downloadMsg := downloadMsgPrefix + fmt.Sprintf("Downloading %s...", strings.Join(downloadNames, ", "))
+	stackWarning := backend.config.StackWarningFor(lifecycleData.Stack)
+	downloadMsg := withStackWarning(downloadMsgPrefix+fmt.Sprintf("Downloading %s...", strings.Join(downloadNames, ", ")), stackWarning)
 	actions = append(actions, models.EmitProgressFor(models.Parallel(downloadActions...), downloadMsg, "Downloaded buildpacks", "Downloading buildpacks failed"))
 
 	fileDescriptorLimit := uint64(request.FileDescriptors)
 
+	builderEnvironment := backend.config.stagingEnvironment(request.Environment)
+	for _, envVar := range []*models.EnvironmentVariable{buildpackCredhubRefsEnv, buildpackPhasesEnv, trustedCertsEnv} {
+		builderEnvironment = withEnvironmentVariable(builderEnvironment, envVar)
+	}
+
 	//Run Builder
 	actions = append(
 		actions,
@@ -164,7 +200,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 				User: "vcap",
 				Path: builderConfig.Path(),
 				Args: builderConfig.Args(),
-				Env:  request.Environment,
+				Env:  builderEnvironment,
 				ResourceLimits: &models.ResourceLimits{
 					Nofile: &fileDescriptorLimit,
 				},
@@ -178,7 +214,7 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	//Upload Droplet
 	uploadActions := []models.ActionInterface{}
 	uploadNames := []string{}
-	uploadURL, err := backend.dropletUploadURL(request, lifecycleData)
+	dropletUploadTarget, err := backend.dropletUploadTarget(request, lifecycleData, timeout)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
@@ -188,62 +224,139 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		&models.UploadAction{
 			Artifact: "droplet",
 			From:     builderConfig.OutputDroplet(), // get the droplet
-			To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
+			To:       dropletUploadTarget,
 			User:     "vcap",
 		},
 	)
 	uploadNames = append(uploadNames, "droplet")
 
 	//Upload Buildpack Artifacts Cache
-	uploadURL, err = backend.buildArtifactsUploadURL(request, lifecycleData)
-	if err != nil {
-		return &models.TaskDefinition{}, "", "", err
-	}
+	if !backend.config.skipsBuildArtifactsCacheUpload(*request.LifecycleData) {
+		uploadURL, err := backend.buildArtifactsUploadURL(request, lifecycleData)
+		if err != nil {
+			return &models.TaskDefinition{}, "", "", err
+		}
 
-	uploadActions = append(uploadActions,
-		models.Try(
-			&models.UploadAction{
-				Artifact: "build artifacts cache",
-				From:     builderConfig.OutputBuildArtifactsCache(), // get the compressed build artifacts cache
-				To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
-				User:     "vcap",
-			},
-		),
-	)
-	uploadNames = append(uploadNames, "build artifacts cache")
+		uploadActions = append(uploadActions,
+			models.Try(
+				&models.UploadAction{
+					Artifact: "build artifacts cache",
+					From:     builderConfig.OutputBuildArtifactsCache(), // get the compressed build artifacts cache
+					To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
+					User:     "vcap",
+				},
+			),
+		)
+		uploadNames = append(uploadNames, "build artifacts cache")
+	}
 
 	uploadMsg := fmt.Sprintf("Uploading %s...", strings.Join(uploadNames, ", "))
 	actions = append(actions, models.EmitProgressFor(models.Parallel(uploadActions...), uploadMsg, "Uploading complete", "Uploading failed"))
 
-	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
-		Lifecycle: TraditionalLifecycleName,
+	volumeMounts, err := volumeMountsFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	scheduling, err := backend.config.schedulingFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	annotationJson, _ := json.Marshal(stagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle: TraditionalLifecycleName,
+		},
+		StackWarning: stackWarning,
 	})
 
 	taskDefinition := &models.TaskDefinition{
-		RootFs:                models.PreloadedRootFS(lifecycleData.Stack),
+		RootFs:                backend.config.RootFSFor(lifecycleData.Stack),
 		ResultFile:            builderConfig.OutputMetadata(),
 		MemoryMb:              int32(request.MemoryMB),
 		DiskMb:                int32(request.DiskMB),
+		MaxPids:               int32(backend.config.MaxStagingPids),
 		CpuWeight:             uint32(StagingTaskCpuWeight),
 		Action:                models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
 		LogGuid:               request.LogGuid,
 		LogSource:             TaskLogSource,
 		CompletionCallbackUrl: backend.config.CallbackURL(stagingGuid),
-		EgressRules:           request.EgressRules,
+		EgressRules:           backend.config.egressRules(request.EgressRules),
+		Network:               scheduling.Network,
+		PlacementTags:         scheduling.PlacementTags,
+		LogRateLimit:          scheduling.LogRateLimit,
+		VolumeMounts:          volumeMounts,
 		Annotation:            string(annotationJson),
-		Privileged:            true,
+		Privileged:            backend.config.privilegedFor(TraditionalLifecycleName, true),
 		EnvironmentVariables:  []*models.EnvironmentVariable{{"LANG", DefaultLANG}},
 	}
 
 	logger.Debug("staging-task-request")
 
-	return taskDefinition, stagingGuid, backend.config.TaskDomain, nil
+	return taskDefinition, stagingGuid, backend.config.TaskDomainFor(TraditionalLifecycleName), nil
+}
+
+// BuildpackPhasesEnvVar gives the builder the supply and final buildpacks, by
+// key, when it runs several non-detecting buildpacks, as a JSON object like
+// {"supply": ["a", "b"], "final": "c"}.
+const BuildpackPhasesEnvVar = "CF_BUILDPACK_PHASES"
+
+type buildpackPhases struct {
+	Supply []string `json:"supply"`
+	Final  string   `json:"final"`
+}
+
+// skipsDetect reports whether the builder runs the requested buildpacks
+// rather than detecting one of them: either a single non-detecting buildpack,
+// or several that are all non-detecting.
+func skipsDetect(buildpacks []cc_messages.Buildpack) bool {
+	if len(buildpacks) == 0 {
+		return false
+	}
+
+	for _, buildpack := range buildpacks {
+		if !buildpack.SkipDetect {
+			return false
+		}
+	}
+	return true
+}
+
+// buildpackPhasesEnv returns the variable that tells the builder to run every
+// buildpack but the last as a supply buildpack, and the last as the final
+// buildpack, or nil unless it runs several non-detecting buildpacks.
+func buildpackPhasesEnv(buildpacks []cc_messages.Buildpack) (*models.EnvironmentVariable, error) {
+	if len(buildpacks) < 2 || !skipsDetect(buildpacks) {
+		return nil, nil
+	}
+
+	phases := buildpackPhases{Supply: []string{}, Final: buildpacks[len(buildpacks)-1].Key}
+	for _, buildpack := range buildpacks[:len(buildpacks)-1] {
+		phases.Supply = append(phases.Supply, buildpack.Key)
+	}
+
+	phasesJSON, err := json.Marshal(phases)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.EnvironmentVariable{Name: BuildpackPhasesEnvVar, Value: string(phasesJSON)}, nil
+}
+
+// buildpackStagingResponse adds the droplet's checksum, when the builder
+// reports one, to the lifecycle data of the staging response, so that the CC
+// can verify the droplet it stores. It also repeats any warning about the
+// app's stack.
+type buildpackStagingResponse struct {
+	cc_messages.BuildpackStagingResponse
+	DropletSHA256 string `json:"droplet_sha256,omitempty"`
+	StackWarning  string `json:"stack_warning,omitempty"`
 }
 
 func (backend *traditionalBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
 	var response cc_messages.StagingResponseForCC
 
-	var annotation cc_messages.StagingTaskAnnotation
+	var annotation stagingTaskAnnotation
 	err := json.Unmarshal([]byte(taskResponse.Annotation), &annotation)
 	if err != nil {
 		return cc_messages.StagingResponseForCC{}, err
@@ -252,15 +365,22 @@ func (backend *traditionalBackend) BuildStagingResponse(taskResponse *models.Tas
 	if taskResponse.Failed {
 		response.Error = backend.config.Sanitizer(taskResponse.FailureReason)
 	} else {
-		var result buildpack_app_lifecycle.StagingResult
+		var result struct {
+			buildpack_app_lifecycle.StagingResult
+			DropletSHA256 string `json:"droplet_sha256"`
+		}
 		err := json.Unmarshal([]byte(taskResponse.Result), &result)
 		if err != nil {
 			return cc_messages.StagingResponseForCC{}, err
 		}
 
-		buildpackResponse := cc_messages.BuildpackStagingResponse{
-			BuildpackKey:      result.BuildpackKey,
-			DetectedBuildpack: result.DetectedBuildpack,
+		buildpackResponse := buildpackStagingResponse{
+			BuildpackStagingResponse: cc_messages.BuildpackStagingResponse{
+				BuildpackKey:      result.BuildpackKey,
+				DetectedBuildpack: result.DetectedBuildpack,
+			},
+			DropletSHA256: result.DropletSHA256,
+			StackWarning:  annotation.StackWarning,
 		}
 
 		lifecycleDataJSON, err := json.Marshal(buildpackResponse)
@@ -277,15 +397,37 @@ func (backend *traditionalBackend) BuildStagingResponse(taskResponse *models.Tas
 	return response, nil
 }
 
+// skipsBuildArtifactsCacheUpload reports whether a staging task shouldn't
+// upload its build artifacts cache, because the stager is configured not to
+// or the lifecycle data sets skip_build_artifacts_cache_upload. Caches that
+// are huge but rarely help slow staging and churn the blobstore.
+func (c Config) skipsBuildArtifactsCacheUpload(lifecycleData json.RawMessage) bool {
+	if c.SkipBuildArtifactsCacheUpload {
+		return true
+	}
+
+	var options struct {
+		SkipBuildArtifactsCacheUpload bool `json:"skip_build_artifacts_cache_upload"`
+	}
+	json.Unmarshal(lifecycleData, &options)
+	return options.SkipBuildArtifactsCacheUpload
+}
+
 func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
 	compilerPath, ok := backend.config.Lifecycles[request.Lifecycle+"/"+buildpackData.Stack]
 	if !ok {
 		return nil, ErrNoCompilerDefined
 	}
 
-	parsed, err := url.Parse(compilerPath)
+	return lifecycleBundleURL(backend.config.FileServerURL, compilerPath)
+}
+
+// lifecycleBundleURL resolves a configured lifecycle bundle, which is either
+// an absolute URL or a path served by the file server.
+func lifecycleBundleURL(fileServerURL, bundlePath string) (*url.URL, error) {
+	parsed, err := url.Parse(bundlePath)
 	if err != nil {
-		return nil, errors.New("couldn't parse compiler URL")
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, "couldn't parse compiler URL")
 	}
 
 	switch parsed.Scheme {
@@ -294,37 +436,62 @@ func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.Stagi
 	case "":
 		break
 	default:
-		return nil, errors.New("Unknown Scheme")
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, "Unknown Scheme")
 	}
 
 	staticPath, err := fileserver.Routes.CreatePathForRoute(fileserver.StaticRoute, nil)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't generate the compiler download path: %s", err)
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, fmt.Sprintf("couldn't generate the compiler download path: %s", err))
 	}
 
-	urlString := urljoiner.Join(backend.config.FileServerURL, staticPath, compilerPath)
+	urlString := urljoiner.Join(fileServerURL, staticPath, bundlePath)
 
 	url, err := url.ParseRequestURI(urlString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse compiler download URL: %s", err)
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, fmt.Sprintf("failed to parse compiler download URL: %s", err))
 	}
 
 	return url, nil
 }
 
+// dropletUploadTarget returns where the droplet is uploaded. When the
+// lifecycle data has a droplet_upload_signed_url, e.g. a pre-signed blobstore
+// URL from a bits service, the droplet goes straight there. Otherwise it goes
+// through the CC uploader.
+func (backend *traditionalBackend) dropletUploadTarget(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData, timeout time.Duration) (string, error) {
+	var options struct {
+		DropletUploadSignedURL string `json:"droplet_upload_signed_url"`
+	}
+	json.Unmarshal(*request.LifecycleData, &options)
+
+	if options.DropletUploadSignedURL != "" {
+		signedURL, err := url.ParseRequestURI(options.DropletUploadSignedURL)
+		if err != nil || signedURL.Host == "" {
+			return "", NewValidationError(InvalidLifecycleDataErrorId, "invalid droplet_upload_signed_url")
+		}
+		return signedURL.String(), nil
+	}
+
+	uploadURL, err := backend.dropletUploadURL(request, buildpackData)
+	if err != nil {
+		return "", err
+	}
+	return addTimeoutParamToURL(*uploadURL, timeout).String(), nil
+}
+
 func (backend *traditionalBackend) dropletUploadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
 	path, err := ccuploader.Routes.CreatePathForRoute(ccuploader.UploadDropletRoute, rata.Params{
 		"guid": request.AppId,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("couldn't generate droplet upload URL: %s", err)
+		return nil, NewConfigurationError(InvalidUploadURLErrorId, fmt.Sprintf("couldn't generate droplet upload URL: %s", err))
 	}
 
 	urlString := urljoiner.Join(backend.config.CCUploaderURL, path)
 
 	u, err := url.ParseRequestURI(urlString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse droplet upload URL: %s", err)
+		return nil, NewConfigurationError(InvalidUploadURLErrorId, fmt.Sprintf("failed to parse droplet upload URL: %s", err))
 	}
 
 	values := make(url.Values, 1)
@@ -339,14 +506,14 @@ func (backend *traditionalBackend) buildArtifactsUploadURL(request cc_messages.S
 		"app_guid": request.AppId,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("couldn't generate build artifacts cache upload URL: %s", err)
+		return nil, NewConfigurationError(InvalidUploadURLErrorId, fmt.Sprintf("couldn't generate build artifacts cache upload URL: %s", err))
 	}
 
 	urlString := urljoiner.Join(backend.config.CCUploaderURL, path)
 
 	u, err := url.ParseRequestURI(urlString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse build artifacts cache upload URL: %s", err)
+		return nil, NewConfigurationError(InvalidUploadURLErrorId, fmt.Sprintf("failed to parse build artifacts cache upload URL: %s", err))
 	}
 
 	values := make(url.Values, 1)
@@ -364,7 +531,7 @@ func (backend *traditionalBackend) buildArtifactsDownloadURL(buildpackData cc_me
 
 	url, err := url.ParseRequestURI(urlString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse build artifacts cache download URL: %s", err)
+		return nil, NewValidationError(InvalidDownloadURLErrorId, fmt.Sprintf("failed to parse build artifacts cache download URL: %s", err))
 	}
 
 	return url, nil
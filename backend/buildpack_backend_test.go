@@ -333,6 +333,66 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 	})
 
+	Context("with multiple specified buildpacks", func() {
+		BeforeEach(func() {
+			buildpacks[0].SkipDetect = true
+			buildpacks[1].SkipDetect = true
+		})
+
+		It("downloads them all and runs them as supply and final buildpacks, in order", func() {
+			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			actions := actionsFromTaskDef(taskDef)
+
+			Expect(actions[1].GetEmitProgressAction()).To(Equal(models.EmitProgressFor(
+				models.Parallel(
+					downloadBuilderAction,
+					downloadFirstBuildpackAction,
+					downloadSecondBuildpackAction,
+					downloadBuildArtifactsAction,
+				),
+				"Downloading buildpacks (zfirst, asecond), build artifacts cache...",
+				"Downloaded buildpacks",
+				"Downloading buildpacks failed",
+			)))
+
+			runArgs := actions[2].GetEmitProgressAction().Action.GetRunAction().Args
+			Expect(runArgs).To(ContainElement("-buildpackOrder=zfirst-buildpack,asecond-buildpack"))
+			Expect(runArgs).To(ContainElement("-skipDetect=true"))
+		})
+
+		It("tells the builder which are supply buildpacks and which is the final one", func() {
+			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			actions := actionsFromTaskDef(taskDef)
+			runEnv := actions[2].GetEmitProgressAction().Action.GetRunAction().Env
+			Expect(runEnv).To(ContainElement(&models.EnvironmentVariable{
+				Name:  backend.BuildpackPhasesEnvVar,
+				Value: `{"supply":["zfirst-buildpack"],"final":"asecond-buildpack"}`,
+			}))
+		})
+
+		Context("when only some of them are non-detecting", func() {
+			BeforeEach(func() {
+				buildpacks[1].SkipDetect = false
+			})
+
+			It("detects", func() {
+				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				actions := actionsFromTaskDef(taskDef)
+				runAction := actions[2].GetEmitProgressAction().Action.GetRunAction()
+				Expect(runAction.Args).To(ContainElement("-skipDetect=false"))
+				for _, envVar := range runAction.Env {
+					Expect(envVar.Name).NotTo(Equal(backend.BuildpackPhasesEnvVar))
+				}
+			})
+		})
+	})
+
 	Context("with a custom buildpack", func() {
 		var customBuildpack = "https://example.com/a/custom-buildpack.git"
 		BeforeEach(func() {
@@ -393,6 +453,36 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 	})
 
+	Describe("network properties", func() {
+		It("tags the task with the app", func() {
+			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDef.Network).To(Equal(&models.Network{
+				Properties: map[string]string{
+					"app_id": "bunny",
+				},
+			}))
+		})
+
+		Context("when the operator configures network properties", func() {
+			BeforeEach(func() {
+				config.NetworkProperties = map[string]string{"space_id": "the-space", "app_id": "overridden"}
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("merges them with the app's properties", func() {
+				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(taskDef.Network.Properties).To(Equal(map[string]string{
+					"space_id": "the-space",
+					"app_id":   "bunny",
+				}))
+			})
+		})
+	})
+
 	It("gives the task a callback URL to call it back", func() {
 		taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
 		Expect(err).NotTo(HaveOccurred())
@@ -444,6 +534,32 @@ var _ = Describe("TraditionalBackend", func() {
 				Expect(timeoutAction.Timeout).To(Equal(int64(backend.DefaultStagingTimeout)))
 			})
 		})
+
+		Context("when the requested timeout exceeds the configured maximum", func() {
+			BeforeEach(func() {
+				timeout = 86400
+				config.MaxStagingTimeout = 30 * time.Minute
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("caps the timeout", func() {
+				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				timeoutAction := taskDef.Action.GetTimeoutAction()
+				Expect(timeoutAction).NotTo(BeNil())
+				Expect(timeoutAction.Timeout).To(Equal(int64(30 * time.Minute)))
+			})
+
+			It("caps the timeout given to the CC uploader", func() {
+				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				actionJSON, err := json.Marshal(taskDef.Action)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(actionJSON)).To(ContainSubstring(cc_messages.CcTimeoutKey + "=1800"))
+			})
+		})
 	})
 
 	Context("when build artifacts download uris are not provided", func() {
@@ -482,6 +598,87 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 	})
 
+	Describe("skipping the build artifacts cache upload", func() {
+		uploadsOnlyTheDroplet := func() {
+			taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			actions := actionsFromTaskDef(taskDef)
+			Expect(actions[len(actions)-1]).To(Equal(models.WrapAction(models.EmitProgressFor(
+				models.Parallel(uploadDropletAction),
+				"Uploading droplet...",
+				"Uploading complete",
+				"Uploading failed",
+			))))
+		}
+
+		Context("when the stager is configured to skip it", func() {
+			BeforeEach(func() {
+				config.SkipBuildArtifactsCacheUpload = true
+				traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+			})
+
+			It("only uploads the droplet", uploadsOnlyTheDroplet)
+		})
+
+		Context("when the staging request asks to skip it", func() {
+			JustBeforeEach(func() {
+				var data map[string]interface{}
+				Expect(json.Unmarshal(*stagingRequest.LifecycleData, &data)).To(Succeed())
+				data["skip_build_artifacts_cache_upload"] = true
+
+				dataJSON, err := json.Marshal(data)
+				Expect(err).NotTo(HaveOccurred())
+				lifecycleData := json.RawMessage(dataJSON)
+				stagingRequest.LifecycleData = &lifecycleData
+			})
+
+			It("only uploads the droplet", uploadsOnlyTheDroplet)
+		})
+	})
+
+	Describe("uploading the droplet straight to the blobstore", func() {
+		var signedURL string
+
+		JustBeforeEach(func() {
+			var data map[string]interface{}
+			Expect(json.Unmarshal(*stagingRequest.LifecycleData, &data)).To(Succeed())
+			data["droplet_upload_signed_url"] = signedURL
+
+			dataJSON, err := json.Marshal(data)
+			Expect(err).NotTo(HaveOccurred())
+			lifecycleData := json.RawMessage(dataJSON)
+			stagingRequest.LifecycleData = &lifecycleData
+		})
+
+		Context("when the staging request has a signed droplet upload URL", func() {
+			BeforeEach(func() {
+				signedURL = "https://blobstore.example.com/droplets/bunny?X-Amz-Signature=abc123"
+			})
+
+			It("uploads the droplet to it, bypassing the CC uploader", func() {
+				taskDef, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				actions := actionsFromTaskDef(taskDef)
+				uploads := actions[len(actions)-1].GetEmitProgressAction().Action.GetParallelAction().Actions
+				Expect(uploads[0].GetUploadAction().To).To(Equal("https://blobstore.example.com/droplets/bunny?X-Amz-Signature=abc123"))
+			})
+		})
+
+		Context("when the signed droplet upload URL is invalid", func() {
+			BeforeEach(func() {
+				signedURL = "blobstore/droplets/bunny"
+			})
+
+			It("returns an error", func() {
+				_, _, _, err := traditional.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidLifecycleDataErrorId))
+			})
+		})
+	})
+
 	Context("when no compiler is defined for the requested stack in backend configuration", func() {
 		BeforeEach(func() {
 			stack = "no_such_stack"
@@ -645,6 +842,21 @@ var _ = Describe("TraditionalBackend", func() {
 						})
 					})
 
+					Context("with a staging result that has the droplet's checksum", func() {
+						BeforeEach(func() {
+							stagingResultJson = []byte(`{"buildpack_key": "buildpack-key", "detected_buildpack": "detected-buildpack", "droplet_sha256": "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c"}`)
+						})
+
+						It("passes it to the CC in the lifecycle data", func() {
+							Expect(buildError).NotTo(HaveOccurred())
+							Expect(*response.LifecycleData).To(MatchJSON(`{
+								"buildpack_key": "buildpack-key",
+								"detected_buildpack": "detected-buildpack",
+								"droplet_sha256": "b5bb9d8014a0f9b1d61e21e796d78dccdf1352f23cd32812f4850b878ae4944c"
+							}`))
+						})
+					})
+
 					Context("with an invalid staging result", func() {
 						BeforeEach(func() {
 							stagingResultJson = []byte("invalid-json")
@@ -704,21 +916,29 @@ var _ = Describe("TraditionalBackend", func() {
 		})
 
 		Context("when the message is missing docker image URL", func() {
-			It("returns a StagingError", func() {
+			It("returns a MissingDockerImageUrl error", func() {
 				stagingErr := backend.SanitizeErrorMessage(diego_errors.MISSING_DOCKER_IMAGE_URL)
-				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+				Expect(stagingErr.Id).To(Equal(backend.MissingDockerImageUrlErrorId))
 				Expect(stagingErr.Message).To(Equal(diego_errors.MISSING_DOCKER_IMAGE_URL))
 			})
 		})
 
 		Context("when the message is missing docker registry", func() {
-			It("returns a StagingError", func() {
+			It("returns a MissingDockerRegistry error", func() {
 				stagingErr := backend.SanitizeErrorMessage(diego_errors.MISSING_DOCKER_REGISTRY)
-				Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+				Expect(stagingErr.Id).To(Equal(backend.MissingDockerRegistryErrorId))
 				Expect(stagingErr.Message).To(Equal(diego_errors.MISSING_DOCKER_REGISTRY))
 			})
 		})
 
+		Context("when the staging task timed out", func() {
+			It("returns a StagingTimedOut error", func() {
+				stagingErr := backend.SanitizeErrorMessage("exceeded 15m0s timeout")
+				Expect(stagingErr.Id).To(Equal(backend.StagingTimedOutErrorId))
+				Expect(stagingErr.Message).To(Equal("staging timed out"))
+			})
+		})
+
 		Context("any other message", func() {
 			It("returns a StagingError", func() {
 				stagingErr := backend.SanitizeErrorMessage("some-error")
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// BuildpackCredhubRefsEnvVar gives the builder the CredHub references of
+// private custom buildpacks' credentials, as a JSON object keyed by buildpack
+// key. Only builders that resolve CredHub references read it.
+const BuildpackCredhubRefsEnvVar = "CF_BUILDPACK_CREDHUB_REFS"
+
+var (
+	ErrCredentialsForAdminBuildpack = NewValidationError(InvalidLifecycleDataErrorId, "credentials are only supported for custom buildpacks")
+	ErrInlineBuildpackCredentials   = NewValidationError(InvalidLifecycleDataErrorId, "buildpack credentials must be given as a credhub-ref")
+)
+
+// BuildpackCredentials name the CredHub credentials that authenticate the
+// builder to a private custom buildpack, e.g. a git repository. A username
+// and password are rejected, as the task definition they would be given to
+// the builder in is stored in the BBS.
+type BuildpackCredentials struct {
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	CredhubRef string `json:"credhub-ref,omitempty"`
+}
+
+type buildpackCredentialsData struct {
+	Buildpacks []struct {
+		Key         string                `json:"key"`
+		Credentials *BuildpackCredentials `json:"credentials"`
+	} `json:"buildpacks"`
+}
+
+// buildpackCredhubRefs returns the variable that gives the builder the
+// CredHub references of the custom buildpacks' credentials given in the
+// lifecycle data, or nil when there are none. Buildpacks, their keys and
+// URLs are left as the CC sent them.
+func buildpackCredhubRefs(lifecycleData json.RawMessage, buildpacks []cc_messages.Buildpack) (*models.EnvironmentVariable, error) {
+	var data buildpackCredentialsData
+	err := json.Unmarshal(lifecycleData, &data)
+	if err != nil {
+		return nil, NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	given := map[string]BuildpackCredentials{}
+	for _, buildpack := range data.Buildpacks {
+		if buildpack.Credentials != nil {
+			given[buildpack.Key] = *buildpack.Credentials
+		}
+	}
+
+	refs := map[string]string{}
+	for _, buildpack := range buildpacks {
+		credentials, ok := given[buildpack.Key]
+		if !ok {
+			continue
+		}
+
+		if buildpack.Name != cc_messages.CUSTOM_BUILDPACK {
+			return nil, ErrCredentialsForAdminBuildpack
+		}
+		if credentials.Username != "" || credentials.Password != "" {
+			return nil, ErrInlineBuildpackCredentials
+		}
+		if credentials.CredhubRef != "" {
+			refs[buildpack.Key] = credentials.CredhubRef
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	refsJSON, err := json.Marshal(refs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.EnvironmentVariable{Name: BuildpackCredhubRefsEnvVar, Value: string(refsJSON)}, nil
+}
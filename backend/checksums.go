@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+var ErrLifecycleChecksumFormatInvalid = errors.New("lifecycle checksums must be lifecycle[/stack]=algorithm:hex-digest")
+
+// checksumLengths are the hex digest lengths of the algorithms that download
+// actions can verify.
+var checksumLengths = map[string]int{
+	"sha1":   40,
+	"sha256": 64,
+}
+
+// Checksum is the expected digest of a downloaded artifact. The executor
+// fails the download, and so the staging task, if the artifact doesn't match.
+type Checksum struct {
+	Algorithm string `json:"type"`
+	Value     string `json:"value"`
+}
+
+func (c Checksum) validate() error {
+	length, ok := checksumLengths[strings.ToLower(c.Algorithm)]
+	if !ok {
+		return fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+
+	_, err := hex.DecodeString(c.Value)
+	if err != nil || len(c.Value) != length {
+		return fmt.Errorf("invalid %s checksum %q", c.Algorithm, c.Value)
+	}
+
+	return nil
+}
+
+// requestChecksums are the checksums that the CC may send alongside the app
+// bits and buildpacks in buildpack lifecycle data.
+type requestChecksums struct {
+	AppBits    *Checksum `json:"app_bits_checksum"`
+	Buildpacks []struct {
+		Key      string    `json:"key"`
+		Checksum *Checksum `json:"checksum"`
+	} `json:"buildpacks"`
+}
+
+// parseRequestChecksums returns the app bits' checksum, if any, and the
+// checksums of buildpacks by key.
+func parseRequestChecksums(lifecycleData json.RawMessage) (*Checksum, map[string]*Checksum, error) {
+	var checksums requestChecksums
+	err := json.Unmarshal(lifecycleData, &checksums)
+	if err != nil {
+		return nil, nil, NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	if checksums.AppBits != nil {
+		err := checksums.AppBits.validate()
+		if err != nil {
+			return nil, nil, NewValidationError(InvalidChecksumErrorId, err.Error())
+		}
+	}
+
+	buildpacks := map[string]*Checksum{}
+	for _, buildpack := range checksums.Buildpacks {
+		if buildpack.Checksum == nil {
+			continue
+		}
+
+		err := buildpack.Checksum.validate()
+		if err != nil {
+			return nil, nil, NewValidationError(InvalidChecksumErrorId, err.Error())
+		}
+		buildpacks[buildpack.Key] = buildpack.Checksum
+	}
+
+	return checksums.AppBits, buildpacks, nil
+}
+
+// withChecksum has the executor verify a download against checksum, if given.
+func withChecksum(action *models.DownloadAction, checksum *Checksum) *models.DownloadAction {
+	if checksum != nil {
+		action.ChecksumAlgorithm = strings.ToLower(checksum.Algorithm)
+		action.ChecksumValue = strings.ToLower(checksum.Value)
+	}
+	return action
+}
+
+// lifecycleChecksum returns the configured checksum of a lifecycle bundle.
+func (c Config) lifecycleChecksum(lifecycle string) *Checksum {
+	checksum, ok := c.LifecycleChecksums[lifecycle]
+	if !ok {
+		return nil
+	}
+	return &checksum
+}
+
+// LifecycleChecksums maps lifecycle bundles, keyed as in Config.Lifecycles,
+// to their expected checksums. It implements flag.Value so that checksums can
+// be configured by repeating a command line flag.
+type LifecycleChecksums map[string]Checksum
+
+func (l *LifecycleChecksums) String() string {
+	checksums := make([]string, 0, len(*l))
+	for lifecycle, checksum := range *l {
+		checksums = append(checksums, lifecycle+"="+checksum.Algorithm+":"+checksum.Value)
+	}
+	sort.Strings(checksums)
+	return strings.Join(checksums, ",")
+}
+
+func (l *LifecycleChecksums) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrLifecycleChecksumFormatInvalid
+	}
+
+	digest := strings.SplitN(parts[1], ":", 2)
+	if len(digest) != 2 {
+		return ErrLifecycleChecksumFormatInvalid
+	}
+
+	checksum := Checksum{Algorithm: digest[0], Value: digest[1]}
+	err := checksum.validate()
+	if err != nil {
+		return err
+	}
+
+	if *l == nil {
+		*l = LifecycleChecksums{}
+	}
+	(*l)[parts[0]] = checksum
+	return nil
+}
@@ -0,0 +1,132 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Checksums", func() {
+	var (
+		config          backend.Config
+		lifecycleData   string
+		appBitsSha256   string
+		buildpackSha1   string
+		lifecycleSha256 string
+	)
+
+	BeforeEach(func() {
+		appBitsSha256 = strings.Repeat("a", 64)
+		buildpackSha1 = strings.Repeat("b", 40)
+		lifecycleSha256 = strings.Repeat("c", 64)
+
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+			LifecycleChecksums: backend.LifecycleChecksums{
+				"buildpack/cflinuxfs2": {Algorithm: "sha256", Value: lifecycleSha256},
+			},
+		}
+
+		lifecycleData = `{
+			"app_bits_download_uri": "http://example-uri.com/bunny",
+			"app_bits_checksum": {"type": "sha256", "value": "` + appBitsSha256 + `"},
+			"stack": "cflinuxfs2",
+			"buildpacks": [{"name": "ruby", "key": "ruby-buildpack", "url": "ruby-buildpack-url", "checksum": {"type": "sha1", "value": "` + buildpackSha1 + `"}}]
+		}`
+	})
+
+	buildRecipe := func() (*models.TaskDefinition, error) {
+		rawLifecycleData := json.RawMessage(lifecycleData)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &rawLifecycleData,
+		})
+		return taskDef, err
+	}
+
+	It("has the executor verify app bits, buildpacks, and lifecycle bundles", func() {
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		appDownload := actions[0].GetDownloadAction()
+		Expect(appDownload.ChecksumAlgorithm).To(Equal("sha256"))
+		Expect(appDownload.ChecksumValue).To(Equal(appBitsSha256))
+
+		downloads := actions[1].GetEmitProgressAction().Action.GetParallelAction().Actions
+		lifecycleDownload := downloads[0].GetEmitProgressAction().Action.GetDownloadAction()
+		Expect(lifecycleDownload.ChecksumAlgorithm).To(Equal("sha256"))
+		Expect(lifecycleDownload.ChecksumValue).To(Equal(lifecycleSha256))
+
+		buildpackDownload := downloads[1].GetDownloadAction()
+		Expect(buildpackDownload.ChecksumAlgorithm).To(Equal("sha1"))
+		Expect(buildpackDownload.ChecksumValue).To(Equal(buildpackSha1))
+	})
+
+	Context("when the request has no checksums", func() {
+		BeforeEach(func() {
+			lifecycleData = `{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2", "buildpacks": []}`
+		})
+
+		It("doesn't verify the app bits", func() {
+			taskDef, err := buildRecipe()
+			Expect(err).NotTo(HaveOccurred())
+
+			appDownload := actionsFromTaskDef(taskDef)[0].GetDownloadAction()
+			Expect(appDownload.ChecksumAlgorithm).To(BeEmpty())
+			Expect(appDownload.ChecksumValue).To(BeEmpty())
+		})
+	})
+
+	Context("when a checksum is malformed", func() {
+		BeforeEach(func() {
+			lifecycleData = `{"app_bits_download_uri": "http://example-uri.com/bunny", "app_bits_checksum": {"type": "md5", "value": "abc"}, "stack": "cflinuxfs2"}`
+		})
+
+		It("rejects the request", func() {
+			_, err := buildRecipe()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidChecksumErrorId))
+		})
+	})
+
+	Describe("LifecycleChecksums", func() {
+		It("parses lifecycle=algorithm:digest", func() {
+			checksums := backend.LifecycleChecksums{}
+			Expect(checksums.Set("docker=sha256:" + lifecycleSha256)).To(Succeed())
+			Expect(checksums).To(Equal(backend.LifecycleChecksums{
+				"docker": {Algorithm: "sha256", Value: lifecycleSha256},
+			}))
+		})
+
+		It("rejects malformed checksums", func() {
+			checksums := backend.LifecycleChecksums{}
+			Expect(checksums.Set("docker")).To(Equal(backend.ErrLifecycleChecksumFormatInvalid))
+			Expect(checksums.Set("docker=" + lifecycleSha256)).To(Equal(backend.ErrLifecycleChecksumFormatInvalid))
+			Expect(checksums.Set("docker=sha256:not-hex")).To(HaveOccurred())
+		})
+	})
+
+	Describe("SanitizeErrorMessage", func() {
+		It("reports checksum mismatches", func() {
+			stagingErr := backend.SanitizeErrorMessage("Checksum failed: expected 2cf24dba, received 486ea462")
+			Expect(stagingErr.Id).To(Equal(backend.ChecksumMismatchErrorId))
+		})
+
+		It("doesn't take other failures that mention checksums for mismatches", func() {
+			stagingErr := backend.SanitizeErrorMessage("failed to download checksum file: connection refused")
+			Expect(stagingErr.Id).To(Equal(cc_messages.STAGING_ERROR))
+		})
+	})
+})
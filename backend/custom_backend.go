@@ -0,0 +1,249 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"text/template"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/pivotal-golang/lager"
+)
+
+const DefaultCustomLifecycleResultFile = "/tmp/result.json"
+
+var (
+	ErrCustomLifecycleIncomplete = errors.New("custom lifecycles need a name, bundle_url and builder_path")
+	ErrMissingStack              = NewValidationError(InvalidLifecycleDataErrorId, "lifecycle data has no stack and the lifecycle has no rootfs")
+)
+
+// CustomLifecycle describes a lifecycle that is staged by downloading its
+// bundle and running its builder, without a backend of its own. Each of Args
+// is a text/template rendered with a CustomLifecycleArgs.
+type CustomLifecycle struct {
+	Name        string   `json:"name"`
+	BundleURL   string   `json:"bundle_url"`
+	BuilderPath string   `json:"builder_path"`
+	Args        []string `json:"args"`
+
+	// ResultFile is where the builder writes the staging response for the
+	// CC. Defaults to /tmp/result.json.
+	ResultFile string `json:"result_file"`
+
+	// RootFS defaults to the rootfs of the lifecycle data's stack.
+	RootFS     string `json:"rootfs"`
+	Privileged bool   `json:"privileged"`
+}
+
+// CustomLifecycleArgs is what custom lifecycle argument templates are
+// rendered with, e.g. {{.LifecycleData.app_bits_download_uri}}.
+type CustomLifecycleArgs struct {
+	StagingGuid   string
+	AppId         string
+	Stack         string
+	MemoryMB      int
+	DiskMB        int
+	Timeout       int
+	ResultFile    string
+	LifecycleData map[string]interface{}
+}
+
+// LoadCustomLifecycles reads a JSON list of custom lifecycles.
+func LoadCustomLifecycles(file string) ([]CustomLifecycle, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var lifecycles []CustomLifecycle
+	err = json.Unmarshal(contents, &lifecycles)
+	if err != nil {
+		return nil, err
+	}
+
+	return lifecycles, nil
+}
+
+type customBackend struct {
+	config    Config
+	logger    lager.Logger
+	lifecycle CustomLifecycle
+	args      []*template.Template
+}
+
+// NewCustomBackend returns a backend for a configured lifecycle, failing if
+// the lifecycle is incomplete or any of its argument templates don't parse.
+func NewCustomBackend(lifecycle CustomLifecycle, config Config, logger lager.Logger) (Backend, error) {
+	if lifecycle.Name == "" || lifecycle.BundleURL == "" || lifecycle.BuilderPath == "" {
+		return nil, ErrCustomLifecycleIncomplete
+	}
+
+	if lifecycle.ResultFile == "" {
+		lifecycle.ResultFile = DefaultCustomLifecycleResultFile
+	}
+
+	args := make([]*template.Template, 0, len(lifecycle.Args))
+	for i, arg := range lifecycle.Args {
+		tmpl, err := template.New(fmt.Sprintf("%s-arg-%d", lifecycle.Name, i)).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, tmpl)
+	}
+
+	return &customBackend{
+		config:    config,
+		logger:    logger.Session("custom", lager.Data{"lifecycle": lifecycle.Name}),
+		lifecycle: lifecycle,
+		args:      args,
+	}, nil
+}
+
+func (backend *customBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid})
+	logger.Info("staging-request")
+
+	if request.AppId == "" {
+		return &models.TaskDefinition{}, "", "", ErrMissingAppId
+	}
+
+	if request.LifecycleData == nil {
+		return &models.TaskDefinition{}, "", "", ErrMissingLifecycleData
+	}
+
+	lifecycleData := map[string]interface{}{}
+	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	stack, _ := lifecycleData["stack"].(string)
+	request = backend.config.withResourceMinimums(stack, request)
+
+	rootFS := backend.lifecycle.RootFS
+	if rootFS == "" {
+		if stack == "" {
+			return &models.TaskDefinition{}, "", "", ErrMissingStack
+		}
+		rootFS = backend.config.RootFSFor(stack)
+	}
+
+	bundleURL, err := lifecycleBundleURL(backend.config.FileServerURL, backend.lifecycle.BundleURL)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	timeout := backend.config.cappedTimeout(traditionalTimeout(request, backend.logger), request, backend.logger)
+
+	args, err := backend.renderArgs(CustomLifecycleArgs{
+		StagingGuid:   stagingGuid,
+		AppId:         request.AppId,
+		Stack:         stack,
+		MemoryMB:      request.MemoryMB,
+		DiskMB:        request.DiskMB,
+		Timeout:       int(timeout.Seconds()),
+		ResultFile:    backend.lifecycle.ResultFile,
+		LifecycleData: lifecycleData,
+	})
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	actions := []models.ActionInterface{
+		models.EmitProgressFor(
+			withChecksum(&models.DownloadAction{
+				From:     bundleURL.String(),
+				To:       path.Dir(backend.lifecycle.BuilderPath),
+				CacheKey: fmt.Sprintf("%s-lifecycle", backend.lifecycle.Name),
+				User:     "vcap",
+			}, backend.config.lifecycleChecksum(backend.lifecycle.Name)),
+			"",
+			"",
+			"Failed to set up staging environment",
+		),
+		models.EmitProgressFor(
+			&models.RunAction{
+				User: "vcap",
+				Path: backend.lifecycle.BuilderPath,
+				Args: args,
+				Env:  backend.config.stagingEnvironment(request.Environment),
+			},
+			"Staging...",
+			"Staging complete",
+			"Staging failed",
+		),
+	}
+
+	volumeMounts, err := volumeMountsFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	scheduling, err := backend.config.schedulingFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
+		Lifecycle: backend.lifecycle.Name,
+	})
+
+	taskDefinition := &models.TaskDefinition{
+		RootFs:                rootFS,
+		ResultFile:            backend.lifecycle.ResultFile,
+		MemoryMb:              int32(request.MemoryMB),
+		DiskMb:                int32(request.DiskMB),
+		MaxPids:               int32(backend.config.MaxStagingPids),
+		CpuWeight:             uint32(StagingTaskCpuWeight),
+		Action:                models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
+		LogGuid:               request.LogGuid,
+		LogSource:             TaskLogSource,
+		CompletionCallbackUrl: backend.config.CallbackURL(stagingGuid),
+		EgressRules:           backend.config.egressRules(request.EgressRules),
+		Network:               scheduling.Network,
+		PlacementTags:         scheduling.PlacementTags,
+		LogRateLimit:          scheduling.LogRateLimit,
+		VolumeMounts:          volumeMounts,
+		Annotation:            string(annotationJson),
+		Privileged:            backend.config.privilegedFor(backend.lifecycle.Name, backend.lifecycle.Privileged),
+	}
+
+	logger.Debug("staging-task-request")
+
+	return taskDefinition, stagingGuid, backend.config.TaskDomainFor(backend.lifecycle.Name), nil
+}
+
+// BuildStagingResponse passes on the builder's result, which custom
+// lifecycles write in the CC's staging response format.
+func (backend *customBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
+	var response cc_messages.StagingResponseForCC
+
+	if taskResponse.Failed {
+		response.Error = backend.config.Sanitizer(taskResponse.FailureReason)
+		return response, nil
+	}
+
+	err := json.Unmarshal([]byte(taskResponse.Result), &response)
+	if err != nil {
+		return cc_messages.StagingResponseForCC{}, err
+	}
+
+	return response, nil
+}
+
+func (backend *customBackend) renderArgs(data CustomLifecycleArgs) ([]string, error) {
+	args := make([]string, 0, len(backend.args))
+	for _, tmpl := range backend.args {
+		var arg bytes.Buffer
+		err := tmpl.Execute(&arg, data)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,197 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("CustomBackend", func() {
+	var (
+		lifecycle      backend.CustomLifecycle
+		config         backend.Config
+		custom         backend.Backend
+		stagingRequest cc_messages.StagingRequestFromCC
+		lifecycleData  string
+	)
+
+	BeforeEach(func() {
+		config = backend.Config{
+			TaskDomain:    "config-task-domain",
+			StagerURL:     "http://the-stager.example.com",
+			FileServerURL: "http://file-server.com",
+			Sanitizer: func(msg string) *cc_messages.StagingError {
+				return &cc_messages.StagingError{Message: msg + " was totally sanitized"}
+			},
+		}
+
+		lifecycle = backend.CustomLifecycle{
+			Name:        "binary",
+			BundleURL:   "binary_app_lifecycle.tgz",
+			BuilderPath: "/tmp/binary_lifecycle/builder",
+			Args: []string{
+				"-appBits={{.LifecycleData.app_bits_download_uri}}",
+				"-app={{.AppId}}",
+				"-timeout={{.Timeout}}",
+				"-result={{.ResultFile}}",
+			},
+		}
+
+		lifecycleData = `{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		custom, err = backend.NewCustomBackend(lifecycle, config, lagertest.NewTestLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+
+		rawLifecycleData := json.RawMessage(lifecycleData)
+		stagingRequest = cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			LogGuid:       "bunny",
+			Lifecycle:     "binary",
+			LifecycleData: &rawLifecycleData,
+			MemoryMB:      1024,
+			DiskMB:        2048,
+			Timeout:       900,
+		}
+	})
+
+	It("downloads the bundle and runs the builder with the rendered arguments", func() {
+		taskDef, guid, domain, err := custom.BuildRecipe("staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(guid).To(Equal("staging-guid"))
+		Expect(domain).To(Equal("config-task-domain"))
+
+		Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("cflinuxfs2")))
+		Expect(taskDef.ResultFile).To(Equal("/tmp/result.json"))
+		Expect(taskDef.Privileged).To(BeFalse())
+		Expect(taskDef.Annotation).To(MatchJSON(`{"lifecycle": "binary"}`))
+
+		Expect(actionsFromTaskDef(taskDef)).To(Equal([]*models.Action{
+			models.WrapAction(models.EmitProgressFor(
+				&models.DownloadAction{
+					From:     "http://file-server.com/v1/static/binary_app_lifecycle.tgz",
+					To:       "/tmp/binary_lifecycle",
+					CacheKey: "binary-lifecycle",
+					User:     "vcap",
+				},
+				"",
+				"",
+				"Failed to set up staging environment",
+			)),
+			models.WrapAction(models.EmitProgressFor(
+				&models.RunAction{
+					User: "vcap",
+					Path: "/tmp/binary_lifecycle/builder",
+					Args: []string{
+						"-appBits=http://example-uri.com/bunny",
+						"-app=bunny",
+						"-timeout=900",
+						"-result=/tmp/result.json",
+					},
+				},
+				"Staging...",
+				"Staging complete",
+				"Staging failed",
+			)),
+		}))
+	})
+
+	Context("when the template references missing lifecycle data", func() {
+		BeforeEach(func() {
+			lifecycleData = `{"stack": "cflinuxfs2"}`
+		})
+
+		It("returns a validation error", func() {
+			_, _, _, err := custom.BuildRecipe("staging-guid", stagingRequest)
+			Expect(err).To(HaveOccurred())
+			Expect(err).To(BeAssignableToTypeOf(&backend.ValidationError{}))
+		})
+	})
+
+	Context("when neither the lifecycle data nor the lifecycle gives a rootfs", func() {
+		BeforeEach(func() {
+			lifecycleData = `{"app_bits_download_uri": "http://example-uri.com/bunny"}`
+		})
+
+		It("returns an error", func() {
+			_, _, _, err := custom.BuildRecipe("staging-guid", stagingRequest)
+			Expect(err).To(Equal(backend.ErrMissingStack))
+		})
+
+		Context("but the lifecycle gives a rootfs", func() {
+			BeforeEach(func() {
+				lifecycle.RootFS = "docker:///binary/rootfs"
+			})
+
+			It("uses it", func() {
+				taskDef, _, _, err := custom.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskDef.RootFs).To(Equal("docker:///binary/rootfs"))
+			})
+		})
+	})
+
+	Describe("NewCustomBackend", func() {
+		It("rejects incomplete lifecycles", func() {
+			_, err := backend.NewCustomBackend(backend.CustomLifecycle{Name: "binary"}, config, lagertest.NewTestLogger("test"))
+			Expect(err).To(Equal(backend.ErrCustomLifecycleIncomplete))
+		})
+
+		It("rejects argument templates that don't parse", func() {
+			lifecycle.Args = []string{"{{.AppId"}
+			_, err := backend.NewCustomBackend(lifecycle, config, lagertest.NewTestLogger("test"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("BuildStagingResponse", func() {
+		It("passes on the builder's result", func() {
+			response, err := custom.BuildStagingResponse(&models.TaskCallbackResponse{
+				Annotation: `{"lifecycle": "binary"}`,
+				Result:     `{"execution_metadata": "metadata", "detected_start_command": {"web": "./app"}}`,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.ExecutionMetadata).To(Equal("metadata"))
+			Expect(response.DetectedStartCommand).To(Equal(map[string]string{"web": "./app"}))
+		})
+
+		It("sanitizes failures", func() {
+			response, err := custom.BuildStagingResponse(&models.TaskCallbackResponse{
+				Failed:        true,
+				FailureReason: "oops",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Error).To(Equal(&cc_messages.StagingError{Message: "oops was totally sanitized"}))
+		})
+	})
+
+	Describe("LoadCustomLifecycles", func() {
+		It("reads a JSON list of lifecycles", func() {
+			file, err := ioutil.TempFile("", "custom-lifecycles")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString(`[{"name": "binary", "bundle_url": "binary.tgz", "builder_path": "/tmp/binary/builder", "args": ["-app={{.AppId}}"]}]`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			lifecycles, err := backend.LoadCustomLifecycles(file.Name())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lifecycles).To(Equal([]backend.CustomLifecycle{{
+				Name:        "binary",
+				BundleURL:   "binary.tgz",
+				BuilderPath: "/tmp/binary/builder",
+				Args:        []string{"-app={{.AppId}}"},
+			}}))
+		})
+	})
+})
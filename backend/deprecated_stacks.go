@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+var ErrDeprecatedStackFormatInvalid = errors.New("deprecated stacks must be stack[=message]")
+
+// DeprecatedStacks maps stacks that operators are retiring to the warning
+// shown when apps stage on them. It implements flag.Value so that stacks can
+// be configured by repeating a command line flag.
+type DeprecatedStacks map[string]string
+
+func (d *DeprecatedStacks) String() string {
+	stacks := make([]string, 0, len(*d))
+	for stack := range *d {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	return strings.Join(stacks, ",")
+}
+
+func (d *DeprecatedStacks) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if parts[0] == "" {
+		return ErrDeprecatedStackFormatInvalid
+	}
+
+	message := fmt.Sprintf("%s is deprecated, please migrate your app to a newer stack", parts[0])
+	if len(parts) == 2 && parts[1] != "" {
+		message = parts[1]
+	}
+
+	if *d == nil {
+		*d = DeprecatedStacks{}
+	}
+	(*d)[parts[0]] = message
+	return nil
+}
+
+// StackWarningFor returns the warning for staging on stack, or "" if the
+// stack isn't deprecated.
+func (c Config) StackWarningFor(stack string) string {
+	message, ok := c.DeprecatedStacks[stack]
+	if !ok {
+		return ""
+	}
+	return "WARNING: " + message
+}
+
+// withStackWarning puts the stack's warning, if any, on the line before a
+// progress message, so that developers see it as their app stages.
+func withStackWarning(message, warning string) string {
+	if warning == "" {
+		return message
+	}
+	return warning + "\n" + message
+}
+
+// stagingTaskAnnotation carries the stack's warning from the staging task to
+// its staging response.
+type stagingTaskAnnotation struct {
+	cc_messages.StagingTaskAnnotation
+	StackWarning string `json:"stack_warning,omitempty"`
+}
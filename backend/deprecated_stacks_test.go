@@ -0,0 +1,85 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Deprecated stacks", func() {
+	var (
+		config      backend.Config
+		traditional backend.Backend
+	)
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+			DeprecatedStacks: backend.DeprecatedStacks{
+				"cflinuxfs2": "cflinuxfs2 is EOL, please migrate",
+			},
+		}
+		traditional = backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test"))
+	})
+
+	buildRecipe := func() *models.TaskDefinition {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`)
+		taskDef, _, _, err := traditional.BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return taskDef
+	}
+
+	It("warns developers as their app starts staging", func() {
+		actions := actionsFromTaskDef(buildRecipe())
+		Expect(actions[1].GetEmitProgressAction().StartMessage).To(HavePrefix("WARNING: cflinuxfs2 is EOL, please migrate\n"))
+	})
+
+	It("repeats the warning in the staging response", func() {
+		response, err := traditional.BuildStagingResponse(&models.TaskCallbackResponse{
+			Annotation: buildRecipe().Annotation,
+			Result:     `{"buildpack_key": "buildpack-key", "detected_buildpack": "detected-buildpack"}`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*response.LifecycleData).To(MatchJSON(`{
+			"buildpack_key": "buildpack-key",
+			"detected_buildpack": "detected-buildpack",
+			"stack_warning": "WARNING: cflinuxfs2 is EOL, please migrate"
+		}`))
+	})
+
+	It("doesn't warn about other stacks", func() {
+		Expect(config.StackWarningFor("cflinuxfs3")).To(BeEmpty())
+	})
+
+	Describe("DeprecatedStacks", func() {
+		It("parses stack=message", func() {
+			stacks := backend.DeprecatedStacks{}
+			Expect(stacks.Set("cflinuxfs2=cflinuxfs2 is EOL, please migrate")).To(Succeed())
+			Expect(stacks).To(Equal(backend.DeprecatedStacks{"cflinuxfs2": "cflinuxfs2 is EOL, please migrate"}))
+		})
+
+		It("defaults the message", func() {
+			stacks := backend.DeprecatedStacks{}
+			Expect(stacks.Set("cflinuxfs2")).To(Succeed())
+			Expect(stacks).To(Equal(backend.DeprecatedStacks{"cflinuxfs2": "cflinuxfs2 is deprecated, please migrate your app to a newer stack"}))
+		})
+
+		It("rejects stacks without a name", func() {
+			stacks := backend.DeprecatedStacks{}
+			Expect(stacks.Set("=message")).To(Equal(backend.ErrDeprecatedStackFormatInvalid))
+		})
+	})
+})
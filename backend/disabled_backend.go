@@ -0,0 +1,23 @@
+package backend
+
+import (
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+var ErrLifecycleNotSupported = NewValidationError(LifecycleNotSupportedErrorId, "lifecycle not supported on this deployment")
+
+type disabledBackend struct {
+	Backend
+}
+
+// NewDisabledBackend turns off a lifecycle: staging requests for it fail
+// with ErrLifecycleNotSupported before any recipe is built. Staging tasks
+// desired before it was turned off still complete through backend.
+func NewDisabledBackend(backend Backend) Backend {
+	return disabledBackend{Backend: backend}
+}
+
+func (disabledBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	return nil, "", "", ErrLifecycleNotSupported
+}
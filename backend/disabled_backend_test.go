@@ -0,0 +1,38 @@
+package backend_test
+
+import (
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DisabledBackend", func() {
+	var (
+		fakeBackend *fake_backend.FakeBackend
+		disabled    backend.Backend
+	)
+
+	BeforeEach(func() {
+		fakeBackend = &fake_backend.FakeBackend{}
+		disabled = backend.NewDisabledBackend(fakeBackend)
+	})
+
+	It("rejects staging requests without building a recipe", func() {
+		_, _, _, err := disabled.BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{Lifecycle: "docker"})
+		Expect(err).To(Equal(backend.ErrLifecycleNotSupported))
+		Expect(backend.StagingErrorFor(err).Id).To(Equal(backend.LifecycleNotSupportedErrorId))
+		Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+	})
+
+	It("still builds staging responses for tasks desired before it was disabled", func() {
+		fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{ExecutionMetadata: "metadata"}, nil)
+
+		response, err := disabled.BuildStagingResponse(&models.TaskCallbackResponse{TaskGuid: "task-guid"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.ExecutionMetadata).To(Equal("metadata"))
+		Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(1))
+	})
+})
@@ -0,0 +1,215 @@
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+const (
+	// DockerAuthConfigEnvVar gives the builder registry credentials in the
+	// format of docker's config.json, so that it can pull image metadata and
+	// layers from registries that require v2 token authentication.
+	DockerAuthConfigEnvVar = "DOCKER_AUTH_CONFIG"
+
+	// DockerCredentialsCredhubRefEnvVar names the CredHub credential that
+	// the builder resolves the login server's username and password from.
+	// Only builders that resolve CredHub references read it.
+	DockerCredentialsCredhubRefEnvVar = "DOCKER_CREDENTIALS_CREDHUB_REF"
+
+	// DefaultDockerLoginServer is the registry that tokens are for when the
+	// lifecycle data doesn't name one.
+	DefaultDockerLoginServer = "https://index.docker.io/v1/"
+)
+
+var (
+	ErrDockerCredentialHelperFormatInvalid = errors.New("docker credential helpers must be registry=helper")
+	ErrInlineDockerCredentials             = NewValidationError(InlineDockerCredentialsErrorId, "docker passwords must be given as docker_credentials_credhub_ref")
+)
+
+// DockerCredentialHelpers maps registries to the docker credential helpers,
+// e.g. ecr-login, that the builder uses to authenticate to them. It
+// implements flag.Value so that helpers can be configured by repeating a
+// command line flag.
+type DockerCredentialHelpers map[string]string
+
+func (h *DockerCredentialHelpers) String() string {
+	helpers := make([]string, 0, len(*h))
+	for registry, helper := range *h {
+		helpers = append(helpers, registry+"="+helper)
+	}
+	sort.Strings(helpers)
+	return strings.Join(helpers, ",")
+}
+
+func (h *DockerCredentialHelpers) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ErrDockerCredentialHelperFormatInvalid
+	}
+
+	if *h == nil {
+		*h = DockerCredentialHelpers{}
+	}
+	(*h)[parts[0]] = parts[1]
+	return nil
+}
+
+type dockerAuthConfig struct {
+	Auths       map[string]dockerRegistryAuth `json:"auths,omitempty"`
+	CredHelpers map[string]string             `json:"credHelpers,omitempty"`
+}
+
+type dockerRegistryAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// dockerConfigAuth is an entry of a docker config.json or .dockercfg, which
+// may give the username and password instead of their encoded auth.
+type dockerConfigAuth struct {
+	dockerRegistryAuth
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// parseDockerConfigJSON returns the per-registry credentials of a docker
+// config.json, or of a legacy .dockercfg, which has no auths key.
+func parseDockerConfigJSON(configJSON json.RawMessage) (map[string]dockerRegistryAuth, error) {
+	var config struct {
+		Auths map[string]dockerConfigAuth `json:"auths"`
+	}
+	err := json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, NewValidationError(InvalidLifecycleDataErrorId, "invalid docker_config_json: "+err.Error())
+	}
+
+	entries := config.Auths
+	if entries == nil {
+		err = json.Unmarshal(configJSON, &entries)
+		if err != nil {
+			return nil, NewValidationError(InvalidLifecycleDataErrorId, "invalid docker_config_json: "+err.Error())
+		}
+	}
+
+	auths := make(map[string]dockerRegistryAuth, len(entries))
+	for registry, entry := range entries {
+		auth := entry.dockerRegistryAuth
+		if auth.Auth == "" && entry.Username != "" {
+			auth.Auth = base64.StdEncoding.EncodeToString([]byte(entry.Username + ":" + entry.Password))
+		}
+		auths[registry] = auth
+	}
+	return auths, nil
+}
+
+// dockerAuthConfig returns the variable that gives the builder the
+// credentials of the docker config.json in the lifecycle data's
+// docker_config_json, the bearer token in its docker_registry_token, for its
+// login server, the configured credential helpers, and credentials for the
+// image's registry from the service account the lifecycle data names as
+// docker_service_account, or if it is a configured ECR registry. It returns
+// nil when there are none.
+//
+// The variable, like the builder's arguments, is part of the task
+// definition, so the credentials it holds are stored in the BBS. Unless
+// AllowInlineDockerCredentials is set, passwords given in the staging
+// request are rejected instead.
+func (c Config) dockerAuthConfig(lifecycleData json.RawMessage, stagingData cc_messages.DockerStagingData) (*models.EnvironmentVariable, error) {
+	var options struct {
+		DockerConfigJSON     json.RawMessage `json:"docker_config_json"`
+		DockerRegistryToken  string          `json:"docker_registry_token"`
+		DockerServiceAccount string          `json:"docker_service_account"`
+	}
+	json.Unmarshal(lifecycleData, &options)
+
+	if !c.AllowInlineDockerCredentials && stagingData.DockerPassword != "" {
+		return nil, ErrInlineDockerCredentials
+	}
+
+	config := dockerAuthConfig{}
+	if len(options.DockerConfigJSON) > 0 && string(options.DockerConfigJSON) != "null" {
+		auths, err := parseDockerConfigJSON(options.DockerConfigJSON)
+		if err != nil {
+			return nil, err
+		}
+		if !c.AllowInlineDockerCredentials {
+			for _, auth := range auths {
+				if auth.Auth != "" {
+					return nil, ErrInlineDockerCredentials
+				}
+			}
+		}
+		config.Auths = auths
+	}
+
+	if len(c.DockerCredentialHelpers) > 0 {
+		config.CredHelpers = map[string]string{}
+		for registry, helper := range c.DockerCredentialHelpers {
+			config.CredHelpers[registry] = helper
+		}
+	}
+
+	if options.DockerRegistryToken != "" {
+		loginServer := stagingData.DockerLoginServer
+		if loginServer == "" {
+			loginServer = DefaultDockerLoginServer
+		}
+		if config.Auths == nil {
+			config.Auths = map[string]dockerRegistryAuth{}
+		}
+		config.Auths[loginServer] = dockerRegistryAuth{RegistryToken: options.DockerRegistryToken}
+	}
+
+	if options.DockerServiceAccount != "" {
+		auth, err := c.gcrAuth(options.DockerServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.Auths == nil {
+			config.Auths = map[string]dockerRegistryAuth{}
+		}
+		config.Auths[DockerImageRegistry(stagingData.DockerImageUrl)] = auth
+	}
+
+	if c.ECRAuthenticator != nil {
+		err := c.ECRAuthenticator.Authenticate(DockerImageRegistry(stagingData.DockerImageUrl), &config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Auths) == 0 && len(config.CredHelpers) == 0 {
+		return nil, nil
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.EnvironmentVariable{Name: DockerAuthConfigEnvVar, Value: string(configJSON)}, nil
+}
+
+// dockerCredentialsCredhubRef returns the variable that names the CredHub
+// credential, given as the lifecycle data's docker_credentials_credhub_ref,
+// holding the username and password for its login server. Only the reference
+// is stored in the BBS. It returns nil when there is none.
+func dockerCredentialsCredhubRef(lifecycleData json.RawMessage) *models.EnvironmentVariable {
+	var options struct {
+		CredhubRef string `json:"docker_credentials_credhub_ref"`
+	}
+	json.Unmarshal(lifecycleData, &options)
+
+	if options.CredhubRef == "" {
+		return nil
+	}
+	return &models.EnvironmentVariable{Name: DockerCredentialsCredhubRefEnvVar, Value: options.CredhubRef}
+}
@@ -0,0 +1,166 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker registry authentication", func() {
+	var (
+		config        backend.Config
+		lifecycleData string
+	)
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+			AllowInlineDockerCredentials: true,
+		}
+		lifecycleData = `{"docker_image": "busybox"}`
+	})
+
+	builderEnv := func() []*models.EnvironmentVariable {
+		data := json.RawMessage(lifecycleData)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		return actions[len(actions)-1].GetEmitProgressAction().Action.GetRunAction().Env
+	}
+
+	authConfig := func(env []*models.EnvironmentVariable) string {
+		for _, envVar := range env {
+			if envVar.Name == backend.DockerAuthConfigEnvVar {
+				return envVar.Value
+			}
+		}
+		return ""
+	}
+
+	It("gives the builder no auth config by default", func() {
+		Expect(authConfig(builderEnv())).To(BeEmpty())
+	})
+
+	It("gives the builder a requested registry token for Docker Hub", func() {
+		lifecycleData = `{"docker_image": "busybox", "docker_registry_token": "the-token"}`
+		Expect(authConfig(builderEnv())).To(MatchJSON(`{"auths": {"https://index.docker.io/v1/": {"registrytoken": "the-token"}}}`))
+	})
+
+	It("gives the builder a requested registry token for the login server", func() {
+		lifecycleData = `{"docker_image": "harbor.example.com/team/app", "docker_login_server": "https://harbor.example.com", "docker_registry_token": "the-token"}`
+		Expect(authConfig(builderEnv())).To(MatchJSON(`{"auths": {"https://harbor.example.com": {"registrytoken": "the-token"}}}`))
+	})
+
+	It("leaves a requested username and password to the builder's arguments", func() {
+		lifecycleData = `{"docker_image": "harbor.example.com/team/app", "docker_login_server": "https://harbor.example.com", "docker_user": "user", "docker_password": "password", "docker_email": "user@example.com"}`
+		Expect(authConfig(builderEnv())).To(BeEmpty())
+	})
+
+	It("gives the builder a requested CredHub reference for the login server's credentials", func() {
+		lifecycleData = `{"docker_image": "busybox", "docker_credentials_credhub_ref": "/c/docker/app-credentials"}`
+		Expect(builderEnv()).To(ContainElement(&models.EnvironmentVariable{Name: backend.DockerCredentialsCredhubRefEnvVar, Value: "/c/docker/app-credentials"}))
+		Expect(authConfig(builderEnv())).To(BeEmpty())
+	})
+
+	It("gives the builder the configured credential helpers", func() {
+		config.DockerCredentialHelpers = backend.DockerCredentialHelpers{"123456789.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}
+		Expect(authConfig(builderEnv())).To(MatchJSON(`{"credHelpers": {"123456789.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}}`))
+	})
+
+	It("gives the builder the registries' credentials from a docker config.json", func() {
+		lifecycleData = `{"docker_image": "registry.example.com/team/app", "docker_config_json": {"auths": {
+			"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="},
+			"base-images.example.com": {"username": "base", "password": "secret"}
+		}}}`
+		Expect(authConfig(builderEnv())).To(MatchJSON(`{"auths": {
+			"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="},
+			"base-images.example.com": {"auth": "YmFzZTpzZWNyZXQ="}
+		}}`))
+	})
+
+	It("accepts a legacy .dockercfg", func() {
+		lifecycleData = `{"docker_image": "registry.example.com/team/app", "docker_config_json": {"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="}}}`
+		Expect(authConfig(builderEnv())).To(MatchJSON(`{"auths": {"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="}}}`))
+	})
+
+	It("prefers a requested registry token to the docker config.json's credentials", func() {
+		lifecycleData = `{"docker_image": "busybox", "docker_registry_token": "the-token", "docker_config_json": {"auths": {
+			"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNzd29yZA=="},
+			"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="}
+		}}}`
+		Expect(authConfig(builderEnv())).To(MatchJSON(`{"auths": {
+			"https://index.docker.io/v1/": {"registrytoken": "the-token"},
+			"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="}
+		}}`))
+	})
+
+	It("rejects an invalid docker config.json", func() {
+		data := json.RawMessage(`{"docker_image": "busybox", "docker_config_json": ["registry.example.com"]}`)
+		_, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidLifecycleDataErrorId))
+	})
+
+	Context("when inline docker credentials aren't allowed, as by default", func() {
+		BeforeEach(func() {
+			config.AllowInlineDockerCredentials = false
+		})
+
+		buildRecipe := func() error {
+			data := json.RawMessage(lifecycleData)
+			_, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+				AppId:         "bunny",
+				Lifecycle:     "docker",
+				LifecycleData: &data,
+			})
+			return err
+		}
+
+		It("rejects a username and password", func() {
+			lifecycleData = `{"docker_image": "busybox", "docker_user": "user", "docker_password": "password", "docker_email": "user@example.com"}`
+			Expect(buildRecipe()).To(Equal(backend.ErrInlineDockerCredentials))
+		})
+
+		It("rejects a docker config.json holding passwords", func() {
+			lifecycleData = `{"docker_image": "busybox", "docker_config_json": {"auths": {"registry.example.com": {"username": "user", "password": "password"}}}}`
+			Expect(buildRecipe()).To(Equal(backend.ErrInlineDockerCredentials))
+		})
+
+		It("accepts a CredHub reference", func() {
+			lifecycleData = `{"docker_image": "busybox", "docker_credentials_credhub_ref": "/c/docker/app-credentials"}`
+			Expect(buildRecipe()).To(Succeed())
+		})
+	})
+
+	Describe("DockerCredentialHelpers", func() {
+		It("parses registry=helper", func() {
+			helpers := backend.DockerCredentialHelpers{}
+			Expect(helpers.Set("gcr.io=gcloud")).To(Succeed())
+			Expect(helpers).To(Equal(backend.DockerCredentialHelpers{"gcr.io": "gcloud"}))
+		})
+
+		It("rejects malformed helpers", func() {
+			helpers := backend.DockerCredentialHelpers{}
+			Expect(helpers.Set("gcr.io")).To(Equal(backend.ErrDockerCredentialHelperFormatInvalid))
+			Expect(helpers.Set("gcr.io=")).To(Equal(backend.ErrDockerCredentialHelperFormatInvalid))
+		})
+	})
+})
@@ -2,13 +2,13 @@ package backend
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,26 +26,74 @@ const (
 	DockerLifecycleName         = "docker"
 	DockerBuilderExecutablePath = "/tmp/docker_app_lifecycle/builder"
 	DockerBuilderOutputPath     = "/tmp/docker-result/result.json"
+
+	// ConsulTokenHeader carries the ACL token on docker registry discovery.
+	ConsulTokenHeader = "X-Consul-Token"
+
+	// DefaultConsulTimeout bounds each docker registry discovery request to
+	// consul when Config.ConsulTimeout is unset.
+	DefaultConsulTimeout = 5 * time.Second
+
+	consulRetryInterval = 250 * time.Millisecond
 )
 
-var ErrMissingDockerImageUrl = errors.New(diego_errors.MISSING_DOCKER_IMAGE_URL)
-var ErrMissingDockerRegistry = errors.New(diego_errors.MISSING_DOCKER_REGISTRY)
-var ErrMissingDockerCredentials = errors.New(diego_errors.MISSING_DOCKER_CREDENTIALS)
-var ErrInvalidDockerRegistryAddress = errors.New(diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS)
+var ErrMissingDockerImageUrl = NewValidationError(MissingDockerImageUrlErrorId, diego_errors.MISSING_DOCKER_IMAGE_URL)
+var ErrMissingDockerRegistry = NewDependencyError(MissingDockerRegistryErrorId, diego_errors.MISSING_DOCKER_REGISTRY)
+var ErrMissingDockerCredentials = NewValidationError(MissingDockerCredentialsErrorId, diego_errors.MISSING_DOCKER_CREDENTIALS)
+var ErrInvalidDockerRegistryAddress = NewConfigurationError(InvalidDockerRegistryAddressErrorId, diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS)
 
 type dockerBackend struct {
-	config Config
-	logger lager.Logger
+	config       Config
+	logger       lager.Logger
+	consulClient *http.Client
+	resolver     HostResolver
+
+	registryCache *dockerRegistryCache
 }
 
 type consulServiceInfo struct {
 	Address string
 }
 
+// consulServiceHealth is an entry of consul's health endpoint. The service's
+// own address, when registered, takes precedence over its node's.
+type consulServiceHealth struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+	}
+}
+
 func NewDockerBackend(config Config, logger lager.Logger) Backend {
+	proxy := config.ConsulProxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	consulTimeout := config.ConsulTimeout
+	if consulTimeout <= 0 {
+		consulTimeout = DefaultConsulTimeout
+	}
+
+	consulClient := &http.Client{
+		Transport: &http.Transport{Proxy: proxy, TLSClientConfig: config.ConsulTLSConfig},
+		Timeout:   consulTimeout,
+	}
+
+	resolver := config.HostResolver
+	if resolver == nil {
+		resolver = netResolver{}
+	}
+
 	return &dockerBackend{
-		config: config,
-		logger: logger.Session("docker"),
+		config:       config,
+		logger:       logger.Session("docker"),
+		consulClient: consulClient,
+		resolver:     resolver,
+
+		registryCache: &dockerRegistryCache{},
 	}
 }
 
@@ -56,46 +104,108 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 	var lifecycleData cc_messages.DockerStagingData
 	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
 	if err != nil {
-		return &models.TaskDefinition{}, "", "", err
+		return &models.TaskDefinition{}, "", "", NewValidationError(InvalidLifecycleDataErrorId, err.Error())
 	}
 
-	err = backend.validateRequest(request, lifecycleData)
+	tarball, err := parseDockerImageTarball(*request.LifecycleData)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	compilerURL, err := backend.compilerDownloadURL()
+	err = backend.validateRequest(request, lifecycleData, tarball != nil)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	cacheDockerImage := false
-	for _, envVar := range request.Environment {
-		if envVar.Name == "DIEGO_DOCKER_CACHE" && envVar.Value == "true" {
-			cacheDockerImage = true
-			break
+	if lifecycleData.DockerImageUrl != "" {
+		err = backend.config.checkDockerRegistry(lifecycleData.DockerImageUrl)
+		if err != nil {
+			return &models.TaskDefinition{}, "", "", err
 		}
 	}
 
+	stack, windows := backend.config.dockerStagingStack(*request.LifecycleData)
+	lifecycleKey := dockerLifecycleKey(stack, windows)
+
+	request = backend.config.withResourceMinimums(stack, request)
+
+	compilerURL, err := backend.compilerDownloadURL(lifecycleKey)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	trustedCertsDownload, trustedCertsEnv, err := backend.config.trustedCerts()
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	dockerAuthEnv, err := backend.config.dockerAuthConfig(*request.LifecycleData, lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	registryCAsEnv, err := backend.config.dockerRegistryCAs()
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	cacheDockerImage, err := backend.config.cachesDockerImage(*request.LifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	// Windows cells have no docker daemon to cache the image with.
+	if windows && cacheDockerImage {
+		logger.Info("not-caching-windows-image")
+		cacheDockerImage = false
+	}
+
+	layout := backend.config.dockerBuilderLayout(lifecycleKey, windows)
+	timeout := backend.config.cappedTimeout(dockerTimeout(request, backend.logger), request, backend.logger)
+
 	actions := []models.ActionInterface{}
 
 	//Download builder
 	actions = append(
 		actions,
 		models.EmitProgressFor(
-			&models.DownloadAction{
+			withChecksum(&models.DownloadAction{
 				From:     compilerURL.String(),
-				To:       path.Dir(DockerBuilderExecutablePath),
+				To:       path.Dir(layout.BuilderPath),
 				CacheKey: "docker-lifecycle",
 				User:     "vcap",
-			},
+			}, backend.config.lifecycleChecksum(lifecycleKey)),
 			"",
 			"",
 			"Failed to set up docker environment",
 		),
 	)
 
-	runActionArguments := []string{"-outputMetadataJSONFilename", DockerBuilderOutputPath, "-dockerRef", lifecycleData.DockerImageUrl}
+	//Download trusted CA certificates
+	if trustedCertsDownload != nil {
+		actions = append(actions, trustedCertsDownload)
+	}
+
+	runActionArguments := []string{"-outputMetadataJSONFilename", layout.ResultFile}
+	if lifecycleData.DockerImageUrl != "" {
+		runActionArguments = append(runActionArguments, "-dockerRef", lifecycleData.DockerImageUrl)
+	}
+
+	//Download exported image
+	if tarball != nil {
+		tarballDownload, err := backend.config.dockerImageTarballDownload(tarball, timeout)
+		if err != nil {
+			return &models.TaskDefinition{}, "", "", err
+		}
+		actions = append(actions, tarballDownload)
+		runActionArguments = append(runActionArguments, "-dockerImagePath", DockerImageTarballPath)
+	}
+
+	runActionArguments = addDockerRegistryMirrorArguments(runActionArguments, backend.config.DockerRegistryMirrors)
+	runActionArguments = addDockerImageSizeArguments(runActionArguments, backend.config.MaxDockerImageSizeMB)
+	if !windows {
+		runActionArguments = addDockerPlatformArguments(runActionArguments, backend.config.DockerStagingPlatform)
+	}
 	runAs := "vcap"
 	if cacheDockerImage {
 		runAs = "root"
@@ -106,19 +216,36 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 			return &models.TaskDefinition{}, "", "", ErrInvalidDockerRegistryAddress
 		}
 
-		registryServices, err := getDockerRegistryServices(backend.config.ConsulCluster, backend.logger)
+		registryPort, err := strconv.ParseUint(port, 10, 16)
 		if err != nil {
-			return &models.TaskDefinition{}, "", "", err
+			logger.Debug("invalid docker registry port", lager.Data{"address": backend.config.DockerRegistryAddress, "error": err.Error()})
+			return &models.TaskDefinition{}, "", "", ErrInvalidDockerRegistryAddress
 		}
-		registryRules := addDockerRegistryRules(request.EgressRules, registryServices)
-		request.EgressRules = append(request.EgressRules, registryRules...)
 
-		registryIPs := strings.Join(buildDockerRegistryAddresses(registryServices), ",")
-
-		runActionArguments, err = addDockerCachingArguments(runActionArguments, registryIPs, backend.config.InsecureDockerRegistry, host, port, lifecycleData)
+		registryServices, err := backend.cachedDockerRegistryServices(host)
 		if err != nil {
 			return &models.TaskDefinition{}, "", "", err
 		}
+		request.EgressRules = append(request.EgressRules, dockerRegistryRules(registryServices, uint32(registryPort), logger)...)
+
+		registryIPs := strings.Join(buildDockerRegistryAddresses(registryServices), ",")
+
+		runActionArguments = addDockerCachingArguments(runActionArguments, registryIPs, backend.config.InsecureDockerRegistry, host, port, lifecycleData)
+	}
+
+	// Without caching, the builder only needs the image's manifest and
+	// config, which it can fetch without a docker daemon.
+	metadataOnly := backend.config.DockerMetadataOnly && !cacheDockerImage
+	if metadataOnly {
+		runActionArguments = append(runActionArguments, "-metadataOnly")
+	}
+
+	// Windows containers can't run privileged, or limit their processes.
+	privileged := backend.config.privilegedFor(DockerLifecycleName, !metadataOnly)
+	maxPids := backend.config.MaxStagingPids
+	if windows {
+		privileged = false
+		maxPids = 0
 	}
 
 	fileDescriptorLimit := uint64(request.FileDescriptors)
@@ -128,9 +255,9 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		actions,
 		models.EmitProgressFor(
 			&models.RunAction{
-				Path: DockerBuilderExecutablePath,
+				Path: layout.BuilderPath,
 				Args: runActionArguments,
-				Env:  request.Environment,
+				Env:  backend.builderEnvironment(request, trustedCertsEnv, dockerAuthEnv, dockerCredentialsCredhubRef(*request.LifecycleData), registryCAsEnv),
 				ResourceLimits: &models.ResourceLimits{
 					Nofile: &fileDescriptorLimit,
 				},
@@ -142,26 +269,41 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		),
 	)
 
+	volumeMounts, err := volumeMountsFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	scheduling, err := backend.config.schedulingFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
 	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
 		Lifecycle: DockerLifecycleName,
 	})
 
 	taskDefinition := &models.TaskDefinition{
-		RootFs:                models.PreloadedRootFS(backend.config.DockerStagingStack),
-		ResultFile:            DockerBuilderOutputPath,
-		Privileged:            true,
+		RootFs:                backend.config.RootFSFor(stack),
+		ResultFile:            layout.ResultFile,
+		Privileged:            privileged,
 		MemoryMb:              int32(request.MemoryMB),
 		LogSource:             TaskLogSource,
 		LogGuid:               request.LogGuid,
-		EgressRules:           request.EgressRules,
+		EgressRules:           backend.config.egressRules(request.EgressRules),
+		Network:               scheduling.Network,
+		PlacementTags:         scheduling.PlacementTags,
+		LogRateLimit:          scheduling.LogRateLimit,
+		VolumeMounts:          volumeMounts,
 		DiskMb:                int32(request.DiskMB),
+		MaxPids:               int32(maxPids),
 		CompletionCallbackUrl: backend.config.CallbackURL(stagingGuid),
 		Annotation:            string(annotationJson),
-		Action:                models.WrapAction(models.Timeout(models.Serial(actions...), dockerTimeout(request, backend.logger))),
+		Action:                models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
 	}
 	logger.Debug("staging-task-request")
 
-	return taskDefinition, stagingGuid, backend.config.TaskDomain, nil
+	return taskDefinition, stagingGuid, backend.config.TaskDomainFor(DockerLifecycleName), nil
 }
 
 func (backend *dockerBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
@@ -176,13 +318,17 @@ func (backend *dockerBackend) BuildStagingResponse(taskResponse *models.TaskCall
 	if taskResponse.Failed {
 		response.Error = backend.config.Sanitizer(taskResponse.FailureReason)
 	} else {
-		var result docker_app_lifecycle.StagingDockerResult
+		var result struct {
+			docker_app_lifecycle.StagingDockerResult
+			DockerImageDigest    string `json:"docker_image_digest"`
+			DockerImageMediaType string `json:"docker_image_media_type"`
+		}
 		err := json.Unmarshal([]byte(taskResponse.Result), &result)
 		if err != nil {
 			return cc_messages.StagingResponseForCC{}, err
 		}
 
-		dockerLifecycleData, err := helpers.BuildDockerStagingData(result.DockerImage)
+		dockerLifecycleData, err := helpers.BuildPinnedDockerStagingData(result.DockerImage, result.DockerImageDigest, result.DockerImageMediaType)
 		if err != nil {
 			return cc_messages.StagingResponseForCC{}, err
 		}
@@ -195,15 +341,25 @@ func (backend *dockerBackend) BuildStagingResponse(taskResponse *models.TaskCall
 	return response, nil
 }
 
-func (backend *dockerBackend) compilerDownloadURL() (*url.URL, error) {
-	lifecycleFilename := backend.config.Lifecycles["docker"]
+// builderEnvironment is the staging environment, with any of the given
+// variables set.
+func (backend *dockerBackend) builderEnvironment(request cc_messages.StagingRequestFromCC, envVars ...*models.EnvironmentVariable) []*models.EnvironmentVariable {
+	environment := backend.config.stagingEnvironment(request.Environment)
+	for _, envVar := range envVars {
+		environment = withEnvironmentVariable(environment, envVar)
+	}
+	return environment
+}
+
+func (backend *dockerBackend) compilerDownloadURL(lifecycleKey string) (*url.URL, error) {
+	lifecycleFilename := backend.config.Lifecycles[lifecycleKey]
 	if lifecycleFilename == "" {
 		return nil, ErrNoCompilerDefined
 	}
 
 	parsed, err := url.Parse(lifecycleFilename)
 	if err != nil {
-		return nil, errors.New("couldn't parse compiler URL")
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, "couldn't parse compiler URL")
 	}
 
 	switch parsed.Scheme {
@@ -212,30 +368,30 @@ func (backend *dockerBackend) compilerDownloadURL() (*url.URL, error) {
 	case "":
 		break
 	default:
-		return nil, fmt.Errorf("unknown scheme: '%s'", parsed.Scheme)
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, fmt.Sprintf("unknown scheme: '%s'", parsed.Scheme))
 	}
 
 	staticPath, err := fileserver.Routes.CreatePathForRoute(fileserver.StaticRoute, nil)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't generate the compiler download path: %s", err)
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, fmt.Sprintf("couldn't generate the compiler download path: %s", err))
 	}
 
 	urlString := urljoiner.Join(backend.config.FileServerURL, staticPath, lifecycleFilename)
 
 	url, err := url.ParseRequestURI(urlString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse compiler download URL: %s", err)
+		return nil, NewConfigurationError(InvalidCompilerURLErrorId, fmt.Sprintf("failed to parse compiler download URL: %s", err))
 	}
 
 	return url, nil
 }
 
-func (backend *dockerBackend) validateRequest(stagingRequest cc_messages.StagingRequestFromCC, dockerData cc_messages.DockerStagingData) error {
+func (backend *dockerBackend) validateRequest(stagingRequest cc_messages.StagingRequestFromCC, dockerData cc_messages.DockerStagingData, fromTarball bool) error {
 	if len(stagingRequest.AppId) == 0 {
 		return ErrMissingAppId
 	}
 
-	if len(dockerData.DockerImageUrl) == 0 {
+	if len(dockerData.DockerImageUrl) == 0 && !fromTarball {
 		return ErrMissingDockerImageUrl
 	}
 
@@ -260,44 +416,108 @@ func dockerTimeout(request cc_messages.StagingRequestFromCC, logger lager.Logger
 	}
 }
 
-func addDockerRegistryRules(egressRules []*models.SecurityGroupRule, registries []consulServiceInfo) []*models.SecurityGroupRule {
+// dockerRegistryRules allow egress to each registry instance, whether
+// discovery returned it as an IPv4 or IPv6 address or as a CIDR. Addresses
+// that are neither, e.g. host names, are skipped.
+func dockerRegistryRules(registries []consulServiceInfo, port uint32, logger lager.Logger) []*models.SecurityGroupRule {
+	var egressRules []*models.SecurityGroupRule
 	for _, registry := range registries {
+		destination, ok := dockerRegistryDestination(registry.Address)
+		if !ok {
+			logger.Info("skipping-invalid-docker-registry-address", lager.Data{"address": registry.Address})
+			continue
+		}
+
 		egressRules = append(egressRules, &models.SecurityGroupRule{
 			Protocol:     models.TCPProtocol,
-			Destinations: []string{registry.Address},
-			Ports:        []uint32{8080},
+			Destinations: []string{destination},
+			Ports:        []uint32{port},
 		})
 	}
 
 	return egressRules
 }
 
+// buildDockerRegistryAddresses returns the IPs of the registry instances for
+// the builder. CIDRs don't name an instance, so they are left out.
 func buildDockerRegistryAddresses(services []consulServiceInfo) []string {
 	registries := make([]string, 0, len(services))
 	for _, service := range services {
-		registries = append(registries, service.Address)
+		destination, ok := dockerRegistryDestination(service.Address)
+		if ok && !strings.Contains(destination, "/") {
+			registries = append(registries, destination)
+		}
 	}
 	return registries
 }
 
-func getDockerRegistryServices(consulCluster string, backendLogger lager.Logger) ([]consulServiceInfo, error) {
-	logger := backendLogger.Session("docker-registry-consul-services")
+// dockerRegistryDestination normalizes a discovered registry address, which
+// may be a bracketed or zoned IPv6 address, to an egress rule destination.
+func dockerRegistryDestination(address string) (string, bool) {
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
 
-	response, err := http.Get(consulCluster + "/v1/catalog/service/docker-registry")
-	if err != nil {
-		return nil, err
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return "", false
+		}
+		return network.String(), true
+	}
+
+	if zone := strings.Index(address, "%"); zone >= 0 {
+		address = address[:zone]
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// getConsulDockerRegistryServices asks consul for the passing registry
+// instances. Requests that fail or time out, and 5xx responses, are retried
+// up to ConsulRetryAttempts times.
+func (backend *dockerBackend) getConsulDockerRegistryServices() ([]consulServiceInfo, error) {
+	logger := backend.logger.Session("docker-registry-consul-services")
+
+	var body []byte
+	var err error
+	for attempt := 0; attempt <= backend.config.ConsulRetryAttempts; attempt++ {
+		if attempt > 0 {
+			logger.Info("retrying", lager.Data{"attempt": attempt, "error": err.Error()})
+			backend.config.clock().Sleep(consulRetryInterval)
+		}
+
+		body, err = backend.queryConsul("/v1/health/service/docker-registry?passing")
+		if err == nil {
+			break
+		}
+		if statusErr, ok := err.(consulStatusError); ok && statusErr.statusCode < http.StatusInternalServerError {
+			break
+		}
 	}
 
-	defer response.Body.Close()
-	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, err
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, NewExhaustedDependencyError(DockerRegistryDiscoveryTimeoutErrorId, err.Error())
+		}
+		return nil, NewExhaustedDependencyError(DockerRegistryDiscoveryErrorId, err.Error())
 	}
 
-	var ips []consulServiceInfo
-	err = json.Unmarshal(body, &ips)
+	var entries []consulServiceHealth
+	err = json.Unmarshal(body, &entries)
 	if err != nil {
-		return nil, err
+		return nil, NewDependencyError(DockerRegistryDiscoveryErrorId, err.Error())
+	}
+
+	ips := make([]consulServiceInfo, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		ips = append(ips, consulServiceInfo{Address: address})
 	}
 
 	if len(ips) == 0 {
@@ -309,7 +529,40 @@ func getDockerRegistryServices(consulCluster string, backendLogger lager.Logger)
 	return ips, nil
 }
 
-func addDockerCachingArguments(args []string, registryIPs string, insecureRegistry bool, host string, port string, stagingData cc_messages.DockerStagingData) ([]string, error) {
+type consulStatusError struct {
+	statusCode int
+}
+
+func (e consulStatusError) Error() string {
+	return fmt.Sprintf("consul responded with status %d", e.statusCode)
+}
+
+func (backend *dockerBackend) queryConsul(path string) ([]byte, error) {
+	request, err := http.NewRequest("GET", backend.config.ConsulCluster+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if backend.config.ConsulACLToken != "" {
+		request.Header.Set(ConsulTokenHeader, backend.config.ConsulACLToken)
+	}
+
+	response, err := backend.consulClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, consulStatusError{statusCode: response.StatusCode}
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// addDockerCachingArguments has the builder cache the image in the docker
+// registry, logging in to the login server with the staging request's
+// credentials, which are only given inline with AllowInlineDockerCredentials.
+func addDockerCachingArguments(args []string, registryIPs string, insecureRegistry bool, host string, port string, stagingData cc_messages.DockerStagingData) []string {
 	args = append(args, "-cacheDockerImage")
 
 	args = append(args, "-dockerRegistryHost", host)
@@ -317,7 +570,7 @@ func addDockerCachingArguments(args []string, registryIPs string, insecureRegist
 
 	args = append(args, "-dockerRegistryIPs", registryIPs)
 	if insecureRegistry {
-		args = append(args, "-insecureDockerRegistries", fmt.Sprintf("%s:%s", host, port))
+		args = append(args, "-insecureDockerRegistries", net.JoinHostPort(host, port))
 	}
 
 	if len(stagingData.DockerLoginServer) > 0 {
@@ -329,5 +582,5 @@ func addDockerCachingArguments(args []string, registryIPs string, insecureRegist
 			"-dockerEmail", stagingData.DockerEmail)
 	}
 
-	return args, nil
+	return args
 }
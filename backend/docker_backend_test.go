@@ -235,19 +235,26 @@ var _ = Describe("DockerBackend", func() {
 
 		Context("with invalid docker registry address", func() {
 			BeforeEach(func() {
+				config.DockerImageCaching = true
 				config.DockerRegistryAddress = "://host:"
 			})
 
-			JustBeforeEach(func() {
-				stagingRequest.Environment = []*models.EnvironmentVariable{
-					{Name: "DIEGO_DOCKER_CACHE", Value: "true"},
-				}
+			It("returns an error", func() {
+				_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+				Expect(err).To(Equal(backend.ErrInvalidDockerRegistryAddress))
+				Expect(logger).To(gbytes.Say(`{"address":"://host:","app-id":"bunny","error":"too many colons in address ://host:"`))
+			})
+		})
+
+		Context("with a docker registry address whose port is not a number", func() {
+			BeforeEach(func() {
+				config.DockerImageCaching = true
+				config.DockerRegistryAddress = "host:registry"
 			})
 
 			It("returns an error", func() {
 				_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
 				Expect(err).To(Equal(backend.ErrInvalidDockerRegistryAddress))
-				Expect(logger).To(gbytes.Say(`{"address":"://host:","app-id":"bunny","error":"too many colons in address ://host:"`))
 			})
 		})
 
@@ -290,6 +297,14 @@ var _ = Describe("DockerBackend", func() {
 		Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("penguin")))
 	})
 
+	It("tags the task with the app", func() {
+		taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(taskDef.Network.Properties).To(HaveKeyWithValue("app_id", "bunny"))
+		Expect(taskDef.Network.Properties).NotTo(HaveKey("policy_group_id"))
+	})
+
 	It("gives the task a callback URL to call it back", func() {
 		taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
 		Expect(err).NotTo(HaveOccurred())
@@ -408,6 +423,54 @@ var _ = Describe("DockerBackend", func() {
 						})
 					})
 
+					Context("with a staging result that has the image's digest", func() {
+						BeforeEach(func() {
+							stagingResultJson = []byte(`{
+								"execution_metadata": "metadata",
+								"docker_image": "cloudfoundry/diego-docker-app",
+								"docker_image_digest": "sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f"
+							}`)
+						})
+
+						It("pins the image to the digest that was staged", func() {
+							Expect(buildError).NotTo(HaveOccurred())
+							Expect([]byte(*response.LifecycleData)).To(MatchJSON(`{
+								"docker_image": "cloudfoundry/diego-docker-app@sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f",
+								"docker_image_digest": "sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f"
+							}`))
+						})
+					})
+
+					Context("with a staging result from an OCI image index", func() {
+						BeforeEach(func() {
+							stagingResultJson = []byte(`{
+								"execution_metadata": "metadata",
+								"docker_image": "registry.example.com/team/app:v1",
+								"docker_image_digest": "sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f",
+								"docker_image_media_type": "application/vnd.oci.image.index.v1+json"
+							}`)
+						})
+
+						It("reports the media type with the digest", func() {
+							Expect(buildError).NotTo(HaveOccurred())
+							Expect([]byte(*response.LifecycleData)).To(MatchJSON(`{
+								"docker_image": "registry.example.com/team/app:v1@sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f",
+								"docker_image_digest": "sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f",
+								"docker_image_media_type": "application/vnd.oci.image.index.v1+json"
+							}`))
+						})
+					})
+
+					Context("with a staging result that has an invalid digest", func() {
+						BeforeEach(func() {
+							stagingResultJson = []byte(`{"docker_image": "cloudfoundry/diego-docker-app", "docker_image_digest": "latest"}`)
+						})
+
+						It("returns an error", func() {
+							Expect(buildError).To(Equal(helpers.ErrInvalidImageDigest))
+						})
+					})
+
 					Context("with an invalid staging result", func() {
 						BeforeEach(func() {
 							stagingResultJson = []byte("invalid-json")
@@ -448,4 +511,19 @@ var _ = Describe("DockerBackend", func() {
 			})
 		})
 	})
+
+	Describe("metadata-only staging", func() {
+		BeforeEach(func() {
+			config.DockerMetadataOnly = true
+		})
+
+		It("has the builder fetch only the image's metadata, unprivileged", func() {
+			taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDef.Privileged).To(BeFalse())
+			actions := actionsFromTaskDef(taskDef)
+			Expect(actions[1].GetEmitProgressAction().Action.GetRunAction().Args).To(ContainElement("-metadataOnly"))
+		})
+	})
 })
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+var ErrDockerBuilderLayoutFormatInvalid = errors.New("docker builder layouts must be lifecycle=builder_path[,result_file] with absolute paths")
+
+// DockerBuilderLayout is where a docker lifecycle bundle's builder is, once
+// the bundle is extracted into the builder's directory, and where the builder
+// writes its result.
+type DockerBuilderLayout struct {
+	BuilderPath string
+	ResultFile  string
+}
+
+// DockerBuilderLayouts lets docker lifecycle bundles, keyed like Lifecycles,
+// e.g. docker or docker/windows2016, lay out their builder differently from
+// the docker app lifecycle's. It implements flag.Value so that layouts can be
+// configured by repeating a command line flag.
+type DockerBuilderLayouts map[string]DockerBuilderLayout
+
+func (l *DockerBuilderLayouts) String() string {
+	layouts := make([]string, 0, len(*l))
+	for lifecycle, layout := range *l {
+		layouts = append(layouts, lifecycle+"="+layout.BuilderPath+","+layout.ResultFile)
+	}
+	sort.Strings(layouts)
+	return strings.Join(layouts, ";")
+}
+
+func (l *DockerBuilderLayouts) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrDockerBuilderLayoutFormatInvalid
+	}
+
+	paths := strings.Split(parts[1], ",")
+	if len(paths) > 2 {
+		return ErrDockerBuilderLayoutFormatInvalid
+	}
+	for _, p := range paths {
+		if !path.IsAbs(p) {
+			return ErrDockerBuilderLayoutFormatInvalid
+		}
+	}
+
+	layout := DockerBuilderLayout{BuilderPath: paths[0]}
+	if len(paths) == 2 {
+		layout.ResultFile = paths[1]
+	}
+
+	if *l == nil {
+		*l = DockerBuilderLayouts{}
+	}
+	(*l)[parts[0]] = layout
+	return nil
+}
+
+// dockerBuilderLayout returns the layout of the docker lifecycle bundle at
+// lifecycleKey: the configured one, with the docker app lifecycle's paths
+// for anything it leaves out.
+func (c Config) dockerBuilderLayout(lifecycleKey string, windows bool) DockerBuilderLayout {
+	layout := DockerBuilderLayout{
+		BuilderPath: DockerBuilderExecutablePath,
+		ResultFile:  DockerBuilderOutputPath,
+	}
+	if windows {
+		layout.BuilderPath = windowsDockerBuilderPath
+	}
+
+	configured := c.DockerBuilderLayouts[lifecycleKey]
+	if configured.BuilderPath != "" {
+		layout.BuilderPath = configured.BuilderPath
+	}
+	if configured.ResultFile != "" {
+		layout.ResultFile = configured.ResultFile
+	}
+	return layout
+}
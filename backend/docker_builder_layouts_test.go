@@ -0,0 +1,88 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker builder layouts", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	buildRecipe := func() *models.TaskDefinition {
+		lifecycleData := json.RawMessage(`{"docker_image": "busybox"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return taskDef
+	}
+
+	It("runs the builder where the lifecycle's layout puts it", func() {
+		config.DockerBuilderLayouts = backend.DockerBuilderLayouts{
+			"docker": {BuilderPath: "/tmp/lifecycle/bin/stage", ResultFile: "/tmp/staging/result.json"},
+		}
+
+		taskDef := buildRecipe()
+		Expect(taskDef.ResultFile).To(Equal("/tmp/staging/result.json"))
+
+		actions := actionsFromTaskDef(taskDef)
+		Expect(actions[0].GetEmitProgressAction().Action.GetDownloadAction().To).To(Equal("/tmp/lifecycle/bin"))
+
+		runAction := actions[1].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Path).To(Equal("/tmp/lifecycle/bin/stage"))
+		Expect(runAction.Args[:2]).To(Equal([]string{"-outputMetadataJSONFilename", "/tmp/staging/result.json"}))
+	})
+
+	It("keeps the docker app lifecycle's result file when the layout has none", func() {
+		config.DockerBuilderLayouts = backend.DockerBuilderLayouts{
+			"docker": {BuilderPath: "/tmp/lifecycle/stage"},
+		}
+
+		Expect(buildRecipe().ResultFile).To(Equal(backend.DockerBuilderOutputPath))
+	})
+
+	It("uses the docker app lifecycle's layout by default", func() {
+		taskDef := buildRecipe()
+		Expect(taskDef.ResultFile).To(Equal(backend.DockerBuilderOutputPath))
+
+		runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Path).To(Equal(backend.DockerBuilderExecutablePath))
+	})
+
+	Describe("DockerBuilderLayouts", func() {
+		It("parses lifecycle=builder_path[,result_file]", func() {
+			layouts := backend.DockerBuilderLayouts{}
+			Expect(layouts.Set("docker=/tmp/lifecycle/stage,/tmp/result.json")).To(Succeed())
+			Expect(layouts.Set("docker/windows2016=/tmp/lifecycle/stage.exe")).To(Succeed())
+			Expect(layouts).To(Equal(backend.DockerBuilderLayouts{
+				"docker":             {BuilderPath: "/tmp/lifecycle/stage", ResultFile: "/tmp/result.json"},
+				"docker/windows2016": {BuilderPath: "/tmp/lifecycle/stage.exe"},
+			}))
+		})
+
+		It("rejects malformed layouts", func() {
+			layouts := backend.DockerBuilderLayouts{}
+			Expect(layouts.Set("docker")).To(Equal(backend.ErrDockerBuilderLayoutFormatInvalid))
+			Expect(layouts.Set("docker=builder")).To(Equal(backend.ErrDockerBuilderLayoutFormatInvalid))
+			Expect(layouts.Set("docker=/tmp/a,/tmp/b,/tmp/c")).To(Equal(backend.ErrDockerBuilderLayoutFormatInvalid))
+		})
+	})
+})
@@ -0,0 +1,37 @@
+package backend
+
+import "encoding/json"
+
+// dockerImageCachingOverrides are the org's and space's docker image caching
+// settings that the CC sends in the lifecycle data's docker_image_caching.
+// Unset fields leave the decision to the next level up.
+type dockerImageCachingOverrides struct {
+	Org   *bool `json:"org"`
+	Space *bool `json:"space"`
+}
+
+// cachesDockerImage reports whether docker staging caches the image in the
+// docker registry: as the space's override says, if any, else as the org's
+// does, and otherwise as DockerImageCaching does. Apps' environment
+// variables have no say.
+func (c Config) cachesDockerImage(lifecycleData json.RawMessage) (bool, error) {
+	var options struct {
+		DockerImageCaching *dockerImageCachingOverrides `json:"docker_image_caching"`
+	}
+	err := json.Unmarshal(lifecycleData, &options)
+	if err != nil {
+		return false, NewValidationError(InvalidLifecycleDataErrorId, "invalid docker_image_caching: "+err.Error())
+	}
+
+	overrides := options.DockerImageCaching
+	switch {
+	case overrides == nil:
+		return c.DockerImageCaching, nil
+	case overrides.Space != nil:
+		return *overrides.Space, nil
+	case overrides.Org != nil:
+		return *overrides.Org, nil
+	default:
+		return c.DockerImageCaching, nil
+	}
+}
@@ -0,0 +1,100 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker image caching policy", func() {
+	var (
+		config        backend.Config
+		lifecycleData string
+		environment   []*models.EnvironmentVariable
+	)
+
+	BeforeEach(func() {
+		resolver := &fake_backend.FakeHostResolver{}
+		resolver.LookupHostReturns([]string{"10.244.2.6"}, nil)
+
+		config = backend.Config{
+			FileServerURL:           "http://file-server.com",
+			DockerStagingStack:      "cflinuxfs2",
+			DockerRegistryAddress:   "docker-registry.service.cf.internal:8080",
+			DockerRegistryDiscovery: backend.DockerRegistryDiscoveryDNS,
+			HostResolver:            resolver,
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+		}
+		lifecycleData = `{"docker_image": "busybox"}`
+		environment = nil
+	})
+
+	cachesImage := func() bool {
+		data := json.RawMessage(lifecycleData)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+			Environment:   environment,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+		for _, arg := range runAction.Args {
+			if arg == "-cacheDockerImage" {
+				return true
+			}
+		}
+		return false
+	}
+
+	It("doesn't cache images by default", func() {
+		Expect(cachesImage()).To(BeFalse())
+	})
+
+	It("caches images when the operator enables caching", func() {
+		config.DockerImageCaching = true
+		Expect(cachesImage()).To(BeTrue())
+	})
+
+	It("ignores the app's DIEGO_DOCKER_CACHE", func() {
+		environment = []*models.EnvironmentVariable{{Name: "DIEGO_DOCKER_CACHE", Value: "true"}}
+		Expect(cachesImage()).To(BeFalse())
+	})
+
+	It("lets the org override the default", func() {
+		lifecycleData = `{"docker_image": "busybox", "docker_image_caching": {"org": true}}`
+		Expect(cachesImage()).To(BeTrue())
+
+		config.DockerImageCaching = true
+		lifecycleData = `{"docker_image": "busybox", "docker_image_caching": {"org": false}}`
+		Expect(cachesImage()).To(BeFalse())
+	})
+
+	It("lets the space override the org", func() {
+		lifecycleData = `{"docker_image": "busybox", "docker_image_caching": {"org": false, "space": true}}`
+		Expect(cachesImage()).To(BeTrue())
+
+		lifecycleData = `{"docker_image": "busybox", "docker_image_caching": {"org": true, "space": false}}`
+		Expect(cachesImage()).To(BeFalse())
+	})
+
+	It("rejects invalid overrides", func() {
+		data := json.RawMessage(`{"docker_image": "busybox", "docker_image_caching": {"space": "yes"}}`)
+		_, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidLifecycleDataErrorId))
+	})
+})
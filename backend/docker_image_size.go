@@ -0,0 +1,18 @@
+package backend
+
+import (
+	"strconv"
+)
+
+// DockerImageTooLargeExitCode is the builder's exit status when the image's
+// compressed layers add up to more than it was allowed.
+const DockerImageTooLargeExitCode = 241
+
+// addDockerImageSizeArguments has the builder check the size in the image's
+// manifest before pulling or caching any layers.
+func addDockerImageSizeArguments(args []string, maxImageSizeMB int) []string {
+	if maxImageSizeMB <= 0 {
+		return args
+	}
+	return append(args, "-maxImageSizeBytes", strconv.FormatInt(int64(maxImageSizeMB)*1024*1024, 10))
+}
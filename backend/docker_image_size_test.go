@@ -0,0 +1,56 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker image size limit", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	builderArgs := func() []string {
+		lifecycleData := json.RawMessage(`{"docker_image": "busybox"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		return actions[len(actions)-1].GetEmitProgressAction().Action.GetRunAction().Args
+	}
+
+	It("has the builder check the image's size against the limit", func() {
+		config.MaxDockerImageSizeMB = 2048
+
+		args := builderArgs()
+		Expect(args).To(ContainElement("-maxImageSizeBytes"))
+		Expect(args).To(ContainElement("2147483648"))
+	})
+
+	It("has no limit by default", func() {
+		Expect(builderArgs()).NotTo(ContainElement("-maxImageSizeBytes"))
+	})
+
+	It("reports images over the limit", func() {
+		stagingErr := backend.SanitizeErrorMessage("Exited with status 241")
+		Expect(stagingErr.Id).To(Equal(backend.DockerImageTooLargeErrorId))
+		Expect(stagingErr.Message).To(Equal("the docker image is larger than allowed"))
+	})
+})
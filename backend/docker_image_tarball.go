@@ -0,0 +1,68 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+// DockerImageTarballPath is where docker staging extracts an image exported
+// with docker save, for the builder to import.
+const DockerImageTarballPath = "/tmp/docker-image"
+
+// dockerImageTarball is an exported image in the blobstore that the lifecycle
+// data can stage instead of pulling docker_image from a registry.
+type dockerImageTarball struct {
+	URL      string    `json:"docker_image_tarball_url"`
+	Checksum *Checksum `json:"docker_image_tarball_checksum"`
+}
+
+// parseDockerImageTarball returns the exported image that the lifecycle data
+// stages, or nil when it stages from a registry.
+func parseDockerImageTarball(lifecycleData json.RawMessage) (*dockerImageTarball, error) {
+	var tarball dockerImageTarball
+	err := json.Unmarshal(lifecycleData, &tarball)
+	if err != nil {
+		return nil, NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	if tarball.URL == "" {
+		return nil, nil
+	}
+
+	parsed, err := url.Parse(tarball.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, NewValidationError(InvalidDownloadURLErrorId, "invalid docker_image_tarball_url")
+	}
+
+	if tarball.Checksum != nil {
+		err := tarball.Checksum.validate()
+		if err != nil {
+			return nil, NewValidationError(InvalidChecksumErrorId, err.Error())
+		}
+	}
+
+	return &tarball, nil
+}
+
+// dockerImageTarballDownload downloads the exported image for a staging task
+// with the given timeout, so that cells needn't reach any registry.
+func (c Config) dockerImageTarballDownload(tarball *dockerImageTarball, timeout time.Duration) (models.ActionInterface, error) {
+	tarballURL, err := c.signedDownloadURL(tarball.URL, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.EmitProgressFor(
+		withChecksum(&models.DownloadAction{
+			From: tarballURL,
+			To:   DockerImageTarballPath,
+			User: "vcap",
+		}, tarball.Checksum),
+		"Downloading docker image...",
+		"Downloaded docker image",
+		"Failed to download docker image",
+	), nil
+}
@@ -0,0 +1,103 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker image tarballs", func() {
+	var (
+		config        backend.Config
+		lifecycleData string
+	)
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+		}
+		lifecycleData = `{"docker_image_tarball_url": "https://blobstore.example.com/images/app.tar"}`
+	})
+
+	buildRecipe := func() (*models.TaskDefinition, error) {
+		data := json.RawMessage(lifecycleData)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+		})
+		return taskDef, err
+	}
+
+	It("downloads the image and has the builder import it", func() {
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		Expect(actions).To(HaveLen(3))
+
+		download := actions[1].GetEmitProgressAction().Action.GetDownloadAction()
+		Expect(download.From).To(Equal("https://blobstore.example.com/images/app.tar"))
+		Expect(download.To).To(Equal(backend.DockerImageTarballPath))
+
+		runAction := actions[2].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Args).To(Equal([]string{
+			"-outputMetadataJSONFilename", backend.DockerBuilderOutputPath,
+			"-dockerImagePath", backend.DockerImageTarballPath,
+		}))
+	})
+
+	It("names the image, when given, to the builder", func() {
+		lifecycleData = `{"docker_image": "registry.example.com/team/app", "docker_image_tarball_url": "https://blobstore.example.com/images/app.tar"}`
+
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		runAction := actionsFromTaskDef(taskDef)[2].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Args).To(ContainElement("registry.example.com/team/app"))
+		Expect(runAction.Args).To(ContainElement("-dockerImagePath"))
+	})
+
+	It("verifies the image's checksum", func() {
+		lifecycleData = `{"docker_image_tarball_url": "https://blobstore.example.com/images/app.tar", "docker_image_tarball_checksum": {"type": "sha256", "value": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}}`
+
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		download := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetDownloadAction()
+		Expect(download.ChecksumAlgorithm).To(Equal("sha256"))
+		Expect(download.ChecksumValue).To(Equal("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"))
+	})
+
+	It("rejects URLs that aren't http or https", func() {
+		lifecycleData = `{"docker_image_tarball_url": "file:///var/images/app.tar"}`
+
+		_, err := buildRecipe()
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidDownloadURLErrorId))
+	})
+
+	It("rejects invalid checksums", func() {
+		lifecycleData = `{"docker_image_tarball_url": "https://blobstore.example.com/images/app.tar", "docker_image_tarball_checksum": {"type": "md5", "value": "abc"}}`
+
+		_, err := buildRecipe()
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidChecksumErrorId))
+	})
+
+	It("still requires an image without a tarball", func() {
+		lifecycleData = `{}`
+
+		_, err := buildRecipe()
+		Expect(err).To(Equal(backend.ErrMissingDockerImageUrl))
+	})
+})
@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"errors"
+	"strings"
+)
+
+// DockerPlatformMismatchExitCode is the builder's exit status when the image
+// is a manifest list with no variant for the staging platform.
+const DockerPlatformMismatchExitCode = 240
+
+var ErrDockerPlatformFormatInvalid = errors.New("docker staging platform must be os/architecture[/variant], e.g. linux/amd64")
+
+// ValidateDockerPlatform checks that platform is an OCI platform such as
+// linux/amd64 or linux/arm64/v8. An empty platform leaves the choice to the
+// builder.
+func ValidateDockerPlatform(platform string) error {
+	if platform == "" {
+		return nil
+	}
+
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return ErrDockerPlatformFormatInvalid
+	}
+	for _, part := range parts {
+		if part == "" {
+			return ErrDockerPlatformFormatInvalid
+		}
+	}
+	return nil
+}
+
+// addDockerPlatformArguments has the builder pick the variant of manifest
+// list images for the platform of the docker staging stack's cells.
+func addDockerPlatformArguments(args []string, platform string) []string {
+	if platform == "" {
+		return args
+	}
+	return append(args, "-dockerPlatform", platform)
+}
@@ -0,0 +1,69 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker staging platform", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	builderArgs := func() []string {
+		lifecycleData := json.RawMessage(`{"docker_image": "busybox"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		return actions[len(actions)-1].GetEmitProgressAction().Action.GetRunAction().Args
+	}
+
+	It("has the builder pick the image variant for the configured platform", func() {
+		config.DockerStagingPlatform = "linux/arm64"
+
+		args := builderArgs()
+		Expect(args).To(ContainElement("-dockerPlatform"))
+		Expect(args).To(ContainElement("linux/arm64"))
+	})
+
+	It("leaves the choice to the builder by default", func() {
+		Expect(builderArgs()).NotTo(ContainElement("-dockerPlatform"))
+	})
+
+	It("reports images with no variant for the platform", func() {
+		stagingErr := backend.SanitizeErrorMessage("Exited with status 240")
+		Expect(stagingErr.Id).To(Equal(backend.DockerPlatformMismatchErrorId))
+	})
+
+	Describe("ValidateDockerPlatform", func() {
+		It("accepts OCI platforms", func() {
+			Expect(backend.ValidateDockerPlatform("linux/amd64")).To(Succeed())
+			Expect(backend.ValidateDockerPlatform("linux/arm64/v8")).To(Succeed())
+			Expect(backend.ValidateDockerPlatform("")).To(Succeed())
+		})
+
+		It("rejects anything else", func() {
+			Expect(backend.ValidateDockerPlatform("amd64")).To(Equal(backend.ErrDockerPlatformFormatInvalid))
+			Expect(backend.ValidateDockerPlatform("linux/")).To(Equal(backend.ErrDockerPlatformFormatInvalid))
+			Expect(backend.ValidateDockerPlatform("linux/arm/v7/extra")).To(Equal(backend.ErrDockerPlatformFormatInvalid))
+		})
+	})
+})
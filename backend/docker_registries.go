@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultDockerRegistry is the registry of images whose references don't
+// name one, e.g. busybox or cloudfoundry/diego-docker-app.
+const DefaultDockerRegistry = "docker.io"
+
+// DockerImageRegistry returns the registry host, with any port, of a docker
+// image reference. As in docker, the first component of the reference is the
+// registry only if it looks like a host.
+func DockerImageRegistry(image string) string {
+	image = strings.TrimPrefix(image, "docker://")
+	if strings.HasPrefix(image, "/") {
+		return DefaultDockerRegistry
+	}
+
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return DefaultDockerRegistry
+	}
+
+	host := strings.ToLower(parts[0])
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return DefaultDockerRegistry
+	}
+
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return DefaultDockerRegistry
+	}
+	return host
+}
+
+// checkDockerRegistry rejects images from registries in
+// DeniedDockerRegistries and, when AllowedDockerRegistries is set, from
+// registries not in it. Entries starting with *. match any subdomain.
+func (c Config) checkDockerRegistry(image string) error {
+	registry := DockerImageRegistry(image)
+
+	if matchesDockerRegistry(registry, c.DeniedDockerRegistries) {
+		return NewValidationError(DockerRegistryNotAllowedErrorId, fmt.Sprintf("docker images from %s are not allowed", registry))
+	}
+
+	if len(c.AllowedDockerRegistries) > 0 && !matchesDockerRegistry(registry, c.AllowedDockerRegistries) {
+		return NewValidationError(DockerRegistryNotAllowedErrorId, fmt.Sprintf("docker images from %s are not allowed", registry))
+	}
+
+	return nil
+}
+
+func matchesDockerRegistry(registry string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(registry, pattern[1:]) {
+				return true
+			}
+		} else if registry == pattern {
+			return true
+		}
+	}
+	return false
+}
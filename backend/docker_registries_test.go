@@ -0,0 +1,75 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker registries", func() {
+	Describe("DockerImageRegistry", func() {
+		It("is docker.io for images that don't name a registry", func() {
+			Expect(backend.DockerImageRegistry("busybox")).To(Equal("docker.io"))
+			Expect(backend.DockerImageRegistry("cloudfoundry/diego-docker-app")).To(Equal("docker.io"))
+			Expect(backend.DockerImageRegistry("docker:///busybox")).To(Equal("docker.io"))
+		})
+
+		It("is docker.io for docker hub's other names", func() {
+			Expect(backend.DockerImageRegistry("index.docker.io/cloudfoundry/diego-docker-app")).To(Equal("docker.io"))
+		})
+
+		It("is the host of images that name a registry", func() {
+			Expect(backend.DockerImageRegistry("registry.example.com/team/app:v1")).To(Equal("registry.example.com"))
+			Expect(backend.DockerImageRegistry("localhost:5000/app")).To(Equal("localhost:5000"))
+			Expect(backend.DockerImageRegistry("docker://registry.example.com/app")).To(Equal("registry.example.com"))
+		})
+	})
+
+	Describe("staging", func() {
+		var config backend.Config
+
+		BeforeEach(func() {
+			config = backend.Config{
+				FileServerURL:      "http://file-server.com",
+				DockerStagingStack: "cflinuxfs2",
+				Lifecycles: map[string]string{
+					"docker": "docker_app_lifecycle.tgz",
+				},
+				AllowedDockerRegistries: []string{"docker.io", "*.example.com"},
+				DeniedDockerRegistries:  []string{"untrusted.example.com"},
+			}
+		})
+
+		stage := func(image string) error {
+			lifecycleData := json.RawMessage(`{"docker_image": "` + image + `"}`)
+			_, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+				AppId:         "bunny",
+				Lifecycle:     "docker",
+				LifecycleData: &lifecycleData,
+			})
+			return err
+		}
+
+		It("stages images from allowed registries", func() {
+			Expect(stage("busybox")).To(Succeed())
+			Expect(stage("registry.example.com/team/app")).To(Succeed())
+		})
+
+		It("rejects images from denied registries", func() {
+			err := stage("untrusted.example.com/app")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryNotAllowedErrorId))
+		})
+
+		It("rejects images from registries that aren't allowed", func() {
+			err := stage("quay.io/team/app")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryNotAllowedErrorId))
+			Expect(err.Error()).To(Equal("docker images from quay.io are not allowed"))
+		})
+	})
+})
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// dockerRegistryCache holds the last registry instances discovered, so that
+// docker staging doesn't look them up for every request.
+type dockerRegistryCache struct {
+	lock      sync.Mutex
+	services  []consulServiceInfo
+	fetchedAt time.Time
+}
+
+func (c *dockerRegistryCache) get() ([]consulServiceInfo, time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.services, c.fetchedAt
+}
+
+func (c *dockerRegistryCache) set(services []consulServiceInfo, fetchedAt time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.services = services
+	c.fetchedAt = fetchedAt
+}
+
+// cachedDockerRegistryServices returns the cached registry instances while
+// they are fresh, and otherwise discovers them again. If discovery fails, e.g.
+// while consul restarts, instances that are stale by less than
+// DockerRegistryCacheMaxStale are still used. A successful lookup that finds
+// no registry is never masked.
+func (backend *dockerBackend) cachedDockerRegistryServices(host string) ([]consulServiceInfo, error) {
+	ttl := backend.config.DockerRegistryCacheTTL
+	if ttl <= 0 {
+		return backend.discoverDockerRegistryServices(host)
+	}
+
+	now := backend.config.now()
+	cached, fetchedAt := backend.registryCache.get()
+	if cached != nil && now.Sub(fetchedAt) < ttl {
+		return cached, nil
+	}
+
+	services, err := backend.discoverDockerRegistryServices(host)
+	if err != nil {
+		if err != ErrMissingDockerRegistry && cached != nil && now.Sub(fetchedAt) < ttl+backend.config.DockerRegistryCacheMaxStale {
+			backend.logger.Info("using-stale-docker-registry-services", lager.Data{"fetched-at": fetchedAt, "error": err.Error()})
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	backend.registryCache.set(services, now)
+	return services, nil
+}
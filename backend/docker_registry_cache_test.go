@@ -0,0 +1,111 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker registry cache", func() {
+	var (
+		resolver  *fake_backend.FakeHostResolver
+		fakeClock *fakeclock.FakeClock
+		docker    backend.Backend
+	)
+
+	BeforeEach(func() {
+		resolver = new(fake_backend.FakeHostResolver)
+		resolver.LookupHostReturns([]string{"10.244.2.6"}, nil)
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		docker = backend.NewDockerBackend(backend.Config{
+			FileServerURL:               "http://file-server.com",
+			DockerRegistryAddress:       "docker-registry.service.cf.internal:8080",
+			DockerImageCaching:          true,
+			DockerRegistryDiscovery:     backend.DockerRegistryDiscoveryDNS,
+			HostResolver:                resolver,
+			DockerRegistryCacheTTL:      30 * time.Second,
+			DockerRegistryCacheMaxStale: 5 * time.Minute,
+			Clock:                       fakeClock,
+			Lifecycles: map[string]string{
+				"docker": "docker_lifecycle/docker_app_lifecycle.tgz",
+			},
+		}, lagertest.NewTestLogger("test"))
+	})
+
+	buildRecipe := func() (*models.TaskDefinition, error) {
+		lifecycleData := json.RawMessage(`{"docker_image": "busybox"}`)
+		taskDef, _, _, err := docker.BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		return taskDef, err
+	}
+
+	registryRule := &models.SecurityGroupRule{
+		Protocol:     models.TCPProtocol,
+		Destinations: []string{"10.244.2.6"},
+		Ports:        []uint32{8080},
+	}
+
+	It("reuses the registry instances until they expire", func() {
+		_, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock.Increment(29 * time.Second)
+		_, err = buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolver.LookupHostCallCount()).To(Equal(1))
+
+		fakeClock.Increment(time.Second)
+		_, err = buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolver.LookupHostCallCount()).To(Equal(2))
+	})
+
+	Context("when discovery fails after the instances expire", func() {
+		BeforeEach(func() {
+			_, err := buildRecipe()
+			Expect(err).NotTo(HaveOccurred())
+
+			resolver.LookupHostReturns(nil, errors.New("no such host"))
+		})
+
+		It("uses the stale instances for a while", func() {
+			fakeClock.Increment(5 * time.Minute)
+
+			taskDef, err := buildRecipe()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule))
+		})
+
+		It("fails once they are too stale", func() {
+			fakeClock.Increment(30*time.Second + 5*time.Minute)
+
+			_, err := buildRecipe()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryDiscoveryErrorId))
+		})
+	})
+
+	It("doesn't mask a registry that has gone away", func() {
+		_, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		resolver.LookupHostReturns([]string{}, nil)
+		fakeClock.Increment(time.Minute)
+
+		_, err = buildRecipe()
+		Expect(err).To(Equal(backend.ErrMissingDockerRegistry))
+	})
+})
@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+// DockerRegistryCAsEnvVar gives the docker builder the CA certificates of
+// registries signed by private CAs, as a JSON object of PEM certificates
+// keyed by registry host, so that they can be used without marking them
+// insecure.
+const DockerRegistryCAsEnvVar = "DOCKER_REGISTRY_CA_CERTS"
+
+var ErrDockerRegistryCAFormatInvalid = errors.New("docker registry CAs must be registry=path-to-pem-file")
+
+// DockerRegistryCAFiles maps registry hosts to files of PEM CA certificates.
+// It implements flag.Value so that CAs can be configured by repeating a
+// command line flag.
+type DockerRegistryCAFiles map[string]string
+
+func (f *DockerRegistryCAFiles) String() string {
+	cas := make([]string, 0, len(*f))
+	for registry, file := range *f {
+		cas = append(cas, registry+"="+file)
+	}
+	sort.Strings(cas)
+	return strings.Join(cas, ",")
+}
+
+func (f *DockerRegistryCAFiles) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ErrDockerRegistryCAFormatInvalid
+	}
+
+	if *f == nil {
+		*f = DockerRegistryCAFiles{}
+	}
+	(*f)[strings.ToLower(parts[0])] = parts[1]
+	return nil
+}
+
+// LoadDockerRegistryCAs reads each registry's CA certificates, failing if
+// any file doesn't hold a PEM certificate.
+func LoadDockerRegistryCAs(files DockerRegistryCAFiles) (map[string]string, error) {
+	cas := make(map[string]string, len(files))
+	for registry, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates for docker registry %s in %s", registry, file)
+		}
+		cas[registry] = string(pem)
+	}
+	return cas, nil
+}
+
+// dockerRegistryCAs returns the variable that gives the builder the
+// configured registry CAs, or nil when there are none.
+func (c Config) dockerRegistryCAs() (*models.EnvironmentVariable, error) {
+	if len(c.DockerRegistryCAs) == 0 {
+		return nil, nil
+	}
+
+	casJSON, err := json.Marshal(c.DockerRegistryCAs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.EnvironmentVariable{Name: DockerRegistryCAsEnvVar, Value: string(casJSON)}, nil
+}
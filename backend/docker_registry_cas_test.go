@@ -0,0 +1,117 @@
+package backend_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker registry CAs", func() {
+	It("gives the docker builder the configured CAs", func() {
+		config := backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+			DockerRegistryCAs: map[string]string{
+				"registry.example.com": "-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n",
+			},
+		}
+
+		lifecycleData := json.RawMessage(`{"docker_image": "registry.example.com/app"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		Expect(actions[len(actions)-1].GetEmitProgressAction().Action.GetRunAction().Env).To(ContainElement(&models.EnvironmentVariable{
+			Name:  "DOCKER_REGISTRY_CA_CERTS",
+			Value: `{"registry.example.com":"-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n"}`,
+		}))
+	})
+
+	Describe("LoadDockerRegistryCAs", func() {
+		var file *os.File
+
+		BeforeEach(func() {
+			var err error
+			file, err = ioutil.TempFile("", "registry-ca")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.Remove(file.Name())
+		})
+
+		It("reads each registry's PEM certificates", func() {
+			caPEM := selfSignedCertificatePEM()
+			_, err := file.Write(caPEM)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			cas, err := backend.LoadDockerRegistryCAs(backend.DockerRegistryCAFiles{"registry.example.com": file.Name()})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cas).To(Equal(map[string]string{"registry.example.com": string(caPEM)}))
+		})
+
+		It("rejects files without certificates", func() {
+			_, err := file.WriteString("not a certificate")
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			_, err = backend.LoadDockerRegistryCAs(backend.DockerRegistryCAFiles{"registry.example.com": file.Name()})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("DockerRegistryCAFiles", func() {
+		It("parses registry=file", func() {
+			files := backend.DockerRegistryCAFiles{}
+			Expect(files.Set("Registry.example.com:5000=/var/vcap/jobs/stager/config/registry-ca.pem")).To(Succeed())
+			Expect(files).To(Equal(backend.DockerRegistryCAFiles{"registry.example.com:5000": "/var/vcap/jobs/stager/config/registry-ca.pem"}))
+		})
+
+		It("rejects malformed CAs", func() {
+			files := backend.DockerRegistryCAFiles{}
+			Expect(files.Set("registry.example.com")).To(Equal(backend.ErrDockerRegistryCAFormatInvalid))
+			Expect(files.Set("=/ca.pem")).To(Equal(backend.ErrDockerRegistryCAFormatInvalid))
+		})
+	})
+})
+
+func selfSignedCertificatePEM() []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registry-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
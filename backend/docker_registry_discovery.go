@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"errors"
+	"net"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// How docker staging finds the instances of the registry it caches images in.
+// Consul discovery asks the consul agent's catalog; DNS discovery resolves the
+// registry host, e.g. through consul DNS or BOSH DNS.
+const (
+	DockerRegistryDiscoveryConsul = "consul"
+	DockerRegistryDiscoveryDNS    = "dns"
+)
+
+var ErrDockerRegistryDiscoveryInvalid = errors.New("docker registry discovery must be consul or dns")
+
+//go:generate counterfeiter -o fake_backend/fake_host_resolver.go . HostResolver
+
+// HostResolver resolves docker registry hosts for DNS discovery.
+type HostResolver interface {
+	LookupSRV(service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(host string) ([]string, error)
+}
+
+// ValidateDockerRegistryDiscovery checks that mode is a known discovery mode.
+// An empty mode means consul.
+func ValidateDockerRegistryDiscovery(mode string) error {
+	switch mode {
+	case "", DockerRegistryDiscoveryConsul, DockerRegistryDiscoveryDNS:
+		return nil
+	default:
+		return ErrDockerRegistryDiscoveryInvalid
+	}
+}
+
+type netResolver struct{}
+
+func (netResolver) LookupSRV(service, proto, name string) (string, []*net.SRV, error) {
+	return net.LookupSRV(service, proto, name)
+}
+
+func (netResolver) LookupHost(host string) ([]string, error) {
+	return net.LookupHost(host)
+}
+
+// discoverDockerRegistryServices finds the instances of the registry at host.
+func (backend *dockerBackend) discoverDockerRegistryServices(host string) ([]consulServiceInfo, error) {
+	if backend.config.DockerRegistryDiscovery == DockerRegistryDiscoveryDNS {
+		return resolveDockerRegistryServices(backend.resolver, host, backend.logger)
+	}
+	return backend.getConsulDockerRegistryServices()
+}
+
+// resolveDockerRegistryServices finds the registry's instances from the SRV
+// records of host, falling back to its A records when it has none.
+func resolveDockerRegistryServices(resolver HostResolver, host string, backendLogger lager.Logger) ([]consulServiceInfo, error) {
+	logger := backendLogger.Session("docker-registry-dns-services", lager.Data{"host": host})
+
+	targets := []string{host}
+	_, records, err := resolver.LookupSRV("", "", host)
+	if err != nil {
+		logger.Debug("no-srv-records", lager.Data{"error": err.Error()})
+	} else if len(records) > 0 {
+		targets = make([]string, 0, len(records))
+		for _, record := range records {
+			targets = append(targets, record.Target)
+		}
+	}
+
+	seen := map[string]bool{}
+	var ips []consulServiceInfo
+	for _, target := range targets {
+		addresses, err := resolver.LookupHost(target)
+		if err != nil {
+			return nil, NewDependencyError(DockerRegistryDiscoveryErrorId, err.Error())
+		}
+
+		for _, address := range addresses {
+			if !seen[address] {
+				seen[address] = true
+				ips = append(ips, consulServiceInfo{Address: address})
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, ErrMissingDockerRegistry
+	}
+
+	logger.Debug("docker-registry-dns-services", lager.Data{"ips": ips})
+
+	return ips, nil
+}
@@ -0,0 +1,133 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker registry discovery", func() {
+	var resolver *fake_backend.FakeHostResolver
+
+	BeforeEach(func() {
+		resolver = new(fake_backend.FakeHostResolver)
+	})
+
+	buildRecipe := func() (*models.TaskDefinition, error) {
+		config := backend.Config{
+			FileServerURL:           "http://file-server.com",
+			DockerRegistryAddress:   "docker-registry.service.cf.internal:8080",
+			DockerImageCaching:      true,
+			DockerRegistryDiscovery: backend.DockerRegistryDiscoveryDNS,
+			HostResolver:            resolver,
+			Lifecycles: map[string]string{
+				"docker": "docker_lifecycle/docker_app_lifecycle.tgz",
+			},
+		}
+
+		lifecycleData := json.RawMessage(`{"docker_image": "busybox"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		return taskDef, err
+	}
+
+	registryRule := func(ip string) *models.SecurityGroupRule {
+		return &models.SecurityGroupRule{
+			Protocol:     models.TCPProtocol,
+			Destinations: []string{ip},
+			Ports:        []uint32{8080},
+		}
+	}
+
+	Context("with DNS discovery", func() {
+		It("resolves the targets of the registry's SRV records", func() {
+			resolver.LookupSRVReturns("", []*net.SRV{
+				{Target: "registry-0.docker-registry.service.cf.internal.", Port: 8080},
+				{Target: "registry-1.docker-registry.service.cf.internal.", Port: 8080},
+			}, nil)
+			resolver.LookupHostStub = func(host string) ([]string, error) {
+				switch host {
+				case "registry-0.docker-registry.service.cf.internal.":
+					return []string{"10.244.2.6"}, nil
+				case "registry-1.docker-registry.service.cf.internal.":
+					return []string{"10.244.2.7"}, nil
+				}
+				return nil, errors.New("no such host")
+			}
+
+			taskDef, err := buildRecipe()
+			Expect(err).NotTo(HaveOccurred())
+
+			_, _, name := resolver.LookupSRVArgsForCall(0)
+			Expect(name).To(Equal("docker-registry.service.cf.internal"))
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule("10.244.2.6")))
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule("10.244.2.7")))
+
+			runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+			Expect(runAction.Args).To(ContainElement("10.244.2.6,10.244.2.7"))
+		})
+
+		It("falls back to the registry's A records", func() {
+			resolver.LookupSRVReturns("", nil, errors.New("no SRV records"))
+			resolver.LookupHostReturns([]string{"10.244.2.6", "10.244.2.7"}, nil)
+
+			taskDef, err := buildRecipe()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resolver.LookupHostArgsForCall(0)).To(Equal("docker-registry.service.cf.internal"))
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule("10.244.2.6")))
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule("10.244.2.7")))
+		})
+
+		It("allows egress to IPv6 registry addresses", func() {
+			resolver.LookupHostReturns([]string{"10.244.2.6", "fd00:0:0:0::6"}, nil)
+
+			taskDef, err := buildRecipe()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule("10.244.2.6")))
+			Expect(taskDef.EgressRules).To(ContainElement(registryRule("fd00::6")))
+
+			runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+			Expect(runAction.Args).To(ContainElement("10.244.2.6,fd00::6"))
+		})
+
+		It("fails when the registry can't be resolved", func() {
+			resolver.LookupHostReturns(nil, errors.New("no such host"))
+
+			_, err := buildRecipe()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryDiscoveryErrorId))
+		})
+
+		It("fails when the registry has no addresses", func() {
+			resolver.LookupHostReturns([]string{}, nil)
+
+			_, err := buildRecipe()
+			Expect(err).To(Equal(backend.ErrMissingDockerRegistry))
+		})
+	})
+
+	Describe("ValidateDockerRegistryDiscovery", func() {
+		It("accepts consul, dns, and the default", func() {
+			Expect(backend.ValidateDockerRegistryDiscovery("")).To(Succeed())
+			Expect(backend.ValidateDockerRegistryDiscovery("consul")).To(Succeed())
+			Expect(backend.ValidateDockerRegistryDiscovery("dns")).To(Succeed())
+		})
+
+		It("rejects other modes", func() {
+			Expect(backend.ValidateDockerRegistryDiscovery("etcd")).To(Equal(backend.ErrDockerRegistryDiscoveryInvalid))
+		})
+	})
+})
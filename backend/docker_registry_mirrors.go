@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var ErrDockerRegistryMirrorInvalid = errors.New("docker registry mirrors must be http or https URLs")
+
+// ValidateDockerRegistryMirrors checks that every mirror is an absolute http
+// or https URL, as the docker daemon requires.
+func ValidateDockerRegistryMirrors(mirrors []string) error {
+	for _, mirror := range mirrors {
+		mirrorURL, err := url.Parse(mirror)
+		if err != nil || (mirrorURL.Scheme != "http" && mirrorURL.Scheme != "https") || mirrorURL.Host == "" {
+			return ErrDockerRegistryMirrorInvalid
+		}
+	}
+	return nil
+}
+
+// addDockerRegistryMirrorArguments points the builder's docker daemon at the
+// pull-through mirrors of Docker Hub, so that staging pulls go through them.
+func addDockerRegistryMirrorArguments(args []string, mirrors []string) []string {
+	if len(mirrors) == 0 {
+		return args
+	}
+	return append(args, "-dockerDaemonRegistryMirrors", strings.Join(mirrors, ","))
+}
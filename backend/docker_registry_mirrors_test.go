@@ -0,0 +1,67 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Docker registry mirrors", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	builderArgs := func() []string {
+		lifecycleData := json.RawMessage(`{"docker_image": "busybox"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		var runAction *models.RunAction
+		for _, action := range actionsFromTaskDef(taskDef) {
+			if emitProgress := action.GetEmitProgressAction(); emitProgress != nil && emitProgress.Action.GetRunAction() != nil {
+				runAction = emitProgress.Action.GetRunAction()
+			}
+		}
+		Expect(runAction).NotTo(BeNil())
+		return runAction.Args
+	}
+
+	It("passes the mirrors to the builder's docker daemon", func() {
+		config.DockerRegistryMirrors = []string{"https://mirror-a.example.com", "http://mirror-b.example.com:5000"}
+
+		Expect(builderArgs()).To(ContainElement("-dockerDaemonRegistryMirrors"))
+		Expect(builderArgs()).To(ContainElement("https://mirror-a.example.com,http://mirror-b.example.com:5000"))
+	})
+
+	It("passes no mirrors by default", func() {
+		Expect(builderArgs()).NotTo(ContainElement("-dockerDaemonRegistryMirrors"))
+	})
+
+	Describe("ValidateDockerRegistryMirrors", func() {
+		It("accepts http and https URLs", func() {
+			Expect(backend.ValidateDockerRegistryMirrors([]string{"https://mirror.example.com", "http://10.0.0.5:5000"})).To(Succeed())
+		})
+
+		It("rejects anything else", func() {
+			Expect(backend.ValidateDockerRegistryMirrors([]string{"mirror.example.com"})).To(Equal(backend.ErrDockerRegistryMirrorInvalid))
+			Expect(backend.ValidateDockerRegistryMirrors([]string{"ftp://mirror.example.com"})).To(Equal(backend.ErrDockerRegistryMirrorInvalid))
+		})
+	})
+})
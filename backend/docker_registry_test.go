@@ -1,10 +1,14 @@
 package backend_test
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/cloudfoundry-incubator/bbs/models"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
@@ -12,6 +16,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/clock/fakeclock"
 	"github.com/pivotal-golang/lager"
 )
 
@@ -24,18 +29,19 @@ var _ = Describe("DockerBackend", func() {
 		dockerRegistryIPs     = []string{"10.244.2.6", "10.244.2.7"}
 		dockerRegistryAddress = fmt.Sprintf("%s:%d", dockerRegistryHost, dockerRegistryPort)
 
-		loginServer string
-		user        string
-		password    string
-		email       string
+		loginServer        string
+		user               string
+		password           string
+		email              string
+		dockerImageCaching bool
 	)
 
-	setupDockerBackend := func(insecureDockerRegistry bool, payload string) backend.Backend {
+	setupDockerBackend := func(registryAddress string, insecureDockerRegistry bool, payload string) backend.Backend {
 		server := ghttp.NewServer()
 
 		server.AppendHandlers(
 			ghttp.CombineHandlers(
-				ghttp.VerifyRequest("GET", "/v1/catalog/service/docker-registry"),
+				ghttp.VerifyRequest("GET", "/v1/health/service/docker-registry", "passing"),
 				http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 					w.Write([]byte(payload))
 				}),
@@ -43,11 +49,13 @@ var _ = Describe("DockerBackend", func() {
 		)
 
 		config := backend.Config{
-			FileServerURL:          "http://file-server.com",
-			CCUploaderURL:          "http://cc-uploader.com",
-			ConsulCluster:          server.URL(),
-			DockerRegistryAddress:  dockerRegistryAddress,
-			InsecureDockerRegistry: insecureDockerRegistry,
+			FileServerURL:                "http://file-server.com",
+			CCUploaderURL:                "http://cc-uploader.com",
+			ConsulCluster:                server.URL(),
+			DockerRegistryAddress:        registryAddress,
+			InsecureDockerRegistry:       insecureDockerRegistry,
+			DockerImageCaching:           dockerImageCaching,
+			AllowInlineDockerCredentials: true,
 			Lifecycles: map[string]string{
 				"docker": "docker_lifecycle/docker_app_lifecycle.tgz",
 			},
@@ -87,6 +95,7 @@ var _ = Describe("DockerBackend", func() {
 		user = ""
 		password = ""
 		email = ""
+		dockerImageCaching = false
 	})
 
 	Context("when docker registry is running", func() {
@@ -116,10 +125,10 @@ var _ = Describe("DockerBackend", func() {
 		})
 
 		JustBeforeEach(func() {
-			docker = setupDockerBackend(insecureDockerRegistry, fmt.Sprintf(
+			docker = setupDockerBackend(dockerRegistryAddress, insecureDockerRegistry, fmt.Sprintf(
 				`[
-						{"Address": "%s"},
-						{"Address": "%s"}
+						{"Node": {"Address": "%s"}, "Service": {"Address": ""}},
+						{"Node": {"Address": "%s"}, "Service": {"Address": ""}}
 				 ]`,
 				dockerRegistryIPs[0], dockerRegistryIPs[1]))
 
@@ -154,7 +163,7 @@ var _ = Describe("DockerBackend", func() {
 			Expect(actions[1].GetEmitProgressAction()).To(Equal(expectedRunAction))
 		}
 
-		Context("user did not opt-in for docker image caching", func() {
+		Context("docker image caching is disabled", func() {
 			It("creates a cf-app-docker-staging Task with no additional egress rules", func() {
 				taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
@@ -162,15 +171,16 @@ var _ = Describe("DockerBackend", func() {
 			})
 		})
 
-		Context("user opted-in for docker image caching", func() {
-			modelsCachingVar := &models.EnvironmentVariable{Name: "DIEGO_DOCKER_CACHE", Value: "true"}
+		Context("docker image caching is enabled", func() {
 			var (
 				internalRunAction models.RunAction
 			)
 
+			BeforeEach(func() {
+				dockerImageCaching = true
+			})
+
 			JustBeforeEach(func() {
-				cachingVar := &models.EnvironmentVariable{Name: "DIEGO_DOCKER_CACHE", Value: "true"}
-				stagingRequest.Environment = append(stagingRequest.Environment, cachingVar)
 				fileDescriptorLimit := uint64(512)
 				internalRunAction = models.RunAction{
 					Path: "/tmp/docker_app_lifecycle/builder",
@@ -187,7 +197,6 @@ var _ = Describe("DockerBackend", func() {
 						"-dockerRegistryIPs",
 						strings.Join(dockerRegistryIPs, ","),
 					},
-					Env: []*models.EnvironmentVariable{modelsCachingVar},
 					ResourceLimits: &models.ResourceLimits{
 						Nofile: &fileDescriptorLimit,
 					},
@@ -243,21 +252,100 @@ var _ = Describe("DockerBackend", func() {
 		})
 	})
 
+	Context("when docker registry listens on another port", func() {
+		It("allows egress to that port", func() {
+			dockerImageCaching = true
+			docker := setupDockerBackend(dockerRegistryHost+":5000", false, fmt.Sprintf(`[{"Node": {"Address": "%s"}}]`, dockerRegistryIPs[0]))
+
+			stagingRequest := setupStagingRequest()
+
+			taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.EgressRules).To(ContainElement(&models.SecurityGroupRule{
+				Protocol:     models.TCPProtocol,
+				Destinations: []string{dockerRegistryIPs[0]},
+				Ports:        []uint32{5000},
+			}))
+		})
+	})
+
+	Context("when docker registry instances register their own address", func() {
+		It("allows egress to the service address rather than the node's", func() {
+			dockerImageCaching = true
+			docker := setupDockerBackend(dockerRegistryAddress, false, fmt.Sprintf(`[{"Node": {"Address": "10.0.16.4"}, "Service": {"Address": "%s"}}]`, dockerRegistryIPs[0]))
+
+			stagingRequest := setupStagingRequest()
+
+			taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.EgressRules).To(ContainElement(&models.SecurityGroupRule{
+				Protocol:     models.TCPProtocol,
+				Destinations: []string{dockerRegistryIPs[0]},
+				Ports:        []uint32{dockerRegistryPort},
+			}))
+			for _, rule := range taskDef.EgressRules {
+				Expect(rule.Destinations).NotTo(ContainElement("10.0.16.4"))
+			}
+		})
+	})
+
+	Context("when the staging request has egress rules", func() {
+		It("keeps each of them once alongside the registry's", func() {
+			dockerImageCaching = true
+			docker := setupDockerBackend(dockerRegistryAddress, false, fmt.Sprintf(`[{"Node": {"Address": "%s"}}]`, dockerRegistryIPs[0]))
+
+			requestRule := &models.SecurityGroupRule{Protocol: models.TCPProtocol, Destinations: []string{"0.0.0.0/0"}, Ports: []uint32{443}}
+			stagingRequest := setupStagingRequest()
+			stagingRequest.EgressRules = []*models.SecurityGroupRule{requestRule}
+
+			taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.EgressRules).To(ConsistOf(requestRule, &models.SecurityGroupRule{
+				Protocol:     models.TCPProtocol,
+				Destinations: []string{dockerRegistryIPs[0]},
+				Ports:        []uint32{dockerRegistryPort},
+			}))
+		})
+	})
+
+	Context("when docker registry instances register IPv6 addresses or CIDRs", func() {
+		It("allows egress to them", func() {
+			dockerImageCaching = true
+			docker := setupDockerBackend(dockerRegistryAddress, false, `[
+				{"Node": {"Address": "10.0.16.4"}, "Service": {"Address": "[fd00::6]"}},
+				{"Node": {"Address": "fe80::7%eth0"}},
+				{"Node": {"Address": "10.244.3.0/24"}},
+				{"Node": {"Address": "registry-host"}}
+			]`)
+
+			taskDef, _, _, err := docker.BuildRecipe(stagingGuid, setupStagingRequest())
+			Expect(err).NotTo(HaveOccurred())
+
+			var destinations []string
+			for _, rule := range taskDef.EgressRules {
+				destinations = append(destinations, rule.Destinations...)
+			}
+			Expect(destinations).To(ConsistOf("fd00::6", "fe80::7", "10.244.3.0/24"))
+
+			runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+			Expect(runAction.Args).To(ContainElement("fd00::6,fe80::7"))
+		})
+	})
+
 	Context("when Docker Registry is not running", func() {
 		var (
 			docker         backend.Backend
 			stagingRequest cc_messages.StagingRequestFromCC
 		)
 
-		BeforeEach(func() {
-			docker = setupDockerBackend(true, "[]")
+		JustBeforeEach(func() {
+			docker = setupDockerBackend(dockerRegistryAddress, true, "[]")
 			stagingRequest = setupStagingRequest()
 		})
 
-		Context("and user opted-in for docker image caching", func() {
+		Context("and docker image caching is enabled", func() {
 			BeforeEach(func() {
-				cachingVar := &models.EnvironmentVariable{Name: "DIEGO_DOCKER_CACHE", Value: "true"}
-				stagingRequest.Environment = append(stagingRequest.Environment, cachingVar)
+				dockerImageCaching = true
 			})
 
 			It("errors", func() {
@@ -267,11 +355,173 @@ var _ = Describe("DockerBackend", func() {
 			})
 		})
 
-		Context("and user did not opt-in for docker image caching", func() {
+		Context("and docker image caching is disabled", func() {
 			It("does not error", func() {
 				_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
 	})
+
+	Describe("discovering the docker registry in a secured consul", func() {
+		var (
+			consul         *ghttp.Server
+			config         backend.Config
+			stagingRequest cc_messages.StagingRequestFromCC
+		)
+
+		BeforeEach(func() {
+			consul = ghttp.NewTLSServer() // self-signed certificate
+			consul.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v1/health/service/docker-registry", "passing"),
+					ghttp.VerifyHeaderKV(backend.ConsulTokenHeader, "consul-acl-token"),
+					ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"Node": {"Address": "%s"}}]`, dockerRegistryIPs[0])),
+				),
+			)
+
+			// muffle server-side log of certificate error
+			consul.HTTPTestServer.Config.ErrorLog = log.New(ioutil.Discard, "", log.Flags())
+
+			config = backend.Config{
+				FileServerURL:         "http://file-server.com",
+				ConsulCluster:         consul.URL(),
+				ConsulACLToken:        "consul-acl-token",
+				DockerRegistryAddress: dockerRegistryAddress,
+				DockerImageCaching:    true,
+				Lifecycles: map[string]string{
+					"docker": "docker_lifecycle/docker_app_lifecycle.tgz",
+				},
+			}
+
+			stagingRequest = setupStagingRequest()
+		})
+
+		AfterEach(func() {
+			consul.Close()
+		})
+
+		It("uses the configured TLS config and ACL token", func() {
+			config.ConsulTLSConfig = &tls.Config{InsecureSkipVerify: true}
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			taskDef, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.EgressRules).To(ContainElement(&models.SecurityGroupRule{
+				Protocol:     models.TCPProtocol,
+				Destinations: []string{dockerRegistryIPs[0]},
+				Ports:        []uint32{dockerRegistryPort},
+			}))
+		})
+
+		It("fails discovery when the agent's certificate isn't trusted", func() {
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryDiscoveryErrorId))
+		})
+
+		It("fails discovery when consul denies the request", func() {
+			consul.SetHandler(0, ghttp.RespondWith(http.StatusForbidden, "Permission denied"))
+			config.ConsulTLSConfig = &tls.Config{InsecureSkipVerify: true}
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryDiscoveryErrorId))
+		})
+	})
+
+	Describe("consul failures", func() {
+		var (
+			consul         *ghttp.Server
+			config         backend.Config
+			stagingRequest cc_messages.StagingRequestFromCC
+		)
+
+		BeforeEach(func() {
+			consul = ghttp.NewServer()
+
+			config = backend.Config{
+				FileServerURL:         "http://file-server.com",
+				ConsulCluster:         consul.URL(),
+				ConsulTimeout:         100 * time.Millisecond,
+				ConsulRetryAttempts:   1,
+				DockerRegistryAddress: dockerRegistryAddress,
+				DockerImageCaching:    true,
+				Lifecycles: map[string]string{
+					"docker": "docker_lifecycle/docker_app_lifecycle.tgz",
+				},
+			}
+
+			stagingRequest = setupStagingRequest()
+		})
+
+		AfterEach(func() {
+			consul.Close()
+		})
+
+		It("retries server errors", func() {
+			consul.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, "No cluster leader"),
+				ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"Node": {"Address": "%s"}}]`, dockerRegistryIPs[0])),
+			)
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(consul.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("waits on the configured clock between attempts", func() {
+			consul.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, "No cluster leader"),
+				ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"Node": {"Address": "%s"}}]`, dockerRegistryIPs[0])),
+			)
+			fakeClock := fakeclock.NewFakeClock(time.Now())
+			config.Clock = fakeClock
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			errs := make(chan error, 1)
+			go func() {
+				_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+				errs <- err
+			}()
+
+			Eventually(consul.ReceivedRequests).Should(HaveLen(1))
+			Consistently(consul.ReceivedRequests).Should(HaveLen(1))
+
+			Eventually(func() int {
+				fakeClock.Increment(250 * time.Millisecond)
+				return len(consul.ReceivedRequests())
+			}).Should(Equal(2))
+			Eventually(errs).Should(Receive(BeNil()))
+		})
+
+		It("doesn't retry client errors", func() {
+			consul.AppendHandlers(
+				ghttp.RespondWith(http.StatusForbidden, "Permission denied"),
+			)
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryDiscoveryErrorId))
+			Expect(consul.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("fails with a distinct error when consul doesn't answer in time", func() {
+			hang := func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(time.Second)
+			}
+			consul.AppendHandlers(hang, hang)
+			docker := backend.NewDockerBackend(config, lager.NewLogger("fakelogger"))
+
+			_, _, _, err := docker.BuildRecipe(stagingGuid, stagingRequest)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.DockerRegistryDiscoveryTimeoutErrorId))
+			Expect(err.(backend.Error).Retryable()).To(BeFalse())
+		})
+	})
 })
@@ -0,0 +1,34 @@
+package backend
+
+import (
+	"encoding/json"
+)
+
+// windowsDockerBuilderPath is where Windows docker staging runs the builder.
+// Like the Windows lifecycle's, the path uses forward slashes.
+const windowsDockerBuilderPath = "/tmp/docker_app_lifecycle/builder.exe"
+
+// dockerStagingStack returns the stack that a docker staging request runs
+// on, and whether it is Windows. Requests stage on WindowsDockerStagingStack
+// when their lifecycle data's stack names it, and on DockerStagingStack
+// otherwise.
+func (c Config) dockerStagingStack(lifecycleData json.RawMessage) (string, bool) {
+	var options struct {
+		Stack string `json:"stack"`
+	}
+	json.Unmarshal(lifecycleData, &options)
+
+	if c.WindowsDockerStagingStack != "" && options.Stack == c.WindowsDockerStagingStack {
+		return c.WindowsDockerStagingStack, true
+	}
+	return c.DockerStagingStack, false
+}
+
+// dockerLifecycleKey is the Lifecycles key of the docker lifecycle bundle for
+// stack. Windows stacks have their own bundle, e.g. docker/windows2016.
+func dockerLifecycleKey(stack string, windows bool) string {
+	if windows {
+		return DockerLifecycleName + "/" + stack
+	}
+	return DockerLifecycleName
+}
@@ -0,0 +1,92 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Windows docker staging", func() {
+	var (
+		config        backend.Config
+		lifecycleData string
+	)
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:             "http://file-server.com",
+			DockerStagingStack:        "cflinuxfs2",
+			WindowsDockerStagingStack: "windows2016",
+			DockerRegistryAddress:     "docker-registry.service.cf.internal:8080",
+			MaxStagingPids:            1024,
+			Lifecycles: map[string]string{
+				"docker":             "docker_app_lifecycle.tgz",
+				"docker/windows2016": "windows_docker_app_lifecycle.tgz",
+			},
+		}
+		lifecycleData = `{"docker_image": "mcr.microsoft.com/windows/servercore/iis", "stack": "windows2016"}`
+	})
+
+	buildRecipe := func() (*models.TaskDefinition, error) {
+		data := json.RawMessage(lifecycleData)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+		})
+		return taskDef, err
+	}
+
+	It("stages images that name the Windows stack on it, unprivileged", func() {
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("windows2016")))
+		Expect(taskDef.Privileged).To(BeFalse())
+		Expect(taskDef.MaxPids).To(BeZero())
+
+		actions := actionsFromTaskDef(taskDef)
+		download := actions[0].GetEmitProgressAction().Action.GetDownloadAction()
+		Expect(download.From).To(Equal("http://file-server.com/v1/static/windows_docker_app_lifecycle.tgz"))
+		Expect(download.To).To(Equal("/tmp/docker_app_lifecycle"))
+
+		runAction := actions[1].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Path).To(Equal("/tmp/docker_app_lifecycle/builder.exe"))
+		Expect(runAction.User).To(Equal("vcap"))
+	})
+
+	It("doesn't cache Windows images", func() {
+		config.DockerImageCaching = true
+
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Args).NotTo(ContainElement("-cacheDockerImage"))
+		Expect(taskDef.EgressRules).To(BeEmpty())
+	})
+
+	It("fails without a lifecycle bundle for the Windows stack", func() {
+		delete(config.Lifecycles, "docker/windows2016")
+
+		_, err := buildRecipe()
+		Expect(err).To(Equal(backend.ErrNoCompilerDefined))
+	})
+
+	It("stages other images on the docker staging stack", func() {
+		lifecycleData = `{"docker_image": "busybox"}`
+
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("cflinuxfs2")))
+		Expect(taskDef.Privileged).To(BeTrue())
+		runAction := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction.Path).To(Equal("/tmp/docker_app_lifecycle/builder"))
+	})
+})
@@ -0,0 +1,253 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+)
+
+const (
+	// ecrTokenRefreshMargin is how long before a cached ECR token expires
+	// that a new one is fetched, so that it outlives the staging task.
+	ecrTokenRefreshMargin = 2 * time.Hour
+
+	ecrCredentialHelper = "ecr-login"
+	ecrTarget           = "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken"
+)
+
+var ErrECRRegistryIncomplete = errors.New("ECR registries need a registry and either an access key or use_iam_role")
+
+// ECRRegistry authenticates docker staging to an AWS ECR registry, e.g.
+// 123456789.dkr.ecr.us-east-1.amazonaws.com. With an access key, the stager
+// exchanges it for a temporary token. With UseIAMRole, the builder gets the
+// token itself with the ecr-login credential helper and the cell's IAM role.
+type ECRRegistry struct {
+	Registry        string `json:"registry"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	UseIAMRole      bool   `json:"use_iam_role,omitempty"`
+
+	// Endpoint overrides the registry region's ECR API endpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// LoadECRRegistries reads a JSON list of ECR registries from a file.
+func LoadECRRegistries(file string) ([]ECRRegistry, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var registries []ECRRegistry
+	err = json.Unmarshal(contents, &registries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, registry := range registries {
+		if registry.Registry == "" || (!registry.UseIAMRole && (registry.AccessKeyID == "" || registry.SecretAccessKey == "")) {
+			return nil, ErrECRRegistryIncomplete
+		}
+	}
+
+	return registries, nil
+}
+
+// ECRAuthenticator gets docker credentials for ECR registries, caching
+// temporary tokens until they are close to expiring.
+type ECRAuthenticator struct {
+	registries map[string]ECRRegistry
+	httpClient *http.Client
+	clock      clock.Clock
+
+	lock   sync.Mutex
+	tokens map[string]ecrToken
+}
+
+type ecrToken struct {
+	auth      string
+	expiresAt time.Time
+}
+
+func NewECRAuthenticator(registries []ECRRegistry, httpClient *http.Client, clock clock.Clock) *ECRAuthenticator {
+	byHost := make(map[string]ECRRegistry, len(registries))
+	for _, registry := range registries {
+		byHost[strings.ToLower(registry.Registry)] = registry
+	}
+
+	return &ECRAuthenticator{
+		registries: byHost,
+		httpClient: httpClient,
+		clock:      clock,
+		tokens:     map[string]ecrToken{},
+	}
+}
+
+// Authenticate adds the credentials for registry, if it is a configured ECR
+// registry, to config.
+func (a *ECRAuthenticator) Authenticate(registry string, config *dockerAuthConfig) error {
+	ecrRegistry, ok := a.registries[registry]
+	if !ok {
+		return nil
+	}
+
+	if ecrRegistry.UseIAMRole {
+		if config.CredHelpers == nil {
+			config.CredHelpers = map[string]string{}
+		}
+		config.CredHelpers[registry] = ecrCredentialHelper
+		return nil
+	}
+
+	auth, err := a.token(ecrRegistry)
+	if err != nil {
+		return NewDependencyError(ECRAuthenticationErrorId, fmt.Sprintf("failed to get an ECR token for %s: %s", registry, err))
+	}
+
+	if config.Auths == nil {
+		config.Auths = map[string]dockerRegistryAuth{}
+	}
+	config.Auths[registry] = dockerRegistryAuth{Auth: auth}
+	return nil
+}
+
+func (a *ECRAuthenticator) token(registry ECRRegistry) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := a.clock.Now()
+	if token, ok := a.tokens[registry.Registry]; ok && now.Add(ecrTokenRefreshMargin).Before(token.expiresAt) {
+		return token.auth, nil
+	}
+
+	token, err := a.fetchToken(registry, now)
+	if err != nil {
+		return "", err
+	}
+
+	a.tokens[registry.Registry] = token
+	return token.auth, nil
+}
+
+func (a *ECRAuthenticator) fetchToken(registry ECRRegistry, now time.Time) (ecrToken, error) {
+	region, err := ecrRegion(registry.Registry)
+	if err != nil {
+		return ecrToken{}, err
+	}
+
+	endpoint := registry.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://api.ecr.%s.amazonaws.com", region)
+	}
+
+	body := []byte("{}")
+	request, err := http.NewRequest("POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return ecrToken{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", ecrTarget)
+	signAWSRequest(request, body, region, "ecr", registry.AccessKeyID, registry.SecretAccessKey, now)
+
+	response, err := a.httpClient.Do(request)
+	if err != nil {
+		return ecrToken{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return ecrToken{}, fmt.Errorf("ECR responded with status %d", response.StatusCode)
+	}
+
+	var result struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return ecrToken{}, err
+	}
+	if len(result.AuthorizationData) == 0 || result.AuthorizationData[0].AuthorizationToken == "" {
+		return ecrToken{}, errors.New("ECR returned no authorization token")
+	}
+
+	data := result.AuthorizationData[0]
+	return ecrToken{
+		auth:      data.AuthorizationToken,
+		expiresAt: time.Unix(int64(data.ExpiresAt), 0),
+	}, nil
+}
+
+// ecrRegion returns the region of an ECR registry host,
+// <account>.dkr.ecr.<region>.amazonaws.com.
+func ecrRegion(registry string) (string, error) {
+	parts := strings.Split(registry, ".")
+	if len(parts) < 6 || parts[1] != "dkr" || parts[2] != "ecr" {
+		return "", fmt.Errorf("%s is not an ECR registry", registry)
+	}
+	return parts[3], nil
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4.
+func signAWSRequest(request *http.Request, body []byte, region, service, accessKeyID, secretAccessKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-date;x-amz-target"
+	canonicalHeaders := "content-type:" + request.Header.Get("Content-Type") + "\n" +
+		"host:" + request.URL.Host + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"x-amz-target:" + request.Header.Get("X-Amz-Target") + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		"/",
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,182 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("ECR registries", func() {
+	const registry = "123456789.dkr.ecr.us-east-1.amazonaws.com"
+
+	var (
+		ecrServer  *ghttp.Server
+		fakeClock  *fakeclock.FakeClock
+		registries []backend.ECRRegistry
+		config     backend.Config
+	)
+
+	BeforeEach(func() {
+		ecrServer = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1500000000, 0))
+
+		registries = []backend.ECRRegistry{{
+			Registry:        registry,
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+			Endpoint:        ecrServer.URL(),
+		}}
+	})
+
+	AfterEach(func() {
+		ecrServer.Close()
+	})
+
+	JustBeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+			ECRAuthenticator: backend.NewECRAuthenticator(registries, http.DefaultClient, fakeClock),
+		}
+	})
+
+	stage := func(image string) ([]*models.EnvironmentVariable, error) {
+		lifecycleData := json.RawMessage(`{"docker_image": "` + image + `"}`)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &lifecycleData,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		actions := actionsFromTaskDef(taskDef)
+		return actions[len(actions)-1].GetEmitProgressAction().Action.GetRunAction().Env, nil
+	}
+
+	authConfig := func(env []*models.EnvironmentVariable) string {
+		for _, envVar := range env {
+			if envVar.Name == backend.DockerAuthConfigEnvVar {
+				return envVar.Value
+			}
+		}
+		return ""
+	}
+
+	Context("with an access key", func() {
+		BeforeEach(func() {
+			ecrServer.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/"),
+				ghttp.VerifyHeaderKV("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken"),
+				ghttp.VerifyHeaderKV("X-Amz-Date", "20170714T024000Z"),
+				func(w http.ResponseWriter, req *http.Request) {
+					Expect(req.Header.Get("Authorization")).To(HavePrefix("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20170714/us-east-1/ecr/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-target, Signature="))
+				},
+				ghttp.RespondWith(http.StatusOK, `{"authorizationData": [{"authorizationToken": "QVdTOnRva2Vu", "expiresAt": 1500043200}]}`),
+			))
+		})
+
+		It("gives the builder a temporary token for the registry", func() {
+			env, err := stage(registry + "/app:v1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authConfig(env)).To(MatchJSON(`{"auths": {"` + registry + `": {"auth": "QVdTOnRva2Vu"}}}`))
+		})
+
+		It("reuses the token until it is close to expiring", func() {
+			_, err := stage(registry + "/app:v1")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeClock.Increment(time.Hour)
+			_, err = stage(registry + "/app:v1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ecrServer.ReceivedRequests()).To(HaveLen(1))
+
+			ecrServer.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{"authorizationData": [{"authorizationToken": "QVdTOm5ldw==", "expiresAt": 1500086400}]}`))
+			fakeClock.Increment(9 * time.Hour)
+			env, err := stage(registry + "/app:v1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ecrServer.ReceivedRequests()).To(HaveLen(2))
+			Expect(authConfig(env)).To(MatchJSON(`{"auths": {"` + registry + `": {"auth": "QVdTOm5ldw=="}}}`))
+		})
+
+		It("doesn't authenticate to other registries", func() {
+			env, err := stage("busybox")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authConfig(env)).To(BeEmpty())
+			Expect(ecrServer.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("when ECR rejects the access key", func() {
+		BeforeEach(func() {
+			ecrServer.AppendHandlers(ghttp.RespondWith(http.StatusForbidden, `{}`))
+		})
+
+		It("fails staging with a retryable error", func() {
+			_, err := stage(registry + "/app:v1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(backend.Error).Id()).To(Equal(backend.ECRAuthenticationErrorId))
+			Expect(err.(backend.Error).Retryable()).To(BeTrue())
+		})
+	})
+
+	Context("with an IAM role", func() {
+		BeforeEach(func() {
+			registries = []backend.ECRRegistry{{Registry: registry, UseIAMRole: true}}
+		})
+
+		It("has the builder use the ecr-login credential helper", func() {
+			env, err := stage(registry + "/app:v1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authConfig(env)).To(MatchJSON(`{"credHelpers": {"` + registry + `": "ecr-login"}}`))
+		})
+	})
+
+	Describe("LoadECRRegistries", func() {
+		var file *os.File
+
+		BeforeEach(func() {
+			var err error
+			file, err = ioutil.TempFile("", "ecr-registries")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.Remove(file.Name())
+		})
+
+		It("reads a JSON list of registries", func() {
+			_, err := file.WriteString(`[{"registry": "` + registry + `", "use_iam_role": true}]`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			registries, err := backend.LoadECRRegistries(file.Name())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(registries).To(Equal([]backend.ECRRegistry{{Registry: registry, UseIAMRole: true}}))
+		})
+
+		It("rejects registries without credentials", func() {
+			_, err := file.WriteString(`[{"registry": "` + registry + `"}]`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			_, err = backend.LoadECRRegistries(file.Name())
+			Expect(err).To(Equal(backend.ErrECRRegistryIncomplete))
+		})
+	})
+})
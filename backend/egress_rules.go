@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+// LoadEgressRules reads a JSON list of security group rules, in the format
+// the CC sends them, from a file. It fails if any rule is invalid.
+func LoadEgressRules(file string) ([]*models.SecurityGroupRule, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*models.SecurityGroupRule
+	err = json.Unmarshal(contents, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		err = rule.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+// egressRules appends the operator's baseline rules, e.g. for DNS, the
+// blobstore or a proxy, to those the CC requested, so that staging can reach
+// them even when the app's security groups don't allow it.
+func (c Config) egressRules(requested []*models.SecurityGroupRule) []*models.SecurityGroupRule {
+	if len(c.DefaultEgressRules) == 0 {
+		return requested
+	}
+
+	rules := make([]*models.SecurityGroupRule, 0, len(requested)+len(c.DefaultEgressRules))
+	rules = append(rules, requested...)
+	return append(rules, c.DefaultEgressRules...)
+}
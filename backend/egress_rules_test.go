@@ -0,0 +1,101 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Default egress rules", func() {
+	var (
+		config   backend.Config
+		dnsRule  *models.SecurityGroupRule
+		appRules []*models.SecurityGroupRule
+	)
+
+	BeforeEach(func() {
+		dnsRule = &models.SecurityGroupRule{
+			Protocol:     models.UDPProtocol,
+			Destinations: []string{"10.0.0.2"},
+			Ports:        []uint32{53},
+		}
+
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+			DefaultEgressRules: []*models.SecurityGroupRule{dnsRule},
+		}
+
+		appRules = nil
+	})
+
+	buildEgressRules := func() []*models.SecurityGroupRule {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+			EgressRules:   appRules,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return taskDef.EgressRules
+	}
+
+	It("adds them when the CC sends no rules", func() {
+		Expect(buildEgressRules()).To(Equal([]*models.SecurityGroupRule{dnsRule}))
+	})
+
+	It("adds them after the app's rules", func() {
+		appRule := &models.SecurityGroupRule{
+			Protocol:     models.TCPProtocol,
+			Destinations: []string{"0.0.0.0/0"},
+			PortRange:    &models.PortRange{Start: 80, End: 443},
+		}
+		appRules = []*models.SecurityGroupRule{appRule}
+
+		Expect(buildEgressRules()).To(Equal([]*models.SecurityGroupRule{appRule, dnsRule}))
+	})
+
+	Describe("LoadEgressRules", func() {
+		var file *os.File
+
+		BeforeEach(func() {
+			var err error
+			file, err = ioutil.TempFile("", "egress-rules")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.Remove(file.Name())
+		})
+
+		It("reads a JSON list of rules", func() {
+			_, err := file.WriteString(`[{"protocol": "udp", "destinations": ["10.0.0.2"], "ports": [53]}]`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			rules, err := backend.LoadEgressRules(file.Name())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rules).To(Equal([]*models.SecurityGroupRule{dnsRule}))
+		})
+
+		It("rejects invalid rules", func() {
+			_, err := file.WriteString(`[{"protocol": "udp", "destinations": ["10.0.0.2"]}]`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			_, err = backend.LoadEgressRules(file.Name())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
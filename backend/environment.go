@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+var ErrEnvironmentVariableFormatInvalid = errors.New("staging environment variables must be NAME=value")
+
+// StagingEnvironment holds environment variables that operators add to every
+// staging container, e.g. JAVA_OPTS defaults or internal mirror URLs. It
+// implements flag.Value so that variables can be set by repeating a command
+// line flag.
+type StagingEnvironment map[string]string
+
+func (e *StagingEnvironment) String() string {
+	names := make([]string, 0, len(*e))
+	for name := range *e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (e *StagingEnvironment) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrEnvironmentVariableFormatInvalid
+	}
+
+	if *e == nil {
+		*e = StagingEnvironment{}
+	}
+	(*e)[parts[0]] = parts[1]
+	return nil
+}
+
+// stagingEnvironment adds the operator's variables to those the CC requested.
+// Requested variables win, so apps can still override the defaults.
+func (c Config) stagingEnvironment(requested []*models.EnvironmentVariable) []*models.EnvironmentVariable {
+	if len(c.StagingEnvironment) == 0 {
+		return requested
+	}
+
+	requestedNames := make(map[string]bool, len(requested))
+	for _, envVar := range requested {
+		requestedNames[envVar.Name] = true
+	}
+
+	names := make([]string, 0, len(c.StagingEnvironment))
+	for name := range c.StagingEnvironment {
+		if !requestedNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	environment := make([]*models.EnvironmentVariable, 0, len(requested)+len(names))
+	environment = append(environment, requested...)
+	for _, name := range names {
+		environment = append(environment, &models.EnvironmentVariable{Name: name, Value: c.StagingEnvironment[name]})
+	}
+	return environment
+}
+
+// withEnvironmentVariable sets envVar, if given, in the builder's
+// environment, replacing any variable of the same name.
+func withEnvironmentVariable(environment []*models.EnvironmentVariable, envVar *models.EnvironmentVariable) []*models.EnvironmentVariable {
+	if envVar == nil {
+		return environment
+	}
+
+	withVar := make([]*models.EnvironmentVariable, 0, len(environment)+1)
+	for _, existing := range environment {
+		if existing.Name != envVar.Name {
+			withVar = append(withVar, existing)
+		}
+	}
+	return append(withVar, envVar)
+}
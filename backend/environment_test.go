@@ -0,0 +1,65 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Staging environment", func() {
+	It("adds operator variables under those the request sets", func() {
+		config := backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"windows/windows2012R2": "windows_app_lifecycle.tgz",
+			},
+			StagingEnvironment: backend.StagingEnvironment{
+				"JAVA_OPTS":  "-Xss1m",
+				"MIRROR_URL": "http://mirror.internal",
+			},
+		}
+
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "windows2012R2", "buildpacks": []}`)
+		request := cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "windows",
+			LifecycleData: &lifecycleData,
+			Environment: []*models.EnvironmentVariable{
+				{Name: "VCAP_APPLICATION", Value: "foo"},
+				{Name: "JAVA_OPTS", Value: "-Xss2m"},
+			},
+		}
+
+		taskDef, _, _, err := backend.NewWindowsBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", request)
+		Expect(err).NotTo(HaveOccurred())
+
+		actions := actionsFromTaskDef(taskDef)
+		runAction := actions[2].GetEmitProgressAction().Action.GetRunAction()
+		Expect(runAction).NotTo(BeNil())
+		Expect(runAction.Env).To(Equal([]*models.EnvironmentVariable{
+			{Name: "VCAP_APPLICATION", Value: "foo"},
+			{Name: "JAVA_OPTS", Value: "-Xss2m"},
+			{Name: "MIRROR_URL", Value: "http://mirror.internal"},
+		}))
+	})
+
+	Describe("StagingEnvironment", func() {
+		It("parses NAME=value, keeping any = in the value", func() {
+			environment := backend.StagingEnvironment{}
+			Expect(environment.Set("JAVA_OPTS=-Dfoo=bar")).To(Succeed())
+			Expect(environment).To(Equal(backend.StagingEnvironment{"JAVA_OPTS": "-Dfoo=bar"}))
+		})
+
+		It("rejects variables without a name", func() {
+			environment := backend.StagingEnvironment{}
+			Expect(environment.Set("=value")).To(Equal(backend.ErrEnvironmentVariableFormatInvalid))
+			Expect(environment.Set("JAVA_OPTS")).To(Equal(backend.ErrEnvironmentVariableFormatInvalid))
+		})
+	})
+})
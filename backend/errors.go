@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// Error ids reported to the CC in StagingResponseForCC. Failures that the CC
+// already has an id for (e.g. cc_messages.INSUFFICIENT_RESOURCES) keep that
+// id; the ids below are stable and documented in the README.
+const (
+	MissingAppIdErrorId                   = "MissingAppId"
+	MissingAppBitsDownloadUriErrorId      = "MissingAppBitsDownloadUri"
+	MissingLifecycleDataErrorId           = "MissingLifecycleData"
+	InvalidLifecycleDataErrorId           = "InvalidLifecycleData"
+	InvalidDownloadURLErrorId             = "InvalidDownloadURL"
+	NoCompilerDefinedErrorId              = "NoCompilerDefined"
+	InvalidCompilerURLErrorId             = "InvalidCompilerURL"
+	InvalidUploadURLErrorId               = "InvalidUploadURL"
+	MissingDockerImageUrlErrorId          = "MissingDockerImageUrl"
+	MissingDockerCredentialsErrorId       = "MissingDockerCredentials"
+	InvalidDockerRegistryAddressErrorId   = "InvalidDockerRegistryAddress"
+	MissingDockerRegistryErrorId          = "MissingDockerRegistry"
+	DockerRegistryDiscoveryErrorId        = "DockerRegistryDiscoveryFailed"
+	DockerRegistryDiscoveryTimeoutErrorId = "DockerRegistryDiscoveryTimedOut"
+	StagingTimedOutErrorId                = "StagingTimedOut"
+	InvalidStagingResultErrorId           = "InvalidStagingResult"
+	InvalidStagingResponseErrorId         = "InvalidStagingResponse"
+	InvalidCompletionAPIErrorId           = "InvalidCompletionAPI"
+	UnknownCCTargetErrorId                = "UnknownCCTarget"
+	InvalidChecksumErrorId                = "InvalidChecksum"
+	ChecksumMismatchErrorId               = "ChecksumMismatch"
+	InvalidVolumeMountErrorId             = "InvalidVolumeMount"
+	DockerRegistryNotAllowedErrorId       = "DockerRegistryNotAllowed"
+	ECRAuthenticationErrorId              = "ECRAuthenticationFailed"
+	DockerPlatformMismatchErrorId         = "DockerPlatformMismatch"
+	DockerImageTooLargeErrorId            = "DockerImageTooLarge"
+	LifecycleNotSupportedErrorId          = "LifecycleNotSupported"
+	InlineDockerCredentialsErrorId        = "InlineDockerCredentials"
+)
+
+// Error is implemented by every error a Backend returns while building a
+// recipe, so callers can decide how to handle it without matching strings.
+type Error interface {
+	error
+	Id() string
+	Retryable() bool
+}
+
+// ValidationError means the staging request itself is unusable and should be
+// rejected back to the CC.
+type ValidationError struct {
+	id      string
+	message string
+}
+
+func NewValidationError(id, message string) *ValidationError {
+	return &ValidationError{id: id, message: message}
+}
+
+func (e *ValidationError) Error() string   { return e.message }
+func (e *ValidationError) Id() string      { return e.id }
+func (e *ValidationError) Retryable() bool { return false }
+
+// ConfigurationError means the stager is misconfigured for the request and
+// an operator needs to intervene.
+type ConfigurationError struct {
+	id      string
+	message string
+}
+
+func NewConfigurationError(id, message string) *ConfigurationError {
+	return &ConfigurationError{id: id, message: message}
+}
+
+func (e *ConfigurationError) Error() string   { return e.message }
+func (e *ConfigurationError) Id() string      { return e.id }
+func (e *ConfigurationError) Retryable() bool { return false }
+
+// DependencyError means a service the backend relies on (e.g. consul) could
+// not be reached or returned something unusable. It is worth retrying unless
+// the backend has already retried the service itself.
+type DependencyError struct {
+	id        string
+	message   string
+	exhausted bool
+}
+
+func NewDependencyError(id, message string) *DependencyError {
+	return &DependencyError{id: id, message: message}
+}
+
+// NewExhaustedDependencyError is a DependencyError for a service the backend
+// has already retried, so that callers don't retry it again.
+func NewExhaustedDependencyError(id, message string) *DependencyError {
+	return &DependencyError{id: id, message: message, exhausted: true}
+}
+
+func (e *DependencyError) Error() string   { return e.message }
+func (e *DependencyError) Id() string      { return e.id }
+func (e *DependencyError) Retryable() bool { return !e.exhausted }
+
+// StagingErrorFor converts an error returned while building a recipe into the
+// error reported to the CC, keeping the id of typed backend errors.
+func StagingErrorFor(err error) *cc_messages.StagingError {
+	if backendErr, ok := err.(Error); ok {
+		return &cc_messages.StagingError{
+			Id:      backendErr.Id(),
+			Message: backendErr.Error(),
+		}
+	}
+
+	return SanitizeErrorMessage(err.Error())
+}
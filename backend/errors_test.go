@@ -0,0 +1,61 @@
+package backend_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Errors", func() {
+	It("does not retry validation errors", func() {
+		var err backend.Error = backend.NewValidationError("some-id", "bad request")
+		Expect(err.Id()).To(Equal("some-id"))
+		Expect(err.Error()).To(Equal("bad request"))
+		Expect(err.Retryable()).To(BeFalse())
+	})
+
+	It("does not retry configuration errors", func() {
+		var err backend.Error = backend.NewConfigurationError("some-id", "bad config")
+		Expect(err.Id()).To(Equal("some-id"))
+		Expect(err.Error()).To(Equal("bad config"))
+		Expect(err.Retryable()).To(BeFalse())
+	})
+
+	It("retries dependency errors", func() {
+		var err backend.Error = backend.NewDependencyError("some-id", "consul is down")
+		Expect(err.Id()).To(Equal("some-id"))
+		Expect(err.Error()).To(Equal("consul is down"))
+		Expect(err.Retryable()).To(BeTrue())
+	})
+
+	It("does not retry dependency errors the backend has already retried", func() {
+		var err backend.Error = backend.NewExhaustedDependencyError("some-id", "consul is still down")
+		Expect(err.Id()).To(Equal("some-id"))
+		Expect(err.Retryable()).To(BeFalse())
+	})
+
+	It("classifies the well-known backend errors", func() {
+		Expect(backend.ErrMissingAppId).To(BeAssignableToTypeOf(&backend.ValidationError{}))
+		Expect(backend.ErrNoCompilerDefined).To(BeAssignableToTypeOf(&backend.ConfigurationError{}))
+		Expect(backend.ErrMissingDockerRegistry).To(BeAssignableToTypeOf(&backend.DependencyError{}))
+	})
+
+	Describe("StagingErrorFor", func() {
+		It("keeps the id of backend errors", func() {
+			Expect(backend.StagingErrorFor(backend.ErrNoCompilerDefined)).To(Equal(&cc_messages.StagingError{
+				Id:      backend.NoCompilerDefinedErrorId,
+				Message: backend.ErrNoCompilerDefined.Error(),
+			}))
+		})
+
+		It("sanitizes other errors", func() {
+			Expect(backend.StagingErrorFor(errors.New("boom"))).To(Equal(&cc_messages.StagingError{
+				Id:      cc_messages.STAGING_ERROR,
+				Message: "staging failed",
+			}))
+		})
+	})
+})
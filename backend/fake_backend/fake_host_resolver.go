@@ -0,0 +1,104 @@
+// This file was generated by counterfeiter
+package fake_backend
+
+import (
+	"net"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/backend"
+)
+
+type FakeHostResolver struct {
+	LookupSRVStub        func(service, proto, name string) (string, []*net.SRV, error)
+	lookupSRVMutex       sync.RWMutex
+	lookupSRVArgsForCall []struct {
+		service string
+		proto   string
+		name    string
+	}
+	lookupSRVReturns struct {
+		result1 string
+		result2 []*net.SRV
+		result3 error
+	}
+	LookupHostStub        func(host string) ([]string, error)
+	lookupHostMutex       sync.RWMutex
+	lookupHostArgsForCall []struct {
+		host string
+	}
+	lookupHostReturns struct {
+		result1 []string
+		result2 error
+	}
+}
+
+func (fake *FakeHostResolver) LookupSRV(service string, proto string, name string) (string, []*net.SRV, error) {
+	fake.lookupSRVMutex.Lock()
+	fake.lookupSRVArgsForCall = append(fake.lookupSRVArgsForCall, struct {
+		service string
+		proto   string
+		name    string
+	}{service, proto, name})
+	fake.lookupSRVMutex.Unlock()
+	if fake.LookupSRVStub != nil {
+		return fake.LookupSRVStub(service, proto, name)
+	} else {
+		return fake.lookupSRVReturns.result1, fake.lookupSRVReturns.result2, fake.lookupSRVReturns.result3
+	}
+}
+
+func (fake *FakeHostResolver) LookupSRVCallCount() int {
+	fake.lookupSRVMutex.RLock()
+	defer fake.lookupSRVMutex.RUnlock()
+	return len(fake.lookupSRVArgsForCall)
+}
+
+func (fake *FakeHostResolver) LookupSRVArgsForCall(i int) (string, string, string) {
+	fake.lookupSRVMutex.RLock()
+	defer fake.lookupSRVMutex.RUnlock()
+	return fake.lookupSRVArgsForCall[i].service, fake.lookupSRVArgsForCall[i].proto, fake.lookupSRVArgsForCall[i].name
+}
+
+func (fake *FakeHostResolver) LookupSRVReturns(result1 string, result2 []*net.SRV, result3 error) {
+	fake.LookupSRVStub = nil
+	fake.lookupSRVReturns = struct {
+		result1 string
+		result2 []*net.SRV
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeHostResolver) LookupHost(host string) ([]string, error) {
+	fake.lookupHostMutex.Lock()
+	fake.lookupHostArgsForCall = append(fake.lookupHostArgsForCall, struct {
+		host string
+	}{host})
+	fake.lookupHostMutex.Unlock()
+	if fake.LookupHostStub != nil {
+		return fake.LookupHostStub(host)
+	} else {
+		return fake.lookupHostReturns.result1, fake.lookupHostReturns.result2
+	}
+}
+
+func (fake *FakeHostResolver) LookupHostCallCount() int {
+	fake.lookupHostMutex.RLock()
+	defer fake.lookupHostMutex.RUnlock()
+	return len(fake.lookupHostArgsForCall)
+}
+
+func (fake *FakeHostResolver) LookupHostArgsForCall(i int) string {
+	fake.lookupHostMutex.RLock()
+	defer fake.lookupHostMutex.RUnlock()
+	return fake.lookupHostArgsForCall[i].host
+}
+
+func (fake *FakeHostResolver) LookupHostReturns(result1 []string, result2 error) {
+	fake.LookupHostStub = nil
+	fake.lookupHostReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+var _ backend.HostResolver = new(FakeHostResolver)
@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// gcrJSONKeyUser is the user that Google Container Registry and Artifact
+// Registry expect with a service account's JSON key as the password.
+const gcrJSONKeyUser = "_json_key"
+
+// GCRServiceAccounts are the JSON keys of Google service accounts, by name,
+// that staging requests can reference instead of passing credentials.
+type GCRServiceAccounts map[string]json.RawMessage
+
+// LoadGCRServiceAccounts reads a JSON object of service account keys, keyed
+// by the name that staging requests reference them by, from a file.
+func LoadGCRServiceAccounts(file string) (GCRServiceAccounts, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts GCRServiceAccounts
+	err = json.Unmarshal(contents, &accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, key := range accounts {
+		var compacted bytes.Buffer
+		err = json.Compact(&compacted, key)
+		if err != nil {
+			return nil, err
+		}
+		accounts[name] = compacted.Bytes()
+	}
+
+	return accounts, nil
+}
+
+// gcrAuth returns the docker credentials for the service account that a
+// staging request references as docker_service_account.
+func (c Config) gcrAuth(serviceAccount string) (dockerRegistryAuth, error) {
+	key, ok := c.GCRServiceAccounts[serviceAccount]
+	if !ok {
+		return dockerRegistryAuth{}, NewValidationError(InvalidLifecycleDataErrorId, fmt.Sprintf("unknown docker service account: %s", serviceAccount))
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(gcrJSONKeyUser + ":" + string(key)))
+	return dockerRegistryAuth{Auth: auth}, nil
+}
@@ -0,0 +1,83 @@
+package backend_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("GCR service accounts", func() {
+	const key = `{"type":"service_account","client_email":"stager@project.iam.gserviceaccount.com"}`
+
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL:      "http://file-server.com",
+			DockerStagingStack: "cflinuxfs2",
+			Lifecycles: map[string]string{
+				"docker": "docker_app_lifecycle.tgz",
+			},
+			GCRServiceAccounts: backend.GCRServiceAccounts{
+				"team-a": json.RawMessage(key),
+			},
+		}
+	})
+
+	stage := func(lifecycleData string) (string, error) {
+		data := json.RawMessage(lifecycleData)
+		taskDef, _, _, err := backend.NewDockerBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "docker",
+			LifecycleData: &data,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		actions := actionsFromTaskDef(taskDef)
+		for _, envVar := range actions[len(actions)-1].GetEmitProgressAction().Action.GetRunAction().Env {
+			if envVar.Name == backend.DockerAuthConfigEnvVar {
+				return envVar.Value, nil
+			}
+		}
+		return "", nil
+	}
+
+	It("gives the builder the referenced service account's key for the image's registry", func() {
+		authConfig, err := stage(`{"docker_image": "us-docker.pkg.dev/project/repo/app", "docker_service_account": "team-a"}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		auth := base64.StdEncoding.EncodeToString([]byte("_json_key:" + key))
+		Expect(authConfig).To(MatchJSON(`{"auths": {"us-docker.pkg.dev": {"auth": "` + auth + `"}}}`))
+	})
+
+	It("rejects requests that reference an unknown service account", func() {
+		_, err := stage(`{"docker_image": "gcr.io/project/app", "docker_service_account": "team-b"}`)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidLifecycleDataErrorId))
+	})
+
+	Describe("LoadGCRServiceAccounts", func() {
+		It("reads service account keys by name", func() {
+			file, err := ioutil.TempFile("", "gcr-service-accounts")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(file.Name())
+
+			_, err = file.WriteString(`{"team-a": {"type": "service_account", "client_email": "stager@project.iam.gserviceaccount.com"}}`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+
+			accounts, err := backend.LoadGCRServiceAccounts(file.Name())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(accounts).To(Equal(backend.GCRServiceAccounts{"team-a": json.RawMessage(key)}))
+		})
+	})
+})
@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrLifecyclePrivilegeFormatInvalid = errors.New("lifecycle privileges must be lifecycle=true|false")
+
+// LifecyclePrivileges says whether each lifecycle's staging tasks run
+// privileged, overriding UnprivilegedStaging. It implements flag.Value so
+// that lifecycles can be configured by repeating a command line flag.
+type LifecyclePrivileges map[string]bool
+
+func (p *LifecyclePrivileges) String() string {
+	privileges := make([]string, 0, len(*p))
+	for lifecycle, privileged := range *p {
+		privileges = append(privileges, lifecycle+"="+strconv.FormatBool(privileged))
+	}
+	sort.Strings(privileges)
+	return strings.Join(privileges, ",")
+}
+
+func (p *LifecyclePrivileges) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrLifecyclePrivilegeFormatInvalid
+	}
+
+	privileged, err := strconv.ParseBool(parts[1])
+	if err != nil {
+		return ErrLifecyclePrivilegeFormatInvalid
+	}
+
+	if *p == nil {
+		*p = LifecyclePrivileges{}
+	}
+	(*p)[parts[0]] = privileged
+	return nil
+}
+
+// privilegedFor returns whether a lifecycle's staging tasks run privileged.
+// lifecycleDefault is what the lifecycle runs as unless configured otherwise.
+// Lifecycles that can't run privileged, like Windows, don't ask.
+func (c Config) privilegedFor(lifecycle string, lifecycleDefault bool) bool {
+	if privileged, ok := c.LifecyclePrivileges[lifecycle]; ok {
+		return privileged
+	}
+	if c.UnprivilegedStaging {
+		return false
+	}
+	return lifecycleDefault
+}
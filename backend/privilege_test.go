@@ -0,0 +1,77 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Staging privileges", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	buildpackStagingPrivileged := func() bool {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return taskDef.Privileged
+	}
+
+	It("stages privileged by default", func() {
+		Expect(buildpackStagingPrivileged()).To(BeTrue())
+	})
+
+	Context("when staging is unprivileged", func() {
+		BeforeEach(func() {
+			config.UnprivilegedStaging = true
+		})
+
+		It("stages unprivileged", func() {
+			Expect(buildpackStagingPrivileged()).To(BeFalse())
+		})
+
+		It("still stages lifecycles configured as privileged privileged", func() {
+			config.LifecyclePrivileges = backend.LifecyclePrivileges{"buildpack": true}
+			Expect(buildpackStagingPrivileged()).To(BeTrue())
+		})
+	})
+
+	It("stages lifecycles configured as unprivileged unprivileged", func() {
+		config.LifecyclePrivileges = backend.LifecyclePrivileges{"buildpack": false}
+		Expect(buildpackStagingPrivileged()).To(BeFalse())
+	})
+
+	Describe("LifecyclePrivileges", func() {
+		It("parses lifecycle=bool", func() {
+			privileges := backend.LifecyclePrivileges{}
+			Expect(privileges.Set("docker=false")).To(Succeed())
+			Expect(privileges.Set("buildpack=true")).To(Succeed())
+			Expect(privileges).To(Equal(backend.LifecyclePrivileges{"docker": false, "buildpack": true}))
+			Expect(privileges.String()).To(Equal("buildpack=true,docker=false"))
+		})
+
+		It("rejects malformed privileges", func() {
+			privileges := backend.LifecyclePrivileges{}
+			Expect(privileges.Set("docker")).To(Equal(backend.ErrLifecyclePrivilegeFormatInvalid))
+			Expect(privileges.Set("docker=maybe")).To(Equal(backend.ErrLifecyclePrivilegeFormatInvalid))
+			Expect(privileges.Set("=false")).To(Equal(backend.ErrLifecyclePrivilegeFormatInvalid))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// Redacted replaces secrets in staging requests that are logged.
+const Redacted = "[REDACTED]"
+
+// redactedLifecycleDataKeys are the lifecycle data fields, at any depth, that
+// hold credentials.
+var redactedLifecycleDataKeys = map[string]bool{
+	"docker_password":       true,
+	"docker_registry_token": true,
+	"docker_config_json":    true,
+	"credentials":           true,
+}
+
+// RedactStagingRequest returns a copy of request that is safe to log: the
+// credentials in its lifecycle data, and in any of its URLs, are replaced
+// with Redacted.
+func RedactStagingRequest(request cc_messages.StagingRequestFromCC) cc_messages.StagingRequestFromCC {
+	if request.LifecycleData == nil {
+		return request
+	}
+
+	var data interface{}
+	err := json.Unmarshal(*request.LifecycleData, &data)
+	if err != nil {
+		request.LifecycleData = nil
+		return request
+	}
+
+	redactedJSON, err := json.Marshal(redactLifecycleData(data))
+	if err != nil {
+		request.LifecycleData = nil
+		return request
+	}
+
+	redacted := json.RawMessage(redactedJSON)
+	request.LifecycleData = &redacted
+	return request
+}
+
+func redactLifecycleData(data interface{}) interface{} {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		for key, value := range data {
+			if redactedLifecycleDataKeys[key] {
+				data[key] = Redacted
+			} else {
+				data[key] = redactLifecycleData(value)
+			}
+		}
+		return data
+	case []interface{}:
+		for i, value := range data {
+			data[i] = redactLifecycleData(value)
+		}
+		return data
+	case string:
+		return RedactURL(data)
+	default:
+		return data
+	}
+}
+
+// RedactURL replaces the password of URLs, e.g. of custom buildpacks, that
+// carry credentials.
+func RedactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.User == nil {
+		return value
+	}
+	if _, ok := parsed.User.Password(); !ok {
+		return value
+	}
+
+	parsed.User = url.UserPassword(parsed.User.Username(), Redacted)
+	return parsed.String()
+}
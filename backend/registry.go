@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// Constructor builds the backend for a lifecycle.
+type Constructor func(config Config, logger lager.Logger) Backend
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Constructor{}
+)
+
+func init() {
+	RegisterBackend(TraditionalLifecycleName, NewTraditionalBackend)
+	RegisterBackend(DockerLifecycleName, NewDockerBackend)
+	RegisterBackend(WindowsLifecycleName, NewWindowsBackend)
+}
+
+// RegisterBackend makes a lifecycle available to staging requests that name
+// it. Like database/sql.Register, it is meant to be called from init and
+// panics if the name is taken.
+func RegisterBackend(name string, constructor Constructor) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if constructor == nil {
+		panic("backend: RegisterBackend constructor is nil")
+	}
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("backend: RegisterBackend called twice for %q", name))
+	}
+	registry[name] = constructor
+}
+
+// RegisteredBackends returns the names of the registered lifecycles, sorted.
+func RegisteredBackends() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackends builds a backend for every registered lifecycle, keyed by name.
+func NewBackends(config Config, logger lager.Logger) map[string]Backend {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	backends := make(map[string]Backend, len(registry))
+	for name, constructor := range registry {
+		backends[name] = constructor(config, logger)
+	}
+	return backends
+}
@@ -0,0 +1,48 @@
+package backend_test
+
+import (
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Registry", func() {
+	It("registers the built-in lifecycles", func() {
+		Expect(backend.RegisteredBackends()).To(ContainElement("buildpack"))
+		Expect(backend.RegisteredBackends()).To(ContainElement("docker"))
+		Expect(backend.RegisteredBackends()).To(ContainElement("windows"))
+	})
+
+	Describe("RegisterBackend", func() {
+		It("builds registered lifecycles with the given config", func() {
+			fakeBackend := new(fake_backend.FakeBackend)
+			fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{ExecutionMetadata: "registered"}, nil)
+
+			var receivedConfig backend.Config
+			backend.RegisterBackend("registry-test", func(config backend.Config, logger lager.Logger) backend.Backend {
+				receivedConfig = config
+				return fakeBackend
+			})
+
+			backends := backend.NewBackends(backend.Config{TaskDomain: "some-domain"}, lagertest.NewTestLogger("test"))
+			Expect(receivedConfig.TaskDomain).To(Equal("some-domain"))
+			Expect(backends).To(HaveKey("buildpack"))
+			Expect(backends).To(HaveKey("registry-test"))
+
+			response, err := backends["registry-test"].BuildStagingResponse(&models.TaskCallbackResponse{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.ExecutionMetadata).To(Equal("registered"))
+		})
+
+		It("panics when a name is registered twice", func() {
+			Expect(func() {
+				backend.RegisterBackend("buildpack", backend.NewTraditionalBackend)
+			}).To(Panic())
+		})
+	})
+})
@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// ReloadableBackend is a Backend whose configuration can be replaced, by
+// replacing the backend it delegates to, while it serves requests. Requests
+// already in flight finish with the backend they started with.
+type ReloadableBackend struct {
+	lock    sync.RWMutex
+	backend Backend
+}
+
+func NewReloadableBackend(backend Backend) *ReloadableBackend {
+	return &ReloadableBackend{backend: backend}
+}
+
+// Reload has later requests use backend.
+func (r *ReloadableBackend) Reload(backend Backend) {
+	r.lock.Lock()
+	r.backend = backend
+	r.lock.Unlock()
+}
+
+func (r *ReloadableBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	return r.current().BuildRecipe(stagingGuid, request)
+}
+
+func (r *ReloadableBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
+	return r.current().BuildStagingResponse(taskResponse)
+}
+
+func (r *ReloadableBackend) current() Backend {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.backend
+}
@@ -0,0 +1,49 @@
+package backend_test
+
+import (
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReloadableBackend", func() {
+	var (
+		original   *fake_backend.FakeBackend
+		reloaded   *fake_backend.FakeBackend
+		reloadable *backend.ReloadableBackend
+	)
+
+	BeforeEach(func() {
+		original = &fake_backend.FakeBackend{}
+		original.BuildRecipeReturns(&models.TaskDefinition{RootFs: "original"}, "guid", "domain", nil)
+
+		reloaded = &fake_backend.FakeBackend{}
+		reloaded.BuildRecipeReturns(&models.TaskDefinition{RootFs: "reloaded"}, "guid", "domain", nil)
+		reloaded.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{DetectedStartCommand: map[string]string{"web": "reloaded"}}, nil)
+
+		reloadable = backend.NewReloadableBackend(original)
+	})
+
+	It("delegates to its backend", func() {
+		taskDef, _, _, err := reloadable.BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskDef.RootFs).To(Equal("original"))
+		Expect(original.BuildRecipeCallCount()).To(Equal(1))
+	})
+
+	It("delegates to the reloaded backend after a reload", func() {
+		reloadable.Reload(reloaded)
+
+		taskDef, _, _, err := reloadable.BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskDef.RootFs).To(Equal("reloaded"))
+
+		response, err := reloadable.BuildStagingResponse(&models.TaskCallbackResponse{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.DetectedStartCommand).To(Equal(map[string]string{"web": "reloaded"}))
+		Expect(original.BuildRecipeCallCount()).To(BeZero())
+	})
+})
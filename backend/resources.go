@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+var ErrResourceMinimumsFormatInvalid = errors.New("stack resource minimums must be stack:memory_mb=N,disk_mb=N,file_descriptors=N")
+
+// ResourceMinimums are the smallest resources a staging task is given. Zero
+// fields don't raise the request.
+type ResourceMinimums struct {
+	MemoryMB        int
+	DiskMB          int
+	FileDescriptors int
+}
+
+// ResourceMinimumsFor returns the minimums for staging on stack: those
+// configured for the stack, with the global ones for any it leaves unset.
+func (c Config) ResourceMinimumsFor(stack string) ResourceMinimums {
+	minimums := ResourceMinimums{
+		MemoryMB:        c.MinMemoryMB,
+		DiskMB:          c.MinDiskMB,
+		FileDescriptors: c.MinFileDescriptors,
+	}
+
+	stackMinimums, ok := c.StackResourceMinimums[stack]
+	if !ok {
+		return minimums
+	}
+	if stackMinimums.MemoryMB > 0 {
+		minimums.MemoryMB = stackMinimums.MemoryMB
+	}
+	if stackMinimums.DiskMB > 0 {
+		minimums.DiskMB = stackMinimums.DiskMB
+	}
+	if stackMinimums.FileDescriptors > 0 {
+		minimums.FileDescriptors = stackMinimums.FileDescriptors
+	}
+	return minimums
+}
+
+// withResourceMinimums raises the request's resources to the stack's
+// minimums. When PackageDiskMultiplier is set and the lifecycle data gives
+// the app package's size as app_package_size_bytes, the task's disk is sized
+// from the package instead of the request, before being raised.
+func (c Config) withResourceMinimums(stack string, request cc_messages.StagingRequestFromCC) cc_messages.StagingRequestFromCC {
+	if c.PackageDiskMultiplier > 0 && request.LifecycleData != nil {
+		var packageSize struct {
+			AppPackageSizeBytes int64 `json:"app_package_size_bytes"`
+		}
+		json.Unmarshal(*request.LifecycleData, &packageSize)
+		if packageSize.AppPackageSizeBytes > 0 {
+			packageMB := float64(packageSize.AppPackageSizeBytes) / (1024 * 1024)
+			request.DiskMB = int(math.Ceil(c.PackageDiskMultiplier * packageMB))
+		}
+	}
+
+	minimums := c.ResourceMinimumsFor(stack)
+	if request.MemoryMB < minimums.MemoryMB {
+		request.MemoryMB = minimums.MemoryMB
+	}
+	if request.DiskMB < minimums.DiskMB {
+		request.DiskMB = minimums.DiskMB
+	}
+	if request.FileDescriptors < minimums.FileDescriptors {
+		request.FileDescriptors = minimums.FileDescriptors
+	}
+	return request
+}
+
+// StackResourceMinimums implements flag.Value so that per-stack minimums can
+// be configured by repeating a command line flag, e.g.
+// windows2012R2:disk_mb=8192,memory_mb=2048.
+type StackResourceMinimums map[string]ResourceMinimums
+
+func (s *StackResourceMinimums) String() string {
+	stacks := make([]string, 0, len(*s))
+	for stack, minimums := range *s {
+		stacks = append(stacks, fmt.Sprintf("%s:memory_mb=%d,disk_mb=%d,file_descriptors=%d", stack, minimums.MemoryMB, minimums.DiskMB, minimums.FileDescriptors))
+	}
+	sort.Strings(stacks)
+	return strings.Join(stacks, ";")
+}
+
+func (s *StackResourceMinimums) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrResourceMinimumsFormatInvalid
+	}
+
+	var minimums ResourceMinimums
+	for _, setting := range strings.Split(parts[1], ",") {
+		keyValue := strings.SplitN(setting, "=", 2)
+		if len(keyValue) != 2 {
+			return ErrResourceMinimumsFormatInvalid
+		}
+
+		amount, err := strconv.Atoi(keyValue[1])
+		if err != nil || amount < 0 {
+			return ErrResourceMinimumsFormatInvalid
+		}
+
+		switch keyValue[0] {
+		case "memory_mb":
+			minimums.MemoryMB = amount
+		case "disk_mb":
+			minimums.DiskMB = amount
+		case "file_descriptors":
+			minimums.FileDescriptors = amount
+		default:
+			return ErrResourceMinimumsFormatInvalid
+		}
+	}
+
+	if *s == nil {
+		*s = StackResourceMinimums{}
+	}
+	(*s)[parts[0]] = minimums
+	return nil
+}
@@ -0,0 +1,167 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Resource minimums", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			TaskDomain:         "config-task-domain",
+			StagerURL:          "http://the-stager.example.com",
+			FileServerURL:      "http://file-server.com",
+			CCUploaderURL:      "http://cc-uploader.com",
+			MinMemoryMB:        1024,
+			MinDiskMB:          2048,
+			MinFileDescriptors: 256,
+			StackResourceMinimums: backend.StackResourceMinimums{
+				"windows2012R2": {DiskMB: 8192},
+			},
+			Lifecycles: map[string]string{
+				"windows/windows2012R2": "windows_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	Describe("ResourceMinimumsFor", func() {
+		It("falls back to the global minimums for stacks without their own", func() {
+			Expect(config.ResourceMinimumsFor("cflinuxfs2")).To(Equal(backend.ResourceMinimums{
+				MemoryMB:        1024,
+				DiskMB:          2048,
+				FileDescriptors: 256,
+			}))
+		})
+
+		It("overrides the global minimums that a stack sets", func() {
+			Expect(config.ResourceMinimumsFor("windows2012R2")).To(Equal(backend.ResourceMinimums{
+				MemoryMB:        1024,
+				DiskMB:          8192,
+				FileDescriptors: 256,
+			}))
+		})
+	})
+
+	Describe("building recipes", func() {
+		var request cc_messages.StagingRequestFromCC
+
+		BeforeEach(func() {
+			lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "droplet_upload_uri": "http://example-uri.com/droplet-upload", "stack": "windows2012R2", "buildpacks": []}`)
+			request = cc_messages.StagingRequestFromCC{
+				AppId:         "bunny",
+				Lifecycle:     "windows",
+				LifecycleData: &lifecycleData,
+				MemoryMB:      512,
+				DiskMB:        4096,
+			}
+		})
+
+		It("raises the task's resources to the stack's minimums", func() {
+			taskDef, _, _, err := backend.NewWindowsBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.MemoryMb).To(BeEquivalentTo(1024))
+			Expect(taskDef.DiskMb).To(BeEquivalentTo(8192))
+		})
+
+		It("keeps resources above the minimums", func() {
+			request.MemoryMB = 4096
+			taskDef, _, _, err := backend.NewWindowsBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.MemoryMb).To(BeEquivalentTo(4096))
+		})
+
+		Context("when disk is sized from the app package", func() {
+			BeforeEach(func() {
+				config.PackageDiskMultiplier = 4
+			})
+
+			withPackageSize := func(sizeBytes string) {
+				lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "droplet_upload_uri": "http://example-uri.com/droplet-upload", "stack": "windows2012R2", "buildpacks": [], "app_package_size_bytes": ` + sizeBytes + `}`)
+				request.LifecycleData = &lifecycleData
+			}
+
+			It("gives large apps the multiple of their package size", func() {
+				withPackageSize("3221225472")
+				taskDef, _, _, err := backend.NewWindowsBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", request)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskDef.DiskMb).To(BeEquivalentTo(12288))
+			})
+
+			It("gives small apps the minimum", func() {
+				request.DiskMB = 16384
+				withPackageSize("1048576")
+				taskDef, _, _, err := backend.NewWindowsBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", request)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskDef.DiskMb).To(BeEquivalentTo(8192))
+			})
+
+			It("keeps the requested disk when the package size isn't given", func() {
+				request.DiskMB = 16384
+				taskDef, _, _, err := backend.NewWindowsBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", request)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(taskDef.DiskMb).To(BeEquivalentTo(16384))
+			})
+		})
+	})
+
+	Describe("StackResourceMinimums", func() {
+		It("parses stack minimums", func() {
+			minimums := backend.StackResourceMinimums{}
+			Expect(minimums.Set("windows2012R2:disk_mb=8192,memory_mb=2048")).To(Succeed())
+			Expect(minimums.Set("cflinuxfs2:file_descriptors=1024")).To(Succeed())
+
+			Expect(minimums).To(Equal(backend.StackResourceMinimums{
+				"windows2012R2": {MemoryMB: 2048, DiskMB: 8192},
+				"cflinuxfs2":    {FileDescriptors: 1024},
+			}))
+		})
+
+		It("rejects malformed minimums", func() {
+			minimums := backend.StackResourceMinimums{}
+			Expect(minimums.Set("windows2012R2")).To(Equal(backend.ErrResourceMinimumsFormatInvalid))
+			Expect(minimums.Set("windows2012R2:disk=8192")).To(Equal(backend.ErrResourceMinimumsFormatInvalid))
+			Expect(minimums.Set("windows2012R2:disk_mb=lots")).To(Equal(backend.ErrResourceMinimumsFormatInvalid))
+		})
+	})
+})
+
+var _ = Describe("Process limit", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	buildMaxPids := func() int32 {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return taskDef.MaxPids
+	}
+
+	It("limits the staging task's processes", func() {
+		config.MaxStagingPids = 1024
+		Expect(buildMaxPids()).To(BeEquivalentTo(1024))
+	})
+
+	It("leaves processes unlimited by default", func() {
+		Expect(buildMaxPids()).To(BeZero())
+	})
+})
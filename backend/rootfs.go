@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+var ErrStackRootFSFormatInvalid = errors.New("stack rootfses must be stack=rootfs-uri")
+
+// StackRootFSes maps stacks to the rootfs their staging tasks use, for
+// rootfses that aren't preloaded on every cell, e.g. docker:///org/image. It
+// implements flag.Value so that rootfses can be configured by repeating a
+// command line flag.
+type StackRootFSes map[string]string
+
+func (s *StackRootFSes) String() string {
+	rootFSes := make([]string, 0, len(*s))
+	for stack, rootFS := range *s {
+		rootFSes = append(rootFSes, stack+"="+rootFS)
+	}
+	sort.Strings(rootFSes)
+	return strings.Join(rootFSes, ",")
+}
+
+func (s *StackRootFSes) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrStackRootFSFormatInvalid
+	}
+
+	rootFS, err := url.Parse(parts[1])
+	if err != nil || rootFS.Scheme == "" {
+		return ErrStackRootFSFormatInvalid
+	}
+
+	if *s == nil {
+		*s = StackRootFSes{}
+	}
+	(*s)[parts[0]] = parts[1]
+	return nil
+}
+
+// RootFSFor returns the rootfs that staging on stack uses: the one configured
+// for the stack, or else the stack's preloaded rootfs.
+func (c Config) RootFSFor(stack string) string {
+	if rootFS, ok := c.StackRootFSes[stack]; ok {
+		return rootFS
+	}
+	return models.PreloadedRootFS(stack)
+}
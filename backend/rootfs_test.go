@@ -0,0 +1,64 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Stack rootfses", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs3": "buildpack_app_lifecycle.tgz",
+			},
+			StackRootFSes: backend.StackRootFSes{
+				"cflinuxfs3": "docker:///cloudfoundry/cflinuxfs3",
+			},
+		}
+	})
+
+	Describe("RootFSFor", func() {
+		It("uses the rootfs configured for the stack", func() {
+			Expect(config.RootFSFor("cflinuxfs3")).To(Equal("docker:///cloudfoundry/cflinuxfs3"))
+		})
+
+		It("uses the preloaded rootfs of other stacks", func() {
+			Expect(config.RootFSFor("cflinuxfs2")).To(Equal(models.PreloadedRootFS("cflinuxfs2")))
+		})
+	})
+
+	It("stages on the configured rootfs", func() {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs3"}`)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskDef.RootFs).To(Equal("docker:///cloudfoundry/cflinuxfs3"))
+	})
+
+	Describe("StackRootFSes", func() {
+		It("parses stack=rootfs-uri", func() {
+			rootFSes := backend.StackRootFSes{}
+			Expect(rootFSes.Set("cflinuxfs3=docker:///cloudfoundry/cflinuxfs3")).To(Succeed())
+			Expect(rootFSes).To(Equal(backend.StackRootFSes{"cflinuxfs3": "docker:///cloudfoundry/cflinuxfs3"}))
+		})
+
+		It("rejects rootfses that aren't URIs", func() {
+			rootFSes := backend.StackRootFSes{}
+			Expect(rootFSes.Set("cflinuxfs3")).To(Equal(backend.ErrStackRootFSFormatInvalid))
+			Expect(rootFSes.Set("cflinuxfs3=cloudfoundry/cflinuxfs3")).To(Equal(backend.ErrStackRootFSFormatInvalid))
+		})
+	})
+})
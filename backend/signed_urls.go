@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters of signed download URLs.
+const (
+	SignedURLExpiresParam   = "expires"
+	SignedURLSignatureParam = "signature"
+)
+
+// signedURLGracePeriod is how long, beyond the staging timeout, a signed URL
+// stays valid, allowing for the task to wait to be placed on a cell.
+const signedURLGracePeriod = 15 * time.Minute
+
+var ErrURLSigningKeyFormatInvalid = errors.New("URL signing keys must be host=secret")
+
+// URLSigningKeys are shared secrets for artifact stores that require signed
+// download URLs, keyed by host. It implements flag.Value so that keys can be
+// configured by repeating a command line flag.
+type URLSigningKeys map[string]string
+
+func (k *URLSigningKeys) String() string {
+	hosts := make([]string, 0, len(*k))
+	for host := range *k {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
+
+func (k *URLSigningKeys) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ErrURLSigningKeyFormatInvalid
+	}
+
+	if *k == nil {
+		*k = URLSigningKeys{}
+	}
+	(*k)[parts[0]] = parts[1]
+	return nil
+}
+
+// SignURL adds an expiry and a signature to rawURL, so that an artifact store
+// holding key can authorize the download without a header. The signature is
+// the unpadded base64url HMAC-SHA256 of the URL's path, a newline, and the
+// expiry in Unix seconds.
+func SignURL(rawURL, key string, expires time.Time) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	expiresParam := strconv.FormatInt(expires.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(parsed.EscapedPath() + "\n" + expiresParam))
+
+	query := parsed.Query()
+	query.Set(SignedURLExpiresParam, expiresParam)
+	query.Set(SignedURLSignatureParam, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
+
+// signedDownloadURL signs rawURL if its host has a signing key, so that it
+// stays valid for a staging task with the given timeout.
+func (c Config) signedDownloadURL(rawURL string, timeout time.Duration) (string, error) {
+	if len(c.URLSigningKeys) == 0 {
+		return rawURL, nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", NewValidationError(InvalidDownloadURLErrorId, err.Error())
+	}
+
+	key, ok := c.URLSigningKeys[parsed.Host]
+	if !ok {
+		return rawURL, nil
+	}
+
+	return SignURL(rawURL, key, c.now().Add(timeout+signedURLGracePeriod))
+}
+
+func (c Config) now() time.Time {
+	return c.clock().Now()
+}
@@ -0,0 +1,109 @@
+package backend_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Signed URLs", func() {
+	Describe("SignURL", func() {
+		It("adds the expiry and its HMAC signature", func() {
+			expires := time.Unix(1500000000, 0)
+			signed, err := backend.SignURL("https://artifacts.example.com/buildpacks/ruby.zip?version=1", "secret", expires)
+			Expect(err).NotTo(HaveOccurred())
+
+			parsed, err := url.Parse(signed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Path).To(Equal("/buildpacks/ruby.zip"))
+			Expect(parsed.Query().Get("version")).To(Equal("1"))
+			Expect(parsed.Query().Get(backend.SignedURLExpiresParam)).To(Equal("1500000000"))
+
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte("/buildpacks/ruby.zip\n1500000000"))
+			Expect(parsed.Query().Get(backend.SignedURLSignatureParam)).To(Equal(base64.RawURLEncoding.EncodeToString(mac.Sum(nil))))
+		})
+	})
+
+	Describe("building recipes", func() {
+		var (
+			config    backend.Config
+			fakeClock *fakeclock.FakeClock
+		)
+
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Unix(1500000000, 0))
+			config = backend.Config{
+				FileServerURL: "http://file-server.com",
+				CCUploaderURL: "http://cc-uploader.com",
+				Lifecycles: map[string]string{
+					"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+				},
+				URLSigningKeys: backend.URLSigningKeys{"artifacts.example.com": "secret"},
+				Clock:          fakeClock,
+			}
+		})
+
+		buildpackURLs := func() []string {
+			lifecycleData := json.RawMessage(`{
+				"app_bits_download_uri": "http://example-uri.com/bunny",
+				"stack": "cflinuxfs2",
+				"buildpacks": [
+					{"name": "private", "key": "private-key", "url": "https://artifacts.example.com/private.zip"},
+					{"name": "public", "key": "public-key", "url": "https://public.example.com/public.zip"}
+				]
+			}`)
+
+			taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+				AppId:         "bunny",
+				Lifecycle:     "buildpack",
+				LifecycleData: &lifecycleData,
+				Timeout:       900,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			downloads := actionsFromTaskDef(taskDef)[1].GetEmitProgressAction().Action.GetParallelAction().Actions
+			return []string{
+				downloads[1].GetDownloadAction().From,
+				downloads[2].GetDownloadAction().From,
+			}
+		}
+
+		It("signs buildpack URLs for hosts with a signing key", func() {
+			urls := buildpackURLs()
+
+			private, err := url.Parse(urls[0])
+			Expect(err).NotTo(HaveOccurred())
+			expires := fakeClock.Now().Add(900*time.Second + 15*time.Minute).Unix()
+			Expect(private.Query().Get(backend.SignedURLExpiresParam)).To(Equal(strconv.FormatInt(expires, 10)))
+			Expect(private.Query().Get(backend.SignedURLSignatureParam)).NotTo(BeEmpty())
+
+			Expect(urls[1]).To(Equal("https://public.example.com/public.zip"))
+		})
+	})
+
+	Describe("URLSigningKeys", func() {
+		It("parses host=secret", func() {
+			keys := backend.URLSigningKeys{}
+			Expect(keys.Set("artifacts.example.com=s3cr=t")).To(Succeed())
+			Expect(keys).To(Equal(backend.URLSigningKeys{"artifacts.example.com": "s3cr=t"}))
+		})
+
+		It("rejects keys without a host or secret", func() {
+			keys := backend.URLSigningKeys{}
+			Expect(keys.Set("artifacts.example.com")).To(Equal(backend.ErrURLSigningKeyFormatInvalid))
+			Expect(keys.Set("artifacts.example.com=")).To(Equal(backend.ErrURLSigningKeyFormatInvalid))
+		})
+	})
+})
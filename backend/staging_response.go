@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+const (
+	// MaxStagingResponseSize is the largest staging response, in bytes, that
+	// the CC accepts as a request body.
+	MaxStagingResponseSize = 1024 * 1024
+
+	// MaxStartCommandLength is the CC's limit on a process's command.
+	MaxStartCommandLength = 4096
+)
+
+// ValidateStagingResponse checks a staging response against what the CC
+// accepts, so that an unusable response can be reported as a failed staging
+// with a meaningful error rather than rejected by the CC with an opaque 422.
+func ValidateStagingResponse(response cc_messages.StagingResponseForCC) error {
+	if response.Error != nil && (response.Error.Id == "" || response.Error.Message == "") {
+		return invalidStagingResponse("staging error must have an id and a message")
+	}
+
+	if response.LifecycleData != nil {
+		var lifecycleData interface{}
+		if err := json.Unmarshal(*response.LifecycleData, &lifecycleData); err != nil {
+			return invalidStagingResponse("lifecycle data is not valid JSON")
+		}
+	}
+
+	for processType, command := range response.DetectedStartCommand {
+		if processType == "" {
+			return invalidStagingResponse("detected start command has an empty process type")
+		}
+		if len(command) > MaxStartCommandLength {
+			return invalidStagingResponse(fmt.Sprintf("start command for process type %q exceeds %d characters", processType, MaxStartCommandLength))
+		}
+	}
+
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return invalidStagingResponse(err.Error())
+	}
+	if len(payload) > MaxStagingResponseSize {
+		return invalidStagingResponse(fmt.Sprintf("staging response is %d bytes, more than the %d the CC accepts", len(payload), MaxStagingResponseSize))
+	}
+
+	return nil
+}
+
+func invalidStagingResponse(message string) *ValidationError {
+	return NewValidationError(InvalidStagingResponseErrorId, "invalid staging response: "+message)
+}
@@ -0,0 +1,60 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateStagingResponse", func() {
+	expectInvalid := func(response cc_messages.StagingResponseForCC) {
+		err := backend.ValidateStagingResponse(response)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidStagingResponseErrorId))
+	}
+
+	It("accepts a successful response", func() {
+		lifecycleData := json.RawMessage(`{"buildpack_key": "ruby"}`)
+		Expect(backend.ValidateStagingResponse(cc_messages.StagingResponseForCC{
+			ExecutionMetadata:    "metadata",
+			DetectedStartCommand: map[string]string{"web": "./start"},
+			LifecycleData:        &lifecycleData,
+		})).To(Succeed())
+	})
+
+	It("accepts a staging error", func() {
+		Expect(backend.ValidateStagingResponse(cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+		})).To(Succeed())
+	})
+
+	It("rejects staging errors without an id", func() {
+		expectInvalid(cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Message: "staging failed"},
+		})
+	})
+
+	It("rejects lifecycle data that isn't JSON", func() {
+		lifecycleData := json.RawMessage(`{`)
+		expectInvalid(cc_messages.StagingResponseForCC{LifecycleData: &lifecycleData})
+	})
+
+	It("rejects start commands the CC won't store", func() {
+		expectInvalid(cc_messages.StagingResponseForCC{
+			DetectedStartCommand: map[string]string{"web": strings.Repeat("x", backend.MaxStartCommandLength+1)},
+		})
+		expectInvalid(cc_messages.StagingResponseForCC{
+			DetectedStartCommand: map[string]string{"": "./start"},
+		})
+	})
+
+	It("rejects responses larger than the CC accepts", func() {
+		expectInvalid(cc_messages.StagingResponseForCC{
+			ExecutionMetadata: strings.Repeat("x", backend.MaxStagingResponseSize),
+		})
+	})
+})
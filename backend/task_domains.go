@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var ErrLifecycleTaskDomainFormatInvalid = errors.New("lifecycle task domains must be lifecycle=domain")
+
+// LifecycleTaskDomains puts each lifecycle's staging tasks in their own task
+// domain, e.g. cf-docker-staging, so that operators can give them their own
+// freshness and reconciliation policies. It implements flag.Value so that
+// domains can be configured by repeating a command line flag.
+type LifecycleTaskDomains map[string]string
+
+func (d *LifecycleTaskDomains) String() string {
+	domains := make([]string, 0, len(*d))
+	for lifecycle, domain := range *d {
+		domains = append(domains, lifecycle+"="+domain)
+	}
+	sort.Strings(domains)
+	return strings.Join(domains, ",")
+}
+
+func (d *LifecycleTaskDomains) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ErrLifecycleTaskDomainFormatInvalid
+	}
+
+	if *d == nil {
+		*d = LifecycleTaskDomains{}
+	}
+	(*d)[parts[0]] = parts[1]
+	return nil
+}
+
+// TaskDomainFor returns the task domain of a lifecycle's staging tasks: its
+// own, or TaskDomain.
+func (c Config) TaskDomainFor(lifecycle string) string {
+	if domain, ok := c.LifecycleTaskDomains[lifecycle]; ok {
+		return domain
+	}
+	return c.TaskDomain
+}
+
+// TaskDomains returns every task domain that staging tasks are put in.
+func (c Config) TaskDomains() []string {
+	seen := map[string]bool{c.TaskDomain: true}
+	domains := []string{c.TaskDomain}
+	for _, domain := range c.LifecycleTaskDomains {
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains[1:])
+	return domains
+}
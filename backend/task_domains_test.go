@@ -0,0 +1,65 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Task domains", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			TaskDomain:    "cf-app-staging",
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+			LifecycleTaskDomains: backend.LifecycleTaskDomains{
+				"buildpack": "cf-buildpack-staging",
+				"docker":    "cf-docker-staging",
+				"windows":   "cf-docker-staging",
+			},
+		}
+	})
+
+	It("puts a lifecycle's staging tasks in its own domain", func() {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`)
+		_, _, domain, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(domain).To(Equal("cf-buildpack-staging"))
+	})
+
+	It("puts other lifecycles' staging tasks in the task domain", func() {
+		Expect(config.TaskDomainFor("binary")).To(Equal("cf-app-staging"))
+	})
+
+	It("lists every task domain once, starting with the task domain", func() {
+		Expect(config.TaskDomains()).To(Equal([]string{"cf-app-staging", "cf-buildpack-staging", "cf-docker-staging"}))
+	})
+
+	Describe("LifecycleTaskDomains", func() {
+		It("parses lifecycle=domain", func() {
+			domains := backend.LifecycleTaskDomains{}
+			Expect(domains.Set("docker=cf-docker-staging")).To(Succeed())
+			Expect(domains).To(Equal(backend.LifecycleTaskDomains{"docker": "cf-docker-staging"}))
+		})
+
+		It("rejects malformed domains", func() {
+			domains := backend.LifecycleTaskDomains{}
+			Expect(domains.Set("docker")).To(Equal(backend.ErrLifecycleTaskDomainFormatInvalid))
+			Expect(domains.Set("docker=")).To(Equal(backend.ErrLifecycleTaskDomainFormatInvalid))
+			Expect(domains.Set("=cf-docker-staging")).To(Equal(backend.ErrLifecycleTaskDomainFormatInvalid))
+		})
+	})
+})
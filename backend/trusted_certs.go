@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"github.com/cloudfoundry-incubator/bbs/models"
+)
+
+// Where staging containers get the operator's CA bundle. The bundle is a
+// .tgz holding ca-certificates.crt, which should include the public roots as
+// well as the private CAs, since it replaces the system's for the builder.
+const (
+	TrustedCertsDir    = "/tmp/trusted-certs"
+	TrustedCertsFile   = TrustedCertsDir + "/ca-certificates.crt"
+	TrustedCertsEnvVar = "SSL_CERT_FILE"
+
+	// TrustedCertsChecksumKey is the LifecycleChecksums key of the bundle.
+	TrustedCertsChecksumKey = "trusted-certs"
+)
+
+// trustedCerts returns the download of the configured CA bundle, and the
+// variable that points the builder at it, so that buildpacks can reach
+// internal HTTPS mirrors signed by private CAs. Both are nil when no bundle is
+// configured.
+func (c Config) trustedCerts() (models.ActionInterface, *models.EnvironmentVariable, error) {
+	if c.TrustedCertsBundle == "" {
+		return nil, nil, nil
+	}
+
+	bundleURL, err := lifecycleBundleURL(c.FileServerURL, c.TrustedCertsBundle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	download := withChecksum(&models.DownloadAction{
+		From:     bundleURL.String(),
+		To:       TrustedCertsDir,
+		CacheKey: "trusted-certs",
+		User:     "vcap",
+	}, c.lifecycleChecksum(TrustedCertsChecksumKey))
+
+	return download, &models.EnvironmentVariable{Name: TrustedCertsEnvVar, Value: TrustedCertsFile}, nil
+}
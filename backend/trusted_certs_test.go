@@ -0,0 +1,69 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Trusted certificates", func() {
+	var config backend.Config
+
+	BeforeEach(func() {
+		config = backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+		}
+	})
+
+	buildRecipe := func() []*models.Action {
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2"}`)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return actionsFromTaskDef(taskDef)
+	}
+
+	Context("when a CA bundle is configured", func() {
+		BeforeEach(func() {
+			config.TrustedCertsBundle = "trusted-certs.tgz"
+		})
+
+		It("downloads it and points the builder at it", func() {
+			actions := buildRecipe()
+
+			downloads := actions[1].GetEmitProgressAction().Action.GetParallelAction().Actions
+			Expect(downloads).To(ContainElement(models.WrapAction(&models.DownloadAction{
+				From:     "http://file-server.com/v1/static/trusted-certs.tgz",
+				To:       "/tmp/trusted-certs",
+				CacheKey: "trusted-certs",
+				User:     "vcap",
+			})))
+
+			runAction := actions[2].GetEmitProgressAction().Action.GetRunAction()
+			Expect(runAction.Env).To(ContainElement(&models.EnvironmentVariable{Name: "SSL_CERT_FILE", Value: "/tmp/trusted-certs/ca-certificates.crt"}))
+		})
+	})
+
+	Context("when no CA bundle is configured", func() {
+		It("leaves the builder with the system certificates", func() {
+			actions := buildRecipe()
+
+			runAction := actions[2].GetEmitProgressAction().Action.GetRunAction()
+			for _, envVar := range runAction.Env {
+				Expect(envVar.Name).NotTo(Equal("SSL_CERT_FILE"))
+			}
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// VolumeMount is a volume that the staging container mounts, as the CC
+// describes volume service bindings, e.g. an NFS share holding a shared cache
+// or license files.
+type VolumeMount struct {
+	Driver       string             `json:"driver"`
+	ContainerDir string             `json:"container_dir"`
+	Mode         string             `json:"mode"`
+	DeviceType   string             `json:"device_type"`
+	Device       *VolumeMountDevice `json:"device"`
+}
+
+type VolumeMountDevice struct {
+	VolumeId    string                 `json:"volume_id"`
+	MountConfig map[string]interface{} `json:"mount_config"`
+}
+
+// volumeMountsFor returns the volume mounts listed in the request's lifecycle
+// data as volume_mounts.
+func volumeMountsFor(request cc_messages.StagingRequestFromCC) ([]*models.VolumeMount, error) {
+	if request.LifecycleData == nil {
+		return nil, nil
+	}
+
+	var data struct {
+		VolumeMounts []VolumeMount `json:"volume_mounts"`
+	}
+	err := json.Unmarshal(*request.LifecycleData, &data)
+	if err != nil {
+		return nil, NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	mounts := make([]*models.VolumeMount, 0, len(data.VolumeMounts))
+	for _, mount := range data.VolumeMounts {
+		volumeMount, err := mount.toModel()
+		if err != nil {
+			return nil, NewValidationError(InvalidVolumeMountErrorId, err.Error())
+		}
+		mounts = append(mounts, volumeMount)
+	}
+
+	if len(mounts) == 0 {
+		return nil, nil
+	}
+	return mounts, nil
+}
+
+func (m VolumeMount) toModel() (*models.VolumeMount, error) {
+	if m.Driver == "" || m.ContainerDir == "" {
+		return nil, fmt.Errorf("volume mounts need a driver and a container_dir")
+	}
+
+	if m.Mode != "r" && m.Mode != "rw" {
+		return nil, fmt.Errorf("invalid volume mount mode %q", m.Mode)
+	}
+
+	if m.DeviceType != "shared" || m.Device == nil || m.Device.VolumeId == "" {
+		return nil, fmt.Errorf("volume mounts need a shared device with a volume_id")
+	}
+
+	mountConfig := ""
+	if len(m.Device.MountConfig) > 0 {
+		mountConfigJSON, err := json.Marshal(m.Device.MountConfig)
+		if err != nil {
+			return nil, err
+		}
+		mountConfig = string(mountConfigJSON)
+	}
+
+	return &models.VolumeMount{
+		Driver:       m.Driver,
+		ContainerDir: m.ContainerDir,
+		Mode:         m.Mode,
+		Shared: &models.SharedDevice{
+			VolumeId:    m.Device.VolumeId,
+			MountConfig: mountConfig,
+		},
+	}, nil
+}
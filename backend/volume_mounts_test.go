@@ -0,0 +1,72 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("Volume mounts", func() {
+	var volumeMounts string
+
+	buildRecipe := func() (*models.TaskDefinition, error) {
+		config := backend.Config{
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2": "buildpack_app_lifecycle.tgz",
+			},
+		}
+
+		lifecycleData := json.RawMessage(`{"app_bits_download_uri": "http://example-uri.com/bunny", "stack": "cflinuxfs2", "volume_mounts": ` + volumeMounts + `}`)
+		taskDef, _, _, err := backend.NewTraditionalBackend(config, lagertest.NewTestLogger("test")).BuildRecipe("staging-guid", cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+		})
+		return taskDef, err
+	}
+
+	It("mounts the volumes listed in the lifecycle data", func() {
+		volumeMounts = `[{
+			"driver": "nfsv3driver",
+			"container_dir": "/var/vcap/data/licenses",
+			"mode": "r",
+			"device_type": "shared",
+			"device": {"volume_id": "licenses", "mount_config": {"source": "nfs://server/licenses"}}
+		}]`
+
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskDef.VolumeMounts).To(Equal([]*models.VolumeMount{{
+			Driver:       "nfsv3driver",
+			ContainerDir: "/var/vcap/data/licenses",
+			Mode:         "r",
+			Shared: &models.SharedDevice{
+				VolumeId:    "licenses",
+				MountConfig: `{"source":"nfs://server/licenses"}`,
+			},
+		}}))
+	})
+
+	It("mounts nothing by default", func() {
+		volumeMounts = `[]`
+
+		taskDef, err := buildRecipe()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(taskDef.VolumeMounts).To(BeNil())
+	})
+
+	It("rejects invalid volume mounts", func() {
+		volumeMounts = `[{"driver": "nfsv3driver", "container_dir": "/data", "mode": "rwx", "device_type": "shared", "device": {"volume_id": "data"}}]`
+
+		_, err := buildRecipe()
+		Expect(err).To(HaveOccurred())
+		Expect(err.(backend.Error).Id()).To(Equal(backend.InvalidVolumeMountErrorId))
+	})
+})
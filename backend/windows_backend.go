@@ -0,0 +1,264 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/buildpack_app_lifecycle"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/pivotal-golang/lager"
+)
+
+const WindowsLifecycleName = "windows"
+
+// Paths inside Windows staging containers. Garden-Windows maps them onto the
+// container's drive, so they use forward slashes like Linux paths do.
+const (
+	windowsLifecycleDir              = "/tmp/lifecycle"
+	windowsBuilderPath               = windowsLifecycleDir + "/builder.exe"
+	windowsBuildDir                  = "/tmp/app"
+	windowsBuildpacksDir             = "/tmp/buildpacks"
+	windowsBuildArtifactsCacheDir    = "/tmp/cache"
+	windowsOutputDroplet             = "/tmp/droplet"
+	windowsOutputMetadata            = "/tmp/result.json"
+	windowsOutputBuildArtifactsCache = "/tmp/output-cache"
+)
+
+type windowsBackend struct {
+	config Config
+	logger lager.Logger
+
+	// the Windows lifecycle takes the same lifecycle data as buildpack
+	// staging, so it shares the traditional backend's URL handling
+	traditional *traditionalBackend
+}
+
+// NewWindowsBackend stages apps on Windows stacks with the Windows app
+// lifecycle. Windows containers can't run privileged, so neither does the
+// staging task.
+func NewWindowsBackend(config Config, logger lager.Logger) Backend {
+	return &windowsBackend{
+		config: config,
+		logger: logger.Session("windows"),
+		traditional: &traditionalBackend{
+			config: config,
+			logger: logger.Session("windows"),
+		},
+	}
+}
+
+func (backend *windowsBackend) BuildRecipe(stagingGuid string, request cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid})
+	logger.Info("staging-request")
+
+	if request.LifecycleData == nil {
+		return &models.TaskDefinition{}, "", "", ErrMissingLifecycleData
+	}
+
+	var lifecycleData cc_messages.BuildpackStagingData
+	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", NewValidationError(InvalidLifecycleDataErrorId, err.Error())
+	}
+
+	err = backend.traditional.validateRequest(request, lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	request = backend.config.withResourceMinimums(lifecycleData.Stack, request)
+
+	compilerURL, err := backend.traditional.compilerDownloadURL(request, lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	appBitsChecksum, buildpackChecksums, err := parseRequestChecksums(*request.LifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	buildpackCredhubRefsEnv, err := buildpackCredhubRefs(*request.LifecycleData, lifecycleData.Buildpacks)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	timeout := backend.config.cappedTimeout(traditionalTimeout(request, backend.logger), request, backend.logger)
+
+	actions := []models.ActionInterface{}
+
+	//Download app package
+	actions = append(actions, withChecksum(&models.DownloadAction{
+		Artifact: "app package",
+		From:     lifecycleData.AppBitsDownloadUri,
+		To:       windowsBuildDir,
+		User:     "vcap",
+	}, appBitsChecksum))
+
+	//Download lifecycle and buildpacks
+	downloadActions := []models.ActionInterface{
+		models.EmitProgressFor(
+			withChecksum(&models.DownloadAction{
+				From:     compilerURL.String(),
+				To:       windowsLifecycleDir,
+				CacheKey: fmt.Sprintf("windows-%s-lifecycle", lifecycleData.Stack),
+				User:     "vcap",
+			}, backend.config.lifecycleChecksum(request.Lifecycle+"/"+lifecycleData.Stack)),
+			"",
+			"",
+			"Failed to set up staging environment",
+		),
+	}
+
+	buildpacksOrder := []string{}
+	for _, buildpack := range lifecycleData.Buildpacks {
+		buildpacksOrder = append(buildpacksOrder, buildpack.Key)
+	}
+
+	// the Windows builder lays out buildpacks the same way the buildpack
+	// lifecycle does
+	builderConfig := buildpack_app_lifecycle.NewLifecycleBuilderConfig(buildpacksOrder, false, backend.config.SkipCertVerify)
+
+	buildpackDownloads := []models.ActionInterface{}
+	for _, buildpack := range lifecycleData.Buildpacks {
+		if buildpack.Name == cc_messages.CUSTOM_BUILDPACK {
+			continue
+		}
+
+		buildpackURL, err := backend.config.signedDownloadURL(buildpack.Url, timeout)
+		if err != nil {
+			return &models.TaskDefinition{}, "", "", err
+		}
+
+		buildpackDownloads = append(buildpackDownloads, withChecksum(&models.DownloadAction{
+			Artifact: buildpack.Name,
+			From:     buildpackURL,
+			To:       builderConfig.BuildpackPath(buildpack.Key),
+			CacheKey: buildpack.Key,
+			User:     "vcap",
+		}, buildpackChecksums[buildpack.Key]))
+	}
+	downloadActions = append(downloadActions, backend.config.batchedDownloads(buildpackDownloads)...)
+
+	downloadURL, err := backend.traditional.buildArtifactsDownloadURL(lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	if downloadURL != nil {
+		downloadActions = append(downloadActions, models.Try(&models.DownloadAction{
+			Artifact: "build artifacts cache",
+			From:     downloadURL.String(),
+			To:       windowsBuildArtifactsCacheDir,
+			User:     "vcap",
+		}))
+	}
+
+	stackWarning := backend.config.StackWarningFor(lifecycleData.Stack)
+	actions = append(actions, models.EmitProgressFor(models.Parallel(downloadActions...), withStackWarning("Downloading buildpacks...", stackWarning), "Downloaded buildpacks", "Downloading buildpacks failed"))
+
+	//Run Builder
+	actions = append(
+		actions,
+		models.EmitProgressFor(
+			&models.RunAction{
+				User: "vcap",
+				Path: windowsBuilderPath,
+				Args: []string{
+					"-buildDir=" + windowsBuildDir,
+					"-buildpacksDir=" + windowsBuildpacksDir,
+					"-buildpackOrder=" + strings.Join(buildpacksOrder, ","),
+					"-buildArtifactsCacheDir=" + windowsBuildArtifactsCacheDir,
+					"-outputDroplet=" + windowsOutputDroplet,
+					"-outputMetadata=" + windowsOutputMetadata,
+					"-outputBuildArtifactsCache=" + windowsOutputBuildArtifactsCache,
+				},
+				Env: withEnvironmentVariable(backend.config.stagingEnvironment(request.Environment), buildpackCredhubRefsEnv),
+			},
+			"Staging...",
+			"Staging complete",
+			"Staging failed",
+		),
+	)
+
+	//Upload droplet and, unless skipped, build artifacts cache
+	dropletUploadTarget, err := backend.traditional.dropletUploadTarget(request, lifecycleData, timeout)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	uploadActions := []models.ActionInterface{
+		&models.UploadAction{
+			Artifact: "droplet",
+			From:     windowsOutputDroplet,
+			To:       dropletUploadTarget,
+			User:     "vcap",
+		},
+	}
+	uploadMsg := "Uploading droplet..."
+
+	if !backend.config.skipsBuildArtifactsCacheUpload(*request.LifecycleData) {
+		cacheUploadURL, err := backend.traditional.buildArtifactsUploadURL(request, lifecycleData)
+		if err != nil {
+			return &models.TaskDefinition{}, "", "", err
+		}
+
+		uploadActions = append(uploadActions, models.Try(&models.UploadAction{
+			Artifact: "build artifacts cache",
+			From:     windowsOutputBuildArtifactsCache,
+			To:       addTimeoutParamToURL(*cacheUploadURL, timeout).String(),
+			User:     "vcap",
+		}))
+		uploadMsg = "Uploading droplet, build artifacts cache..."
+	}
+
+	actions = append(actions, models.EmitProgressFor(models.Parallel(uploadActions...), uploadMsg, "Uploading complete", "Uploading failed"))
+
+	volumeMounts, err := volumeMountsFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	scheduling, err := backend.config.schedulingFor(request)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	annotationJson, _ := json.Marshal(stagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle: WindowsLifecycleName,
+		},
+		StackWarning: stackWarning,
+	})
+
+	taskDefinition := &models.TaskDefinition{
+		RootFs:                backend.config.RootFSFor(lifecycleData.Stack),
+		ResultFile:            windowsOutputMetadata,
+		MemoryMb:              int32(request.MemoryMB),
+		DiskMb:                int32(request.DiskMB),
+		CpuWeight:             uint32(StagingTaskCpuWeight),
+		Action:                models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
+		LogGuid:               request.LogGuid,
+		LogSource:             TaskLogSource,
+		CompletionCallbackUrl: backend.config.CallbackURL(stagingGuid),
+		EgressRules:           backend.config.egressRules(request.EgressRules),
+		Network:               scheduling.Network,
+		PlacementTags:         scheduling.PlacementTags,
+		LogRateLimit:          scheduling.LogRateLimit,
+		VolumeMounts:          volumeMounts,
+		Annotation:            string(annotationJson),
+		Privileged:            false,
+	}
+
+	logger.Debug("staging-task-request")
+
+	return taskDefinition, stagingGuid, backend.config.TaskDomainFor(WindowsLifecycleName), nil
+}
+
+// BuildStagingResponse reads the Windows lifecycle's result, which has the
+// same format as the buildpack lifecycle's.
+func (backend *windowsBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
+	return backend.traditional.BuildStagingResponse(taskResponse)
+}
@@ -0,0 +1,104 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/lager/lagertest"
+)
+
+var _ = Describe("WindowsBackend", func() {
+	var (
+		windows        backend.Backend
+		stagingRequest cc_messages.StagingRequestFromCC
+		stack          string
+	)
+
+	BeforeEach(func() {
+		config := backend.Config{
+			TaskDomain:    "config-task-domain",
+			StagerURL:     "http://the-stager.example.com",
+			FileServerURL: "http://file-server.com",
+			CCUploaderURL: "http://cc-uploader.com",
+			Lifecycles: map[string]string{
+				"windows/windows2012R2": "windows_app_lifecycle.tgz",
+			},
+		}
+
+		windows = backend.NewWindowsBackend(config, lagertest.NewTestLogger("test"))
+		stack = "windows2012R2"
+	})
+
+	JustBeforeEach(func() {
+		lifecycleDataJSON, err := json.Marshal(cc_messages.BuildpackStagingData{
+			AppBitsDownloadUri:           "http://example-uri.com/bunny",
+			BuildArtifactsCacheUploadUri: "http://example-uri.com/bunny-uppings",
+			Buildpacks: []cc_messages.Buildpack{
+				{Name: "hwc", Key: "hwc-buildpack", Url: "hwc-buildpack-url"},
+			},
+			DropletUploadUri: "http://example-uri.com/droplet-upload",
+			Stack:            stack,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		lifecycleData := json.RawMessage(lifecycleDataJSON)
+		stagingRequest = cc_messages.StagingRequestFromCC{
+			AppId:         "bunny",
+			LogGuid:       "bunny",
+			Lifecycle:     "windows",
+			LifecycleData: &lifecycleData,
+			MemoryMB:      2048,
+			DiskMB:        3072,
+			Timeout:       900,
+		}
+	})
+
+	It("builds an unprivileged task on the Windows rootfs", func() {
+		taskDef, guid, domain, err := windows.BuildRecipe("staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(guid).To(Equal("staging-guid"))
+		Expect(domain).To(Equal("config-task-domain"))
+
+		Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("windows2012R2")))
+		Expect(taskDef.Privileged).To(BeFalse())
+		Expect(taskDef.ResultFile).To(Equal("/tmp/result.json"))
+		Expect(taskDef.Annotation).To(MatchJSON(`{"lifecycle": "windows"}`))
+	})
+
+	It("runs the Windows builder with slash-separated paths", func() {
+		taskDef, _, _, err := windows.BuildRecipe("staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+
+		actionJSON, err := json.Marshal(taskDef.Action)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(actionJSON)).To(ContainSubstring(`"path":"/tmp/lifecycle/builder.exe"`))
+		Expect(string(actionJSON)).To(ContainSubstring(`http://file-server.com/v1/static/windows_app_lifecycle.tgz`))
+		Expect(string(actionJSON)).To(ContainSubstring(`-buildpackOrder=hwc-buildpack`))
+		Expect(string(actionJSON)).NotTo(ContainSubstring(`\\`))
+	})
+
+	Context("when no lifecycle is configured for the stack", func() {
+		BeforeEach(func() {
+			stack = "windows2016"
+		})
+
+		It("returns an error", func() {
+			_, _, _, err := windows.BuildRecipe("staging-guid", stagingRequest)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("builds staging responses like the buildpack lifecycle", func() {
+		response, err := windows.BuildStagingResponse(&models.TaskCallbackResponse{
+			Annotation: `{"lifecycle": "windows"}`,
+			Result:     `{"process_types": {"web": "hwc.exe"}, "lifecycle_metadata": {"detected_buildpack": "hwc", "buildpack_key": "hwc-buildpack"}, "execution_metadata": "", "lifecycle_type": "windows"}`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Error).To(BeNil())
+		Expect(response.ExecutionMetadata).To(Equal(""))
+	})
+})
@@ -0,0 +1,246 @@
+package cc_client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
+)
+
+var ErrInvalidBatchSize = errors.New("staging response batch size must be positive")
+
+// BatchingCcClient is a CcClient that must be run as an ifrit process for
+// responses to be batched.
+type BatchingCcClient interface {
+	CcClient
+	ifrit.Runner
+}
+
+type BatchConfig struct {
+	// Path of the CC endpoint accepting many staging responses in one POST.
+	Path          string
+	MaxSize       int
+	FlushInterval time.Duration
+}
+
+type BatchedStagingResponse struct {
+	StagingGuid string          `json:"staging_guid"`
+	Response    json.RawMessage `json:"response"`
+}
+
+type BatchedStagingResponses struct {
+	Responses []BatchedStagingResponse `json:"responses"`
+}
+
+type pendingResponse struct {
+	ctx         context.Context
+	stagingGuid string
+	payload     []byte
+	logger      lager.Logger
+	result      chan error
+}
+
+type batchingCcClient struct {
+	client *ccClient
+	config BatchConfig
+	clock  clock.Clock
+
+	pending     chan pendingResponse
+	stopped     chan struct{}
+	inFlight    sync.WaitGroup
+	unsupported int32
+}
+
+func ValidateBatchConfig(config BatchConfig) error {
+	if config.MaxSize <= 0 {
+		return ErrInvalidBatchSize
+	}
+	return nil
+}
+
+// NewBatchingCcClient returns a CcClient that collects staging responses for
+// up to config.FlushInterval, or until config.MaxSize have been collected,
+// and delivers them to the CC in a single request. StagingComplete still
+// blocks until the response it was given has been delivered.
+//
+// If the CC does not support batch delivery, or a batch holds a single
+// response, responses are delivered individually to the configured
+// endpoints instead.
+func NewBatchingCcClient(clientConfig Config, config BatchConfig, clock clock.Clock) (BatchingCcClient, error) {
+	err := ValidateBatchConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchingCcClient{
+		client:  newCcClient(clientConfig),
+		config:  config,
+		clock:   clock,
+		pending: make(chan pendingResponse),
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// BuildComplete is not batched: the CC has no batch endpoint for builds.
+func (cc *batchingCcClient) BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error {
+	return cc.client.BuildComplete(ctx, buildGuid, payload, logger)
+}
+
+func (cc *batchingCcClient) StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error {
+	if atomic.LoadInt32(&cc.unsupported) == 1 {
+		return cc.client.StagingComplete(ctx, stagingGuid, payload, logger)
+	}
+
+	response := pendingResponse{
+		ctx:         ctx,
+		stagingGuid: stagingGuid,
+		payload:     payload,
+		logger:      logger,
+		result:      make(chan error, 1),
+	}
+
+	select {
+	case cc.pending <- response:
+	case <-cc.stopped:
+		return cc.client.StagingComplete(ctx, stagingGuid, payload, logger)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-response.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run batches responses until signalled. It then delivers the batch it is
+// collecting and returns once every batch in flight has been delivered.
+func (cc *batchingCcClient) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	var batch []pendingResponse
+	var timer clock.Timer
+	var flushTimeout <-chan time.Time
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		if len(batch) > 0 {
+			cc.inFlight.Add(1)
+			go func(batch []pendingResponse) {
+				defer cc.inFlight.Done()
+				cc.deliver(batch)
+			}(batch)
+		}
+		batch = nil
+		timer = nil
+		flushTimeout = nil
+	}
+
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			flush()
+			close(cc.stopped)
+			cc.inFlight.Wait()
+			return nil
+
+		case response := <-cc.pending:
+			batch = append(batch, response)
+			if len(batch) >= cc.config.MaxSize {
+				flush()
+			} else if timer == nil {
+				timer = cc.clock.NewTimer(cc.config.FlushInterval)
+				flushTimeout = timer.C()
+			}
+
+		case <-flushTimeout:
+			flush()
+		}
+	}
+}
+
+func (cc *batchingCcClient) deliver(batch []pendingResponse) {
+	if len(batch) == 1 || atomic.LoadInt32(&cc.unsupported) == 1 {
+		cc.deliverIndividually(batch)
+		return
+	}
+
+	logger := batch[0].logger.Session("cc-client").Session("deliver-batch", lager.Data{"size": len(batch)})
+
+	body := BatchedStagingResponses{Responses: make([]BatchedStagingResponse, 0, len(batch))}
+	for _, response := range batch {
+		body.Responses = append(body.Responses, BatchedStagingResponse{
+			StagingGuid: response.stagingGuid,
+			Response:    json.RawMessage(response.payload),
+		})
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		logger.Error("marshal-batch-failed", err)
+		cc.deliverIndividually(batch)
+		return
+	}
+
+	ctx, cancel := batchContext(batch)
+	defer cancel()
+
+	err = cc.client.postStagingComplete(ctx, cc.config.Path, cc.client.baseURI+cc.config.Path, payload, logger)
+	if badResponse, ok := err.(*BadResponseError); ok && batchUnsupported(badResponse.StatusCode) {
+		logger.Info("batch-delivery-unsupported", lager.Data{"status": badResponse.StatusCode})
+		atomic.StoreInt32(&cc.unsupported, 1)
+		cc.deliverIndividually(batch)
+		return
+	}
+
+	if err != nil {
+		logger.Error("deliver-batch-failed", err)
+	} else {
+		logger.Info("delivered-batch")
+	}
+
+	for _, response := range batch {
+		response.result <- err
+	}
+}
+
+func (cc *batchingCcClient) deliverIndividually(batch []pendingResponse) {
+	for _, response := range batch {
+		response.result <- cc.client.StagingComplete(response.ctx, response.stagingGuid, response.payload, response.logger)
+	}
+}
+
+// batchContext is done once every caller waiting on the batch has stopped
+// waiting, as its delivery is shared by all of them.
+func batchContext(batch []pendingResponse) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		for _, response := range batch {
+			select {
+			case <-response.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+
+	return ctx, cancel
+}
+
+func batchUnsupported(statusCode int) bool {
+	return statusCode == http.StatusNotFound || statusCode == http.StatusMethodNotAllowed
+}
@@ -0,0 +1,192 @@
+package cc_client_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batching CC Client", func() {
+	const flushInterval = 100 * time.Millisecond
+
+	var (
+		fakeCC    *ghttp.Server
+		fakeClock *fakeclock.FakeClock
+		logger    lager.Logger
+
+		ccClient cc_client.BatchingCcClient
+		process  ifrit.Process
+	)
+
+	deliverAsync := func(stagingGuid string) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{"guid":"`+stagingGuid+`"}`), logger)
+		}()
+		return result
+	}
+
+	verifyBatch := func(expected ...string) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			var batch cc_client.BatchedStagingResponses
+			Expect(json.Unmarshal(body, &batch)).To(Succeed())
+
+			guids := []string{}
+			for _, response := range batch.Responses {
+				guids = append(guids, response.StagingGuid)
+				Expect(response.Response).To(MatchJSON(`{"guid":"` + response.StagingGuid + `"}`))
+			}
+			Expect(guids).To(ConsistOf(expected))
+		}
+	}
+
+	BeforeEach(func() {
+		fakeCC = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+
+		var err error
+		ccClient, err = cc_client.NewBatchingCcClient(cc_client.Config{BaseURI: fakeCC.URL(), Username: "username", Password: "password", SkipCertVerify: true}, cc_client.BatchConfig{
+			Path:          "/internal/staging/completed",
+			MaxSize:       2,
+			FlushInterval: flushInterval,
+		}, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		process = ifrit.Invoke(ccClient)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+		fakeCC.Close()
+	})
+
+	Context("when the batch fills up", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/internal/staging/completed"),
+				ghttp.VerifyBasicAuth("username", "password"),
+				verifyBatch("guid-1", "guid-2"),
+				ghttp.RespondWith(200, `{}`),
+			))
+		})
+
+		It("delivers the responses in a single request", func() {
+			first := deliverAsync("guid-1")
+			second := deliverAsync("guid-2")
+
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(second).Should(Receive(BeNil()))
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when signalled while a batch is being delivered", func() {
+		var delivering, release chan struct{}
+
+		BeforeEach(func() {
+			delivering = make(chan struct{})
+			release = make(chan struct{})
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(
+				func(http.ResponseWriter, *http.Request) {
+					close(delivering)
+					<-release
+				},
+				ghttp.RespondWith(200, `{}`),
+			))
+		})
+
+		It("exits once the batch has been delivered", func() {
+			first := deliverAsync("guid-1")
+			second := deliverAsync("guid-2")
+			Eventually(delivering).Should(BeClosed())
+
+			process.Signal(os.Interrupt)
+			Consistently(process.Wait()).ShouldNot(Receive())
+
+			close(release)
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(second).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("when the flush interval elapses", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/internal/staging/guid-1/completed"),
+				ghttp.RespondWith(200, `{}`),
+			))
+		})
+
+		It("delivers what has been collected", func() {
+			result := deliverAsync("guid-1")
+			Consistently(result).ShouldNot(Receive())
+
+			fakeClock.Increment(flushInterval)
+
+			Eventually(result).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("when the batch delivery fails", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(ghttp.RespondWith(500, `{}`))
+		})
+
+		It("returns the error for every response in the batch", func() {
+			first := deliverAsync("guid-1")
+			second := deliverAsync("guid-2")
+
+			var err error
+			Eventually(first).Should(Receive(&err))
+			Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 500}))
+			Eventually(second).Should(Receive(&err))
+			Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 500}))
+		})
+	})
+
+	Context("when the CC does not support batch delivery", func() {
+		BeforeEach(func() {
+			fakeCC.RouteToHandler("POST", "/internal/staging/completed", ghttp.RespondWith(404, `{}`))
+			fakeCC.RouteToHandler("POST", "/internal/staging/guid-1/completed", ghttp.RespondWith(200, `{}`))
+			fakeCC.RouteToHandler("POST", "/internal/staging/guid-2/completed", ghttp.RespondWith(200, `{}`))
+			fakeCC.RouteToHandler("POST", "/internal/staging/guid-3/completed", ghttp.RespondWith(200, `{}`))
+		})
+
+		It("falls back to delivering responses individually from then on", func() {
+			first := deliverAsync("guid-1")
+			second := deliverAsync("guid-2")
+
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(second).Should(Receive(BeNil()))
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(3))
+
+			Expect(ccClient.StagingComplete(context.Background(), "guid-3", []byte(`{}`), logger)).To(Succeed())
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	It("rejects batches that can't hold a response", func() {
+		_, err := cc_client.NewBatchingCcClient(cc_client.Config{BaseURI: fakeCC.URL()}, cc_client.BatchConfig{
+			Path:          "/internal/staging/completed",
+			FlushInterval: flushInterval,
+		}, fakeClock)
+		Expect(err).To(Equal(cc_client.ErrInvalidBatchSize))
+	})
+})
@@ -0,0 +1,175 @@
+package cc_client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+
+	// Metrics
+	breakerOpenMetric     = "CCCircuitBreakerOpen"
+	breakerTrippedCounter = "CCCircuitBreakerTripped"
+	breakerShedCounter    = "CCCircuitBreakerShedRequests"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open: not contacting the CC")
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker.
+	FailureThreshold int
+
+	// ResetTimeout is how long the breaker stays open before letting a
+	// single request through to probe whether the CC has recovered.
+	ResetTimeout time.Duration
+
+	// Name, when set, is appended to the breaker's metric names to tell
+	// apart breakers guarding different CC targets.
+	Name string
+}
+
+// CircuitBreaker is a CcClient that stops contacting the CC while it is
+// down, failing fast with ErrCircuitOpen instead.
+type CircuitBreaker interface {
+	CcClient
+	State() string
+}
+
+type circuitBreaker struct {
+	client CcClient
+	config BreakerConfig
+	clock  clock.Clock
+
+	openMetric     metric.Metric
+	trippedCounter metric.Counter
+	shedCounter    metric.Counter
+
+	lock     sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker wraps client in a breaker that opens after
+// FailureThreshold consecutive retryable failures (see IsRetryable). CC
+// responses that are permanent failures show the CC is up, so they don't
+// count towards opening the breaker.
+func NewCircuitBreaker(client CcClient, config BreakerConfig, clock clock.Clock) CircuitBreaker {
+	suffix := ""
+	if config.Name != "" {
+		suffix = "." + config.Name
+	}
+
+	breaker := &circuitBreaker{
+		client: client,
+		config: config,
+		clock:  clock,
+		state:  BreakerClosed,
+
+		openMetric:     metric.Metric(breakerOpenMetric + suffix),
+		trippedCounter: metric.Counter(breakerTrippedCounter + suffix),
+		shedCounter:    metric.Counter(breakerShedCounter + suffix),
+	}
+	breaker.openMetric.Send(0)
+	return breaker
+}
+
+func (b *circuitBreaker) StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error {
+	logger = logger.Session("circuit-breaker")
+
+	if !b.allow(logger) {
+		b.shedCounter.Increment()
+		return ErrCircuitOpen
+	}
+
+	err := b.client.StagingComplete(ctx, stagingGuid, payload, logger)
+	b.record(logger, err)
+	return err
+}
+
+func (b *circuitBreaker) BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error {
+	logger = logger.Session("circuit-breaker")
+
+	if !b.allow(logger) {
+		b.shedCounter.Increment()
+		return ErrCircuitOpen
+	}
+
+	err := b.client.BuildComplete(ctx, buildGuid, payload, logger)
+	b.record(logger, err)
+	return err
+}
+
+func (b *circuitBreaker) State() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) allow(logger lager.Logger) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.config.ResetTimeout {
+			return false
+		}
+		logger.Info("probing-cc")
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// a probe is already in flight
+		return false
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(logger lager.Logger, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err != nil && IsRetryable(err) {
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.config.FailureThreshold {
+			b.open(logger)
+		}
+		return
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		// the caller gave up, which says nothing about the CC
+		if b.state == BreakerHalfOpen {
+			b.state = BreakerOpen
+		}
+		return
+	}
+
+	b.failures = 0
+	if b.state != BreakerClosed {
+		logger.Info("closed")
+		b.state = BreakerClosed
+		b.openMetric.Send(0)
+	}
+}
+
+func (b *circuitBreaker) open(logger lager.Logger) {
+	if b.state == BreakerClosed {
+		logger.Info("opened", lager.Data{"failures": b.failures})
+		b.trippedCounter.Increment()
+		b.openMetric.Send(1)
+	}
+
+	b.state = BreakerOpen
+	b.openedAt = b.clock.Now()
+}
@@ -0,0 +1,125 @@
+package cc_client_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/cc_client/fakes"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		fakeCcClient *fakes.FakeCcClient
+		fakeClock    *fakeclock.FakeClock
+		logger       *lagertest.TestLogger
+		metricSender *fake.FakeMetricSender
+
+		breaker cc_client.CircuitBreaker
+	)
+
+	stagingComplete := func() error {
+		return breaker.StagingComplete(context.Background(), "the-staging-guid", []byte(`{}`), logger)
+	}
+
+	BeforeEach(func() {
+		fakeCcClient = &fakes.FakeCcClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+
+		breaker = cc_client.NewCircuitBreaker(fakeCcClient, cc_client.BreakerConfig{
+			FailureThreshold: 2,
+			ResetTimeout:     time.Minute,
+		}, fakeClock)
+	})
+
+	It("starts closed", func() {
+		Expect(breaker.State()).To(Equal(cc_client.BreakerClosed))
+		Expect(metricSender.GetValue("CCCircuitBreakerOpen").Value).To(BeEquivalentTo(0))
+	})
+
+	Context("when the CC keeps failing", func() {
+		BeforeEach(func() {
+			fakeCcClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: 503})
+
+			stagingComplete()
+			stagingComplete()
+		})
+
+		It("opens after the failure threshold", func() {
+			Expect(breaker.State()).To(Equal(cc_client.BreakerOpen))
+			Expect(metricSender.GetValue("CCCircuitBreakerOpen").Value).To(BeEquivalentTo(1))
+			Expect(metricSender.GetCounter("CCCircuitBreakerTripped")).To(BeEquivalentTo(1))
+		})
+
+		It("sheds requests without contacting the CC", func() {
+			Expect(stagingComplete()).To(Equal(cc_client.ErrCircuitOpen))
+			Expect(fakeCcClient.StagingCompleteCallCount()).To(Equal(2))
+			Expect(metricSender.GetCounter("CCCircuitBreakerShedRequests")).To(BeEquivalentTo(1))
+		})
+
+		Context("once the reset timeout passes", func() {
+			BeforeEach(func() {
+				fakeClock.Increment(time.Minute)
+			})
+
+			It("closes when the probe succeeds", func() {
+				fakeCcClient.StagingCompleteReturns(nil)
+
+				Expect(stagingComplete()).To(Succeed())
+				Expect(fakeCcClient.StagingCompleteCallCount()).To(Equal(3))
+				Expect(breaker.State()).To(Equal(cc_client.BreakerClosed))
+				Expect(metricSender.GetValue("CCCircuitBreakerOpen").Value).To(BeEquivalentTo(0))
+			})
+
+			It("reopens when the probe fails", func() {
+				Expect(stagingComplete()).To(Equal(&cc_client.BadResponseError{StatusCode: 503}))
+				Expect(breaker.State()).To(Equal(cc_client.BreakerOpen))
+
+				Expect(stagingComplete()).To(Equal(cc_client.ErrCircuitOpen))
+				Expect(fakeCcClient.StagingCompleteCallCount()).To(Equal(3))
+			})
+		})
+	})
+
+	Context("when the breaker is named", func() {
+		BeforeEach(func() {
+			breaker = cc_client.NewCircuitBreaker(fakeCcClient, cc_client.BreakerConfig{
+				FailureThreshold: 1,
+				ResetTimeout:     time.Minute,
+				Name:             "other-cc",
+			}, fakeClock)
+
+			fakeCcClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: 503})
+			stagingComplete()
+		})
+
+		It("emits its metrics under its name", func() {
+			Expect(metricSender.GetValue("CCCircuitBreakerOpen.other-cc").Value).To(BeEquivalentTo(1))
+			Expect(metricSender.GetCounter("CCCircuitBreakerTripped.other-cc")).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("when the CC rejects responses", func() {
+		BeforeEach(func() {
+			fakeCcClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: 400})
+		})
+
+		It("stays closed", func() {
+			stagingComplete()
+			stagingComplete()
+			stagingComplete()
+
+			Expect(breaker.State()).To(Equal(cc_client.BreakerClosed))
+		})
+	})
+})
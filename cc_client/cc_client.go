@@ -4,27 +4,111 @@ import (
 	"bytes"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"sync/atomic"
 	"time"
 
+	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/net/http2"
 )
 
 const (
-	stagingCompleteRequestTimeout = 5 * time.Second
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultRequestTimeout = 5 * time.Second
+
+	DefaultMaxIdleConnsPerHost = 32
+
+	maxLoggedResponseBody = 4096
 )
 
 //go:generate counterfeiter -o fakes/fake_cc_client.go . CcClient
 type CcClient interface {
-	StagingComplete(stagingGuid string, payload []byte, logger lager.Logger) error
+	// StagingComplete delivers a staging response, giving up when ctx is
+	// cancelled or its deadline passes.
+	StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error
+
+	// BuildComplete delivers a BuildCompletedForCC to the CC v3 builds API,
+	// for CCs that no longer serve the v2 internal staging API.
+	BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error
+}
+
+type Config struct {
+	BaseURI        string
+	Username       string
+	Password       string
+	SkipCertVerify bool
+	Endpoints      StagingCompleteEndpoints
+
+	// TLSConfig, when set, is used for connections to the CC in place of
+	// one built from SkipCertVerify. See NewTLSConfig.
+	TLSConfig *tls.Config
+
+	// TokenFetcher, when set, authenticates requests with a bearer token
+	// instead of basic auth.
+	TokenFetcher TokenFetcher
+
+	// Proxy chooses the proxy for requests to the CC. It defaults to
+	// http.ProxyFromEnvironment; see proxy.Func.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// ConnectTimeout bounds establishing a connection to the CC, including
+	// the TLS handshake. It defaults to DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+
+	// RequestTimeout bounds a single POST to the CC, from connecting to
+	// reading the response. Retries each get their own RequestTimeout. It
+	// defaults to DefaultRequestTimeout.
+	RequestTimeout time.Duration
+
+	// MaxIdleConnsPerHost bounds the keep-alive connections kept open to the
+	// CC between callbacks. It defaults to DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+
+	// DisableHTTP2 stops the client from negotiating HTTP/2 with the CC.
+	DisableHTTP2 bool
+
+	// Signer, when set, signs each staging response. The signature is sent
+	// in SignatureHeader.
+	Signer Signer
+
+	// GzipThreshold is the payload size, in bytes, from which staging
+	// responses are sent gzip-compressed. Zero disables compression. A CC
+	// that rejects a compressed payload with 415 is sent uncompressed
+	// payloads from then on.
+	GzipThreshold int
+
+	// MaxRequestsPerSecond caps the requests made to the CC, retries
+	// included, so that a flood of finishing tasks doesn't overwhelm it.
+	// Requests over the cap wait their turn. Zero means no cap.
+	MaxRequestsPerSecond int
+
+	Retry RetryPolicy
+
+	// Clock times retry backoffs. It defaults to the real clock.
+	Clock clock.Clock
 }
 
 type ccClient struct {
-	baseURI    string
-	username   string
-	password   string
-	httpClient *http.Client
+	baseURI      string
+	username     string
+	password     string
+	endpoints    StagingCompleteEndpoints
+	tokenFetcher TokenFetcher
+	retry        RetryPolicy
+	clock        clock.Clock
+	limiter      *rateLimiter
+	signer       Signer
+	httpClient   *http.Client
+
+	gzipThreshold   int
+	gzipUnsupported int32
 }
 
 type BadResponseError struct {
@@ -35,59 +119,293 @@ func (b *BadResponseError) Error() string {
 	return fmt.Sprintf("Staging response POST failed with %d", b.StatusCode)
 }
 
-func NewCcClient(baseURI string, username string, password string, skipCertVerify bool) CcClient {
-	httpClient := &http.Client{
-		Timeout: stagingCompleteRequestTimeout,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			Dial: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).Dial,
-			TLSHandshakeTimeout: 10 * time.Second,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipCertVerify,
-				MinVersion:         tls.VersionTLS10,
-			},
-		},
+// Retryable reports whether the CC failed on its side, so that posting the
+// same response again may succeed.
+func (b *BadResponseError) Retryable() bool {
+	return b.StatusCode >= http.StatusInternalServerError
+}
+
+func NewCcClient(config Config) CcClient {
+	return newCcClient(config)
+}
+
+func newCcClient(config Config) *ccClient {
+	endpoints := config.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = DefaultStagingCompleteEndpoints
 	}
 
+	clock := config.clock()
+
 	return &ccClient{
-		baseURI:    baseURI,
-		username:   username,
-		password:   password,
-		httpClient: httpClient,
+		baseURI:      config.BaseURI,
+		username:     config.Username,
+		password:     config.Password,
+		endpoints:    endpoints,
+		tokenFetcher: config.TokenFetcher,
+		retry:        config.Retry,
+		clock:        clock,
+		limiter:      newRateLimiter(config.MaxRequestsPerSecond, clock),
+		signer:       config.Signer,
+		httpClient:   newHTTPClient(config),
+
+		gzipThreshold: config.GzipThreshold,
+	}
+}
+
+func (config Config) clock() clock.Clock {
+	if config.Clock != nil {
+		return config.Clock
 	}
+	return clock.NewClock()
 }
 
-func (cc *ccClient) StagingComplete(stagingGuid string, payload []byte, logger lager.Logger) error {
+func (config Config) tlsConfig() *tls.Config {
+	if config.TLSConfig != nil {
+		return config.TLSConfig
+	}
+
+	return &tls.Config{
+		InsecureSkipVerify: config.SkipCertVerify,
+		MinVersion:         tls.VersionTLS10,
+	}
+}
+
+// newHTTPClient keeps connections to the CC alive and pooled, so that a burst
+// of callbacks neither opens a connection (and TLS session) per response nor
+// runs out of ephemeral ports.
+func newHTTPClient(config Config) *http.Client {
+	maxIdleConnsPerHost := config.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+
+	connectTimeout := config.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+
+	requestTimeout := config.RequestTimeout
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+
+	proxy := config.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		Dial: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: connectTimeout,
+		TLSClientConfig:     config.tlsConfig(),
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+	}
+
+	if !config.DisableHTTP2 {
+		// ConfigureTransport only fails if the transport already speaks
+		// HTTP/2, which a fresh one doesn't.
+		http2.ConfigureTransport(transport)
+	}
+
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+	}
+}
+
+func (cc *ccClient) StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error {
+	return cc.deliver(ctx, cc.endpoints, stagingGuid, payload, logger)
+}
+
+func (cc *ccClient) BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error {
+	return cc.deliver(ctx, V3BuildCompletedEndpoints, buildGuid, payload, logger)
+}
+
+func (cc *ccClient) deliver(ctx context.Context, endpoints StagingCompleteEndpoints, stagingGuid string, payload []byte, logger lager.Logger) error {
 	logger = logger.Session("cc-client")
-	logger.Info("delivering-staging-response", lager.Data{"payload": string(payload)})
+	logger.Info("delivering-staging-response")
+
+	var requiredErr, firstErr error
+	accepted := make(map[string]bool, len(endpoints))
+	for _, endpoint := range endpoints {
+		err := cc.postStagingComplete(ctx, endpoint.Path, endpoint.URI(cc.baseURI, stagingGuid), payload, logger)
+		accepted[endpoint.Path] = err == nil
+		if err == nil {
+			continue
+		}
 
-	request, err := http.NewRequest("POST", cc.stagingCompleteURI(stagingGuid), bytes.NewReader(payload))
+		logger.Error("deliver-staging-response-failed", err, lager.Data{"endpoint": endpoint.Path, "required": endpoint.Required})
+		if firstErr == nil {
+			firstErr = err
+		}
+		if endpoint.Required && requiredErr == nil {
+			requiredErr = err
+		}
+	}
+
+	if len(endpoints) > 1 && diverged(accepted) {
+		logger.Info("staging-response-acceptance-diverged", lager.Data{"accepted": accepted})
+	}
+
+	if requiredErr != nil {
+		return requiredErr
+	}
+
+	if !anyAccepted(accepted) {
+		return firstErr
+	}
+
+	logger.Info("delivered-staging-response")
+	return nil
+}
+
+// postStagingComplete POSTs payload to uri, retrying retryable failures
+// according to the client's RetryPolicy. Posting a staging response is
+// idempotent, so a retry is safe even if the CC processed the earlier POST.
+//
+// When authenticating with a token, a 401 response invalidates the token and
+// the POST is retried once with a fresh one. Likewise a compressed POST
+// rejected with 415 is retried once uncompressed.
+func (cc *ccClient) postStagingComplete(ctx context.Context, path, uri string, payload []byte, logger lager.Logger) error {
+	metrics := requestMetricsFor(path)
+
+	signature, err := cc.sign(payload)
+	if err != nil {
+		return err
+	}
+
+	return withRetries(ctx, cc.retry, cc.clock, func() error {
+		compressed := cc.compresses(payload)
+		err := cc.post(ctx, uri, payload, signature, metrics, logger)
+
+		if badResponse, ok := err.(*BadResponseError); ok && badResponse.StatusCode == http.StatusUnsupportedMediaType && compressed {
+			logger.Info("gzip-unsupported")
+			atomic.StoreInt32(&cc.gzipUnsupported, 1)
+			err = cc.post(ctx, uri, payload, signature, metrics, logger)
+		}
+
+		if badResponse, ok := err.(*BadResponseError); ok && badResponse.StatusCode == http.StatusUnauthorized && cc.tokenFetcher != nil {
+			cc.tokenFetcher.Invalidate()
+			err = cc.post(ctx, uri, payload, signature, metrics, logger)
+		}
+
+		return err
+	})
+}
+
+// post logs each request and response at debug level, redacting
+// credentials (see RedactHeaders and RedactPayload) so that debug logging is
+// safe to enable in production. Requests that reach the CC are recorded in
+// metrics.
+func (cc *ccClient) post(ctx context.Context, uri string, payload []byte, signature string, metrics requestMetrics, logger lager.Logger) error {
+	err := cc.limiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := payload
+	compressed := cc.compresses(payload)
+	if compressed {
+		body, err = gzipPayload(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest("POST", uri, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
-	request.SetBasicAuth(cc.username, cc.password)
+	err = cc.authorize(ctx, request)
+	if err != nil {
+		return err
+	}
 	request.Header.Set("content-type", "application/json")
+	if compressed {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	if signature != "" {
+		request.Header.Set(SignatureHeader, signature)
+	}
 
-	response, err := cc.httpClient.Do(request)
+	logger.Debug("request", lager.Data{
+		"method":  request.Method,
+		"url":     uri,
+		"headers": RedactHeaders(request.Header),
+		"body":    RedactPayload(payload),
+	})
+
+	startTime := cc.clock.Now()
+	response, err := ctxhttp.Do(ctx, cc.httpClient, request)
 	if err != nil {
-		logger.Error("deliver-staging-response-failed", err)
+		metrics.record(startTime, cc.clock.Now(), err)
 		return err
 	}
 
 	defer response.Body.Close()
 
+	responseBody, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxLoggedResponseBody))
+	logger.Debug("response", lager.Data{
+		"status":   response.StatusCode,
+		"headers":  RedactHeaders(response.Header),
+		"body":     RedactPayload(responseBody),
+		"duration": cc.clock.Now().Sub(startTime).String(),
+	})
+
+	// drain the rest of the body so the connection can be reused
+	io.Copy(ioutil.Discard, response.Body)
+
 	if response.StatusCode != http.StatusOK {
-		return &BadResponseError{response.StatusCode}
+		err = &BadResponseError{response.StatusCode}
 	}
 
-	logger.Info("delivered-staging-response")
+	metrics.record(startTime, cc.clock.Now(), err)
+	return err
+}
+
+// sign signs the uncompressed payload, so that verifiers check what the CC
+// decodes rather than what was sent over the wire.
+func (cc *ccClient) sign(payload []byte) (string, error) {
+	if cc.signer == nil {
+		return "", nil
+	}
+	return cc.signer.Sign(payload)
+}
+
+func (cc *ccClient) authorize(ctx context.Context, request *http.Request) error {
+	if cc.tokenFetcher == nil {
+		request.SetBasicAuth(cc.username, cc.password)
+		return nil
+	}
+
+	token, err := cc.tokenFetcher.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "bearer "+token)
 	return nil
 }
 
-func (cc *ccClient) stagingCompleteURI(stagingGuid string) string {
-	return fmt.Sprintf("%s/internal/staging/%s/completed", cc.baseURI, stagingGuid)
+func anyAccepted(accepted map[string]bool) bool {
+	for _, ok := range accepted {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func diverged(accepted map[string]bool) bool {
+	seen := map[bool]bool{}
+	for _, ok := range accepted {
+		seen[ok] = true
+	}
+	return len(seen) > 1
 }
@@ -1,17 +1,27 @@
 package cc_client_test
 
 import (
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/cc_client/fakes"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/clock/fakeclock"
 	"github.com/pivotal-golang/lager"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/net/context"
 )
 
 var _ = Describe("CC Client", func() {
@@ -30,7 +40,7 @@ var _ = Describe("CC Client", func() {
 		logger = lager.NewLogger("fakelogger")
 		logger.RegisterSink(lager.NewWriterSink(GinkgoWriter, lager.DEBUG))
 
-		ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true)
+		ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: fakeCC.URL(), Username: "username", Password: "password", SkipCertVerify: true})
 
 		stagingGuid = "the-staging-guid"
 	})
@@ -62,11 +72,226 @@ var _ = Describe("CC Client", func() {
 		})
 
 		It("sends the request payload to the CC without modification", func() {
-			err := ccClient.StagingComplete(stagingGuid, expectedBody, logger)
+			err := ccClient.StagingComplete(context.Background(), stagingGuid, expectedBody, logger)
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
 
+	Describe("Logging requests", func() {
+		var testLogger *lagertest.TestLogger
+
+		BeforeEach(func() {
+			testLogger = lagertest.NewTestLogger("test")
+
+			fakeCC.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid)),
+					ghttp.RespondWith(200, `{"access_token": "some-token"}`),
+				),
+			)
+		})
+
+		It("logs the request and response without credentials", func() {
+			err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{"password": "secret"}`), testLogger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(testLogger.LogMessages()).To(ContainElement("test.cc-client.request"))
+			Expect(testLogger.LogMessages()).To(ContainElement("test.cc-client.response"))
+
+			logs := string(testLogger.Buffer().Contents())
+			Expect(logs).To(ContainSubstring("[REDACTED]"))
+			Expect(logs).NotTo(ContainSubstring("secret"))
+			Expect(logs).NotTo(ContainSubstring("some-token"))
+			Expect(logs).NotTo(ContainSubstring("dXNlcm5hbWU6cGFzc3dvcmQ="))
+		})
+	})
+
+	Describe("Metrics", func() {
+		var metricSender *fake.FakeMetricSender
+
+		BeforeEach(func() {
+			metricSender = fake.NewFakeMetricSender()
+			metrics.Initialize(metricSender, nil)
+
+			fakeCC.AppendHandlers(
+				ghttp.RespondWith(200, `{}`),
+				ghttp.RespondWith(400, `{}`),
+				ghttp.RespondWith(200, `{}`),
+			)
+		})
+
+		It("counts requests and errors and times requests per endpoint", func() {
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).To(Succeed())
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).NotTo(Succeed())
+			Expect(ccClient.BuildComplete(context.Background(), "the-build-guid", []byte(`{}`), logger)).To(Succeed())
+
+			Expect(metricSender.GetCounter("CCStagingCompletedRequests")).To(BeEquivalentTo(2))
+			Expect(metricSender.GetCounter("CCStagingCompletedRequestErrors")).To(BeEquivalentTo(1))
+			Expect(metricSender.GetValue("CCStagingCompletedRequestTime").Unit).To(Equal("nanos"))
+
+			Expect(metricSender.GetCounter("CCBuildsCompletedRequests")).To(BeEquivalentTo(1))
+			Expect(metricSender.GetCounter("CCBuildsCompletedRequestErrors")).To(BeEquivalentTo(0))
+		})
+	})
+
+	Describe("Compressing payloads", func() {
+		var largePayload []byte
+
+		BeforeEach(func() {
+			largePayload = []byte(fmt.Sprintf(`{"execution_metadata": "%s"}`, strings.Repeat("x", 1024)))
+
+			ccClient = cc_client.NewCcClient(cc_client.Config{
+				BaseURI:        fakeCC.URL(),
+				SkipCertVerify: true,
+				GzipThreshold:  512,
+			})
+		})
+
+		verifyGzipped := func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.Header.Get("Content-Encoding")).To(Equal("gzip"))
+
+			reader, err := gzip.NewReader(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			body, err := ioutil.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(body).To(Equal(largePayload))
+		}
+
+		verifyUncompressed := func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.Header.Get("Content-Encoding")).To(BeEmpty())
+		}
+
+		It("compresses payloads over the threshold", func() {
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(verifyGzipped, ghttp.RespondWith(200, `{}`)))
+
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, largePayload, logger)).To(Succeed())
+		})
+
+		It("sends small payloads uncompressed", func() {
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(verifyUncompressed, ghttp.RespondWith(200, `{}`)))
+
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).To(Succeed())
+		})
+
+		Context("when the CC doesn't accept compressed payloads", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.CombineHandlers(verifyGzipped, ghttp.RespondWith(415, `{}`)),
+					ghttp.CombineHandlers(verifyUncompressed, ghttp.RespondWith(200, `{}`)),
+					ghttp.CombineHandlers(verifyUncompressed, ghttp.RespondWith(200, `{}`)),
+				)
+			})
+
+			It("falls back to uncompressed payloads from then on", func() {
+				Expect(ccClient.StagingComplete(context.Background(), stagingGuid, largePayload, logger)).To(Succeed())
+				Expect(ccClient.StagingComplete(context.Background(), stagingGuid, largePayload, logger)).To(Succeed())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("Signing payloads", func() {
+		var fakeSigner *fakes.FakeSigner
+
+		BeforeEach(func() {
+			fakeSigner = &fakes.FakeSigner{}
+			fakeSigner.SignReturns("the-header..the-signature", nil)
+
+			ccClient = cc_client.NewCcClient(cc_client.Config{
+				BaseURI:        fakeCC.URL(),
+				SkipCertVerify: true,
+				Signer:         fakeSigner,
+			})
+		})
+
+		It("sends the payload's signature", func() {
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyHeaderKV(cc_client.SignatureHeader, "the-header..the-signature"),
+				ghttp.RespondWith(200, `{}`),
+			))
+
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{"key": "value"}`), logger)).To(Succeed())
+			Expect(fakeSigner.SignArgsForCall(0)).To(Equal([]byte(`{"key": "value"}`)))
+		})
+
+		It("does not post responses it can't sign", func() {
+			fakeSigner.SignReturns("", errors.New("signing failed"))
+
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).To(MatchError("signing failed"))
+			Expect(fakeCC.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Describe("Reporting a v3 build", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/internal/builds/the-build-guid/completed"),
+					ghttp.VerifyBasicAuth("username", "password"),
+					ghttp.VerifyJSON(`{"state": "STAGED"}`),
+					ghttp.RespondWith(200, `{}`),
+				),
+			)
+		})
+
+		It("posts to the v3 builds API", func() {
+			err := ccClient.BuildComplete(context.Background(), "the-build-guid", []byte(`{"state": "STAGED"}`), logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Describe("Delivering to multiple endpoints", func() {
+		var endpoints cc_client.StagingCompleteEndpoints
+
+		BeforeEach(func() {
+			endpoints = cc_client.StagingCompleteEndpoints{}
+			Expect(endpoints.Set("/internal/staging/%s/completed")).To(Succeed())
+			Expect(endpoints.Set("optional:/internal/v4/staging/%s/completed")).To(Succeed())
+
+			ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: fakeCC.URL(), Username: "username", Password: "password", SkipCertVerify: true, Endpoints: endpoints})
+		})
+
+		Context("when both endpoints accept the response", func() {
+			BeforeEach(func() {
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid), ghttp.RespondWith(200, `{}`))
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/v4/staging/%s/completed", stagingGuid), ghttp.RespondWith(200, `{}`))
+			})
+
+			It("posts the response to every endpoint", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+			})
+		})
+
+		Context("when the optional endpoint rejects the response", func() {
+			BeforeEach(func() {
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid), ghttp.RespondWith(200, `{}`))
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/v4/staging/%s/completed", stagingGuid), ghttp.RespondWith(404, `{}`))
+			})
+
+			It("succeeds", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the required endpoint rejects the response", func() {
+			BeforeEach(func() {
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid), ghttp.RespondWith(500, `{}`))
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/v4/staging/%s/completed", stagingGuid), ghttp.RespondWith(200, `{}`))
+			})
+
+			It("returns the required endpoint's error", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).To(BeAssignableToTypeOf(&cc_client.BadResponseError{}))
+				Expect(err.(*cc_client.BadResponseError).StatusCode).To(Equal(500))
+			})
+		})
+	})
+
 	Describe("TLS certificate validation", func() {
 		BeforeEach(func() {
 			fakeCC = ghttp.NewTLSServer() // self-signed certificate
@@ -84,41 +309,326 @@ var _ = Describe("CC Client", func() {
 
 		Context("when certificate verfication is enabled", func() {
 			BeforeEach(func() {
-				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", false)
+				ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: fakeCC.URL(), Username: "username", Password: "password", SkipCertVerify: false})
 			})
 
 			It("fails with a self-signed certificate", func() {
-				err := ccClient.StagingComplete(stagingGuid, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
 				Expect(err).To(HaveOccurred())
 			})
 		})
 
 		Context("when certificate verfication is disabled", func() {
 			BeforeEach(func() {
-				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true)
+				ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: fakeCC.URL(), Username: "username", Password: "password", SkipCertVerify: true})
 			})
 
 			It("Attempts to validate SSL certificates", func() {
-				err := ccClient.StagingComplete(stagingGuid, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
 	})
 
+	Describe("Authenticating with a token", func() {
+		var tokenFetcher *fakes.FakeTokenFetcher
+
+		BeforeEach(func() {
+			tokenFetcher = &fakes.FakeTokenFetcher{}
+			tokenFetcher.TokenReturns("the-token", nil)
+
+			ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: fakeCC.URL(), SkipCertVerify: true, TokenFetcher: tokenFetcher})
+		})
+
+		Context("when the CC accepts the token", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid)),
+						ghttp.VerifyHeaderKV("Authorization", "bearer the-token"),
+						ghttp.RespondWith(200, `{}`),
+					),
+				)
+			})
+
+			It("sends the token instead of basic auth", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tokenFetcher.InvalidateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the CC rejects the token", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(401, `{}`),
+					ghttp.RespondWith(200, `{}`),
+				)
+			})
+
+			It("invalidates the token and retries once", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tokenFetcher.InvalidateCallCount()).To(Equal(1))
+				Expect(tokenFetcher.TokenCallCount()).To(Equal(2))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+			})
+		})
+
+		Context("when fetching the token fails", func() {
+			BeforeEach(func() {
+				tokenFetcher.TokenReturns("", &cc_client.TokenFetchError{StatusCode: 401})
+			})
+
+			It("returns the error without contacting the CC", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).To(Equal(&cc_client.TokenFetchError{StatusCode: 401}))
+				Expect(fakeCC.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Rate limiting", func() {
+		var fakeClock *fakeclock.FakeClock
+
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+
+			ccClient = cc_client.NewCcClient(cc_client.Config{
+				BaseURI:              fakeCC.URL(),
+				SkipCertVerify:       true,
+				MaxRequestsPerSecond: 2,
+				Clock:                fakeClock,
+			})
+
+			fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid), ghttp.RespondWith(200, `{}`))
+		})
+
+		It("holds requests over the cap until their turn", func() {
+			errCh := make(chan error, 3)
+			for i := 0; i < 3; i++ {
+				go func() {
+					errCh <- ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				}()
+			}
+
+			Eventually(fakeCC.ReceivedRequests).Should(HaveLen(1))
+			Consistently(fakeCC.ReceivedRequests).Should(HaveLen(1))
+
+			Eventually(func() []*http.Request {
+				fakeClock.Increment(500 * time.Millisecond)
+				return fakeCC.ReceivedRequests()
+			}).Should(HaveLen(3))
+
+			for i := 0; i < 3; i++ {
+				Eventually(errCh).Should(Receive(BeNil()))
+			}
+		})
+
+		It("stops waiting when the context ends", func() {
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(ccClient.StagingComplete(ctx, stagingGuid, []byte(`{}`), logger)).To(Equal(context.Canceled))
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Describe("Retrying", func() {
+		var fakeClock *fakeclock.FakeClock
+
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+
+			ccClient = cc_client.NewCcClient(cc_client.Config{
+				BaseURI:        fakeCC.URL(),
+				SkipCertVerify: true,
+				Retry:          cc_client.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 2 * time.Second},
+				Clock:          fakeClock,
+			})
+		})
+
+		stagingComplete := func() <-chan error {
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+			}()
+			return errCh
+		}
+
+		requestCountAfterBackoff := func() int {
+			fakeClock.Increment(2 * time.Second)
+			return len(fakeCC.ReceivedRequests())
+		}
+
+		Context("when the CC recovers from a 5xx", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(503, `{}`),
+					ghttp.RespondWith(500, `{}`),
+					ghttp.RespondWith(200, `{}`),
+				)
+			})
+
+			It("retries with backoff until the post succeeds", func() {
+				errCh := stagingComplete()
+
+				Eventually(requestCountAfterBackoff).Should(Equal(3))
+				Eventually(errCh).Should(Receive(BeNil()))
+			})
+		})
+
+		Context("when the CC keeps failing", func() {
+			BeforeEach(func() {
+				fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid), ghttp.RespondWith(502, `{}`))
+			})
+
+			It("counts the response as pending a retry while it backs off", func() {
+				errCh := stagingComplete()
+
+				Eventually(cc_client.PendingRetries).Should(Equal(1))
+
+				Eventually(func() <-chan error {
+					fakeClock.Increment(2 * time.Second)
+					return errCh
+				}).Should(Receive())
+				Expect(cc_client.PendingRetries()).To(Equal(0))
+			})
+
+			It("gives up after the maximum attempts with a retryable error", func() {
+				errCh := stagingComplete()
+
+				var err error
+				Eventually(func() <-chan error {
+					fakeClock.Increment(2 * time.Second)
+					return errCh
+				}).Should(Receive(&err))
+
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(3))
+				Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 502}))
+				Expect(cc_client.IsRetryable(err)).To(BeTrue())
+			})
+		})
+
+		Context("when the CC rejects the response", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(ghttp.RespondWith(400, `{}`))
+			})
+
+			It("does not retry and returns a permanent error", func() {
+				err := <-stagingComplete()
+				Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 400}))
+				Expect(cc_client.IsRetryable(err)).To(BeFalse())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("IsRetryable", func() {
+		It("retries connection errors", func() {
+			Expect(cc_client.IsRetryable(&url.Error{Op: "Post", URL: "http://cc", Err: &testNetError{}})).To(BeTrue())
+			Expect(cc_client.IsRetryable(&testNetError{timeout: true})).To(BeTrue())
+		})
+
+		It("retries 5xx responses only", func() {
+			Expect(cc_client.IsRetryable(&cc_client.BadResponseError{StatusCode: 503})).To(BeTrue())
+			Expect(cc_client.IsRetryable(&cc_client.BadResponseError{StatusCode: 404})).To(BeFalse())
+		})
+
+		It("does not retry once the context has ended", func() {
+			Expect(cc_client.IsRetryable(context.DeadlineExceeded)).To(BeFalse())
+			Expect(cc_client.IsRetryable(context.Canceled)).To(BeFalse())
+		})
+
+		It("does not retry other errors", func() {
+			Expect(cc_client.IsRetryable(errors.New("boom"))).To(BeFalse())
+		})
+	})
+
+	Describe("Connection pooling", func() {
+		var remoteAddrs []string
+
+		BeforeEach(func() {
+			remoteAddrs = []string{}
+			fakeCC.RouteToHandler("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid), ghttp.CombineHandlers(
+				func(w http.ResponseWriter, req *http.Request) {
+					remoteAddrs = append(remoteAddrs, req.RemoteAddr)
+				},
+				ghttp.RespondWith(200, `{"some": "body"}`),
+			))
+		})
+
+		It("reuses the connection for subsequent responses", func() {
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).To(Succeed())
+			Expect(ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)).To(Succeed())
+
+			Expect(remoteAddrs).To(HaveLen(2))
+			Expect(remoteAddrs[1]).To(Equal(remoteAddrs[0]))
+		})
+	})
+
 	Describe("Error conditions", func() {
 		Context("when the request couldn't be completed", func() {
 			BeforeEach(func() {
 				bogusURL := "http://0.0.0.0.0:80"
-				ccClient = cc_client.NewCcClient(bogusURL, "username", "password", true)
+				ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: bogusURL, Username: "username", Password: "password", SkipCertVerify: true})
 			})
 
 			It("percolates the error", func() {
-				err := ccClient.StagingComplete(stagingGuid, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(BeAssignableToTypeOf(&url.Error{}))
 			})
 		})
 
+		Context("when the context ends before the CC responds", func() {
+			var unblock chan struct{}
+
+			BeforeEach(func() {
+				unblock = make(chan struct{})
+				fakeCC.AppendHandlers(func(http.ResponseWriter, *http.Request) {
+					<-unblock
+				})
+			})
+
+			AfterEach(func() {
+				close(unblock)
+			})
+
+			It("returns the context's error", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				err := ccClient.StagingComplete(ctx, stagingGuid, []byte(`{}`), logger)
+				Expect(err).To(Equal(context.DeadlineExceeded))
+			})
+		})
+
+		Context("when the CC does not respond within the request timeout", func() {
+			var unblock chan struct{}
+
+			BeforeEach(func() {
+				unblock = make(chan struct{})
+				fakeCC.AppendHandlers(func(http.ResponseWriter, *http.Request) {
+					<-unblock
+				})
+
+				ccClient = cc_client.NewCcClient(cc_client.Config{BaseURI: fakeCC.URL(), RequestTimeout: 50 * time.Millisecond})
+			})
+
+			AfterEach(func() {
+				close(unblock)
+			})
+
+			It("returns a retryable timeout error", func() {
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
+				Expect(err).To(BeAssignableToTypeOf(&url.Error{}))
+				Expect(cc_client.IsRetryable(err)).To(BeTrue())
+			})
+		})
+
 		Context("when the response code is not StatusOK (200)", func() {
 			BeforeEach(func() {
 				fakeCC.AppendHandlers(
@@ -130,7 +640,7 @@ var _ = Describe("CC Client", func() {
 			})
 
 			It("returns an error with the actual status code", func() {
-				err := ccClient.StagingComplete(stagingGuid, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(context.Background(), stagingGuid, []byte(`{}`), logger)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(BeAssignableToTypeOf(&cc_client.BadResponseError{}))
 				Expect(err.(*cc_client.BadResponseError).StatusCode).To(Equal(500))
@@ -0,0 +1,63 @@
+package cc_client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var ErrCredentialsIncomplete = errors.New("CC target credentials need a username and password, or a UAA URL, client id and client secret")
+
+// TargetCredentials authenticate the stager with one CC target, either with
+// basic auth or, when UAAURL is set, with a UAA client.
+type TargetCredentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	UAAURL       string `json:"uaa_url,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func (c TargetCredentials) UsesUAA() bool {
+	return c.UAAURL != ""
+}
+
+func (c TargetCredentials) Validate() error {
+	if c.UsesUAA() {
+		if c.ClientID == "" || c.ClientSecret == "" {
+			return ErrCredentialsIncomplete
+		}
+		return nil
+	}
+
+	if c.Username == "" || c.Password == "" {
+		return ErrCredentialsIncomplete
+	}
+	return nil
+}
+
+// LoadTargetCredentials reads a JSON object mapping CC target names to their
+// TargetCredentials, so that credentials need not be given on the command
+// line.
+func LoadTargetCredentials(path string) (map[string]TargetCredentials, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := map[string]TargetCredentials{}
+	err = json.Unmarshal(contents, &credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, targetCredentials := range credentials {
+		if err := targetCredentials.Validate(); err != nil {
+			return nil, fmt.Errorf("CC target %q: %s", name, err)
+		}
+	}
+
+	return credentials, nil
+}
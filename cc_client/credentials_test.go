@@ -0,0 +1,58 @@
+package cc_client_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadTargetCredentials", func() {
+	var credentialsFile string
+
+	writeCredentials := func(contents string) {
+		file, err := ioutil.TempFile("", "cc-target-credentials")
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+
+		_, err = file.WriteString(contents)
+		Expect(err).NotTo(HaveOccurred())
+		credentialsFile = file.Name()
+	}
+
+	AfterEach(func() {
+		os.Remove(credentialsFile)
+	})
+
+	It("loads basic auth and UAA credentials by target", func() {
+		writeCredentials(`{
+			"cc-a": {"username": "user", "password": "pass"},
+			"cc-b": {"uaa_url": "https://uaa.b.example.com", "client_id": "stager", "client_secret": "secret"}
+		}`)
+
+		credentials, err := cc_client.LoadTargetCredentials(credentialsFile)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(credentials["cc-a"].UsesUAA()).To(BeFalse())
+		Expect(credentials["cc-a"].Username).To(Equal("user"))
+
+		Expect(credentials["cc-b"].UsesUAA()).To(BeTrue())
+		Expect(credentials["cc-b"].ClientSecret).To(Equal("secret"))
+	})
+
+	It("rejects incomplete credentials", func() {
+		writeCredentials(`{"cc-a": {"uaa_url": "https://uaa.a.example.com", "client_id": "stager"}}`)
+
+		_, err := cc_client.LoadTargetCredentials(credentialsFile)
+		Expect(err).To(MatchError(ContainSubstring(cc_client.ErrCredentialsIncomplete.Error())))
+	})
+
+	It("rejects files that aren't JSON", func() {
+		writeCredentials(`cc-a: user:pass`)
+
+		_, err := cc_client.LoadTargetCredentials(credentialsFile)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,65 @@
+package cc_client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	requiredEndpointPrefix = "required:"
+	optionalEndpointPrefix = "optional:"
+)
+
+var ErrEndpointFormatInvalid = errors.New("endpoint must be of the form [required:|optional:]path, with a %s placeholder for the staging guid")
+
+// StagingCompleteEndpoint is a CC path that staging responses are delivered
+// to. Failures to deliver to an optional endpoint are logged but do not fail
+// the callback, which allows publishing to a new endpoint while CC migrates.
+type StagingCompleteEndpoint struct {
+	Path     string
+	Required bool
+}
+
+var DefaultStagingCompleteEndpoints = StagingCompleteEndpoints{
+	{Path: "/internal/staging/%s/completed", Required: true},
+}
+
+func (e StagingCompleteEndpoint) URI(baseURI, stagingGuid string) string {
+	return baseURI + fmt.Sprintf(e.Path, stagingGuid)
+}
+
+// StagingCompleteEndpoints implements flag.Value so that endpoints can be
+// configured by repeating a command line flag.
+type StagingCompleteEndpoints []StagingCompleteEndpoint
+
+func (e *StagingCompleteEndpoints) String() string {
+	paths := make([]string, 0, len(*e))
+	for _, endpoint := range *e {
+		prefix := optionalEndpointPrefix
+		if endpoint.Required {
+			prefix = requiredEndpointPrefix
+		}
+		paths = append(paths, prefix+endpoint.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+func (e *StagingCompleteEndpoints) Set(value string) error {
+	endpoint := StagingCompleteEndpoint{Path: value, Required: true}
+
+	switch {
+	case strings.HasPrefix(value, requiredEndpointPrefix):
+		endpoint.Path = strings.TrimPrefix(value, requiredEndpointPrefix)
+	case strings.HasPrefix(value, optionalEndpointPrefix):
+		endpoint.Path = strings.TrimPrefix(value, optionalEndpointPrefix)
+		endpoint.Required = false
+	}
+
+	if !strings.HasPrefix(endpoint.Path, "/") || strings.Count(endpoint.Path, "%s") != 1 {
+		return ErrEndpointFormatInvalid
+	}
+
+	*e = append(*e, endpoint)
+	return nil
+}
@@ -0,0 +1,45 @@
+package cc_client_test
+
+import (
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingCompleteEndpoints", func() {
+	var endpoints cc_client.StagingCompleteEndpoints
+
+	BeforeEach(func() {
+		endpoints = cc_client.StagingCompleteEndpoints{}
+	})
+
+	It("treats endpoints without a prefix as required", func() {
+		Expect(endpoints.Set("/internal/staging/%s/completed")).To(Succeed())
+		Expect(endpoints).To(Equal(cc_client.StagingCompleteEndpoints{
+			{Path: "/internal/staging/%s/completed", Required: true},
+		}))
+	})
+
+	It("parses required and optional prefixes", func() {
+		Expect(endpoints.Set("required:/a/%s")).To(Succeed())
+		Expect(endpoints.Set("optional:/b/%s")).To(Succeed())
+		Expect(endpoints).To(Equal(cc_client.StagingCompleteEndpoints{
+			{Path: "/a/%s", Required: true},
+			{Path: "/b/%s", Required: false},
+		}))
+		Expect(endpoints.String()).To(Equal("required:/a/%s,optional:/b/%s"))
+	})
+
+	It("rejects paths without a staging guid placeholder", func() {
+		Expect(endpoints.Set("/internal/staging/completed")).To(Equal(cc_client.ErrEndpointFormatInvalid))
+	})
+
+	It("rejects relative paths", func() {
+		Expect(endpoints.Set("optional:internal/%s")).To(Equal(cc_client.ErrEndpointFormatInvalid))
+	})
+
+	It("builds the URI for a staging guid", func() {
+		endpoint := cc_client.StagingCompleteEndpoint{Path: "/internal/staging/%s/completed"}
+		Expect(endpoint.URI("http://cc.example.com", "the-guid")).To(Equal("http://cc.example.com/internal/staging/the-guid/completed"))
+	})
+})
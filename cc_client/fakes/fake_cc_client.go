@@ -6,12 +6,14 @@ import (
 
 	"github.com/cloudfoundry-incubator/stager/cc_client"
 	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
 )
 
 type FakeCcClient struct {
-	StagingCompleteStub        func(stagingGuid string, payload []byte, logger lager.Logger) error
+	StagingCompleteStub        func(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error
 	stagingCompleteMutex       sync.RWMutex
 	stagingCompleteArgsForCall []struct {
+		ctx         context.Context
 		stagingGuid string
 		payload     []byte
 		logger      lager.Logger
@@ -19,18 +21,30 @@ type FakeCcClient struct {
 	stagingCompleteReturns struct {
 		result1 error
 	}
+	BuildCompleteStub        func(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error
+	buildCompleteMutex       sync.RWMutex
+	buildCompleteArgsForCall []struct {
+		ctx       context.Context
+		buildGuid string
+		payload   []byte
+		logger    lager.Logger
+	}
+	buildCompleteReturns struct {
+		result1 error
+	}
 }
 
-func (fake *FakeCcClient) StagingComplete(stagingGuid string, payload []byte, logger lager.Logger) error {
+func (fake *FakeCcClient) StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error {
 	fake.stagingCompleteMutex.Lock()
 	fake.stagingCompleteArgsForCall = append(fake.stagingCompleteArgsForCall, struct {
+		ctx         context.Context
 		stagingGuid string
 		payload     []byte
 		logger      lager.Logger
-	}{stagingGuid, payload, logger})
+	}{ctx, stagingGuid, payload, logger})
 	fake.stagingCompleteMutex.Unlock()
 	if fake.StagingCompleteStub != nil {
-		return fake.StagingCompleteStub(stagingGuid, payload, logger)
+		return fake.StagingCompleteStub(ctx, stagingGuid, payload, logger)
 	} else {
 		return fake.stagingCompleteReturns.result1
 	}
@@ -42,10 +56,10 @@ func (fake *FakeCcClient) StagingCompleteCallCount() int {
 	return len(fake.stagingCompleteArgsForCall)
 }
 
-func (fake *FakeCcClient) StagingCompleteArgsForCall(i int) (string, []byte, lager.Logger) {
+func (fake *FakeCcClient) StagingCompleteArgsForCall(i int) (context.Context, string, []byte, lager.Logger) {
 	fake.stagingCompleteMutex.RLock()
 	defer fake.stagingCompleteMutex.RUnlock()
-	return fake.stagingCompleteArgsForCall[i].stagingGuid, fake.stagingCompleteArgsForCall[i].payload, fake.stagingCompleteArgsForCall[i].logger
+	return fake.stagingCompleteArgsForCall[i].ctx, fake.stagingCompleteArgsForCall[i].stagingGuid, fake.stagingCompleteArgsForCall[i].payload, fake.stagingCompleteArgsForCall[i].logger
 }
 
 func (fake *FakeCcClient) StagingCompleteReturns(result1 error) {
@@ -55,4 +69,39 @@ func (fake *FakeCcClient) StagingCompleteReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakeCcClient) BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error {
+	fake.buildCompleteMutex.Lock()
+	fake.buildCompleteArgsForCall = append(fake.buildCompleteArgsForCall, struct {
+		ctx       context.Context
+		buildGuid string
+		payload   []byte
+		logger    lager.Logger
+	}{ctx, buildGuid, payload, logger})
+	fake.buildCompleteMutex.Unlock()
+	if fake.BuildCompleteStub != nil {
+		return fake.BuildCompleteStub(ctx, buildGuid, payload, logger)
+	} else {
+		return fake.buildCompleteReturns.result1
+	}
+}
+
+func (fake *FakeCcClient) BuildCompleteCallCount() int {
+	fake.buildCompleteMutex.RLock()
+	defer fake.buildCompleteMutex.RUnlock()
+	return len(fake.buildCompleteArgsForCall)
+}
+
+func (fake *FakeCcClient) BuildCompleteArgsForCall(i int) (context.Context, string, []byte, lager.Logger) {
+	fake.buildCompleteMutex.RLock()
+	defer fake.buildCompleteMutex.RUnlock()
+	return fake.buildCompleteArgsForCall[i].ctx, fake.buildCompleteArgsForCall[i].buildGuid, fake.buildCompleteArgsForCall[i].payload, fake.buildCompleteArgsForCall[i].logger
+}
+
+func (fake *FakeCcClient) BuildCompleteReturns(result1 error) {
+	fake.BuildCompleteStub = nil
+	fake.buildCompleteReturns = struct {
+		result1 error
+	}{result1}
+}
+
 var _ cc_client.CcClient = new(FakeCcClient)
@@ -0,0 +1,55 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+)
+
+type FakeSigner struct {
+	SignStub        func(payload []byte) (string, error)
+	signMutex       sync.RWMutex
+	signArgsForCall []struct {
+		payload []byte
+	}
+	signReturns struct {
+		result1 string
+		result2 error
+	}
+}
+
+func (fake *FakeSigner) Sign(payload []byte) (string, error) {
+	fake.signMutex.Lock()
+	fake.signArgsForCall = append(fake.signArgsForCall, struct {
+		payload []byte
+	}{payload})
+	fake.signMutex.Unlock()
+	if fake.SignStub != nil {
+		return fake.SignStub(payload)
+	} else {
+		return fake.signReturns.result1, fake.signReturns.result2
+	}
+}
+
+func (fake *FakeSigner) SignCallCount() int {
+	fake.signMutex.RLock()
+	defer fake.signMutex.RUnlock()
+	return len(fake.signArgsForCall)
+}
+
+func (fake *FakeSigner) SignArgsForCall(i int) []byte {
+	fake.signMutex.RLock()
+	defer fake.signMutex.RUnlock()
+	return fake.signArgsForCall[i].payload
+}
+
+func (fake *FakeSigner) SignReturns(result1 string, result2 error) {
+	fake.SignStub = nil
+	fake.signReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+var _ cc_client.Signer = new(FakeSigner)
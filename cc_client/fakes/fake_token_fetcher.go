@@ -0,0 +1,74 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"golang.org/x/net/context"
+)
+
+type FakeTokenFetcher struct {
+	TokenStub        func(ctx context.Context) (string, error)
+	tokenMutex       sync.RWMutex
+	tokenArgsForCall []struct {
+		ctx context.Context
+	}
+	tokenReturns struct {
+		result1 string
+		result2 error
+	}
+	InvalidateStub        func()
+	invalidateMutex       sync.RWMutex
+	invalidateArgsForCall []struct{}
+}
+
+func (fake *FakeTokenFetcher) Token(ctx context.Context) (string, error) {
+	fake.tokenMutex.Lock()
+	fake.tokenArgsForCall = append(fake.tokenArgsForCall, struct {
+		ctx context.Context
+	}{ctx})
+	fake.tokenMutex.Unlock()
+	if fake.TokenStub != nil {
+		return fake.TokenStub(ctx)
+	} else {
+		return fake.tokenReturns.result1, fake.tokenReturns.result2
+	}
+}
+
+func (fake *FakeTokenFetcher) TokenCallCount() int {
+	fake.tokenMutex.RLock()
+	defer fake.tokenMutex.RUnlock()
+	return len(fake.tokenArgsForCall)
+}
+
+func (fake *FakeTokenFetcher) TokenArgsForCall(i int) context.Context {
+	fake.tokenMutex.RLock()
+	defer fake.tokenMutex.RUnlock()
+	return fake.tokenArgsForCall[i].ctx
+}
+
+func (fake *FakeTokenFetcher) TokenReturns(result1 string, result2 error) {
+	fake.TokenStub = nil
+	fake.tokenReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeTokenFetcher) Invalidate() {
+	fake.invalidateMutex.Lock()
+	fake.invalidateArgsForCall = append(fake.invalidateArgsForCall, struct{}{})
+	fake.invalidateMutex.Unlock()
+	if fake.InvalidateStub != nil {
+		fake.InvalidateStub()
+	}
+}
+
+func (fake *FakeTokenFetcher) InvalidateCallCount() int {
+	fake.invalidateMutex.RLock()
+	defer fake.invalidateMutex.RUnlock()
+	return len(fake.invalidateArgsForCall)
+}
+
+var _ cc_client.TokenFetcher = new(FakeTokenFetcher)
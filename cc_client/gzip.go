@@ -0,0 +1,33 @@
+package cc_client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync/atomic"
+)
+
+// compresses reports whether payload is sent gzip-compressed: it must be at
+// least the configured threshold, and the CC must not have rejected a
+// compressed payload before.
+func (cc *ccClient) compresses(payload []byte) bool {
+	return cc.gzipThreshold > 0 &&
+		len(payload) >= cc.gzipThreshold &&
+		atomic.LoadInt32(&cc.gzipUnsupported) == 0
+}
+
+func gzipPayload(payload []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+package cc_client
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+)
+
+// requestMetrics are emitted for every request made to one CC endpoint, so
+// that CC health can be told apart from staging throughput. Latency is sent
+// per request; percentiles are computed by the metrics pipeline, as for the
+// staging durations.
+type requestMetrics struct {
+	requests metric.Counter
+	errors   metric.Counter
+	latency  metric.Duration
+}
+
+// requestMetricsFor names an endpoint's metrics after its path, e.g.
+// CCStagingCompletedRequests for /internal/staging/%s/completed.
+func requestMetricsFor(path string) requestMetrics {
+	name := "CC" + endpointMetricName(path)
+	return requestMetrics{
+		requests: metric.Counter(name + "Requests"),
+		errors:   metric.Counter(name + "RequestErrors"),
+		latency:  metric.Duration(name + "RequestTime"),
+	}
+}
+
+func (m requestMetrics) record(startTime time.Time, now time.Time, err error) {
+	m.requests.Increment()
+	m.latency.Send(now.Sub(startTime))
+	if err != nil {
+		m.errors.Increment()
+	}
+}
+
+func endpointMetricName(path string) string {
+	path = strings.Replace(path, "%s", "", -1)
+	path = strings.TrimPrefix(path, "/internal/")
+
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	name := ""
+	for _, word := range words {
+		name += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+package cc_client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"golang.org/x/net/context"
+)
+
+// rateLimiter spaces requests evenly so that no more than a fixed number are
+// made per second. Requests that arrive while the CC is idle go out
+// immediately; a burst is queued and released at the configured rate.
+type rateLimiter struct {
+	interval time.Duration
+	clock    clock.Clock
+
+	lock sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(requestsPerSecond int, clock clock.Clock) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		interval: time.Second / time.Duration(requestsPerSecond),
+		clock:    clock,
+	}
+}
+
+// Wait blocks until the caller may make a request, or until ctx ends. A nil
+// rateLimiter never blocks.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := l.clock.NewTimer(delay)
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) reserve() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return delay
+}
@@ -0,0 +1,88 @@
+package cc_client
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are replaced wholesale when logging requests and
+// responses.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// sensitiveKeys are JSON object keys, compared case-insensitively, whose
+// values are replaced when logging payloads. VCAP_SERVICES holds service
+// credentials; the rest are credentials in their own right.
+var sensitiveKeys = map[string]bool{
+	"password":        true,
+	"docker_password": true,
+	"client_secret":   true,
+	"access_token":    true,
+	"refresh_token":   true,
+	"authorization":   true,
+	"vcap_services":   true,
+}
+
+// RedactHeaders returns a copy of headers that is safe to log.
+func RedactHeaders(headers http.Header) http.Header {
+	safe := make(http.Header, len(headers))
+	for name, values := range headers {
+		safe[name] = values
+	}
+
+	for _, name := range sensitiveHeaders {
+		if _, ok := safe[name]; ok {
+			safe[name] = []string{redacted}
+		}
+	}
+	return safe
+}
+
+// RedactPayload returns a JSON payload as a string that is safe to log, with
+// the values of sensitive keys and of VCAP_SERVICES environment variables
+// (given as {"name": ..., "value": ...}) replaced. Payloads that aren't JSON
+// can't be inspected, so they are replaced entirely.
+func RedactPayload(payload []byte) string {
+	if len(payload) == 0 {
+		return ""
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return redacted
+	}
+
+	safe, err := json.Marshal(redactValue(decoded))
+	if err != nil {
+		return redacted
+	}
+	return string(safe)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if name, ok := value["name"].(string); ok && sensitiveKeys[strings.ToLower(name)] {
+			if _, ok := value["value"]; ok {
+				value["value"] = redacted
+			}
+		}
+
+		for key, v := range value {
+			if sensitiveKeys[strings.ToLower(key)] {
+				value[key] = redacted
+				continue
+			}
+			value[key] = redactValue(v)
+		}
+		return value
+	case []interface{}:
+		for i, v := range value {
+			value[i] = redactValue(v)
+		}
+		return value
+	}
+	return value
+}
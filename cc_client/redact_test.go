@@ -0,0 +1,41 @@
+package cc_client_test
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redaction", func() {
+	Describe("RedactHeaders", func() {
+		It("redacts credentials without modifying the original headers", func() {
+			headers := http.Header{
+				"Authorization": {"bearer some-token"},
+				"Content-Type":  {"application/json"},
+			}
+
+			safe := cc_client.RedactHeaders(headers)
+			Expect(safe.Get("Authorization")).To(Equal("[REDACTED]"))
+			Expect(safe.Get("Content-Type")).To(Equal("application/json"))
+			Expect(headers.Get("Authorization")).To(Equal("bearer some-token"))
+		})
+	})
+
+	Describe("RedactPayload", func() {
+		It("redacts sensitive keys at any depth", func() {
+			payload := []byte(`{"lifecycle_data": {"docker_user": "user", "docker_password": "secret"}}`)
+			Expect(cc_client.RedactPayload(payload)).To(MatchJSON(`{"lifecycle_data": {"docker_user": "user", "docker_password": "[REDACTED]"}}`))
+		})
+
+		It("redacts VCAP_SERVICES environment variables", func() {
+			payload := []byte(`{"environment": [{"name": "VCAP_SERVICES", "value": "{\"db\": []}"}, {"name": "FOO", "value": "bar"}]}`)
+			Expect(cc_client.RedactPayload(payload)).To(MatchJSON(`{"environment": [{"name": "VCAP_SERVICES", "value": "[REDACTED]"}, {"name": "FOO", "value": "bar"}]}`))
+		})
+
+		It("redacts payloads that aren't JSON", func() {
+			Expect(cc_client.RedactPayload([]byte("password=secret"))).To(Equal("[REDACTED]"))
+		})
+	})
+})
@@ -0,0 +1,112 @@
+package cc_client
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"golang.org/x/net/context"
+)
+
+// RetryPolicy controls how failed staging-complete posts are retried. The
+// zero value makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// backoff returns how long to wait before the given retry, counting from 1.
+// The delay doubles with each retry up to MaxBackoff, and is jittered down by
+// up to half so that stagers don't retry in lockstep.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+// IsRetryable reports whether a staging-complete post that failed with err
+// may succeed if posted again: connection errors and 5xx responses are
+// retryable, anything else is permanent. Errors from an ended context are
+// permanent, since the caller has stopped waiting.
+func IsRetryable(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	if err == ErrCircuitOpen {
+		return true
+	}
+
+	switch err := err.(type) {
+	case *BadResponseError:
+		return err.Retryable()
+	case *TokenFetchError:
+		return err.StatusCode >= http.StatusInternalServerError
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// pendingRetries counts the posts, across every CC client, waiting out a
+// backoff before being retried.
+var pendingRetries int64
+
+// PendingRetries returns how many staging responses are waiting to be
+// redelivered to the CC.
+func PendingRetries() int {
+	return int(atomic.LoadInt64(&pendingRetries))
+}
+
+// withRetries calls post until it succeeds, fails permanently, the policy's
+// attempts are used up or ctx ends, and returns the last error.
+func withRetries(ctx context.Context, policy RetryPolicy, clock clock.Clock, post func() error) error {
+	err := post()
+	for attempt := 1; err != nil && attempt < policy.MaxAttempts && IsRetryable(err); attempt++ {
+		if !waitToRetry(ctx, clock, policy.backoff(attempt)) {
+			return err
+		}
+
+		err = post()
+	}
+	return err
+}
+
+// waitToRetry waits for backoff, returning false if ctx ends first.
+func waitToRetry(ctx context.Context, clock clock.Clock, backoff time.Duration) bool {
+	atomic.AddInt64(&pendingRetries, 1)
+	defer atomic.AddInt64(&pendingRetries, -1)
+
+	timer := clock.NewTimer(backoff)
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	}
+}
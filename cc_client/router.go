@@ -0,0 +1,118 @@
+package cc_client
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+var (
+	ErrUnknownTarget       = errors.New("unknown CC target")
+	ErrTargetFormatInvalid = errors.New("CC target must be of the form name=url")
+)
+
+type targetKey struct{}
+
+// WithTarget returns a context that routes staging responses delivered with
+// it to the named CC target. An empty name routes to the default CC.
+func WithTarget(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, targetKey{}, name)
+}
+
+func targetFrom(ctx context.Context) string {
+	name, _ := ctx.Value(targetKey{}).(string)
+	return name
+}
+
+// Router is a CcClient that delivers each staging response to the CC target
+// named in its context (see WithTarget), so that one stager can serve
+// several foundations.
+type Router interface {
+	CcClient
+	HasTarget(name string) bool
+}
+
+type router struct {
+	defaultClient CcClient
+	targets       map[string]CcClient
+}
+
+func NewRouter(defaultClient CcClient, targets map[string]CcClient) Router {
+	return &router{
+		defaultClient: defaultClient,
+		targets:       targets,
+	}
+}
+
+func (r *router) HasTarget(name string) bool {
+	_, ok := r.targets[name]
+	return ok
+}
+
+func (r *router) StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error {
+	client, err := r.clientFor(ctx)
+	if err != nil {
+		return err
+	}
+	return client.StagingComplete(ctx, stagingGuid, payload, logger)
+}
+
+func (r *router) BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error {
+	client, err := r.clientFor(ctx)
+	if err != nil {
+		return err
+	}
+	return client.BuildComplete(ctx, buildGuid, payload, logger)
+}
+
+func (r *router) clientFor(ctx context.Context) (CcClient, error) {
+	name := targetFrom(ctx)
+	if name == "" {
+		return r.defaultClient, nil
+	}
+
+	client, ok := r.targets[name]
+	if !ok {
+		return nil, ErrUnknownTarget
+	}
+	return client, nil
+}
+
+// Targets implements flag.Value so that CC targets can be configured by
+// repeating a command line flag. Credentials for a target's internal API are
+// given as the URL's user info.
+type Targets map[string]*url.URL
+
+func (t *Targets) String() string {
+	targets := make([]string, 0, len(*t))
+	for name, target := range *t {
+		withoutUser := *target
+		withoutUser.User = nil
+		targets = append(targets, name+"="+withoutUser.String())
+	}
+	sort.Strings(targets)
+	return strings.Join(targets, ",")
+}
+
+func (t *Targets) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return ErrTargetFormatInvalid
+	}
+
+	target, err := url.ParseRequestURI(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid CC target URL: %s", err)
+	}
+
+	if *t == nil {
+		*t = Targets{}
+	}
+	(*t)[parts[0]] = target
+	return nil
+}
@@ -0,0 +1,151 @@
+package cc_client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+)
+
+// SignatureHeader carries the detached JWS of a staging response's body.
+const SignatureHeader = "X-Stager-Signature"
+
+var (
+	ErrNoPrivateKey          = errors.New("signing key file contains no PEM private key")
+	ErrUnsupportedSigningKey = errors.New("signing key must be an RSA or P-256 ECDSA private key")
+)
+
+//go:generate counterfeiter -o fakes/fake_signer.go . Signer
+
+// Signer signs staging response payloads so that the CC, or anything
+// downstream of it, can verify that a response came from an authorized
+// stager.
+type Signer interface {
+	// Sign returns a JWS in compact serialization with a detached payload
+	// (RFC 7515, appendix F): the payload section is empty, and verifiers
+	// use the request body in its place.
+	Sign(payload []byte) (string, error)
+}
+
+type jwsHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+type jwsSigner struct {
+	header  string
+	key     crypto.Signer
+	encoder func(signature []byte) ([]byte, error)
+}
+
+// NewJWSSigner signs with the PEM private key in keyFile: RS256 for RSA keys,
+// ES256 for P-256 ECDSA keys. keyID, when given, is sent as the JWS "kid" so
+// that verifiers can pick the matching public key.
+func NewJWSSigner(keyFile, keyID string) (Signer, error) {
+	contents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, ErrNoPrivateKey
+	}
+
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &jwsSigner{key: key}
+	header := jwsHeader{KeyID: keyID}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		header.Algorithm = "RS256"
+		signer.encoder = func(signature []byte) ([]byte, error) { return signature, nil }
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedSigningKey
+		}
+		header.Algorithm = "ES256"
+		signer.encoder = encodeES256
+	default:
+		return nil, ErrUnsupportedSigningKey
+	}
+
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	signer.header = base64.RawURLEncoding.EncodeToString(encodedHeader)
+
+	return signer, nil
+}
+
+func (s *jwsSigner) Sign(payload []byte) (string, error) {
+	signingInput := s.header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err = s.encoder(signature)
+	if err != nil {
+		return "", err
+	}
+
+	return s.header + ".." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, ErrUnsupportedSigningKey
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedSigningKey
+	}
+	return signer, nil
+}
+
+// encodeES256 converts an ASN.1 ECDSA signature into the fixed-width r || s
+// form that JWS uses.
+func encodeES256(signature []byte) ([]byte, error) {
+	var parsed struct {
+		R, S *big.Int
+	}
+	_, err := asn1.Unmarshal(signature, &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]byte, 64)
+	rBytes := parsed.R.Bytes()
+	sBytes := parsed.S.Bytes()
+	copy(encoded[32-len(rBytes):32], rBytes)
+	copy(encoded[64-len(sBytes):], sBytes)
+	return encoded, nil
+}
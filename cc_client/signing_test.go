@@ -0,0 +1,123 @@
+package cc_client_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JWS Signer", func() {
+	var (
+		tmpDir  string
+		keyFile string
+		payload []byte
+	)
+
+	writeKey := func(blockType string, der []byte) {
+		keyFile = filepath.Join(tmpDir, "signing.key")
+		Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)).To(Succeed())
+	}
+
+	splitJWS := func(jws string) (map[string]string, []byte, []byte) {
+		parts := strings.Split(jws, ".")
+		Expect(parts).To(HaveLen(3))
+		Expect(parts[1]).To(BeEmpty())
+
+		headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+		Expect(err).NotTo(HaveOccurred())
+
+		var header map[string]string
+		Expect(json.Unmarshal(headerJSON, &header)).To(Succeed())
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		Expect(err).NotTo(HaveOccurred())
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload)))
+		return header, digest[:], signature
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "cc-client-signing")
+		Expect(err).NotTo(HaveOccurred())
+
+		payload = []byte(`{"execution_metadata": "metadata"}`)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("signs with RS256 for RSA keys", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).NotTo(HaveOccurred())
+		writeKey("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+		signer, err := cc_client.NewJWSSigner(keyFile, "key-1")
+		Expect(err).NotTo(HaveOccurred())
+
+		jws, err := signer.Sign(payload)
+		Expect(err).NotTo(HaveOccurred())
+
+		header, digest, signature := splitJWS(jws)
+		Expect(header).To(Equal(map[string]string{"alg": "RS256", "kid": "key-1"}))
+		Expect(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, signature)).To(Succeed())
+	})
+
+	It("signs with ES256 for P-256 keys", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		der, err := x509.MarshalECPrivateKey(key)
+		Expect(err).NotTo(HaveOccurred())
+		writeKey("EC PRIVATE KEY", der)
+
+		signer, err := cc_client.NewJWSSigner(keyFile, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		jws, err := signer.Sign(payload)
+		Expect(err).NotTo(HaveOccurred())
+
+		header, digest, signature := splitJWS(jws)
+		Expect(header).To(Equal(map[string]string{"alg": "ES256"}))
+		Expect(signature).To(HaveLen(64))
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		Expect(ecdsa.Verify(&key.PublicKey, digest, r, s)).To(BeTrue())
+	})
+
+	It("rejects other curves", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		der, err := x509.MarshalECPrivateKey(key)
+		Expect(err).NotTo(HaveOccurred())
+		writeKey("EC PRIVATE KEY", der)
+
+		_, err = cc_client.NewJWSSigner(keyFile, "")
+		Expect(err).To(Equal(cc_client.ErrUnsupportedSigningKey))
+	})
+
+	It("rejects files without a PEM key", func() {
+		keyFile = filepath.Join(tmpDir, "signing.key")
+		Expect(ioutil.WriteFile(keyFile, []byte("not a key"), 0600)).To(Succeed())
+
+		_, err := cc_client.NewJWSSigner(keyFile, "")
+		Expect(err).To(Equal(cc_client.ErrNoPrivateKey))
+	})
+})
@@ -0,0 +1,57 @@
+package cc_client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+var (
+	ErrIncompleteClientCertificate = errors.New("client certificate and key must be given together")
+	ErrNoCACertificates            = errors.New("CA certificate file contains no PEM certificates")
+)
+
+// NewTLSConfig builds the TLS configuration for connections to the CC and
+// UAA. When certFile and keyFile are given, the stager presents them as its
+// client certificate. When caCertFiles are given, server certificates are
+// verified against the certificates they hold instead of the system roots;
+// empty paths are ignored.
+func NewTLSConfig(certFile, keyFile string, caCertFiles []string, skipCertVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: skipCertVerify,
+		MinVersion:         tls.VersionTLS10,
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, ErrIncompleteClientCertificate
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	for _, caCertFile := range caCertFiles {
+		if caCertFile == "" {
+			continue
+		}
+
+		caCerts, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.RootCAs == nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, ErrNoCACertificates
+		}
+	}
+
+	return config, nil
+}
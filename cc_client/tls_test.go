@@ -0,0 +1,148 @@
+package cc_client_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("TLS", func() {
+	var (
+		tmpDir string
+
+		certFile, keyFile, caCertFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "cc-client-tls")
+		Expect(err).NotTo(HaveOccurred())
+
+		certFile, keyFile = writeClientCertificate(tmpDir)
+		caCertFile = filepath.Join(tmpDir, "ca.crt")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("NewTLSConfig", func() {
+		It("loads the client certificate", func() {
+			config, err := cc_client.NewTLSConfig(certFile, keyFile, nil, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Certificates).To(HaveLen(1))
+			Expect(config.RootCAs).To(BeNil())
+		})
+
+		It("trusts the CA certificates", func() {
+			Expect(ioutil.WriteFile(caCertFile, readFile(certFile), 0644)).To(Succeed())
+
+			config, err := cc_client.NewTLSConfig("", "", []string{caCertFile}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.RootCAs.Subjects()).To(HaveLen(1))
+		})
+
+		It("fails when only one of the certificate and key is given", func() {
+			_, err := cc_client.NewTLSConfig(certFile, "", nil, false)
+			Expect(err).To(Equal(cc_client.ErrIncompleteClientCertificate))
+		})
+
+		It("fails when the CA file holds no certificates", func() {
+			Expect(ioutil.WriteFile(caCertFile, []byte("garbage"), 0644)).To(Succeed())
+
+			_, err := cc_client.NewTLSConfig("", "", []string{caCertFile}, false)
+			Expect(err).To(Equal(cc_client.ErrNoCACertificates))
+		})
+	})
+
+	Describe("connecting to a CC that requires client certificates", func() {
+		var (
+			fakeCC    *ghttp.Server
+			tlsServer *httptest.Server
+		)
+
+		BeforeEach(func() {
+			fakeCC = ghttp.NewServer()
+			tlsServer = httptest.NewUnstartedServer(fakeCC)
+			tlsServer.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+			tlsServer.StartTLS()
+
+			fakeCC.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/internal/staging/the-staging-guid/completed"),
+				func(w http.ResponseWriter, req *http.Request) {
+					Expect(req.TLS.PeerCertificates).To(HaveLen(1))
+					Expect(req.TLS.PeerCertificates[0].Subject.CommonName).To(Equal("stager"))
+				},
+				ghttp.RespondWith(200, `{}`),
+			))
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+			fakeCC.Close()
+		})
+
+		It("presents the client certificate", func() {
+			tlsConfig, err := cc_client.NewTLSConfig(certFile, keyFile, nil, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			ccClient := cc_client.NewCcClient(cc_client.Config{BaseURI: tlsServer.URL, TLSConfig: tlsConfig})
+			err = ccClient.StagingComplete(context.Background(), "the-staging-guid", []byte(`{}`), lagertest.NewTestLogger("test"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})
+
+func writeClientCertificate(dir string) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stager"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	Expect(ioutil.WriteFile(certFile, certPEM, 0644)).To(Succeed())
+	Expect(ioutil.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+
+	return certFile, keyFile
+}
+
+func readFile(path string) []byte {
+	contents, err := ioutil.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred(), fmt.Sprintf("reading %s", path))
+	return contents
+}
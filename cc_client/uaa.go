@@ -0,0 +1,227 @@
+package cc_client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	// tokenExpiryMargin is subtracted from a token's lifetime so that it is
+	// refreshed before the CC starts rejecting it.
+	tokenExpiryMargin = 30 * time.Second
+
+	// tokenRefreshRetryInterval is how long a background refresh waits
+	// after failing to fetch a token before trying again.
+	tokenRefreshRetryInterval = 5 * time.Second
+)
+
+//go:generate counterfeiter -o fakes/fake_token_fetcher.go . TokenFetcher
+
+// TokenFetcher supplies bearer tokens for requests to the CC.
+type TokenFetcher interface {
+	// Token returns a cached token, fetching a new one if none is cached or
+	// the cached one is about to expire.
+	Token(ctx context.Context) (string, error)
+
+	// Invalidate discards the cached token, e.g. after the CC rejected it.
+	Invalidate()
+}
+
+// UAATokenFetcher is a TokenFetcher that, when run as an ifrit process,
+// refreshes its token in the background before it expires, so that callbacks
+// don't wait on UAA.
+type UAATokenFetcher interface {
+	TokenFetcher
+	ifrit.Runner
+}
+
+type TokenFetchError struct {
+	StatusCode int
+}
+
+func (e *TokenFetchError) Error() string {
+	return fmt.Sprintf("UAA token request failed with %d", e.StatusCode)
+}
+
+type uaaToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type uaaTokenFetcher struct {
+	uaaURL       string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+	clock        clock.Clock
+
+	// fetchLock serializes fetches, so that concurrent callbacks finding no
+	// valid token wait for a single request to UAA.
+	fetchLock sync.Mutex
+
+	lock      sync.Mutex
+	token     string
+	expiresAt time.Time
+	refreshAt time.Time
+}
+
+// NewUAATokenFetcher fetches tokens from the UAA at uaaURL using the OAuth2
+// client credentials grant. tlsConfig and proxy may be nil to use the
+// defaults.
+func NewUAATokenFetcher(uaaURL, clientID, clientSecret string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error), clock clock.Clock) UAATokenFetcher {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	return &uaaTokenFetcher{
+		uaaURL:       strings.TrimSuffix(uaaURL, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		clock:        clock,
+		httpClient: &http.Client{
+			Timeout: DefaultRequestTimeout,
+			Transport: &http.Transport{
+				Proxy:           proxy,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+func (f *uaaTokenFetcher) Token(ctx context.Context) (string, error) {
+	if token, ok := f.cachedToken(); ok {
+		return token, nil
+	}
+
+	f.fetchLock.Lock()
+	defer f.fetchLock.Unlock()
+
+	// another caller may have fetched a token while we waited
+	if token, ok := f.cachedToken(); ok {
+		return token, nil
+	}
+
+	return f.refresh(ctx)
+}
+
+func (f *uaaTokenFetcher) Invalidate() {
+	f.lock.Lock()
+	f.token = ""
+	f.lock.Unlock()
+}
+
+// Run refreshes the token once three quarters of its lifetime has passed,
+// retrying every tokenRefreshRetryInterval while UAA is failing. Callbacks
+// that find no valid token still fetch one themselves.
+func (f *uaaTokenFetcher) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		if delay := f.untilRefresh(); delay > 0 {
+			timer := f.clock.NewTimer(delay)
+
+			select {
+			case <-signals:
+				timer.Stop()
+				return nil
+			case <-timer.C():
+			}
+		}
+
+		f.fetchLock.Lock()
+		_, err := f.refresh(context.Background())
+		f.fetchLock.Unlock()
+
+		// also back off from tokens too short-lived to refresh early
+		if err != nil || f.untilRefresh() == 0 {
+			f.lock.Lock()
+			f.refreshAt = f.clock.Now().Add(tokenRefreshRetryInterval)
+			f.lock.Unlock()
+		}
+	}
+}
+
+func (f *uaaTokenFetcher) cachedToken() (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.token != "" && f.clock.Now().Before(f.expiresAt) {
+		return f.token, true
+	}
+	return "", false
+}
+
+func (f *uaaTokenFetcher) untilRefresh() time.Duration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delay := f.refreshAt.Sub(f.clock.Now())
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// refresh fetches a new token and caches it. Callers must hold fetchLock.
+func (f *uaaTokenFetcher) refresh(ctx context.Context) (string, error) {
+	token, err := f.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	now := f.clock.Now()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.token = token.AccessToken
+	f.expiresAt = now.Add(lifetime - tokenExpiryMargin)
+	f.refreshAt = now.Add(lifetime * 3 / 4)
+	if f.refreshAt.After(f.expiresAt) {
+		f.refreshAt = f.expiresAt
+	}
+	return f.token, nil
+}
+
+func (f *uaaTokenFetcher) fetch(ctx context.Context) (*uaaToken, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	request, err := http.NewRequest("POST", f.uaaURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	request.SetBasicAuth(f.clientID, f.clientSecret)
+	request.Header.Set("content-type", "application/x-www-form-urlencoded")
+	request.Header.Set("accept", "application/json")
+
+	response, err := ctxhttp.Do(ctx, f.httpClient, request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &TokenFetchError{response.StatusCode}
+	}
+
+	token := &uaaToken{}
+	err = json.NewDecoder(response.Body).Decode(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,137 @@
+package cc_client_test
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
+)
+
+var _ = Describe("UAA Token Fetcher", func() {
+	var (
+		fakeUAA   *ghttp.Server
+		fakeClock *fakeclock.FakeClock
+
+		tokenFetcher cc_client.UAATokenFetcher
+	)
+
+	BeforeEach(func() {
+		fakeUAA = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		tokenFetcher = cc_client.NewUAATokenFetcher(fakeUAA.URL(), "the-client", "the-secret", nil, nil, fakeClock)
+	})
+
+	AfterEach(func() {
+		fakeUAA.Close()
+	})
+
+	Context("when the UAA grants a token", func() {
+		BeforeEach(func() {
+			fakeUAA.RouteToHandler("POST", "/oauth/token", ghttp.CombineHandlers(
+				ghttp.VerifyBasicAuth("the-client", "the-secret"),
+				ghttp.VerifyContentType("application/x-www-form-urlencoded"),
+				func(w http.ResponseWriter, req *http.Request) {
+					Expect(req.ParseForm()).To(Succeed())
+					Expect(req.PostForm.Get("grant_type")).To(Equal("client_credentials"))
+				},
+				ghttp.RespondWith(200, `{"access_token": "the-token", "token_type": "bearer", "expires_in": 600}`),
+			))
+		})
+
+		It("returns the access token", func() {
+			token, err := tokenFetcher.Token(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(Equal("the-token"))
+		})
+
+		It("caches the token until shortly before it expires", func() {
+			_, err := tokenFetcher.Token(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeClock.Increment(500 * time.Second)
+			_, err = tokenFetcher.Token(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeUAA.ReceivedRequests()).To(HaveLen(1))
+
+			fakeClock.Increment(80 * time.Second)
+			_, err = tokenFetcher.Token(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeUAA.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("fetches a single token for concurrent callers", func() {
+			tokens := make(chan string, 5)
+			for i := 0; i < 5; i++ {
+				go func() {
+					token, _ := tokenFetcher.Token(context.Background())
+					tokens <- token
+				}()
+			}
+
+			for i := 0; i < 5; i++ {
+				Eventually(tokens).Should(Receive(Equal("the-token")))
+			}
+			Expect(fakeUAA.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		Context("when run in the background", func() {
+			var process ifrit.Process
+
+			BeforeEach(func() {
+				process = ifrit.Invoke(tokenFetcher)
+			})
+
+			AfterEach(func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive())
+			})
+
+			It("fetches a token straight away", func() {
+				Eventually(fakeUAA.ReceivedRequests).Should(HaveLen(1))
+
+				_, err := tokenFetcher.Token(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeUAA.ReceivedRequests()).To(HaveLen(1))
+			})
+
+			It("refreshes the token before it expires", func() {
+				Eventually(fakeUAA.ReceivedRequests).Should(HaveLen(1))
+
+				Eventually(func() []*http.Request {
+					fakeClock.Increment(450 * time.Second)
+					return fakeUAA.ReceivedRequests()
+				}).Should(HaveLen(2))
+			})
+		})
+
+		It("fetches a new token once invalidated", func() {
+			_, err := tokenFetcher.Token(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			tokenFetcher.Invalidate()
+
+			_, err = tokenFetcher.Token(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeUAA.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+
+	Context("when the UAA rejects the client", func() {
+		BeforeEach(func() {
+			fakeUAA.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(401, `{"error": "unauthorized"}`))
+		})
+
+		It("returns a TokenFetchError", func() {
+			_, err := tokenFetcher.Token(context.Background())
+			Expect(err).To(Equal(&cc_client.TokenFetchError{StatusCode: 401}))
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package cc_client
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// CC APIs that staging completion can be reported to.
+const (
+	APIVersionV2 = "v2"
+	APIVersionV3 = "v3"
+)
+
+var ErrUnknownAPIVersion = errors.New("CC API version must be one of: v2, v3")
+
+func ValidateAPIVersion(version string) error {
+	switch version {
+	case APIVersionV2, APIVersionV3:
+		return nil
+	}
+	return ErrUnknownAPIVersion
+}
+
+const (
+	BuildStateStaged = "STAGED"
+	BuildStateFailed = "FAILED"
+)
+
+var V3BuildCompletedEndpoints = StagingCompleteEndpoints{
+	{Path: "/internal/builds/%s/completed", Required: true},
+}
+
+// BuildCompletedForCC is the payload of the CC v3 build completion API. It
+// carries the same outcome as a v2 StagingResponseForCC, in the v3 shape.
+type BuildCompletedForCC struct {
+	State  string                    `json:"state"`
+	Error  *cc_messages.StagingError `json:"error,omitempty"`
+	Result *BuildResult              `json:"result,omitempty"`
+}
+
+type BuildResult struct {
+	ProcessTypes      map[string]string `json:"process_types"`
+	ExecutionMetadata string            `json:"execution_metadata"`
+	LifecycleType     string            `json:"lifecycle_type"`
+	LifecycleMetadata *json.RawMessage  `json:"lifecycle_metadata,omitempty"`
+}
+
+// NewBuildCompletedForCC converts a v2 staging response for the given
+// lifecycle into the v3 payload.
+func NewBuildCompletedForCC(lifecycle string, response cc_messages.StagingResponseForCC) BuildCompletedForCC {
+	if response.Error != nil {
+		return BuildCompletedForCC{State: BuildStateFailed, Error: response.Error}
+	}
+
+	return BuildCompletedForCC{
+		State: BuildStateStaged,
+		Result: &BuildResult{
+			ProcessTypes:      response.DetectedStartCommand,
+			ExecutionMetadata: response.ExecutionMetadata,
+			LifecycleType:     lifecycle,
+			LifecycleMetadata: response.LifecycleData,
+		},
+	}
+}
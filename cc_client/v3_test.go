@@ -0,0 +1,43 @@
+package cc_client_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewBuildCompletedForCC", func() {
+	It("reports a staged build with its result", func() {
+		lifecycleData := json.RawMessage(`{"buildpack_key": "the-key"}`)
+		build := cc_client.NewBuildCompletedForCC("buildpack", cc_messages.StagingResponseForCC{
+			ExecutionMetadata:    "metadata",
+			DetectedStartCommand: map[string]string{"web": "./start"},
+			LifecycleData:        &lifecycleData,
+		})
+
+		payload, err := json.Marshal(build)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payload).To(MatchJSON(`{
+			"state": "STAGED",
+			"result": {
+				"process_types": {"web": "./start"},
+				"execution_metadata": "metadata",
+				"lifecycle_type": "buildpack",
+				"lifecycle_metadata": {"buildpack_key": "the-key"}
+			}
+		}`))
+	})
+
+	It("reports a failed build with its error", func() {
+		build := cc_client.NewBuildCompletedForCC("buildpack", cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "boom"},
+		})
+
+		payload, err := json.Marshal(build)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(payload).To(MatchJSON(`{"state": "FAILED", "error": {"id": "StagingError", "message": "boom"}}`))
+	})
+})
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+var configFile = flag.String(
+	"configFile",
+	"",
+	"JSON or YAML file of settings keyed by flag name; flags given on the command line or in the environment override it",
+)
+
+// loadConfigFile sets the flags in flagSet that the config file at path
+// gives, other than those already set. Keys are flag
+// names. Lists set repeatable flags once per entry, and maps set them once
+// per key=value pair. YAML is a superset of JSON, so one parser reads both.
+func loadConfigFile(flagSet *flag.FlagSet, path string) error {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	setOnCommandLine := visitedFlags(flagSet)
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flagSet.Lookup(name) == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if setOnCommandLine[name] {
+			continue
+		}
+
+		err := setConfigFileFlag(flagSet, path, name, settings[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings map[string]interface{}
+	err = yaml.Unmarshal(contents, &settings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+	return settings, nil
+}
+
+// visitedFlags returns the names of the flags that have been set in flagSet.
+func visitedFlags(flagSet *flag.FlagSet) map[string]bool {
+	visited := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+	})
+	return visited
+}
+
+func setConfigFileFlag(flagSet *flag.FlagSet, path, name string, setting interface{}) error {
+	values, err := configFileValues(setting)
+	if err != nil {
+		return fmt.Errorf("config file %s: setting %q: %s", path, name, err)
+	}
+
+	for _, value := range values {
+		err := flagSet.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("config file %s: setting %q: %s", path, name, err)
+		}
+	}
+	return nil
+}
+
+// configFileValues returns the flag values of a setting.
+func configFileValues(setting interface{}) ([]string, error) {
+	switch setting := setting.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(setting))
+		for _, entry := range setting {
+			value, err := configFileValue(entry)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case map[interface{}]interface{}:
+		values := make([]string, 0, len(setting))
+		for key, entry := range setting {
+			value, err := configFileValue(entry)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, fmt.Sprintf("%v=%s", key, value))
+		}
+		sort.Strings(values)
+		return values, nil
+	default:
+		value, err := configFileValue(setting)
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+}
+
+func configFileValue(entry interface{}) (string, error) {
+	switch entry := entry.(type) {
+	case string:
+		return entry, nil
+	case bool:
+		return strconv.FormatBool(entry), nil
+	case int:
+		return strconv.Itoa(entry), nil
+	case float64:
+		return strconv.FormatFloat(entry, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", entry)
+	}
+}
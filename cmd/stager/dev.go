@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/stager/dev"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
+)
+
+const devCommand = "dev"
+
+var devLifecycleDir = flag.String(
+	"devLifecycleDir",
+	".",
+	"Directory of lifecycle bundles served by the embedded file server in dev mode",
+)
+
+var devCCAddress = flag.String(
+	"devCCAddress",
+	"127.0.0.1:8891",
+	"Address (host:port) of the embedded fake Cloud Controller in dev mode",
+)
+
+var devFileServerAddress = flag.String(
+	"devFileServerAddress",
+	"127.0.0.1:8892",
+	"Address (host:port) of the embedded file server in dev mode",
+)
+
+// initializeDevMode points the stager at an embedded fake CC, file server
+// and in-memory task runner so it can be run without a Diego deployment.
+func initializeDevMode(logger lager.Logger) (bbs.Client, grouper.Members) {
+	logger = logger.Session("dev")
+
+	if *stagerURL == "" {
+		*stagerURL = "http://127.0.0.1:8890"
+	}
+	if *dockerStagingStack == "" {
+		*dockerStagingStack = "cflinuxfs2"
+	}
+	*ccBaseURL = "http://" + *devCCAddress
+	*fileServerURL = "http://" + *devFileServerAddress
+
+	fileServer, err := dev.NewFileServer(*devLifecycleDir)
+	if err != nil {
+		logger.Fatal("Failed to create dev file server", err)
+	}
+
+	logger.Info("dev-mode", lager.Data{
+		"stager-url":      *stagerURL,
+		"cc-url":          *ccBaseURL,
+		"file-server-url": *fileServerURL,
+		"lifecycle-dir":   *devLifecycleDir,
+	})
+
+	return dev.NewTaskRunner(logger, clock.NewClock()), grouper.Members{
+		{"fake-cc", http_server.New(*devCCAddress, dev.NewFakeCC(logger))},
+		{"file-server", http_server.New(*devFileServerAddress, fileServer)},
+	}
+}
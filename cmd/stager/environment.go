@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// environmentPrefix starts the names of the environment variables that set
+// flags, e.g. STAGER_CC_BASE_URL for -ccBaseURL.
+const environmentPrefix = "STAGER_"
+
+// loadEnvironment sets the flags in flagSet, other than those already set on
+// the command line, that environ, in the format of os.Environ, has variables
+// for. Repeatable flags are set once per line of their variable's value.
+func loadEnvironment(flagSet *flag.FlagSet, environ []string) error {
+	variables := map[string]string{}
+	for _, variable := range environ {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], environmentPrefix) {
+			variables[parts[0]] = parts[1]
+		}
+	}
+
+	setOnCommandLine := visitedFlags(flagSet)
+
+	var err error
+	flagSet.VisitAll(func(f *flag.Flag) {
+		value, ok := variables[environmentVariableName(f.Name)]
+		if err != nil || !ok || setOnCommandLine[f.Name] {
+			return
+		}
+
+		for _, line := range strings.Split(value, "\n") {
+			if strings.Contains(value, "\n") && strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			setErr := flagSet.Set(f.Name, line)
+			if setErr != nil {
+				err = fmt.Errorf("environment variable %s: %s", environmentVariableName(f.Name), setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// environmentVariableName returns the variable for a flag: its name in upper
+// snake case, with the prefix. Runs of capitals, such as URL in ccBaseURL,
+// are kept together.
+func environmentVariableName(flagName string) string {
+	runes := []rune(flagName)
+	name := make([]rune, 0, len(runes)+8)
+	for i, r := range runes {
+		if r == '-' || r == '.' {
+			name = append(name, '_')
+			continue
+		}
+
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				name = append(name, '_')
+			}
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return environmentPrefix + string(name)
+}
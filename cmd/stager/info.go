@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/handlers"
+)
+
+// version and gitSHA are set when the stager is built, e.g. with
+// -ldflags "-X main.version=1.2.3 -X main.gitSHA=$(git rev-parse HEAD)".
+var (
+	version = "dev"
+	gitSHA  = ""
+)
+
+// buildInfo serves GET /v1/info. Its lifecycle bundles follow the
+// configuration as it is reloaded.
+type buildInfo struct {
+	lock             sync.RWMutex
+	lifecycles       []string
+	lifecycleBundles []string
+}
+
+func newBuildInfo(backends map[string]backend.Backend, config backend.Config) *buildInfo {
+	disabled := map[string]bool{}
+	for _, name := range config.DisabledLifecycles {
+		disabled[name] = true
+	}
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		if !disabled[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	info := &buildInfo{lifecycles: names}
+	info.setLifecycleBundles(config.Lifecycles)
+	return info
+}
+
+func (b *buildInfo) setLifecycleBundles(lifecycles map[string]string) {
+	bundles := make([]string, 0, len(lifecycles))
+	for key := range lifecycles {
+		bundles = append(bundles, key)
+	}
+	sort.Strings(bundles)
+
+	b.lock.Lock()
+	b.lifecycleBundles = bundles
+	b.lock.Unlock()
+}
+
+func (b *buildInfo) Info() handlers.Info {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return handlers.Info{
+		Version:               version,
+		GitSHA:                gitSHA,
+		Lifecycles:            b.lifecycles,
+		LifecycleBundles:      b.lifecycleBundles,
+		CompletionAPIVersions: []string{cc_client.APIVersionV2, cc_client.APIVersionV3},
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"log/syslog"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/gunk/diegonats"
 	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/ifrit"
@@ -16,13 +25,26 @@ import (
 	"github.com/tedsuo/ifrit/sigmon"
 
 	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/bbs/models"
 	"github.com/cloudfoundry-incubator/cf-debug-server"
 	cf_lager "github.com/cloudfoundry-incubator/cf-lager"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages/flags"
+	"github.com/cloudfoundry-incubator/stager/audit"
+	"github.com/cloudfoundry-incubator/stager/authz"
 	"github.com/cloudfoundry-incubator/stager/backend"
 	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/consul"
 	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry-incubator/stager/health"
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/logging"
+	"github.com/cloudfoundry-incubator/stager/nats_emitter"
+	"github.com/cloudfoundry-incubator/stager/proxy"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/cloudfoundry-incubator/stager/staging_logs"
+	"github.com/cloudfoundry-incubator/stager/state"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
 )
 
 var ccBaseURL = flag.String(
@@ -43,6 +65,72 @@ var ccPassword = flag.String(
 	"Basic auth password for CC internal API",
 )
 
+var ccTargetCredentialsFile = flag.String(
+	"ccTargetCredentialsFile",
+	"",
+	"JSON file mapping -ccTarget names to their credentials ({\"name\": {\"username\": ..., \"password\": ...}} or {\"name\": {\"uaa_url\": ..., \"client_id\": ..., \"client_secret\": ...}})",
+)
+
+var stagingResponseSigningKey = flag.String(
+	"stagingResponseSigningKey",
+	"",
+	"PEM file with the RSA or P-256 ECDSA private key to sign staging responses with; responses are unsigned when unset",
+)
+
+var stagingResponseSigningKeyID = flag.String(
+	"stagingResponseSigningKeyID",
+	"",
+	"Key id sent with staging response signatures so that verifiers can pick the matching public key",
+)
+
+var uaaURL = flag.String(
+	"uaaURL",
+	"",
+	"URL of the UAA; when set, CC requests are authenticated with a client credentials token instead of basic auth",
+)
+
+var uaaClientID = flag.String(
+	"uaaClientID",
+	"",
+	"UAA client used to authenticate with the CC",
+)
+
+var uaaClientSecret = flag.String(
+	"uaaClientSecret",
+	"",
+	"secret of the UAA client used to authenticate with the CC",
+)
+
+var ccClientCert = flag.String(
+	"ccClientCert",
+	"",
+	"PEM-encoded client certificate presented to the CC internal API",
+)
+
+var ccClientKey = flag.String(
+	"ccClientKey",
+	"",
+	"PEM-encoded key for the client certificate presented to the CC internal API",
+)
+
+var ccCACert = flag.String(
+	"ccCACert",
+	"",
+	"PEM-encoded CA certificates used, in addition to -caCertFile, to verify the CC internal API",
+)
+
+var caCertFile = flag.String(
+	"caCertFile",
+	"",
+	"PEM-encoded CA bundle used to verify the CC and UAA instead of the system roots",
+)
+
+var httpProxy = flag.String(
+	"httpProxy",
+	"",
+	"Proxy URL for requests to the CC, UAA and consul; when unset, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored",
+)
+
 var skipCertVerify = flag.Bool(
 	"skipCertVerify",
 	false,
@@ -55,6 +143,36 @@ var bbsAddress = flag.String(
 	"Address to the BBS Server",
 )
 
+var bbsCACert = flag.String(
+	"bbsCACert",
+	"",
+	"PEM-encoded CA certificates used to verify an HTTPS BBS",
+)
+
+var bbsClientCert = flag.String(
+	"bbsClientCert",
+	"",
+	"PEM-encoded client certificate presented to an HTTPS BBS",
+)
+
+var bbsClientKey = flag.String(
+	"bbsClientKey",
+	"",
+	"PEM-encoded key for the client certificate presented to an HTTPS BBS",
+)
+
+var bbsUsername = flag.String(
+	"bbsUsername",
+	"",
+	"Basic auth username for the BBS",
+)
+
+var bbsPassword = flag.String(
+	"bbsPassword",
+	"",
+	"Basic auth password for the BBS",
+)
+
 var stagerURL = flag.String(
 	"stagerURL",
 	"",
@@ -91,108 +209,1452 @@ var consulCluster = flag.String(
 	"Consul Agent URL",
 )
 
+var consulTimeout = flag.Duration(
+	"consulTimeout",
+	backend.DefaultConsulTimeout,
+	"Timeout for each docker registry discovery request to consul",
+)
+
+var consulRetryAttempts = flag.Int(
+	"consulRetryAttempts",
+	2,
+	"How many times a failed docker registry discovery request to consul is retried",
+)
+
+var dockerRegistryDiscovery = flag.String(
+	"dockerRegistryDiscovery",
+	backend.DockerRegistryDiscoveryConsul,
+	"How to find docker registry instances: consul (passing instances in the agent's health endpoint) or dns",
+)
+
+var dockerRegistryCacheTTL = flag.Duration(
+	"dockerRegistryCacheTTL",
+	30*time.Second,
+	"How long discovered docker registry instances are reused; 0 looks them up for every docker staging request",
+)
+
+var dockerRegistryCacheMaxStale = flag.Duration(
+	"dockerRegistryCacheMaxStale",
+	5*time.Minute,
+	"How long past -dockerRegistryCacheTTL docker registry instances are still used when looking them up fails",
+)
+
+var consulCACert = flag.String(
+	"consulCACert",
+	"",
+	"PEM-encoded CA certificates used to verify an HTTPS consul agent",
+)
+
+var consulClientCert = flag.String(
+	"consulClientCert",
+	"",
+	"PEM-encoded client certificate presented to the consul agent",
+)
+
+var consulClientKey = flag.String(
+	"consulClientKey",
+	"",
+	"PEM-encoded key for the client certificate presented to the consul agent",
+)
+
+var consulACLToken = flag.String(
+	"consulACLToken",
+	"",
+	"ACL token used to discover the docker registry in, and register the stager with, consul",
+)
+
+var logFormat = flag.String(
+	"logFormat",
+	logging.FormatJSON,
+	"Log format: json (lager's), rfc3339 (lager's JSON with RFC 3339 timestamps) or human",
+)
+
+var logFile = flag.String(
+	"logFile",
+	"",
+	"File that logs are appended to, as well as written to stdout",
+)
+
+var syslogAddress = flag.String(
+	"syslogAddress",
+	"",
+	"Syslog server that logs are sent to, as well as written to stdout: local, or udp://host:port or tcp://host:port",
+)
+
+var disabledLifecycles = flag.String(
+	"disabledLifecycles",
+	"",
+	"Comma-separated lifecycles, e.g. docker or buildpack, whose staging requests are rejected as not supported on this deployment",
+)
+
+var drainTimeout = flag.Duration(
+	"drainTimeout",
+	30*time.Second,
+	"How long the stager waits on shutdown for staging requests and completions in flight",
+)
+
+var consulRegistration = flag.Bool(
+	"consulRegistration",
+	false,
+	"Register the stager in consul as stager.service.cf.internal, with an HTTP health check on its /healthz endpoint",
+)
+
+var consulRegistrationAddress = flag.String(
+	"consulRegistrationAddress",
+	"",
+	"Address this stager instance registers in consul, and that consul health checks; defaults to the first non-loopback IPv4 address of the host",
+)
+
+var consulCheckInterval = flag.Duration(
+	"consulCheckInterval",
+	10*time.Second,
+	"How often consul checks the health of a registered stager",
+)
+
+var consulLock = flag.Bool(
+	"consulLock",
+	false,
+	"Hold a lock in consul while running, so that only one of several stagers is active",
+)
+
+var consulLockTTL = flag.Duration(
+	"consulLockTTL",
+	10*time.Second,
+	"How long after the active stager stops renewing its consul lock another stager can take over",
+)
+
+var consulLockRetryInterval = flag.Duration(
+	"consulLockRetryInterval",
+	5*time.Second,
+	"How often a passive stager tries to acquire the consul lock",
+)
+
+var taskDomain = flag.String(
+	"taskDomain",
+	cc_messages.StagingTaskDomain,
+	"BBS domain of the staging tasks created by this stager; must be unique to each stager deployment sharing a BBS",
+)
+
 var dockerStagingStack = flag.String(
 	"dockerStagingStack",
 	"",
 	"Stack to use for staging Docker applications",
 )
 
-const (
-	dropsondeDestination = "localhost:3457"
-	dropsondeOrigin      = "stager"
+var stagingCompleteCallbackTimeout = flag.Duration(
+	"stagingCompleteCallbackTimeout",
+	30*time.Second,
+	"Maximum time spent handling a staging task completion callback, including delivering the staging response to the CC",
 )
 
-func main() {
-	cf_debug_server.AddFlags(flag.CommandLine)
-	cf_lager.AddFlags(flag.CommandLine)
+var ccConnectTimeout = flag.Duration(
+	"ccConnectTimeout",
+	cc_client.DefaultConnectTimeout,
+	"Timeout for connecting to the CC, including the TLS handshake",
+)
 
-	lifecycles := flags.LifecycleMap{}
-	flag.Var(&lifecycles, "lifecycle", "app lifecycle binary bundle mapping (lifecycle[/stack]:bundle-filepath-in-fileserver)")
-	flag.Parse()
+var ccRequestTimeout = flag.Duration(
+	"ccRequestTimeout",
+	cc_client.DefaultRequestTimeout,
+	"Timeout for each attempt to deliver a staging response to the CC",
+)
 
-	logger, reconfigurableSink := cf_lager.New("stager")
-	initializeDropsonde(logger)
+var ccMaxIdleConnsPerHost = flag.Int(
+	"ccMaxIdleConnsPerHost",
+	cc_client.DefaultMaxIdleConnsPerHost,
+	"Maximum idle keep-alive connections to keep open to the CC",
+)
 
-	ccClient := cc_client.NewCcClient(*ccBaseURL, *ccUsername, *ccPassword, *skipCertVerify)
-	bbsClient := bbs.NewClient(*bbsAddress)
+var ccDisableHTTP2 = flag.Bool(
+	"ccDisableHTTP2",
+	false,
+	"Use HTTP/1.1 even if the CC supports HTTP/2",
+)
 
-	address, err := getStagerAddress()
-	if err != nil {
-		logger.Fatal("Invalid stager URL", err)
-	}
+var ccGzipThreshold = flag.Int(
+	"ccGzipThreshold",
+	0,
+	"Size in bytes from which staging responses are sent to the CC gzip-compressed (0 to never compress)",
+)
 
-	backends := initializeBackends(logger, lifecycles)
+var ccMaxRequestsPerSecond = flag.Int(
+	"ccMaxRequestsPerSecond",
+	0,
+	"Maximum requests per second made to the CC, retries included; requests over the cap wait their turn (0 for no cap)",
+)
 
-	handler := handlers.New(logger, ccClient, bbsClient, backends, clock.NewClock())
+var ccRetryAttempts = flag.Int(
+	"ccRetryAttempts",
+	cc_client.DefaultRetryPolicy.MaxAttempts,
+	"Maximum attempts to deliver a staging response to the CC when it is unreachable or fails with a 5xx",
+)
 
-	members := grouper.Members{
-		{"server", http_server.New(address, handler)},
-	}
+var ccRetryInitialBackoff = flag.Duration(
+	"ccRetryInitialBackoff",
+	cc_client.DefaultRetryPolicy.InitialBackoff,
+	"Backoff before the first retry of a staging response; it doubles with each further retry",
+)
 
-	if dbgAddr := cf_debug_server.DebugAddress(flag.CommandLine); dbgAddr != "" {
-		members = append(grouper.Members{
-			{"debug-server", cf_debug_server.Runner(dbgAddr, reconfigurableSink)},
-		}, members...)
-	}
+var ccRetryMaxBackoff = flag.Duration(
+	"ccRetryMaxBackoff",
+	cc_client.DefaultRetryPolicy.MaxBackoff,
+	"Maximum backoff between retries of a staging response",
+)
 
-	logger.Info("starting")
+var ccBreakerFailureThreshold = flag.Int(
+	"ccBreakerFailureThreshold",
+	5,
+	"Consecutive failures to reach the CC after which staging responses fail fast until the CC recovers (0 to disable)",
+)
 
-	group := grouper.NewOrdered(os.Interrupt, members)
+var ccBreakerResetTimeout = flag.Duration(
+	"ccBreakerResetTimeout",
+	30*time.Second,
+	"Time to fail fast for before probing whether the CC has recovered",
+)
 
-	process := ifrit.Invoke(sigmon.New(group))
+var startupCheckAttempts = flag.Int(
+	"startupCheckAttempts",
+	10,
+	"Times to check that the BBS and CC are reachable before starting, failing if they still aren't (0 to skip the checks)",
+)
 
-	logger.Info("Listening for staging requests!")
+var startupCheckInterval = flag.Duration(
+	"startupCheckInterval",
+	3*time.Second,
+	"Time between startup reachability checks",
+)
 
-	err = <-process.Wait()
-	if err != nil {
-		logger.Fatal("Stager exited with error", err)
-	}
+var completedTaskCleanupPolicy = flag.String(
+	"completedTaskCleanupPolicy",
+	handlers.TaskCleanupNone,
+	"What to do with staging tasks after notifying the CC (none, immediate, ttl)",
+)
 
-	logger.Info("stopped")
-}
+var completedTaskTTL = flag.Duration(
+	"completedTaskTTL",
+	time.Minute,
+	"How long to keep completed staging tasks before deleting them when using the ttl cleanup policy",
+)
 
-func initializeDropsonde(logger lager.Logger) {
-	err := dropsonde.Initialize(dropsondeDestination, dropsondeOrigin)
-	if err != nil {
-		logger.Error("failed to initialize dropsonde: %v", err)
-	}
-}
+var ccCompletionAPI = flag.String(
+	"ccCompletionAPI",
+	cc_client.APIVersionV2,
+	"CC API to report staging completion to unless the staging request asks otherwise (v2 or v3)",
+)
 
-func initializeBackends(logger lager.Logger, lifecycles flags.LifecycleMap) map[string]backend.Backend {
-	_, err := url.Parse(*stagerURL)
-	if err != nil {
-		logger.Fatal("Error parsing stager URL", err)
-	}
-	if *dockerStagingStack == "" {
-		logger.Fatal("Invalid Docker staging stack", errors.New("dockerStagingStack cannot be blank"))
-	}
+var ccStagingCompleteBatchPath = flag.String(
+	"ccStagingCompleteBatchPath",
+	"",
+	"CC path accepting batches of staging responses; responses are delivered individually when unset or unsupported by the CC",
+)
 
-	_, err = url.Parse(*consulCluster)
-	if err != nil {
-		logger.Fatal("Error parsing consul agent URL", err)
-	}
-	_, err = url.Parse(*dockerRegistryAddress)
-	if err != nil {
-		logger.Fatal("Error parsing Docker Registry address", err)
-	}
+var ccStagingCompleteBatchSize = flag.Int(
+	"ccStagingCompleteBatchSize",
+	50,
+	"Maximum number of staging responses delivered to the CC in one batch",
+)
 
-	config := backend.Config{
-		TaskDomain:             cc_messages.StagingTaskDomain,
-		StagerURL:              *stagerURL,
-		FileServerURL:          *fileServerURL,
-		CCUploaderURL:          *ccUploaderURL,
-		Lifecycles:             lifecycles,
-		DockerRegistryAddress:  *dockerRegistryAddress,
-		InsecureDockerRegistry: *insecureDockerRegistry,
-		ConsulCluster:          *consulCluster,
-		SkipCertVerify:         *skipCertVerify,
-		Sanitizer:              backend.SanitizeErrorMessage,
-		DockerStagingStack:     *dockerStagingStack,
-	}
-
-	return map[string]backend.Backend{
-		"buildpack": backend.NewTraditionalBackend(config, logger),
-		"docker":    backend.NewDockerBackend(config, logger),
+var ccStagingCompleteFlushInterval = flag.Duration(
+	"ccStagingCompleteFlushInterval",
+	200*time.Millisecond,
+	"Maximum time a staging response waits for a batch to fill before being delivered to the CC",
+)
+
+var stagingWebhookURLs = flag.String(
+	"stagingWebhookURLs",
+	"",
+	"Comma-separated URLs that receive staging started/succeeded/failed events",
+)
+
+var natsAddresses = flag.String(
+	"natsAddresses",
+	"",
+	"Comma-separated list of NATS addresses (ip:port); staging completion events are published to NATS when set",
+)
+
+var natsUsername = flag.String(
+	"natsUsername",
+	"nats",
+	"Username to connect to nats",
+)
+
+var natsPassword = flag.String(
+	"natsPassword",
+	"nats",
+	"Password for nats user",
+)
+
+var minStagingMemoryMB = flag.Int(
+	"minStagingMemoryMB",
+	0,
+	"Smallest memory limit, in MB, that staging tasks are given",
+)
+
+var minStagingDiskMB = flag.Int(
+	"minStagingDiskMB",
+	0,
+	"Smallest disk limit, in MB, that staging tasks are given",
+)
+
+var minStagingFileDescriptors = flag.Int(
+	"minStagingFileDescriptors",
+	0,
+	"Smallest file descriptor limit that staging tasks are given",
+)
+
+var stagingLogRateLimit = flag.Int64(
+	"stagingLogRateLimitBytesPerSecond",
+	0,
+	"Fastest that staging tasks may log, in bytes per second (0 means no limit)",
+)
+
+var packageDiskMultiplier = flag.Float64(
+	"packageDiskMultiplier",
+	0,
+	"Size staging tasks' disk as this many times their app package, when the CC gives the package's size (0 keeps the requested disk)",
+)
+
+var maxStagingPids = flag.Int(
+	"maxStagingPids",
+	0,
+	"Most processes a staging container may run (0 means no limit)",
+)
+
+var buildpackDownloadBatchSize = flag.Int(
+	"buildpackDownloadBatchSize",
+	0,
+	"Most buildpacks a staging task downloads at once (0 means no limit)",
+)
+
+var skipBuildArtifactsCacheUpload = flag.Bool(
+	"skipBuildArtifactsCacheUpload",
+	false,
+	"Don't upload build artifacts caches after staging with buildpacks",
+)
+
+var maxStagingTimeout = flag.Duration(
+	"maxStagingTimeout",
+	0,
+	"Longest timeout that staging tasks are given, whatever the CC asks for (0 means no limit)",
+)
+
+var unprivilegedStaging = flag.Bool(
+	"unprivilegedStaging",
+	false,
+	"Run staging tasks unprivileged, unless -lifecyclePrivileged says otherwise for their lifecycle",
+)
+
+var trustedCertsBundle = flag.String(
+	"trustedCertsBundle",
+	"",
+	"CA certificate bundle (.tgz holding ca-certificates.crt) trusted by staging containers, as a URL or a path on the file server",
+)
+
+var ecrRegistriesFile = flag.String(
+	"ecrRegistriesFile",
+	"",
+	"JSON file listing AWS ECR registries that docker staging authenticates to ([{\"registry\": ..., \"access_key_id\": ..., \"secret_access_key\": ...} or {\"registry\": ..., \"use_iam_role\": true}])",
+)
+
+var gcrServiceAccountsFile = flag.String(
+	"gcrServiceAccountsFile",
+	"",
+	"JSON file of Google service account keys, by name, that docker staging requests can reference as docker_service_account ({\"name\": {...key...}})",
+)
+
+var allowedDockerRegistries = flag.String(
+	"allowedDockerRegistries",
+	"",
+	"Comma-separated registry hosts that docker staging may pull images from, e.g. docker.io,*.example.com (empty allows any not denied)",
+)
+
+var deniedDockerRegistries = flag.String(
+	"deniedDockerRegistries",
+	"",
+	"Comma-separated registry hosts that docker staging may not pull images from",
+)
+
+var dockerRegistryMirrors = flag.String(
+	"dockerRegistryMirrors",
+	"",
+	"Comma-separated URLs of pull-through mirrors of Docker Hub for docker staging, e.g. https://mirror.example.com",
+)
+
+var dockerStagingPlatform = flag.String(
+	"dockerStagingPlatform",
+	"",
+	"Platform (os/architecture[/variant], e.g. linux/arm64) of the docker staging stack's cells, used to pick the variant of multi-architecture images",
+)
+
+var maxDockerImageSizeMB = flag.Int(
+	"maxDockerImageSizeMB",
+	0,
+	"Largest compressed docker image, in MB, that docker staging will pull (0 for no limit)",
+)
+
+var windowsDockerStagingStack = flag.String(
+	"windowsDockerStagingStack",
+	"",
+	"Windows stack for docker staging requests that name it as their stack, e.g. windows2016",
+)
+
+var dockerImageCaching = flag.Bool(
+	"dockerImageCaching",
+	false,
+	"Cache docker images in the docker registry when staging, unless the staging request's org or space overrides it",
+)
+
+var allowInlineDockerCredentials = flag.Bool(
+	"allowInlineDockerCredentials",
+	false,
+	"Accept docker staging requests giving registry passwords inline rather than as docker_credentials_credhub_ref; inline passwords are stored in the BBS as part of the staging task",
+)
+
+var dockerMetadataOnly = flag.Bool(
+	"dockerMetadataOnly",
+	false,
+	"Have docker staging that doesn't cache the image fetch only its manifest and config, unprivileged",
+)
+
+var stagingPlacementTags = flag.String(
+	"stagingPlacementTags",
+	"",
+	"Comma-separated placement tags for staging tasks whose request doesn't name an isolation segment",
+)
+
+var stagingNetworkProperties = flag.String(
+	"stagingNetworkProperties",
+	"",
+	"Comma-separated key=value container network properties added to every staging task",
+)
+
+var stagingLogExcerptURL = flag.String(
+	"stagingLogExcerptURL",
+	"",
+	"URL, with a %s placeholder for the staging guid, serving a staging task's log; its tail is added to staging failure messages when set",
+)
+
+var stagingLogExcerptBytes = flag.Int(
+	"stagingLogExcerptBytes",
+	2048,
+	"Maximum size of the staging log excerpt added to staging failure messages",
+)
+
+var restageInterval = flag.Duration(
+	"restageInterval",
+	time.Second,
+	"How often to submit the next app from a restage campaign",
+)
+
+var lifecycleStateFile = flag.String(
+	"lifecycleStateFile",
+	"",
+	"File used to record configured lifecycle bundles between runs, so that changed bundles can be reported for restaging",
+)
+
+var stagingEgressRulesFile = flag.String(
+	"stagingEgressRulesFile",
+	"",
+	"JSON file listing security group rules added to every staging task's egress rules, e.g. for DNS and the blobstore ([{\"protocol\": ..., \"destinations\": [...], \"ports\": [...]}])",
+)
+
+var customLifecyclesFile = flag.String(
+	"customLifecyclesFile",
+	"",
+	"JSON file listing lifecycles staged from configuration ([{\"name\": ..., \"bundle_url\": ..., \"builder_path\": ..., \"args\": [...]}])",
+)
+
+var importState = flag.String(
+	"importState",
+	"",
+	"File holding state exported from /v1/state/export by the stager being replaced",
+)
+
+var adminPolicyFile = flag.String(
+	"adminPolicyFile",
+	"",
+	"JSON file mapping admin API tokens and client certificate common names to viewer or operator roles; the admin API is closed when unset",
+)
+
+var auditLogFile = flag.String(
+	"auditLogFile",
+	"",
+	"file that every admin API request is appended to, and that support bundles include the end of",
+)
+
+var serverCert = flag.String(
+	"serverCert",
+	"",
+	"Certificate to serve the stager API over TLS with",
+)
+
+var serverKey = flag.String(
+	"serverKey",
+	"",
+	"Key of -serverCert",
+)
+
+var serverClientCACert = flag.String(
+	"serverClientCACert",
+	"",
+	"CA certificate verifying the client certificates admin API callers may present; needs -serverCert",
+)
+
+const (
+	dropsondeDestination = "localhost:3457"
+	dropsondeOrigin      = "stager"
+
+	healthCheckTimeout = time.Second
+)
+
+func main() {
+	cf_debug_server.AddFlags(flag.CommandLine)
+	cf_lager.AddFlags(flag.CommandLine)
+
+	lifecycles := flags.LifecycleMap{}
+	flag.Var(&lifecycles, "lifecycle", "app lifecycle binary bundle mapping (lifecycle[/stack]:bundle-filepath-in-fileserver)")
+
+	urlSigningKeys := backend.URLSigningKeys{}
+	flag.Var(&urlSigningKeys, "buildpackURLSigningKey", "secret for signing buildpack download URLs on an artifact store that requires signed URLs (host=secret); may be repeated")
+
+	lifecycleChecksums := backend.LifecycleChecksums{}
+	flag.Var(&lifecycleChecksums, "lifecycleChecksum", "expected checksum of a lifecycle bundle, verified when staging tasks download it (lifecycle[/stack]=sha1|sha256:hex-digest); may be repeated")
+
+	ccEndpoints := cc_client.StagingCompleteEndpoints{}
+	flag.Var(&ccEndpoints, "ccStagingCompleteEndpoint", "CC path to deliver staging responses to ([required:|optional:]path-with-%s-for-staging-guid); may be repeated")
+
+	ccTargets := cc_client.Targets{}
+	flag.Var(&ccTargets, "ccTarget", "additional CC that staging requests can ask to report to (name=url, with credentials as the url's user info); may be repeated")
+
+	stackResourceMinimums := backend.StackResourceMinimums{}
+	flag.Var(&stackResourceMinimums, "stackResourceMinimums", "staging resource minimums for a stack, overriding -minStaging* (stack:memory_mb=N,disk_mb=N,file_descriptors=N); may be repeated")
+
+	stackRootFSes := backend.StackRootFSes{}
+	flag.Var(&stackRootFSes, "stackRootFS", "rootfs for staging on a stack instead of its preloaded rootfs (stack=rootfs-uri); may be repeated")
+
+	lifecycleTaskDomains := backend.LifecycleTaskDomains{}
+	flag.Var(&lifecycleTaskDomains, "lifecycleTaskDomain", "BBS domain of a lifecycle's staging tasks instead of -taskDomain (lifecycle=domain); may be repeated")
+
+	dockerCredentialHelpers := backend.DockerCredentialHelpers{}
+	flag.Var(&dockerCredentialHelpers, "dockerCredentialHelper", "docker credential helper that docker staging authenticates to a registry with (registry=helper); may be repeated")
+
+	dockerRegistryCAFiles := backend.DockerRegistryCAFiles{}
+	flag.Var(&dockerRegistryCAFiles, "dockerRegistryCA", "file of PEM CA certificates that docker staging trusts for a registry (registry=path); may be repeated")
+
+	dockerBuilderLayouts := backend.DockerBuilderLayouts{}
+	flag.Var(&dockerBuilderLayouts, "dockerBuilderLayout", "builder path, and optionally result file, inside a docker lifecycle bundle that doesn't use the docker app lifecycle's (lifecycle=builder_path[,result_file]); may be repeated")
+
+	deprecatedStacks := backend.DeprecatedStacks{}
+	flag.Var(&deprecatedStacks, "deprecatedStack", "stack that developers are warned about when their apps stage on it (stack[=warning]); may be repeated")
+
+	lifecyclePrivileges := backend.LifecyclePrivileges{}
+	flag.Var(&lifecyclePrivileges, "lifecyclePrivileged", "whether a lifecycle's staging tasks run privileged, overriding -unprivilegedStaging (lifecycle=true|false); may be repeated")
+
+	stagingEnvironment := backend.StagingEnvironment{}
+	flag.Var(&stagingEnvironment, "stagingEnv", "environment variable added to every staging container unless the staging request sets it (NAME=value); may be repeated")
+
+	args := os.Args[1:]
+	devMode := len(args) > 0 && args[0] == devCommand
+	supportBundleMode := len(args) > 0 && args[0] == supportBundleCommand
+	validateConfigMode := len(args) > 0 && args[0] == validateConfigCommand
+	if devMode || supportBundleMode || validateConfigMode {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	err := loadEnvironment(flag.CommandLine, os.Environ())
+	if err != nil {
+		exitWithConfigurationError(err)
+	}
+
+	// Flags given on the command line or in the environment take precedence
+	// over the config file.
+	commandLineFlags := visitedFlags(flag.CommandLine)
+
+	if *configFile != "" {
+		err = loadConfigFile(flag.CommandLine, *configFile)
+		if err != nil {
+			exitWithConfigurationError(err)
+		}
+	}
+
+	if validateConfigMode {
+		os.Exit(validateConfig(os.Stdout, lifecycles, dockerRegistryCAFiles))
+	}
+
+	logger, reconfigurableSink := initializeLogger()
+
+	taskDomains := backend.Config{TaskDomain: *taskDomain, LifecycleTaskDomains: lifecycleTaskDomains}.TaskDomains()
+
+	if supportBundleMode {
+		writeSupportBundle(logger, taskDomains)
+		return
+	}
+
+	initializeDropsonde(logger)
+
+	members := grouper.Members{}
+
+	var bbsClient bbs.Client
+	if devMode {
+		var devMembers grouper.Members
+		bbsClient, devMembers = initializeDevMode(logger)
+		members = append(members, devMembers...)
+	} else {
+		bbsClient = initializeBBSClient(logger)
+	}
+
+	ccTLSConfig, err := cc_client.NewTLSConfig(*ccClientCert, *ccClientKey, []string{*caCertFile, *ccCACert}, *skipCertVerify)
+	if err != nil {
+		logger.Fatal("Invalid CC TLS configuration", err)
+	}
+
+	ccConfig := cc_client.Config{
+		BaseURI:   *ccBaseURL,
+		Username:  *ccUsername,
+		Password:  *ccPassword,
+		Endpoints: ccEndpoints,
+		TLSConfig: ccTLSConfig,
+		Proxy:     outboundProxy(logger),
+
+		ConnectTimeout:      *ccConnectTimeout,
+		RequestTimeout:      *ccRequestTimeout,
+		MaxIdleConnsPerHost: *ccMaxIdleConnsPerHost,
+		DisableHTTP2:        *ccDisableHTTP2,
+
+		GzipThreshold:        *ccGzipThreshold,
+		MaxRequestsPerSecond: *ccMaxRequestsPerSecond,
+
+		Retry: cc_client.RetryPolicy{
+			MaxAttempts:    *ccRetryAttempts,
+			InitialBackoff: *ccRetryInitialBackoff,
+			MaxBackoff:     *ccRetryMaxBackoff,
+		},
+	}
+
+	if *stagingResponseSigningKey != "" {
+		ccConfig.Signer, err = cc_client.NewJWSSigner(*stagingResponseSigningKey, *stagingResponseSigningKeyID)
+		if err != nil {
+			logger.Fatal("Invalid staging response signing key", err)
+		}
+	}
+
+	uaaTLSConfig, err := cc_client.NewTLSConfig("", "", []string{*caCertFile}, *skipCertVerify)
+	if err != nil {
+		logger.Fatal("Invalid UAA TLS configuration", err)
+	}
+	if *uaaURL != "" {
+		tokenFetcher := cc_client.NewUAATokenFetcher(*uaaURL, *uaaClientID, *uaaClientSecret, uaaTLSConfig, outboundProxy(logger), clock.NewClock())
+		ccConfig.TokenFetcher = tokenFetcher
+		members = append(members, grouper.Member{"uaa-token-fetcher", tokenFetcher})
+	}
+
+	ccBreakers := map[string]cc_client.CircuitBreaker{}
+
+	ccClient, ccBreaker, ccMembers := initializeCcClient(logger, ccConfig, "")
+	members = append(members, ccMembers...)
+	if ccBreaker != nil {
+		ccBreakers["cc-circuit-breaker"] = ccBreaker
+	}
+
+	if len(ccTargets) > 0 {
+		targetCredentials := loadCCTargetCredentials(logger, ccTargets)
+
+		targetClients := map[string]cc_client.CcClient{}
+		for name, target := range ccTargets {
+			targetConfig := ccTargetConfig(ccConfig, target)
+			if credentials, ok := targetCredentials[name]; ok {
+				var credentialMembers grouper.Members
+				targetConfig, credentialMembers = withCCTargetCredentials(logger, name, targetConfig, credentials, uaaTLSConfig)
+				members = append(members, credentialMembers...)
+			}
+
+			targetClient, targetBreaker, targetMembers := initializeCcClient(logger, targetConfig, name)
+			targetClients[name] = targetClient
+			members = append(members, targetMembers...)
+			if targetBreaker != nil {
+				ccBreakers["cc-circuit-breaker-"+name] = targetBreaker
+			}
+		}
+		ccClient = cc_client.NewRouter(ccClient, targetClients)
+	}
+
+	address, err := getStagerAddress()
+	if err != nil {
+		logger.Fatal("Invalid stager URL", err)
+	}
+
+	backendConfig, customLifecycles := initializeBackendConfig(logger, repeatedBackendFlags{
+		lifecycles:              lifecycles,
+		lifecycleChecksums:      lifecycleChecksums,
+		urlSigningKeys:          urlSigningKeys,
+		stackResourceMinimums:   stackResourceMinimums,
+		stackRootFSes:           stackRootFSes,
+		stagingEnvironment:      stagingEnvironment,
+		lifecyclePrivileges:     lifecyclePrivileges,
+		deprecatedStacks:        deprecatedStacks,
+		lifecycleTaskDomains:    lifecycleTaskDomains,
+		dockerCredentialHelpers: dockerCredentialHelpers,
+		dockerRegistryCAFiles:   dockerRegistryCAFiles,
+		dockerBuilderLayouts:    dockerBuilderLayouts,
+	})
+	stagingBackends, err := newBackends(logger, backendConfig, customLifecycles)
+	if err != nil {
+		logger.Fatal("Invalid custom lifecycles", err)
+	}
+	backends, reloadableBackends := reloadable(stagingBackends)
+	info := newBuildInfo(backends, backendConfig)
+
+	taskCleaner, err := handlers.NewCompletedTaskCleaner(bbsClient, *completedTaskCleanupPolicy, *completedTaskTTL, clock.NewClock())
+	if err != nil {
+		logger.Fatal("Invalid completed task cleanup policy", err)
+	}
+	members = append(members, grouper.Member{"task-cleaner", taskCleaner})
+
+	publisher := webhooks.NewPublisher(parseWebhookURLs(logger), clock.NewClock())
+
+	healthChecks := map[string]health.Checker{
+		"bbs": health.BBSCheck(bbsClient),
+		"cc":  health.TCPCheck(ccAddress(logger), healthCheckTimeout),
+	}
+	for name, breaker := range ccBreakers {
+		healthChecks[name] = health.CircuitBreakerCheck(breaker)
+	}
+
+	natsEmitter := nats_emitter.NewNoopEmitter()
+	if *natsAddresses != "" {
+		natsClient := diegonats.NewClient()
+		natsEmitter = nats_emitter.New(natsClient)
+		healthChecks["nats"] = health.NATSCheck(natsClient)
+		members = append(members, grouper.Member{"nats-client", diegonats.NewClientRunner(*natsAddresses, *natsUsername, *natsPassword, logger, natsClient)})
+	}
+
+	logFetcher := staging_logs.NewNoopFetcher()
+	if *stagingLogExcerptURL != "" {
+		logFetcher = staging_logs.NewFetcher(*stagingLogExcerptURL, *stagingLogExcerptBytes)
+	}
+
+	changedLifecycles := []string{}
+	if *lifecycleStateFile != "" {
+		changedLifecycles, err = restage.DetectLifecycleChanges(*lifecycleStateFile, lifecycles, lifecycleRootFSes(backendConfig))
+		if err != nil {
+			logger.Fatal("Failed to detect lifecycle changes", err)
+		}
+		if len(changedLifecycles) > 0 {
+			logger.Info("lifecycles-changed", lager.Data{"lifecycles": changedLifecycles})
+		}
+	}
+
+	stagingHandler := handlers.NewStagingHandler(logger, backends, ccClient, bbsClient, publisher, clock.NewClock())
+	err = restage.ValidateInterval(*restageInterval)
+	if err != nil {
+		logger.Fatal("Invalid restage interval", err)
+	}
+	restageController := restage.NewController(logger, stagingHandler, lifecycles, changedLifecycles, *restageInterval, clock.NewClock())
+	stagingHistory := history.New(history.DefaultSize, clock.NewClock())
+	if *importState != "" {
+		importedState, err := state.Load(*importState)
+		if err != nil {
+			logger.Fatal("Failed to load imported state", err)
+		}
+
+		err = state.Import(restageController, stagingHistory, importedState)
+		if err != nil {
+			logger.Fatal("Failed to import state", err)
+		}
+		logger.Info("imported-state", lager.Data{
+			"restage-campaigns": len(importedState.RestageCampaigns),
+			"staging-history":   len(importedState.StagingHistory),
+		})
+	}
+	members = append(members, grouper.Member{"restage-controller", restageController})
+	members = append(members, grouper.Member{"config-reloader", newConfigReloader(logger, backendConfig, customLifecycles, commandLineFlags, reloadableBackends, restageController, info)})
+
+	adminPolicy := authz.Policy{}
+	if *adminPolicyFile != "" {
+		adminPolicy, err = authz.LoadPolicy(*adminPolicyFile)
+		if err != nil {
+			logger.Fatal("Invalid admin policy file", err)
+		}
+	}
+	auditLog := audit.NewNoopLog()
+	if *auditLogFile != "" {
+		auditLog, err = audit.NewFileLog(logger, *auditLogFile, clock.NewClock())
+		if err != nil {
+			logger.Fatal("Failed to open audit log", err)
+		}
+	}
+	authorizer := authz.NewAuthorizer(logger, adminPolicy, auditLog)
+
+	handler := handlers.New(logger, handlers.Config{
+		Notifier:          ccClient,
+		BBSClient:         bbsClient,
+		TaskDomains:       taskDomains,
+		Backends:          backends,
+		TaskCleaner:       taskCleaner,
+		StagingHistory:    stagingHistory,
+		Publisher:         publisher,
+		NatsEmitter:       natsEmitter,
+		LogFetcher:        logFetcher,
+		CallbackTimeout:   *stagingCompleteCallbackTimeout,
+		RestageController: restageController,
+		Authorizer:        authorizer,
+		HealthChecks:      healthChecks,
+		PendingRetries:    cc_client.PendingRetries,
+		Info:              info.Info,
+		Clock:             clock.NewClock(),
+	})
+
+	// The group stops its members in reverse order: the server stops
+	// accepting requests, the drainer waits for those in flight, the task
+	// cleaner deletes the tasks they queued, and then the CC batchers flush
+	// the staging responses they hold.
+	drainer := handlers.NewDrainer(logger, *drainTimeout, clock.NewClock())
+	members = append(members, grouper.Member{"drainer", drainer})
+	members = append(members, grouper.Member{"server", initializeServer(logger, address, drainer.Track(handler))})
+
+	if *consulRegistration {
+		members = append(members, grouper.Member{"consul-registration", initializeConsulRegistration(logger, backendConfig)})
+	}
+
+	if *consulLock {
+		members = append(grouper.Members{
+			{"consul-lock", initializeConsulLock(logger, backendConfig)},
+		}, members...)
+	}
+
+	if dbgAddr := cf_debug_server.DebugAddress(flag.CommandLine); dbgAddr != "" {
+		members = append(grouper.Members{
+			{"debug-server", cf_debug_server.Runner(dbgAddr, reconfigurableSink)},
+		}, members...)
+	}
+
+	if !devMode {
+		waitForDependencies(logger, bbsClient, ccTargets)
+	}
+
+	logger.Info("starting")
+
+	group := grouper.NewOrdered(os.Interrupt, members)
+
+	process := ifrit.Invoke(sigmon.New(group))
+
+	logger.Info("Listening for staging requests!")
+
+	err = <-process.Wait()
+	if err != nil {
+		logger.Fatal("Stager exited with error", err)
+	}
+
+	logger.Info("stopped")
+}
+
+// initializeLogger logs like cf_lager unless a log format other than lager's
+// JSON, a log file or a syslog server is configured.
+func initializeLogger() (lager.Logger, *lager.ReconfigurableSink) {
+	if *logFormat == logging.FormatJSON && *logFile == "" && *syslogAddress == "" {
+		return cf_lager.New("stager")
+	}
+
+	err := logging.ValidateFormat(*logFormat)
+	if err != nil {
+		exitWithConfigurationError(err)
+	}
+
+	minLevel, err := logging.ParseLevel(flag.Lookup("logLevel").Value.String())
+	if err != nil {
+		exitWithConfigurationError(err)
+	}
+
+	writers := []io.Writer{os.Stdout}
+	if *logFile != "" {
+		file, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			exitWithConfigurationError(err)
+		}
+		writers = append(writers, file)
+	}
+	if *syslogAddress != "" {
+		writer, err := dialSyslog(*syslogAddress)
+		if err != nil {
+			exitWithConfigurationError(err)
+		}
+		writers = append(writers, writer)
+	}
+
+	// The reconfigurable sink lets the debug server change the level; the
+	// writer sink is created at the lowest level so that it never filters.
+	sink := lager.NewReconfigurableSink(logging.NewSink(io.MultiWriter(writers...), *logFormat, lager.DEBUG), minLevel)
+	logger := lager.NewLogger("stager")
+	logger.RegisterSink(sink)
+	return logger, sink
+}
+
+// dialSyslog connects to the local syslog daemon, or to the syslog server at
+// a udp:// or tcp:// URL.
+func dialSyslog(address string) (io.Writer, error) {
+	const priority = syslog.LOG_INFO | syslog.LOG_USER
+	if address == "local" {
+		return syslog.New(priority, "stager")
+	}
+
+	syslogURL, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if syslogURL.Scheme != "udp" && syslogURL.Scheme != "tcp" {
+		return nil, fmt.Errorf("syslog address must be local, or udp://host:port or tcp://host:port: %s", address)
+	}
+	return syslog.Dial(syslogURL.Scheme, syslogURL.Host, priority, "stager")
+}
+
+// exitWithConfigurationError reports errors found before the logger exists.
+func exitWithConfigurationError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
+
+// initializeBBSClient connects to the BBS over mutual TLS when -bbsAddress is
+// an https URL, and with basic auth when -bbsUsername is set.
+func initializeBBSClient(logger lager.Logger) bbs.Client {
+	bbsURL, err := url.Parse(*bbsAddress)
+	if err != nil {
+		logger.Fatal("Invalid BBS URL", err)
+	}
+	if *bbsUsername != "" {
+		bbsURL.User = url.UserPassword(*bbsUsername, *bbsPassword)
+	}
+
+	if bbsURL.Scheme != "https" {
+		return bbs.NewClient(bbsURL.String())
+	}
+
+	bbsClient, err := bbs.NewSecureClient(bbsURL.String(), *bbsCACert, *bbsClientCert, *bbsClientKey)
+	if err != nil {
+		logger.Fatal("Invalid BBS TLS configuration", err)
+	}
+	return bbsClient
+}
+
+// initializeServer serves handler over TLS when -serverCert is set.
+func initializeServer(logger lager.Logger, address string, handler http.Handler) ifrit.Runner {
+	tlsConfig, err := authz.NewServerTLSConfig(*serverCert, *serverKey, *serverClientCACert)
+	if err != nil {
+		logger.Fatal("Invalid server TLS configuration", err)
+	}
+
+	if tlsConfig == nil {
+		return http_server.New(address, handler)
+	}
+	return http_server.NewTLSServer(address, handler, tlsConfig)
+}
+
+// initializeCcClient builds a CC client for config, batching and guarding it
+// with a circuit breaker as configured. The breaker is nil when disabled.
+// targetName is empty for the default CC.
+func initializeCcClient(logger lager.Logger, config cc_client.Config, targetName string) (cc_client.CcClient, cc_client.CircuitBreaker, grouper.Members) {
+	batcherName := "cc-batcher"
+	if targetName != "" {
+		batcherName += "-" + targetName
+	}
+
+	var ccClient cc_client.CcClient
+	var members grouper.Members
+	if *ccStagingCompleteBatchPath != "" {
+		batchingCcClient, err := cc_client.NewBatchingCcClient(config, ccBatchConfig(), clock.NewClock())
+		if err != nil {
+			logger.Fatal("Invalid CC batch configuration", err)
+		}
+		ccClient = batchingCcClient
+		members = append(members, grouper.Member{batcherName, batchingCcClient})
+	} else {
+		ccClient = cc_client.NewCcClient(config)
+	}
+
+	if *ccBreakerFailureThreshold <= 0 {
+		return ccClient, nil, members
+	}
+
+	breaker := cc_client.NewCircuitBreaker(ccClient, cc_client.BreakerConfig{
+		FailureThreshold: *ccBreakerFailureThreshold,
+		ResetTimeout:     *ccBreakerResetTimeout,
+		Name:             targetName,
+	}, clock.NewClock())
+	return breaker, breaker, members
+}
+
+func ccBatchConfig() cc_client.BatchConfig {
+	return cc_client.BatchConfig{
+		Path:          *ccStagingCompleteBatchPath,
+		MaxSize:       *ccStagingCompleteBatchSize,
+		FlushInterval: *ccStagingCompleteFlushInterval,
+	}
+}
+
+// ccTargetConfig returns the default CC's config pointed at target. A target
+// with credentials in its URL authenticates with them instead of UAA.
+func ccTargetConfig(config cc_client.Config, target *url.URL) cc_client.Config {
+	baseURI := *target
+	baseURI.User = nil
+	config.BaseURI = baseURI.String()
+
+	if target.User != nil {
+		config.Username = target.User.Username()
+		config.Password, _ = target.User.Password()
+		config.TokenFetcher = nil
+	}
+	return config
+}
+
+// loadCCTargetCredentials loads -ccTargetCredentialsFile, if given, checking
+// that it only names configured targets.
+func loadCCTargetCredentials(logger lager.Logger, targets cc_client.Targets) map[string]cc_client.TargetCredentials {
+	if *ccTargetCredentialsFile == "" {
+		return nil
+	}
+
+	credentials, err := cc_client.LoadTargetCredentials(*ccTargetCredentialsFile)
+	if err != nil {
+		logger.Fatal("Invalid CC target credentials", err)
+	}
+
+	for name := range credentials {
+		if _, ok := targets[name]; !ok {
+			logger.Fatal("Invalid CC target credentials", cc_client.ErrUnknownTarget, lager.Data{"cc-target": name})
+		}
+	}
+
+	return credentials
+}
+
+// withCCTargetCredentials authenticates config with a target's own
+// credentials, replacing any given in the target's URL. A target with its own
+// UAA client returns the token fetcher to run.
+func withCCTargetCredentials(logger lager.Logger, targetName string, config cc_client.Config, credentials cc_client.TargetCredentials, uaaTLSConfig *tls.Config) (cc_client.Config, grouper.Members) {
+	if !credentials.UsesUAA() {
+		config.Username = credentials.Username
+		config.Password = credentials.Password
+		config.TokenFetcher = nil
+		return config, nil
+	}
+
+	tokenFetcher := cc_client.NewUAATokenFetcher(credentials.UAAURL, credentials.ClientID, credentials.ClientSecret, uaaTLSConfig, outboundProxy(logger), clock.NewClock())
+	config.TokenFetcher = tokenFetcher
+	return config, grouper.Members{{"uaa-token-fetcher-" + targetName, tokenFetcher}}
+}
+
+func initializeDropsonde(logger lager.Logger) {
+	err := dropsonde.Initialize(dropsondeDestination, dropsondeOrigin)
+	if err != nil {
+		logger.Error("failed to initialize dropsonde: %v", err)
+	}
+}
+
+// repeatedBackendFlags are the values of the repeatable flags that configure
+// the backends.
+type repeatedBackendFlags struct {
+	lifecycles              flags.LifecycleMap
+	lifecycleChecksums      backend.LifecycleChecksums
+	urlSigningKeys          backend.URLSigningKeys
+	stackResourceMinimums   backend.StackResourceMinimums
+	stackRootFSes           backend.StackRootFSes
+	stagingEnvironment      backend.StagingEnvironment
+	lifecyclePrivileges     backend.LifecyclePrivileges
+	deprecatedStacks        backend.DeprecatedStacks
+	lifecycleTaskDomains    backend.LifecycleTaskDomains
+	dockerCredentialHelpers backend.DockerCredentialHelpers
+	dockerRegistryCAFiles   backend.DockerRegistryCAFiles
+	dockerBuilderLayouts    backend.DockerBuilderLayouts
+}
+
+func initializeBackendConfig(logger lager.Logger, repeated repeatedBackendFlags) (backend.Config, []backend.CustomLifecycle) {
+	_, err := url.Parse(*stagerURL)
+	if err != nil {
+		logger.Fatal("Error parsing stager URL", err)
+	}
+	if *dockerStagingStack == "" {
+		logger.Fatal("Invalid Docker staging stack", errors.New("dockerStagingStack cannot be blank"))
+	}
+
+	_, err = url.Parse(*consulCluster)
+	if err != nil {
+		logger.Fatal("Error parsing consul agent URL", err)
+	}
+
+	err = backend.ValidateDockerPlatform(*dockerStagingPlatform)
+	if err != nil {
+		logger.Fatal("Invalid docker staging platform", err)
+	}
+
+	err = backend.ValidateDockerRegistryDiscovery(*dockerRegistryDiscovery)
+	if err != nil {
+		logger.Fatal("Invalid docker registry discovery", err)
+	}
+
+	mirrors := parseList(*dockerRegistryMirrors)
+	err = backend.ValidateDockerRegistryMirrors(mirrors)
+	if err != nil {
+		logger.Fatal("Invalid docker registry mirrors", err)
+	}
+
+	consulTLSConfig, err := cc_client.NewTLSConfig(*consulClientCert, *consulClientKey, []string{*consulCACert}, *skipCertVerify)
+	if err != nil {
+		logger.Fatal("Invalid consul TLS configuration", err)
+	}
+
+	err = cc_client.ValidateAPIVersion(*ccCompletionAPI)
+	if err != nil {
+		logger.Fatal("Invalid CC completion API", err)
+	}
+	_, err = url.Parse(*dockerRegistryAddress)
+	if err != nil {
+		logger.Fatal("Error parsing Docker Registry address", err)
+	}
+
+	dockerRegistryCAs, err := backend.LoadDockerRegistryCAs(repeated.dockerRegistryCAFiles)
+	if err != nil {
+		logger.Fatal("Invalid docker registry CAs", err)
+	}
+
+	config := backend.Config{
+		TaskDomain:                    *taskDomain,
+		LifecycleTaskDomains:          repeated.lifecycleTaskDomains,
+		StagerURL:                     *stagerURL,
+		FileServerURL:                 *fileServerURL,
+		CCUploaderURL:                 *ccUploaderURL,
+		Lifecycles:                    repeated.lifecycles,
+		DockerRegistryAddress:         *dockerRegistryAddress,
+		InsecureDockerRegistry:        *insecureDockerRegistry,
+		ConsulCluster:                 *consulCluster,
+		ConsulProxy:                   outboundProxy(logger),
+		ConsulTLSConfig:               consulTLSConfig,
+		ConsulACLToken:                *consulACLToken,
+		ConsulTimeout:                 *consulTimeout,
+		ConsulRetryAttempts:           *consulRetryAttempts,
+		DockerRegistryDiscovery:       *dockerRegistryDiscovery,
+		DockerRegistryCacheTTL:        *dockerRegistryCacheTTL,
+		DockerRegistryCacheMaxStale:   *dockerRegistryCacheMaxStale,
+		SkipCertVerify:                *skipCertVerify,
+		Sanitizer:                     backend.SanitizeErrorMessage,
+		DockerStagingStack:            *dockerStagingStack,
+		DockerCredentialHelpers:       repeated.dockerCredentialHelpers,
+		AllowInlineDockerCredentials:  *allowInlineDockerCredentials,
+		ECRAuthenticator:              loadECRAuthenticator(logger),
+		GCRServiceAccounts:            loadGCRServiceAccounts(logger),
+		DockerRegistryCAs:             dockerRegistryCAs,
+		AllowedDockerRegistries:       parseList(*allowedDockerRegistries),
+		DeniedDockerRegistries:        parseList(*deniedDockerRegistries),
+		DockerRegistryMirrors:         mirrors,
+		DockerImageCaching:            *dockerImageCaching,
+		DockerMetadataOnly:            *dockerMetadataOnly,
+		DockerStagingPlatform:         *dockerStagingPlatform,
+		WindowsDockerStagingStack:     *windowsDockerStagingStack,
+		MaxDockerImageSizeMB:          *maxDockerImageSizeMB,
+		DockerBuilderLayouts:          repeated.dockerBuilderLayouts,
+		NetworkProperties:             parseNetworkProperties(logger),
+		DefaultEgressRules:            loadStagingEgressRules(logger),
+		MinMemoryMB:                   *minStagingMemoryMB,
+		MinDiskMB:                     *minStagingDiskMB,
+		MinFileDescriptors:            *minStagingFileDescriptors,
+		StackResourceMinimums:         repeated.stackResourceMinimums,
+		MaxStagingPids:                *maxStagingPids,
+		PackageDiskMultiplier:         *packageDiskMultiplier,
+		LogRateLimitBytesPerSecond:    *stagingLogRateLimit,
+		StackRootFSes:                 repeated.stackRootFSes,
+		DeprecatedStacks:              repeated.deprecatedStacks,
+		MaxStagingTimeout:             *maxStagingTimeout,
+		StagingEnvironment:            repeated.stagingEnvironment,
+		LifecycleChecksums:            repeated.lifecycleChecksums,
+		BuildpackDownloadBatchSize:    *buildpackDownloadBatchSize,
+		URLSigningKeys:                repeated.urlSigningKeys,
+		Clock:                         clock.NewClock(),
+		SkipBuildArtifactsCacheUpload: *skipBuildArtifactsCacheUpload,
+		PlacementTags:                 parsePlacementTags(),
+		TrustedCertsBundle:            *trustedCertsBundle,
+		UnprivilegedStaging:           *unprivilegedStaging,
+		LifecyclePrivileges:           repeated.lifecyclePrivileges,
+		CompletionAPI:                 *ccCompletionAPI,
+		DisabledLifecycles:            parseList(*disabledLifecycles),
+	}
+
+	var customLifecycles []backend.CustomLifecycle
+	if *customLifecyclesFile != "" {
+		customLifecycles, err = backend.LoadCustomLifecycles(*customLifecyclesFile)
+		if err != nil {
+			logger.Fatal("Invalid custom lifecycles", err)
+		}
+	}
+
+	return config, customLifecycles
+}
+
+// newBackends builds the backends of the registered lifecycles and of the
+// custom ones for config, turning off the disabled ones.
+func newBackends(logger lager.Logger, config backend.Config, customLifecycles []backend.CustomLifecycle) (map[string]backend.Backend, error) {
+	backends := backend.NewBackends(config, logger)
+
+	for _, lifecycle := range customLifecycles {
+		if _, ok := backends[lifecycle.Name]; ok {
+			return nil, fmt.Errorf("lifecycle %s is already defined", lifecycle.Name)
+		}
+
+		custom, err := backend.NewCustomBackend(lifecycle, config, logger)
+		if err != nil {
+			return nil, fmt.Errorf("lifecycle %s: %s", lifecycle.Name, err)
+		}
+		backends[lifecycle.Name] = custom
+	}
+
+	for _, name := range config.DisabledLifecycles {
+		stagingBackend, ok := backends[name]
+		if !ok {
+			return nil, fmt.Errorf("lifecycle %s can't be disabled: it isn't defined", name)
+		}
+		backends[name] = backend.NewDisabledBackend(stagingBackend)
+	}
+
+	return backends, nil
+}
+
+func parseWebhookURLs(logger lager.Logger) []string {
+	urls := []string{}
+	for _, webhookURL := range strings.Split(*stagingWebhookURLs, ",") {
+		webhookURL = strings.TrimSpace(webhookURL)
+		if webhookURL == "" {
+			continue
+		}
+
+		_, err := url.ParseRequestURI(webhookURL)
+		if err != nil {
+			logger.Fatal("Error parsing staging webhook URL", err)
+		}
+		urls = append(urls, webhookURL)
+	}
+	return urls
+}
+
+func parsePlacementTags() []string {
+	return parseList(*stagingPlacementTags)
+}
+
+// parseList splits a comma-separated flag value, dropping empty entries.
+func parseList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func loadECRAuthenticator(logger lager.Logger) *backend.ECRAuthenticator {
+	if *ecrRegistriesFile == "" {
+		return nil
+	}
+
+	registries, err := backend.LoadECRRegistries(*ecrRegistriesFile)
+	if err != nil {
+		logger.Fatal("Invalid ECR registries", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{Proxy: outboundProxy(logger)},
+		Timeout:   30 * time.Second,
+	}
+	return backend.NewECRAuthenticator(registries, httpClient, clock.NewClock())
+}
+
+func loadGCRServiceAccounts(logger lager.Logger) backend.GCRServiceAccounts {
+	if *gcrServiceAccountsFile == "" {
+		return nil
+	}
+
+	accounts, err := backend.LoadGCRServiceAccounts(*gcrServiceAccountsFile)
+	if err != nil {
+		logger.Fatal("Invalid GCR service accounts", err)
+	}
+	return accounts
+}
+
+func loadStagingEgressRules(logger lager.Logger) []*models.SecurityGroupRule {
+	if *stagingEgressRulesFile == "" {
+		return nil
+	}
+
+	rules, err := backend.LoadEgressRules(*stagingEgressRulesFile)
+	if err != nil {
+		logger.Fatal("Invalid staging egress rules", err)
+	}
+	return rules
+}
+
+func parseNetworkProperties(logger lager.Logger) map[string]string {
+	properties := map[string]string{}
+	for _, pair := range strings.Split(*stagingNetworkProperties, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			logger.Fatal("Invalid staging network property", errors.New("network properties must be of the form key=value"), lager.Data{"property": pair})
+		}
+		properties[parts[0]] = parts[1]
+	}
+	return properties
+}
+
+// outboundProxy returns the proxy function for requests to the CC, UAA and
+// consul.
+func outboundProxy(logger lager.Logger) func(*http.Request) (*url.URL, error) {
+	if *httpProxy == "" {
+		return proxy.Func(nil)
+	}
+
+	proxyURL, err := url.ParseRequestURI(*httpProxy)
+	if err != nil {
+		logger.Fatal("Error parsing HTTP proxy URL", err)
+	}
+	return proxy.Func(proxyURL)
+}
+
+// ccAddress returns the host:port of the CC, defaulting the port from the
+// scheme, for use by the readiness check.
+func ccAddress(logger lager.Logger) string {
+	ccURL, err := url.Parse(*ccBaseURL)
+	if err != nil {
+		logger.Fatal("Error parsing CC base URL", err)
+	}
+
+	return hostPort(ccURL)
+}
+
+// initializeConsulLock holds the stager's lock in consul. Everything else
+// waits for it, so a passive stager neither serves requests nor connects to
+// NATS, and isn't registered in consul.
+func initializeConsulLock(logger lager.Logger, config backend.Config) ifrit.Runner {
+	if *consulCluster == "" {
+		logger.Fatal("Invalid consul lock", errors.New("consulCluster is required to lock in consul"))
+	}
+
+	callbackURL, err := url.Parse(*stagerURL)
+	if err != nil {
+		logger.Fatal("Invalid stager URL", err)
+	}
+
+	return consul.NewLockRunner(logger, newConsulClient(config), consul.Lock{
+		Key:           "v1/locks/stager_lock",
+		Owner:         "stager-" + callbackURL.Host,
+		TTL:           *consulLockTTL,
+		RetryInterval: *consulLockRetryInterval,
+	}, clock.NewClock())
+}
+
+func newConsulClient(config backend.Config) *consul.Client {
+	return consul.NewClient(config.ConsulCluster, config.ConsulACLToken, &http.Client{
+		Transport: &http.Transport{Proxy: config.ConsulProxy, TLSClientConfig: config.ConsulTLSConfig},
+		Timeout:   config.ConsulTimeout,
+	})
+}
+
+// initializeConsulRegistration registers this instance at
+// -consulRegistrationAddress, or the host's own address, and the port it
+// listens on. -stagerURL is usually the service's name in consul, which every
+// instance shares, so its host isn't used.
+func initializeConsulRegistration(logger lager.Logger, config backend.Config) ifrit.Runner {
+	if *consulCluster == "" {
+		logger.Fatal("Invalid consul registration", errors.New("consulCluster is required to register in consul"))
+	}
+
+	listenAddress, err := getStagerAddress()
+	if err != nil {
+		logger.Fatal("Invalid stager URL", err)
+	}
+	_, portString, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		logger.Fatal("Invalid stager URL", err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		logger.Fatal("Invalid stager URL", err)
+	}
+
+	host := *consulRegistrationAddress
+	if host == "" {
+		host, err = localIP()
+		if err != nil {
+			logger.Fatal("Failed to find the address to register in consul", err)
+		}
+	}
+
+	// The health check reaches the stager's own listener, which serves TLS
+	// when -serverCert is set, whatever -stagerURL's scheme.
+	checkScheme := "http"
+	if *serverCert != "" {
+		checkScheme = "https"
+	}
+
+	return consul.NewRegistrationRunner(logger, newConsulClient(config), consul.Registration{
+		ID:            "stager-" + host + "-" + portString,
+		Name:          "stager",
+		Address:       host,
+		Port:          port,
+		CheckURL:      checkScheme + "://" + net.JoinHostPort(host, portString) + "/healthz",
+		CheckInterval: *consulCheckInterval,
+	}, clock.NewClock())
+}
+
+// localIP returns the host's first non-loopback IPv4 address.
+func localIP() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		return ipNet.IP.String(), nil
+	}
+
+	return "", errors.New("no non-loopback IPv4 address")
+}
+
+func hostPort(u *url.URL) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Host, "443")
+	}
+	return net.JoinHostPort(u.Host, "80")
+}
+
+// waitForDependencies checks that the BBS and every CC can be reached before
+// the stager starts, giving them -startupCheckAttempts chances.
+func waitForDependencies(logger lager.Logger, bbsClient bbs.Client, ccTargets cc_client.Targets) {
+	if *startupCheckAttempts <= 0 {
+		return
+	}
+
+	checks := map[string]health.Checker{
+		"bbs": health.BBSCheck(bbsClient),
+		"cc":  health.TCPCheck(ccAddress(logger), healthCheckTimeout),
+	}
+	for name, target := range ccTargets {
+		checks["cc-"+name] = health.TCPCheck(hostPort(target), healthCheckTimeout)
+	}
+
+	err := health.WaitForDependencies(logger, checks, *startupCheckAttempts, *startupCheckInterval, clock.NewClock())
+	if err != nil {
+		logger.Fatal("Dependencies unreachable", err)
 	}
 }
 
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/exec"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/cloudfoundry-incubator/bbs/models"
 	"github.com/cloudfoundry-incubator/bbs/models/test/model_helpers"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages/flags"
 	"github.com/cloudfoundry-incubator/stager"
 	"github.com/cloudfoundry-incubator/stager/cmd/stager/testrunner"
@@ -107,6 +111,24 @@ var _ = Describe("Stager", func() {
 			})
 		})
 
+		Describe("GET /v1/info", func() {
+			It("reports the version, lifecycles and completion APIs", func() {
+				req, err := requestGenerator.CreateRequest(stager.InfoRoute, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				resp, err := httpClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var info map[string]interface{}
+				Expect(json.NewDecoder(resp.Body).Decode(&info)).To(Succeed())
+				Expect(info["version"]).To(Equal("dev"))
+				Expect(info["lifecycle_bundles"]).To(Equal([]interface{}{"buildpack/linux", "docker"}))
+				Expect(info["completion_api_versions"]).To(Equal([]interface{}{"v2", "v3"}))
+			})
+		})
+
 		Describe("when a docker staging request is received", func() {
 			It("desires a staging task via the API", func() {
 				fakeBBS.RouteToHandler("POST", "/v1/tasks/desire", func(w http.ResponseWriter, req *http.Request) {
@@ -198,6 +220,21 @@ var _ = Describe("Stager", func() {
 		})
 
 		Describe("when a staging task completes", func() {
+			BeforeEach(func() {
+				taskDef := model_helpers.NewValidTaskDefinition()
+				taskResponse := models.TaskResponse{
+					Task: &models.Task{
+						TaskDefinition: taskDef,
+						TaskGuid:       "the-task-guid",
+						Domain:         cc_messages.StagingTaskDomain,
+					},
+				}
+
+				fakeBBS.RouteToHandler("GET", "/v1/tasks/get_by_task_guid", func(w http.ResponseWriter, req *http.Request) {
+					writeResponse(w, &taskResponse)
+				})
+			})
+
 			Context("for a docker lifecycle", func() {
 				BeforeEach(func() {
 					fakeCC.AppendHandlers(
@@ -367,6 +404,298 @@ var _ = Describe("Stager", func() {
 		})
 	})
 
+	Describe("-configFile arg", func() {
+		var configFile string
+
+		writeConfigFile := func(contents string) {
+			file, err := ioutil.TempFile("", "stager-config")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			_, err = file.WriteString(contents)
+			Expect(err).NotTo(HaveOccurred())
+			configFile = file.Name()
+		}
+
+		AfterEach(func() {
+			os.Remove(configFile)
+		})
+
+		Context("when started with a valid config file", func() {
+			BeforeEach(func() {
+				writeConfigFile(`
+lifecycle:
+- buildpack/linux:lifecycle.zip
+- docker:docker/lifecycle.tgz
+dockerRegistryAddress: docker-registry.service.cf.internal:8080
+stagerURL: "://overridden-on-the-command-line"
+`)
+				runner.Start("-configFile", configFile)
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+			})
+
+			It("starts successfully, preferring the command line's flags", func() {
+				Consistently(runner.Session()).ShouldNot(gexec.Exit())
+			})
+		})
+
+		Context("when sent SIGHUP", func() {
+			BeforeEach(func() {
+				writeConfigFile(`{"lifecycle": ["docker:docker/lifecycle.tgz"], "minStagingMemoryMB": 512}`)
+				runner.Start("-configFile", configFile)
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+			})
+
+			It("reloads the lifecycles and staging resource minimums without restarting", func() {
+				err := ioutil.WriteFile(configFile, []byte(`{"lifecycle": ["docker:docker/lifecycle.tgz", "buildpack/cflinuxfs3:lifecycle.zip"], "minStagingMemoryMB": 1024}`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				runner.Session().Signal(syscall.SIGHUP)
+
+				Eventually(runner.Session()).Should(gbytes.Say("config-reloader.reloaded"))
+				Consistently(runner.Session()).ShouldNot(gexec.Exit())
+			})
+
+			It("keeps the running configuration when the file is invalid", func() {
+				err := ioutil.WriteFile(configFile, []byte(`{"lifecycle": ["invalid form"]}`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				runner.Session().Signal(syscall.SIGHUP)
+
+				Eventually(runner.Session()).Should(gbytes.Say("config-reloader.reload-failed"))
+				Consistently(runner.Session()).ShouldNot(gexec.Exit())
+			})
+		})
+
+		Context("when the config file has an invalid setting", func() {
+			BeforeEach(func() {
+				writeConfigFile(`{"lifecycle": ["invalid form"]}`)
+				runner.Start("-configFile", configFile)
+			})
+
+			It("logs and errors", func() {
+				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
+				Eventually(runner.Session().Err).Should(gbytes.Say(flags.ErrLifecycleFormatInvalid.Error()))
+			})
+		})
+
+		Context("when the config file has an unknown setting", func() {
+			BeforeEach(func() {
+				writeConfigFile(`{"noSuchFlag": true}`)
+				runner.Start("-configFile", configFile)
+			})
+
+			It("logs and errors", func() {
+				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
+				Eventually(runner.Session().Err).Should(gbytes.Say(`unknown setting "noSuchFlag"`))
+			})
+		})
+	})
+
+	Describe("-disabledLifecycles arg", func() {
+		Context("when docker staging is disabled", func() {
+			BeforeEach(func() {
+				runner.Start(
+					"-lifecycle", "buildpack/linux:lifecycle.zip",
+					"-lifecycle", "docker:docker/lifecycle.tgz",
+					"-disabledLifecycles", "docker",
+				)
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+			})
+
+			It("rejects docker staging requests as not supported", func() {
+				req, err := requestGenerator.CreateRequest(stager.StageRoute, rata.Params{"staging_guid": "my-task-guid"}, strings.NewReader(`{
+					"app_id":"my-app-guid",
+					"lifecycle": "docker",
+					"lifecycle_data": {"docker_image": "busybox"}
+				}`))
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Content-Type", "application/json")
+
+				resp, err := httpClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+				var response cc_messages.StagingResponseForCC
+				Expect(json.NewDecoder(resp.Body).Decode(&response)).To(Succeed())
+				Expect(response.Error.Id).To(Equal("LifecycleNotSupported"))
+				Expect(fakeBBS.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when a disabled lifecycle isn't defined", func() {
+			BeforeEach(func() {
+				runner.Start("-disabledLifecycles", "no-such-lifecycle")
+			})
+
+			It("logs and errors", func() {
+				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
+				Eventually(runner.Session()).Should(gbytes.Say("lifecycle no-such-lifecycle can't be disabled"))
+			})
+		})
+	})
+
+	Describe("-logFormat arg", func() {
+		Context("when started with the human log format and a log file", func() {
+			var logFile string
+
+			BeforeEach(func() {
+				file, err := ioutil.TempFile("", "stager-log")
+				Expect(err).NotTo(HaveOccurred())
+				file.Close()
+				logFile = file.Name()
+
+				runner.Start("-logFormat", "human", "-logFile", logFile)
+			})
+
+			AfterEach(func() {
+				os.Remove(logFile)
+			})
+
+			It("logs lines of text to stdout and the file", func() {
+				Eventually(runner.Session()).Should(gbytes.Say(`Z \[info\] stager\.starting`))
+				Eventually(func() (string, error) {
+					contents, err := ioutil.ReadFile(logFile)
+					return string(contents), err
+				}).Should(ContainSubstring("[info] stager.starting"))
+			})
+		})
+
+		Context("when started with an unknown log format", func() {
+			BeforeEach(func() {
+				runner.Start("-logFormat", "xml")
+			})
+
+			It("logs and errors", func() {
+				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
+				Eventually(runner.Session().Err).Should(gbytes.Say("log format must be json, rfc3339 or human"))
+			})
+		})
+	})
+
+	Describe("-consulLock arg", func() {
+		var fakeConsul *ghttp.Server
+
+		BeforeEach(func() {
+			fakeConsul = ghttp.NewServer()
+			fakeConsul.AllowUnhandledRequests = true
+			fakeConsul.RouteToHandler("PUT", "/v1/session/create", ghttp.RespondWith(http.StatusOK, `{"ID": "session-id"}`))
+			fakeConsul.RouteToHandler("PUT", "/v1/kv/v1/locks/stager_lock", ghttp.RespondWith(http.StatusOK, "false"))
+
+			runner.Start(
+				"-consulLock",
+				"-consulCluster", fakeConsul.URL(),
+				"-consulLockRetryInterval", "100ms",
+			)
+		})
+
+		AfterEach(func() {
+			fakeConsul.Close()
+		})
+
+		It("waits for the lock before serving requests", func() {
+			Consistently(runner.Session()).ShouldNot(gbytes.Say("Listening for staging requests!"))
+
+			fakeConsul.RouteToHandler("PUT", "/v1/kv/v1/locks/stager_lock", ghttp.RespondWith(http.StatusOK, "true"))
+			Eventually(runner.Session()).Should(gbytes.Say("consul-lock.acquired-lock"))
+			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+		})
+	})
+
+	Describe("STAGER_* environment variables", func() {
+		AfterEach(func() {
+			os.Unsetenv("STAGER_LIFECYCLE")
+			os.Unsetenv("STAGER_DOCKER_REGISTRY_ADDRESS")
+			os.Unsetenv("STAGER_STAGER_URL")
+		})
+
+		Context("when started with valid settings in the environment", func() {
+			BeforeEach(func() {
+				os.Setenv("STAGER_LIFECYCLE", "buildpack/linux:lifecycle.zip\ndocker:docker/lifecycle.tgz")
+				os.Setenv("STAGER_DOCKER_REGISTRY_ADDRESS", "docker-registry.service.cf.internal:8080")
+				os.Setenv("STAGER_STAGER_URL", "://overridden-on-the-command-line")
+				runner.Start()
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+			})
+
+			It("starts successfully, preferring the command line's flags", func() {
+				Consistently(runner.Session()).ShouldNot(gexec.Exit())
+			})
+		})
+
+		Context("when a variable has an invalid value", func() {
+			BeforeEach(func() {
+				os.Setenv("STAGER_LIFECYCLE", "invalid form")
+				runner.Start()
+			})
+
+			It("logs and errors", func() {
+				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
+				Eventually(runner.Session().Err).Should(gbytes.Say("STAGER_LIFECYCLE"))
+			})
+		})
+	})
+
+	Describe("-bbsAddress arg", func() {
+		Context("when the BBS is https and its TLS configuration is invalid", func() {
+			BeforeEach(func() {
+				runner.Start(
+					"-bbsAddress", "https://bbs.service.cf.internal:8889",
+					"-bbsCACert", "/no/such/ca.crt",
+					"-bbsClientCert", "/no/such/client.crt",
+					"-bbsClientKey", "/no/such/client.key",
+				)
+			})
+
+			It("logs and errors", func() {
+				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
+				Eventually(runner.Session()).Should(gbytes.Say("Invalid BBS TLS configuration"))
+			})
+		})
+	})
+
+	Describe("validate-config", func() {
+		validateConfig := func(args ...string) *gexec.Session {
+			session, err := gexec.Start(exec.Command(stagerPath, append([]string{"validate-config"}, args...)...), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			return session
+		}
+
+		It("reports every check and exits zero when the config is valid", func() {
+			session := validateConfig(
+				"-ccBaseURL", "http://cc.example.com",
+				"-stagerURL", "http://127.0.0.1:8888",
+				"-bbsAddress", "http://bbs.service.cf.internal:8889",
+				"-dockerStagingStack", "cflinuxfs2",
+				"-lifecycle", "buildpack/cflinuxfs2:lifecycle.zip",
+				"-lifecycle", "docker:docker/lifecycle.tgz",
+			)
+
+			Eventually(session).Should(gexec.Exit(0))
+			Expect(session).To(gbytes.Say("ok      ccBaseURL"))
+			Expect(session).To(gbytes.Say("all 23 checks passed"))
+		})
+
+		It("reports the failed checks and exits non-zero when the config is invalid", func() {
+			session := validateConfig(
+				"-ccBaseURL", "http://cc.example.com",
+				"-stagerURL", "http://127.0.0.1",
+				"-bbsAddress", "https://bbs.service.cf.internal:8889",
+				"-dockerStagingStack", "cflinuxfs2",
+				"-lifecycle", "rocket/coreos:rocket_lifecycle.tgz",
+				"-restageInterval", "0",
+			)
+
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session).To(gbytes.Say("FAILED  stagerURL"))
+			Expect(session).To(gbytes.Say("FAILED  lifecycle: rocket/coreos is a bundle for unknown lifecycle rocket"))
+			Expect(session).To(gbytes.Say("FAILED  bbsClientCert: an https BBS needs -bbsCACert, -bbsClientCert and -bbsClientKey"))
+			Expect(session).To(gbytes.Say("FAILED  restageInterval: restage interval must be positive"))
+			Expect(session).To(gbytes.Say("4 of 23 checks failed"))
+		})
+	})
+
 	Describe("-stagerURL arg", func() {
 		Context("when started with an invalid -stagerURL arg", func() {
 			BeforeEach(func() {
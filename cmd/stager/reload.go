@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages/flags"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// reloadableFlags are the settings that SIGHUP reloads from the config file.
+// The failure reason sanitizer is built in, so there is nothing to reload.
+var reloadableFlags = []string{
+	"lifecycle",
+	"lifecycleChecksum",
+	"minStagingMemoryMB",
+	"minStagingDiskMB",
+	"minStagingFileDescriptors",
+	"stackResourceMinimums",
+	"stackRootFS",
+}
+
+// reloadable wraps backends so that their configuration can be reloaded.
+func reloadable(backends map[string]backend.Backend) (map[string]backend.Backend, map[string]*backend.ReloadableBackend) {
+	wrapped := make(map[string]backend.Backend, len(backends))
+	reloadables := make(map[string]*backend.ReloadableBackend, len(backends))
+	for name, stagingBackend := range backends {
+		reloadables[name] = backend.NewReloadableBackend(stagingBackend)
+		wrapped[name] = reloadables[name]
+	}
+	return wrapped, reloadables
+}
+
+// newConfigReloader reloads the lifecycle bundles, stack rootfses and staging
+// resource minimums from the config file on SIGHUP, and reports the
+// lifecycles whose bundle or rootfs changed to restageController. Settings
+// given on the command line or in the environment keep their values.
+func newConfigReloader(logger lager.Logger, config backend.Config, customLifecycles []backend.CustomLifecycle, commandLineFlags map[string]bool, backends map[string]*backend.ReloadableBackend, restageController restage.Controller, info *buildInfo) ifrit.Runner {
+	backendLogger := logger
+	logger = logger.Session("config-reloader")
+
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		defer signal.Stop(hangups)
+
+		close(ready)
+
+		for {
+			select {
+			case <-hangups:
+				if *configFile == "" {
+					logger.Info("no-config-file")
+					continue
+				}
+
+				reloaded, err := reloadConfig(*configFile, config, commandLineFlags)
+				if err != nil {
+					logger.Error("reload-failed", err)
+					continue
+				}
+
+				stagingBackends, err := newBackends(backendLogger, reloaded, customLifecycles)
+				if err != nil {
+					logger.Error("reload-failed", err)
+					continue
+				}
+
+				for name, stagingBackend := range backends {
+					stagingBackend.Reload(stagingBackends[name])
+				}
+				info.setLifecycleBundles(reloaded.Lifecycles)
+				config = reloaded
+
+				changedLifecycles := []string{}
+				if *lifecycleStateFile != "" {
+					changedLifecycles, err = restage.DetectLifecycleChanges(*lifecycleStateFile, config.Lifecycles, lifecycleRootFSes(config))
+					if err != nil {
+						logger.Error("detect-lifecycle-changes-failed", err)
+					}
+				}
+				restageController.Reload(config.Lifecycles, changedLifecycles)
+
+				logger.Info("reloaded", lager.Data{"lifecycles": config.Lifecycles, "changed-lifecycles": changedLifecycles})
+			case <-signals:
+				return nil
+			}
+		}
+	})
+}
+
+// reloadConfig returns config with the reloadable settings of the config file
+// at path. Reloadable settings that the file no longer gives revert to their
+// defaults.
+func reloadConfig(path string, config backend.Config, commandLineFlags map[string]bool) (backend.Config, error) {
+	settings, err := readConfigFile(path)
+	if err != nil {
+		return backend.Config{}, err
+	}
+
+	flagSet := flag.NewFlagSet("reload", flag.ContinueOnError)
+	lifecycles := flags.LifecycleMap{}
+	flagSet.Var(&lifecycles, "lifecycle", "")
+	lifecycleChecksums := backend.LifecycleChecksums{}
+	flagSet.Var(&lifecycleChecksums, "lifecycleChecksum", "")
+	stackResourceMinimums := backend.StackResourceMinimums{}
+	flagSet.Var(&stackResourceMinimums, "stackResourceMinimums", "")
+	stackRootFSes := backend.StackRootFSes{}
+	flagSet.Var(&stackRootFSes, "stackRootFS", "")
+	minMemoryMB := flagSet.Int("minStagingMemoryMB", defaultIntFlag("minStagingMemoryMB"), "")
+	minDiskMB := flagSet.Int("minStagingDiskMB", defaultIntFlag("minStagingDiskMB"), "")
+	minFileDescriptors := flagSet.Int("minStagingFileDescriptors", defaultIntFlag("minStagingFileDescriptors"), "")
+
+	for _, name := range reloadableFlags {
+		setting, ok := settings[name]
+		if !ok || commandLineFlags[name] {
+			continue
+		}
+
+		err := setConfigFileFlag(flagSet, path, name, setting)
+		if err != nil {
+			return backend.Config{}, err
+		}
+	}
+
+	if !commandLineFlags["lifecycle"] {
+		config.Lifecycles = lifecycles
+	}
+	if !commandLineFlags["lifecycleChecksum"] {
+		config.LifecycleChecksums = lifecycleChecksums
+	}
+	if !commandLineFlags["stackResourceMinimums"] {
+		config.StackResourceMinimums = stackResourceMinimums
+	}
+	if !commandLineFlags["stackRootFS"] {
+		config.StackRootFSes = stackRootFSes
+	}
+	if !commandLineFlags["minStagingMemoryMB"] {
+		config.MinMemoryMB = *minMemoryMB
+	}
+	if !commandLineFlags["minStagingDiskMB"] {
+		config.MinDiskMB = *minDiskMB
+	}
+	if !commandLineFlags["minStagingFileDescriptors"] {
+		config.MinFileDescriptors = *minFileDescriptors
+	}
+
+	return config, nil
+}
+
+func defaultIntFlag(name string) int {
+	value, _ := strconv.Atoi(flag.CommandLine.Lookup(name).DefValue)
+	return value
+}
+
+// lifecycleRootFSes maps the keys of lifecycles for a stack, e.g.
+// buildpack/cflinuxfs2, to the rootfs they stage on.
+func lifecycleRootFSes(config backend.Config) map[string]string {
+	rootFSes := map[string]string{}
+	for key := range config.Lifecycles {
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) == 2 {
+			rootFSes[key] = config.RootFSFor(parts[1])
+		}
+	}
+	return rootFSes
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/stager/audit"
+	"github.com/cloudfoundry-incubator/stager/support"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	supportBundleCommand = "support-bundle"
+
+	supportBundleHealthTimeout = 5 * time.Second
+	supportBundleAuditEntries  = 1000
+)
+
+var supportBundleOut = flag.String(
+	"out",
+	"stager-support-bundle.tgz",
+	"Path of the archive written by the support-bundle command",
+)
+
+type healthSnapshot struct {
+	StatusCode int             `json:"status_code,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// writeSupportBundle collects the stager's redacted configuration, its
+// recent audit log entries, a summary of the staging tasks in the BBS with
+// their stats, and the running stager's readiness into a single archive to
+// attach to support tickets.
+func writeSupportBundle(logger lager.Logger, taskDomains []string) {
+	logger = logger.Session("support-bundle", lager.Data{"out": *supportBundleOut})
+
+	files := []support.File{
+		{Name: "config.json", Contents: mustMarshal(logger, support.RedactedConfig(flag.CommandLine))},
+		{Name: "health.json", Contents: mustMarshal(logger, fetchHealth())},
+	}
+
+	tasks, err := fetchTasks(initializeBBSClient(logger), taskDomains)
+	if err != nil {
+		logger.Error("failed-to-fetch-tasks", err)
+		files = append(files, support.File{Name: "tasks-error.txt", Contents: []byte(err.Error())})
+	} else {
+		files = append(files,
+			support.File{Name: "tasks.json", Contents: mustMarshal(logger, tasks)},
+			support.File{Name: "stats.json", Contents: mustMarshal(logger, support.TaskStats(tasks))},
+		)
+	}
+
+	if *auditLogFile != "" {
+		entries, err := audit.Recent(*auditLogFile, supportBundleAuditEntries)
+		if err != nil {
+			logger.Error("failed-to-read-audit-log", err)
+			files = append(files, support.File{Name: "audit-error.txt", Contents: []byte(err.Error())})
+		} else {
+			files = append(files, support.File{Name: "audit.json", Contents: mustMarshal(logger, entries)})
+		}
+	}
+
+	out, err := os.Create(*supportBundleOut)
+	if err != nil {
+		logger.Fatal("failed-to-create-bundle", err)
+	}
+	defer out.Close()
+
+	err = support.WriteBundle(out, files, time.Now())
+	if err != nil {
+		logger.Fatal("failed-to-write-bundle", err)
+	}
+
+	logger.Info("wrote-bundle")
+}
+
+// fetchTasks summarizes the staging tasks in all of the stager's task
+// domains.
+func fetchTasks(bbsClient bbs.Client, taskDomains []string) ([]support.TaskSummary, error) {
+	tasks := []support.TaskSummary{}
+	for _, domain := range taskDomains {
+		domainTasks, err := bbsClient.TasksByDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, support.SummarizeTasks(domainTasks)...)
+	}
+	return tasks, nil
+}
+
+func fetchHealth() healthSnapshot {
+	client := &http.Client{Timeout: supportBundleHealthTimeout}
+
+	response, err := client.Get(*stagerURL + "/readyz")
+	if err != nil {
+		return healthSnapshot{Error: err.Error()}
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return healthSnapshot{StatusCode: response.StatusCode, Error: err.Error()}
+	}
+
+	snapshot := healthSnapshot{StatusCode: response.StatusCode}
+	var raw json.RawMessage
+	if json.Unmarshal(body, &raw) == nil {
+		snapshot.Body = raw
+	}
+	return snapshot
+}
+
+func mustMarshal(logger lager.Logger, value interface{}) []byte {
+	payload, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		logger.Fatal("failed-to-marshal", err)
+	}
+	return payload
+}
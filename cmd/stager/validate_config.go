@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages/flags"
+	"github.com/cloudfoundry-incubator/stager/authz"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/logging"
+	"github.com/cloudfoundry-incubator/stager/restage"
+)
+
+const validateConfigCommand = "validate-config"
+
+var errSettingRequired = errors.New("must be set")
+
+// configCheck is a line of the validate-config report: the setting checked
+// and what is wrong with it, if anything.
+type configCheck struct {
+	setting string
+	err     error
+}
+
+// validateConfig checks the settings the stager would start with, without
+// connecting to the BBS, the CC, NATS or consul, and writes a report of the
+// checks to out. It returns the exit status: 1 if any check failed.
+func validateConfig(out io.Writer, lifecycles flags.LifecycleMap, dockerRegistryCAFiles backend.DockerRegistryCAFiles) int {
+	customLifecycles, customLifecyclesErr := loadCustomLifecycles()
+	lifecycleNames := map[string]bool{}
+	for _, name := range backend.RegisteredBackends() {
+		lifecycleNames[name] = true
+	}
+	for _, lifecycle := range customLifecycles {
+		lifecycleNames[lifecycle.Name] = true
+	}
+
+	_, dockerRegistryCAsErr := backend.LoadDockerRegistryCAs(dockerRegistryCAFiles)
+
+	checks := []configCheck{
+		{"ccBaseURL", validateURL(*ccBaseURL, true)},
+		{"stagerURL", validateStagerURL()},
+		{"bbsAddress", validateURL(*bbsAddress, true)},
+		{"fileServerURL", validateURL(*fileServerURL, false)},
+		{"consulCluster", validateURL(*consulCluster, false)},
+		{"httpProxy", validateURL(*httpProxy, false)},
+		{"stagingWebhookURLs", validateURLs(parseList(*stagingWebhookURLs))},
+		{"customLifecyclesFile", customLifecyclesErr},
+		{"lifecycle", validateLifecycles(lifecycles, lifecycleNames)},
+		{"disabledLifecycles", validateDisabledLifecycles(parseList(*disabledLifecycles), lifecycleNames)},
+		{"dockerStagingStack", validateRequired(*dockerStagingStack)},
+		{"dockerStagingPlatform", backend.ValidateDockerPlatform(*dockerStagingPlatform)},
+		{"dockerRegistryDiscovery", backend.ValidateDockerRegistryDiscovery(*dockerRegistryDiscovery)},
+		{"dockerRegistryMirrors", backend.ValidateDockerRegistryMirrors(parseList(*dockerRegistryMirrors))},
+		{"dockerRegistryCA", dockerRegistryCAsErr},
+		{"ccCompletionAPI", cc_client.ValidateAPIVersion(*ccCompletionAPI)},
+		{"ccStagingCompleteBatchSize", validateBatchConfig()},
+		{"restageInterval", restage.ValidateInterval(*restageInterval)},
+		{"ccClientCert", validateTLS(*ccClientCert, *ccClientKey, *caCertFile, *ccCACert)},
+		{"consulClientCert", validateTLS(*consulClientCert, *consulClientKey, *consulCACert)},
+		{"bbsClientCert", validateBBSTLS()},
+		{"serverCert", validateServerTLS()},
+		{"logFormat", logging.ValidateFormat(*logFormat)},
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if check.err != nil {
+			failed++
+			fmt.Fprintf(out, "FAILED  %s: %s\n", check.setting, check.err)
+		} else {
+			fmt.Fprintf(out, "ok      %s\n", check.setting)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(out, "%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Fprintf(out, "all %d checks passed\n", len(checks))
+	return 0
+}
+
+func loadCustomLifecycles() ([]backend.CustomLifecycle, error) {
+	if *customLifecyclesFile == "" {
+		return nil, nil
+	}
+	return backend.LoadCustomLifecycles(*customLifecyclesFile)
+}
+
+func validateRequired(value string) error {
+	if value == "" {
+		return errSettingRequired
+	}
+	return nil
+}
+
+// validateURL checks that value is an absolute URL. Optional settings may be
+// empty.
+func validateURL(value string, required bool) error {
+	if value == "" {
+		if required {
+			return errSettingRequired
+		}
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", value)
+	}
+	return nil
+}
+
+func validateURLs(values []string) error {
+	for _, value := range values {
+		err := validateURL(value, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateStagerURL() error {
+	err := validateURL(*stagerURL, true)
+	if err != nil {
+		return err
+	}
+	_, err = getStagerAddress()
+	return err
+}
+
+// validateLifecycles checks that every lifecycle bundle is for a lifecycle
+// the stager has, e.g. buildpack/cflinuxfs2 for the buildpack lifecycle.
+func validateLifecycles(lifecycles flags.LifecycleMap, lifecycleNames map[string]bool) error {
+	if len(lifecycles) == 0 {
+		return errors.New("no lifecycle bundles are configured")
+	}
+
+	keys := make([]string, 0, len(lifecycles))
+	for key := range lifecycles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.SplitN(key, "/", 2)[0]
+		if !lifecycleNames[name] {
+			return fmt.Errorf("%s is a bundle for unknown lifecycle %s", key, name)
+		}
+	}
+	return nil
+}
+
+func validateDisabledLifecycles(disabled []string, lifecycleNames map[string]bool) error {
+	for _, name := range disabled {
+		if !lifecycleNames[name] {
+			return fmt.Errorf("lifecycle %s can't be disabled: it isn't defined", name)
+		}
+	}
+	return nil
+}
+
+// validateTLS loads the client certificate and CA certificates as the
+// stager would.
+func validateTLS(certFile, keyFile string, caCertFiles ...string) error {
+	_, err := cc_client.NewTLSConfig(certFile, keyFile, caCertFiles, *skipCertVerify)
+	return err
+}
+
+func validateBBSTLS() error {
+	if !strings.HasPrefix(*bbsAddress, "https://") {
+		return nil
+	}
+	if *bbsCACert == "" || *bbsClientCert == "" || *bbsClientKey == "" {
+		return errors.New("an https BBS needs -bbsCACert, -bbsClientCert and -bbsClientKey")
+	}
+	return validateTLS(*bbsClientCert, *bbsClientKey, *bbsCACert)
+}
+
+func validateBatchConfig() error {
+	if *ccStagingCompleteBatchPath == "" {
+		return nil
+	}
+	return cc_client.ValidateBatchConfig(ccBatchConfig())
+}
+
+func validateServerTLS() error {
+	_, err := authz.NewServerTLSConfig(*serverCert, *serverKey, *serverClientCACert)
+	return err
+}
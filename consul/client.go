@@ -0,0 +1,76 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// TokenHeader carries the ACL token on requests to the agent.
+const TokenHeader = "X-Consul-Token"
+
+// StatusError is returned when the agent responds with anything but 200.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("consul responded with status %d", e.StatusCode)
+}
+
+// Client talks to the HTTP API of a consul agent.
+type Client struct {
+	url        string
+	aclToken   string
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the agent at url, e.g.
+// http://127.0.0.1:8500. aclToken may be empty.
+func NewClient(url, aclToken string, httpClient *http.Client) *Client {
+	return &Client{
+		url:        url,
+		aclToken:   aclToken,
+		httpClient: httpClient,
+	}
+}
+
+// do sends body, when not nil, as JSON to the agent and decodes the response
+// into result, when not nil.
+func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	if c.aclToken != "" {
+		request.Header.Set(TokenHeader, c.aclToken)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return StatusError{StatusCode: response.StatusCode}
+	}
+
+	if result == nil {
+		_, err = io.Copy(ioutil.Discard, response.Body)
+		return err
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
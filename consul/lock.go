@@ -0,0 +1,148 @@
+package consul
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+var ErrLockLost = errors.New("lost the consul lock")
+
+// Lock describes a lock held through a consul session. The session expires,
+// releasing the lock, when its holder stops renewing it for TTL.
+type Lock struct {
+	Key           string
+	Owner         string
+	TTL           time.Duration
+	RetryInterval time.Duration
+}
+
+type sessionDefinition struct {
+	Name      string
+	TTL       string
+	Behavior  string
+	LockDelay string
+}
+
+type sessionResponse struct {
+	ID string
+}
+
+type lockRunner struct {
+	logger    lager.Logger
+	client    *Client
+	lock      Lock
+	clock     clock.Clock
+	sessionID string
+}
+
+// NewLockRunner returns a runner that becomes ready once it holds the lock,
+// trying to acquire it every RetryInterval until then. It renews its session
+// every half TTL, exits with ErrLockLost if it fails to, and releases the
+// lock when signaled.
+func NewLockRunner(logger lager.Logger, client *Client, lock Lock, clock clock.Clock) ifrit.Runner {
+	return &lockRunner{
+		logger: logger.Session("consul-lock", lager.Data{"key": lock.Key, "owner": lock.Owner}),
+		client: client,
+		lock:   lock,
+		clock:  clock,
+	}
+}
+
+func (r *lockRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	retryTicker := r.clock.NewTicker(r.lock.RetryInterval)
+	defer retryTicker.Stop()
+	renewTicker := r.clock.NewTicker(r.lock.TTL / 2)
+	defer renewTicker.Stop()
+
+	acquired := r.acquire()
+	if acquired {
+		close(ready)
+	}
+
+	for {
+		select {
+		case <-signals:
+			r.release(acquired)
+			return nil
+		case <-retryTicker.C():
+			if !acquired && r.acquire() {
+				acquired = true
+				close(ready)
+			}
+		case <-renewTicker.C():
+			if r.sessionID == "" {
+				continue
+			}
+
+			err := r.client.do("PUT", "/v1/session/renew/"+r.sessionID, nil, nil)
+			if err == nil {
+				continue
+			}
+
+			r.sessionID = ""
+			if acquired {
+				r.logger.Error("lost-lock", err)
+				return ErrLockLost
+			}
+			r.logger.Error("renew-session-failed", err)
+		}
+	}
+}
+
+// acquire creates a session, unless one is still alive, and tries to take
+// the lock with it.
+func (r *lockRunner) acquire() bool {
+	if r.sessionID == "" {
+		var session sessionResponse
+		err := r.client.do("PUT", "/v1/session/create", sessionDefinition{
+			Name:      r.lock.Owner,
+			TTL:       r.lock.TTL.String(),
+			Behavior:  "delete",
+			LockDelay: "0s",
+		}, &session)
+		if err != nil {
+			r.logger.Error("create-session-failed", err)
+			return false
+		}
+		r.sessionID = session.ID
+	}
+
+	var acquired bool
+	err := r.client.do("PUT", "/v1/kv/"+r.lock.Key+"?acquire="+r.sessionID, r.lock.Owner, &acquired)
+	if err != nil {
+		r.logger.Error("acquire-failed", err)
+		return false
+	}
+
+	if acquired {
+		r.logger.Info("acquired-lock")
+	}
+	return acquired
+}
+
+// release gives up the lock, if held, and destroys the session, so that
+// another instance can take over without waiting for the session to expire.
+func (r *lockRunner) release(acquired bool) {
+	if r.sessionID == "" {
+		return
+	}
+
+	if acquired {
+		err := r.client.do("PUT", "/v1/kv/"+r.lock.Key+"?release="+r.sessionID, r.lock.Owner, nil)
+		if err != nil {
+			r.logger.Error("release-failed", err)
+		} else {
+			r.logger.Info("released-lock")
+		}
+	}
+
+	err := r.client.do("PUT", "/v1/session/destroy/"+r.sessionID, nil, nil)
+	if err != nil {
+		r.logger.Error("destroy-session-failed", err)
+	}
+}
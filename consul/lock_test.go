@@ -0,0 +1,123 @@
+package consul_test
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/consul"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("LockRunner", func() {
+	const (
+		ttl           = 10 * time.Second
+		retryInterval = 2 * time.Second
+	)
+
+	var (
+		agent     *ghttp.Server
+		fakeClock *fakeclock.FakeClock
+		process   ifrit.Process
+	)
+
+	createSession := ghttp.CombineHandlers(
+		ghttp.VerifyRequest("PUT", "/v1/session/create"),
+		ghttp.VerifyJSON(`{"Name": "stager-10.0.0.1", "TTL": "10s", "Behavior": "delete", "LockDelay": "0s"}`),
+		ghttp.RespondWith(http.StatusOK, `{"ID": "session-id"}`),
+	)
+
+	acquire := func(acquired string) http.HandlerFunc {
+		return ghttp.CombineHandlers(
+			ghttp.VerifyRequest("PUT", "/v1/kv/v1/locks/stager_lock", "acquire=session-id"),
+			ghttp.VerifyJSON(`"stager-10.0.0.1"`),
+			ghttp.RespondWith(http.StatusOK, acquired),
+		)
+	}
+
+	BeforeEach(func() {
+		agent = ghttp.NewServer()
+		agent.AllowUnhandledRequests = true
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	JustBeforeEach(func() {
+		client := consul.NewClient(agent.URL(), "", &http.Client{})
+		process = ifrit.Background(consul.NewLockRunner(lagertest.NewTestLogger("test"), client, consul.Lock{
+			Key:           "v1/locks/stager_lock",
+			Owner:         "stager-10.0.0.1",
+			TTL:           ttl,
+			RetryInterval: retryInterval,
+		}, fakeClock))
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+		agent.Close()
+	})
+
+	Context("when the lock is free", func() {
+		BeforeEach(func() {
+			agent.AppendHandlers(
+				createSession,
+				acquire("true"),
+				ghttp.VerifyRequest("PUT", "/v1/kv/v1/locks/stager_lock", "release=session-id"),
+				ghttp.VerifyRequest("PUT", "/v1/session/destroy/session-id"),
+			)
+		})
+
+		It("becomes ready, and releases the lock when signaled", func() {
+			Eventually(process.Ready()).Should(BeClosed())
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(agent.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	Context("when another instance holds the lock", func() {
+		BeforeEach(func() {
+			agent.AppendHandlers(
+				createSession,
+				acquire("false"),
+				acquire("true"),
+			)
+		})
+
+		It("waits until it can acquire the lock", func() {
+			Eventually(agent.ReceivedRequests).Should(HaveLen(2))
+			Consistently(process.Ready()).ShouldNot(BeClosed())
+
+			fakeClock.Increment(retryInterval)
+			Eventually(process.Ready()).Should(BeClosed())
+			Expect(agent.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
+	Context("when its session can't be renewed after acquiring the lock", func() {
+		BeforeEach(func() {
+			agent.AppendHandlers(
+				createSession,
+				acquire("true"),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/v1/session/renew/session-id"),
+					ghttp.RespondWith(http.StatusNotFound, ""),
+				),
+			)
+		})
+
+		It("exits, so that another instance can take over", func() {
+			Eventually(process.Ready()).Should(BeClosed())
+
+			fakeClock.Increment(ttl / 2)
+			Eventually(process.Wait()).Should(Receive(Equal(consul.ErrLockLost)))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+package consul
+
+import (
+	"os"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// Registration describes a service instance and the HTTP check that consul
+// runs against it to decide whether it is healthy.
+type Registration struct {
+	ID            string
+	Name          string
+	Address       string
+	Port          int
+	CheckURL      string
+	CheckInterval time.Duration
+}
+
+type serviceDefinition struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Check   checkDefinition
+}
+
+type checkDefinition struct {
+	HTTP     string
+	Interval string
+	Timeout  string
+}
+
+type registrationRunner struct {
+	logger       lager.Logger
+	client       *Client
+	registration Registration
+	clock        clock.Clock
+}
+
+// NewRegistrationRunner returns a runner that registers the service with the
+// agent, retrying every CheckInterval until the agent accepts it, and
+// deregisters it when signaled. The runner is ready straight away, so an
+// unavailable agent never holds up the rest of the process.
+func NewRegistrationRunner(logger lager.Logger, client *Client, registration Registration, clock clock.Clock) ifrit.Runner {
+	return &registrationRunner{
+		logger:       logger.Session("consul-registration", lager.Data{"service-id": registration.ID}),
+		client:       client,
+		registration: registration,
+		clock:        clock,
+	}
+}
+
+func (r *registrationRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	ticker := r.clock.NewTicker(r.registration.CheckInterval)
+	defer ticker.Stop()
+
+	registered := r.register()
+	for {
+		select {
+		case <-signals:
+			if registered {
+				r.deregister()
+			}
+			return nil
+		case <-ticker.C():
+			if !registered {
+				registered = r.register()
+			}
+		}
+	}
+}
+
+func (r *registrationRunner) register() bool {
+	interval := r.registration.CheckInterval.String()
+	err := r.client.do("PUT", "/v1/agent/service/register", serviceDefinition{
+		ID:      r.registration.ID,
+		Name:    r.registration.Name,
+		Address: r.registration.Address,
+		Port:    r.registration.Port,
+		Check: checkDefinition{
+			HTTP:     r.registration.CheckURL,
+			Interval: interval,
+			Timeout:  interval,
+		},
+	}, nil)
+	if err != nil {
+		r.logger.Error("register-failed", err)
+		return false
+	}
+
+	r.logger.Info("registered")
+	return true
+}
+
+func (r *registrationRunner) deregister() {
+	err := r.client.do("PUT", "/v1/agent/service/deregister/"+r.registration.ID, nil, nil)
+	if err != nil {
+		r.logger.Error("deregister-failed", err)
+		return
+	}
+
+	r.logger.Info("deregistered")
+}
@@ -0,0 +1,108 @@
+package consul_test
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/consul"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("RegistrationRunner", func() {
+	const checkInterval = 10 * time.Second
+
+	var (
+		agent     *ghttp.Server
+		fakeClock *fakeclock.FakeClock
+		runner    ifrit.Runner
+		process   ifrit.Process
+	)
+
+	BeforeEach(func() {
+		agent = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		client := consul.NewClient(agent.URL(), "the-token", &http.Client{})
+		runner = consul.NewRegistrationRunner(lagertest.NewTestLogger("test"), client, consul.Registration{
+			ID:            "stager-10.0.0.1-8888",
+			Name:          "stager",
+			Address:       "10.0.0.1",
+			Port:          8888,
+			CheckURL:      "http://10.0.0.1:8888/healthz",
+			CheckInterval: checkInterval,
+		}, fakeClock)
+	})
+
+	JustBeforeEach(func() {
+		process = ifrit.Invoke(runner)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+		agent.Close()
+	})
+
+	registration := ghttp.CombineHandlers(
+		ghttp.VerifyRequest("PUT", "/v1/agent/service/register"),
+		ghttp.VerifyHeader(http.Header{consul.TokenHeader: []string{"the-token"}}),
+		ghttp.VerifyJSON(`{
+			"ID": "stager-10.0.0.1-8888",
+			"Name": "stager",
+			"Address": "10.0.0.1",
+			"Port": 8888,
+			"Check": {"HTTP": "http://10.0.0.1:8888/healthz", "Interval": "10s", "Timeout": "10s"}
+		}`),
+	)
+
+	Context("when the agent accepts the registration", func() {
+		BeforeEach(func() {
+			agent.AppendHandlers(
+				registration,
+				ghttp.VerifyRequest("PUT", "/v1/agent/service/deregister/stager-10.0.0.1-8888"),
+			)
+		})
+
+		It("registers the service with its health check, and deregisters it when signaled", func() {
+			Eventually(agent.ReceivedRequests).Should(HaveLen(1))
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(agent.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+
+	Context("when the agent is unavailable", func() {
+		BeforeEach(func() {
+			agent.AppendHandlers(
+				ghttp.RespondWith(http.StatusInternalServerError, ""),
+				registration,
+			)
+		})
+
+		It("retries every check interval until it registers", func() {
+			Eventually(agent.ReceivedRequests).Should(HaveLen(1))
+
+			fakeClock.Increment(checkInterval)
+			Eventually(agent.ReceivedRequests).Should(HaveLen(2))
+
+			fakeClock.Increment(checkInterval)
+			Consistently(agent.ReceivedRequests).Should(HaveLen(2))
+		})
+
+		It("doesn't deregister a service it never registered", func() {
+			Eventually(agent.ReceivedRequests).Should(HaveLen(1))
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(agent.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+})
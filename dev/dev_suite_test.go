@@ -0,0 +1,13 @@
+package dev_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDev(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dev Suite")
+}
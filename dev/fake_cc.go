@@ -0,0 +1,37 @@
+package dev
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// NewFakeCC returns a handler that accepts staging completion callbacks the
+// way the CC internal API would, logging each staging response it receives.
+func NewFakeCC(logger lager.Logger) http.Handler {
+	logger = logger.Session("fake-cc")
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || !strings.HasPrefix(req.URL.Path, "/internal/") {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		payload, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			logger.Error("read-body-failed", err)
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("staging-response-received", lager.Data{
+			"path":    req.URL.Path,
+			"payload": string(payload),
+		})
+
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte(`{}`))
+	})
+}
@@ -0,0 +1,79 @@
+package dev_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stager/dev"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("FakeCC", func() {
+	var (
+		logger   *lagertest.TestLogger
+		recorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		recorder = httptest.NewRecorder()
+	})
+
+	It("accepts staging completion callbacks", func() {
+		req, err := http.NewRequest("POST", "/internal/staging/the-guid/completed", strings.NewReader(`{"error":null}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		dev.NewFakeCC(logger).ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(logger).To(gbytes.Say("staging-response-received"))
+	})
+
+	It("returns not found for other requests", func() {
+		req, err := http.NewRequest("GET", "/v2/apps", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		dev.NewFakeCC(logger).ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("FileServer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "lifecycles")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = ioutil.WriteFile(filepath.Join(dir, "lifecycle.tgz"), []byte("bundle"), 0644)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("serves lifecycle bundles on the static route", func() {
+		handler, err := dev.NewFileServer(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		req, err := http.NewRequest("GET", "/v1/static/lifecycle.tgz", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("bundle"))
+	})
+})
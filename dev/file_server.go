@@ -0,0 +1,18 @@
+package dev
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/file-server"
+)
+
+// NewFileServer serves lifecycle bundles out of dir on the same static route
+// the Diego file server uses, so backends can build download URLs unchanged.
+func NewFileServer(dir string) (http.Handler, error) {
+	staticPath, err := fileserver.Routes.CreatePathForRoute(fileserver.StaticRoute, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.StripPrefix(staticPath, http.FileServer(http.Dir(dir))), nil
+}
@@ -0,0 +1,163 @@
+package dev
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+// StubStagingResult is reported as the result of every task run by the
+// TaskRunner. It satisfies both the buildpack and docker result formats.
+const StubStagingResult = `{
+	"detected_start_command": {"web": ""},
+	"execution_metadata": "{}"
+}`
+
+const cancelledFailureReason = "task was cancelled"
+
+// TaskRunner is an in-memory stand-in for the BBS. Desired tasks are not
+// run; they complete immediately with StubStagingResult and their completion
+// callback is invoked, exercising the full stager round trip locally.
+//
+// Only Ping and the task methods used by the stager are implemented; calling any
+// other bbs.Client method panics.
+type TaskRunner struct {
+	bbs.Client
+
+	logger     lager.Logger
+	clock      clock.Clock
+	httpClient *http.Client
+
+	lock  sync.Mutex
+	tasks map[string]*models.Task
+}
+
+func NewTaskRunner(logger lager.Logger, clock clock.Clock) *TaskRunner {
+	return &TaskRunner{
+		logger:     logger.Session("task-runner"),
+		clock:      clock,
+		httpClient: &http.Client{},
+		tasks:      map[string]*models.Task{},
+	}
+}
+
+func (r *TaskRunner) Ping() bool {
+	return true
+}
+
+func (r *TaskRunner) DesireTask(taskGuid, domain string, taskDef *models.TaskDefinition) error {
+	r.lock.Lock()
+	if _, ok := r.tasks[taskGuid]; ok {
+		r.lock.Unlock()
+		return models.ErrResourceExists
+	}
+
+	task := &models.Task{
+		TaskDefinition: taskDef,
+		TaskGuid:       taskGuid,
+		Domain:         domain,
+		CreatedAt:      r.clock.Now().UnixNano(),
+		State:          models.Task_Completed,
+		Result:         StubStagingResult,
+	}
+	r.tasks[taskGuid] = task
+	r.lock.Unlock()
+
+	r.logger.Info("desired-task", lager.Data{"task-guid": taskGuid, "domain": domain})
+
+	go r.complete(task)
+	return nil
+}
+
+func (r *TaskRunner) TaskByGuid(taskGuid string) (*models.Task, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	task, ok := r.tasks[taskGuid]
+	if !ok {
+		return nil, models.ErrResourceNotFound
+	}
+	return task, nil
+}
+
+func (r *TaskRunner) CancelTask(taskGuid string) error {
+	r.lock.Lock()
+	task, ok := r.tasks[taskGuid]
+	if ok {
+		task.Failed = true
+		task.FailureReason = cancelledFailureReason
+		task.Result = ""
+	}
+	r.lock.Unlock()
+
+	if !ok {
+		return models.ErrResourceNotFound
+	}
+
+	r.logger.Info("cancelled-task", lager.Data{"task-guid": taskGuid})
+	return nil
+}
+
+func (r *TaskRunner) ResolvingTask(taskGuid string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	task, ok := r.tasks[taskGuid]
+	if !ok {
+		return models.ErrResourceNotFound
+	}
+	task.State = models.Task_Resolving
+	return nil
+}
+
+func (r *TaskRunner) DeleteTask(taskGuid string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.tasks[taskGuid]; !ok {
+		return models.ErrResourceNotFound
+	}
+	delete(r.tasks, taskGuid)
+	return nil
+}
+
+func (r *TaskRunner) complete(task *models.Task) {
+	logger := r.logger.Session("complete", lager.Data{"task-guid": task.TaskGuid})
+
+	r.lock.Lock()
+	callback := &models.TaskCallbackResponse{
+		TaskGuid:      task.TaskGuid,
+		Failed:        task.Failed,
+		FailureReason: task.FailureReason,
+		Result:        task.Result,
+		Annotation:    task.Annotation,
+		CreatedAt:     task.CreatedAt,
+	}
+	callbackURL := task.CompletionCallbackUrl
+	r.lock.Unlock()
+
+	if callbackURL == "" {
+		return
+	}
+
+	payload, err := json.Marshal(callback)
+	if err != nil {
+		logger.Error("marshal-callback-failed", err)
+		return
+	}
+
+	response, err := r.httpClient.Post(callbackURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		logger.Error("callback-failed", err)
+		return
+	}
+	response.Body.Close()
+
+	logger.Info("callback-delivered", lager.Data{"status": response.StatusCode})
+}
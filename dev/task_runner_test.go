@@ -0,0 +1,89 @@
+package dev_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/stager/dev"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("TaskRunner", func() {
+	var (
+		fakeStager *ghttp.Server
+		runner     *dev.TaskRunner
+		taskDef    *models.TaskDefinition
+		callbacks  chan models.TaskCallbackResponse
+	)
+
+	BeforeEach(func() {
+		callbacks = make(chan models.TaskCallbackResponse, 1)
+
+		fakeStager = ghttp.NewServer()
+		fakeStager.RouteToHandler("POST", "/v1/staging/the-guid/completed", func(w http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			var callback models.TaskCallbackResponse
+			Expect(json.Unmarshal(body, &callback)).To(Succeed())
+			callbacks <- callback
+		})
+
+		taskDef = &models.TaskDefinition{
+			Annotation:            `{"lifecycle":"buildpack"}`,
+			CompletionCallbackUrl: fakeStager.URL() + "/v1/staging/the-guid/completed",
+		}
+
+		runner = dev.NewTaskRunner(lagertest.NewTestLogger("test"), fakeclock.NewFakeClock(time.Now()))
+	})
+
+	AfterEach(func() {
+		fakeStager.Close()
+	})
+
+	It("completes desired tasks by calling back the stager", func() {
+		Expect(runner.DesireTask("the-guid", "the-domain", taskDef)).To(Succeed())
+
+		var callback models.TaskCallbackResponse
+		Eventually(callbacks).Should(Receive(&callback))
+		Expect(callback.TaskGuid).To(Equal("the-guid"))
+		Expect(callback.Failed).To(BeFalse())
+		Expect(callback.Result).To(Equal(dev.StubStagingResult))
+		Expect(callback.Annotation).To(Equal(`{"lifecycle":"buildpack"}`))
+	})
+
+	It("rejects tasks that already exist", func() {
+		Expect(runner.DesireTask("the-guid", "the-domain", taskDef)).To(Succeed())
+		Expect(runner.DesireTask("the-guid", "the-domain", taskDef)).To(Equal(models.ErrResourceExists))
+	})
+
+	It("looks up desired tasks", func() {
+		Expect(runner.DesireTask("the-guid", "the-domain", taskDef)).To(Succeed())
+
+		task, err := runner.TaskByGuid("the-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(task.Domain).To(Equal("the-domain"))
+	})
+
+	It("deletes resolved tasks", func() {
+		Expect(runner.DesireTask("the-guid", "the-domain", taskDef)).To(Succeed())
+		Expect(runner.ResolvingTask("the-guid")).To(Succeed())
+		Expect(runner.DeleteTask("the-guid")).To(Succeed())
+
+		_, err := runner.TaskByGuid("the-guid")
+		Expect(err).To(Equal(models.ErrResourceNotFound))
+	})
+
+	It("returns not found for unknown tasks", func() {
+		Expect(runner.CancelTask("unknown")).To(Equal(models.ErrResourceNotFound))
+		Expect(runner.DeleteTask("unknown")).To(Equal(models.ErrResourceNotFound))
+	})
+})
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/metric"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	TaskCleanupNone = "none"
+	// TaskCleanupImmediate deletes tasks as soon as their response is
+	// delivered, racing the BBS, which resolves and deletes the task itself
+	// once the completion callback succeeds. Whichever gets there second finds
+	// the task resolved or gone, which counts as cleaned up.
+	TaskCleanupImmediate = "immediate"
+	TaskCleanupTTL       = "ttl"
+
+	// Metrics
+	completedTasksDeletedCounter       = metric.Counter("StagingTasksDeleted")
+	completedTaskDeletionFailedCounter = metric.Counter("StagingTaskDeletionsFailed")
+)
+
+var ErrInvalidTaskCleanupPolicy = errors.New("task cleanup policy must be one of: none, immediate, ttl")
+
+// CompletedTaskCleaner resolves and deletes staging tasks once their
+// staging response has been delivered to the CC. It must be run as an ifrit
+// process for tasks to be deleted.
+type CompletedTaskCleaner interface {
+	ifrit.Runner
+	Cleanup(logger lager.Logger, taskGuid string)
+}
+
+type pendingDeletion struct {
+	logger   lager.Logger
+	taskGuid string
+	due      time.Time
+}
+
+type completedTaskCleaner struct {
+	bbsClient bbs.Client
+	policy    string
+	ttl       time.Duration
+	clock     clock.Clock
+
+	lock      sync.Mutex
+	deletions []pendingDeletion
+	stopped   bool
+	wake      chan struct{}
+}
+
+func NewCompletedTaskCleaner(bbsClient bbs.Client, policy string, ttl time.Duration, clock clock.Clock) (CompletedTaskCleaner, error) {
+	switch policy {
+	case TaskCleanupNone, TaskCleanupImmediate, TaskCleanupTTL:
+	default:
+		return nil, ErrInvalidTaskCleanupPolicy
+	}
+
+	return &completedTaskCleaner{
+		bbsClient: bbsClient,
+		policy:    policy,
+		ttl:       ttl,
+		clock:     clock,
+		wake:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Cleanup queues the task for deletion, now or once the ttl has elapsed,
+// outside of the callback that delivered its staging response. It never
+// waits for the cleaner, which may be busy with the BBS.
+func (c *completedTaskCleaner) Cleanup(logger lager.Logger, taskGuid string) {
+	deletion := pendingDeletion{logger: logger, taskGuid: taskGuid, due: c.clock.Now()}
+	switch c.policy {
+	case TaskCleanupNone:
+		return
+	case TaskCleanupTTL:
+		deletion.due = deletion.due.Add(c.ttl)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.stopped {
+		logger.Info("task-cleaner-stopped", lager.Data{"task-guid": taskGuid})
+		return
+	}
+	c.deletions = append(c.deletions, deletion)
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeDeletions returns the deletions queued since it was last called, and
+// when stop is set, stops queueing any more.
+func (c *completedTaskCleaner) takeDeletions(stop bool) []pendingDeletion {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	deletions := c.deletions
+	c.deletions = nil
+	c.stopped = c.stopped || stop
+	return deletions
+}
+
+// Run deletes queued tasks as they fall due. On signal, it deletes every
+// queued task, including those whose ttl hasn't elapsed, so that none are
+// left behind in the BBS.
+func (c *completedTaskCleaner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	var queue []pendingDeletion
+
+	close(ready)
+
+	for {
+		now := c.clock.Now()
+		for len(queue) > 0 && !queue[0].due.After(now) {
+			c.deleteTask(queue[0].logger, queue[0].taskGuid)
+			queue = queue[1:]
+		}
+
+		var timer clock.Timer
+		var timeout <-chan time.Time
+		if len(queue) > 0 {
+			timer = c.clock.NewTimer(queue[0].due.Sub(now))
+			timeout = timer.C()
+		}
+
+		select {
+		case <-signals:
+			if timer != nil {
+				timer.Stop()
+			}
+			queue = append(queue, c.takeDeletions(true)...)
+			for _, deletion := range queue {
+				c.deleteTask(deletion.logger, deletion.taskGuid)
+			}
+			return nil
+
+		case <-c.wake:
+			queue = append(queue, c.takeDeletions(false)...)
+
+		case <-timeout:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (c *completedTaskCleaner) deleteTask(logger lager.Logger, taskGuid string) {
+	logger = logger.Session("delete-completed-task", lager.Data{"task-guid": taskGuid})
+
+	err := c.bbsClient.ResolvingTask(taskGuid)
+	if err != nil && !alreadyCleanedUp(err) {
+		logger.Error("failed-to-mark-task-resolving", err)
+		completedTaskDeletionFailedCounter.Increment()
+		return
+	}
+
+	err = c.bbsClient.DeleteTask(taskGuid)
+	if err != nil && !alreadyCleanedUp(err) {
+		logger.Error("failed-to-delete-task", err)
+		completedTaskDeletionFailedCounter.Increment()
+		return
+	}
+
+	logger.Info("deleted-task")
+	completedTasksDeletedCounter.Increment()
+}
+
+// alreadyCleanedUp reports whether err means the BBS itself has resolved or
+// deleted the task, as it does once the completion callback succeeds.
+func alreadyCleanedUp(err error) bool {
+	bbsErr, ok := err.(*models.Error)
+	if !ok {
+		return false
+	}
+	return bbsErr.Type == models.Error_ResourceNotFound || bbsErr.Type == models.Error_InvalidStateTransition
+}
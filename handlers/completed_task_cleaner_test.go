@@ -0,0 +1,192 @@
+package handlers_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/fake_bbs"
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompletedTaskCleaner", func() {
+	var (
+		logger        *lagertest.TestLogger
+		fakeBBSClient *fake_bbs.FakeClient
+		fakeClock     *fakeclock.FakeClock
+		metricSender  *fake.FakeMetricSender
+
+		policy  string
+		cleaner handlers.CompletedTaskCleaner
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		policy = handlers.TaskCleanupNone
+
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		cleaner, err = handlers.NewCompletedTaskCleaner(fakeBBSClient, policy, 30*time.Second, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+
+		process = ifrit.Invoke(cleaner)
+		cleaner.Cleanup(logger, "the-task-guid")
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	Context("when the policy is none", func() {
+		BeforeEach(func() {
+			policy = handlers.TaskCleanupNone
+		})
+
+		It("leaves the task alone", func() {
+			Consistently(fakeBBSClient.ResolvingTaskCallCount).Should(Equal(0))
+			Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the policy is immediate", func() {
+		BeforeEach(func() {
+			policy = handlers.TaskCleanupImmediate
+		})
+
+		It("resolves and deletes the task", func() {
+			Eventually(fakeBBSClient.DeleteTaskCallCount).Should(Equal(1))
+			Expect(fakeBBSClient.DeleteTaskArgsForCall(0)).To(Equal("the-task-guid"))
+			Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(1))
+			Expect(fakeBBSClient.ResolvingTaskArgsForCall(0)).To(Equal("the-task-guid"))
+		})
+
+		It("increments the deleted counter", func() {
+			Eventually(func() uint64 { return metricSender.GetCounter("StagingTasksDeleted") }).Should(BeEquivalentTo(1))
+		})
+
+		Context("when resolving the task fails", func() {
+			BeforeEach(func() {
+				fakeBBSClient.ResolvingTaskReturns(errors.New("boom"))
+			})
+
+			It("does not delete the task", func() {
+				Eventually(fakeBBSClient.ResolvingTaskCallCount).Should(Equal(1))
+				Consistently(fakeBBSClient.DeleteTaskCallCount).Should(Equal(0))
+			})
+
+			It("increments the failure counter", func() {
+				Eventually(func() uint64 { return metricSender.GetCounter("StagingTaskDeletionsFailed") }).Should(BeEquivalentTo(1))
+			})
+		})
+
+		Context("when the BBS is slow", func() {
+			var release chan struct{}
+
+			BeforeEach(func() {
+				release = make(chan struct{})
+				fakeBBSClient.ResolvingTaskStub = func(string) error {
+					<-release
+					return nil
+				}
+			})
+
+			It("queues more tasks without waiting for it", func() {
+				Eventually(fakeBBSClient.ResolvingTaskCallCount).Should(Equal(1))
+
+				queued := make(chan struct{})
+				go func() {
+					cleaner.Cleanup(logger, "another-task-guid")
+					cleaner.Cleanup(logger, "a-third-task-guid")
+					close(queued)
+				}()
+				Eventually(queued).Should(BeClosed())
+
+				close(release)
+				Eventually(fakeBBSClient.DeleteTaskCallCount).Should(Equal(3))
+			})
+		})
+
+		Context("when the BBS has already resolved the task", func() {
+			BeforeEach(func() {
+				fakeBBSClient.ResolvingTaskReturns(&models.Error{Type: models.Error_InvalidStateTransition})
+			})
+
+			It("still deletes it", func() {
+				Eventually(fakeBBSClient.DeleteTaskCallCount).Should(Equal(1))
+				Eventually(func() uint64 { return metricSender.GetCounter("StagingTasksDeleted") }).Should(BeEquivalentTo(1))
+			})
+		})
+
+		Context("when the BBS has already deleted the task", func() {
+			BeforeEach(func() {
+				fakeBBSClient.ResolvingTaskReturns(models.ErrResourceNotFound)
+				fakeBBSClient.DeleteTaskReturns(models.ErrResourceNotFound)
+			})
+
+			It("counts it as deleted", func() {
+				Eventually(func() uint64 { return metricSender.GetCounter("StagingTasksDeleted") }).Should(BeEquivalentTo(1))
+				Expect(metricSender.GetCounter("StagingTaskDeletionsFailed")).To(BeEquivalentTo(0))
+			})
+		})
+
+		Context("when deleting the task fails", func() {
+			BeforeEach(func() {
+				fakeBBSClient.DeleteTaskReturns(errors.New("boom"))
+			})
+
+			It("increments the failure counter", func() {
+				Eventually(func() uint64 { return metricSender.GetCounter("StagingTaskDeletionsFailed") }).Should(BeEquivalentTo(1))
+				Expect(metricSender.GetCounter("StagingTasksDeleted")).To(BeEquivalentTo(0))
+			})
+		})
+	})
+
+	Context("when the policy is ttl", func() {
+		BeforeEach(func() {
+			policy = handlers.TaskCleanupTTL
+		})
+
+		It("deletes the task once the ttl elapses", func() {
+			Consistently(fakeBBSClient.DeleteTaskCallCount).Should(Equal(0))
+
+			fakeClock.Increment(30 * time.Second)
+
+			Eventually(fakeBBSClient.DeleteTaskCallCount).Should(Equal(1))
+			Expect(fakeBBSClient.DeleteTaskArgsForCall(0)).To(Equal("the-task-guid"))
+		})
+
+		It("deletes the task when signalled before the ttl elapses", func() {
+			Consistently(fakeBBSClient.DeleteTaskCallCount).Should(Equal(0))
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+
+			Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(1))
+			Expect(fakeBBSClient.DeleteTaskArgsForCall(0)).To(Equal("the-task-guid"))
+		})
+	})
+
+	Context("when the policy is unknown", func() {
+		It("returns an error", func() {
+			_, err := handlers.NewCompletedTaskCleaner(fakeBBSClient, "sometimes", time.Second, fakeClock)
+			Expect(err).To(Equal(handlers.ErrInvalidTaskCleanupPolicy))
+		})
+	})
+})
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+// drainProgressInterval is how often a drain logs the requests it still
+// waits for.
+const drainProgressInterval = 5 * time.Second
+
+// Drainer tracks the requests being handled so that shutdown can wait for
+// them: staging requests building recipes and desiring tasks, and staging
+// completions delivering their responses to the CC. As an ifrit.Runner, it
+// waits, on being signaled, until they finish or the drain timeout passes.
+type Drainer interface {
+	Track(handler http.Handler) http.Handler
+	InFlight() int
+	Run(signals <-chan os.Signal, ready chan<- struct{}) error
+}
+
+type drainer struct {
+	logger  lager.Logger
+	timeout time.Duration
+	clock   clock.Clock
+
+	lock     sync.Mutex
+	inFlight int
+	drained  chan struct{}
+}
+
+func NewDrainer(logger lager.Logger, timeout time.Duration, clock clock.Clock) Drainer {
+	return &drainer{
+		logger:  logger.Session("drain"),
+		timeout: timeout,
+		clock:   clock,
+	}
+}
+
+func (d *drainer) Track(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		d.start()
+		defer d.finish()
+		handler.ServeHTTP(resp, req)
+	})
+}
+
+func (d *drainer) InFlight() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.inFlight
+}
+
+func (d *drainer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+	<-signals
+
+	timeout := d.clock.NewTimer(d.timeout)
+	defer timeout.Stop()
+	progress := d.clock.NewTicker(drainProgressInterval)
+	defer progress.Stop()
+
+	drained := d.drainedChannel()
+	logger := d.logger.Session("draining", lager.Data{"timeout": d.timeout.String()})
+	logger.Info("started", lager.Data{"in-flight": d.InFlight()})
+
+	for {
+		select {
+		case <-drained:
+			logger.Info("finished")
+			return nil
+		case <-progress.C():
+			logger.Info("waiting", lager.Data{"in-flight": d.InFlight()})
+		case <-timeout.C():
+			logger.Info("timed-out", lager.Data{"in-flight": d.InFlight()})
+			return nil
+		}
+	}
+}
+
+func (d *drainer) start() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.inFlight++
+}
+
+func (d *drainer) finish() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.inFlight--
+	if d.inFlight == 0 && d.drained != nil {
+		close(d.drained)
+		d.drained = nil
+	}
+}
+
+// drainedChannel returns a channel that is closed once no requests are in
+// flight.
+func (d *drainer) drainedChannel() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	drained := make(chan struct{})
+	if d.inFlight == 0 {
+		close(drained)
+	} else {
+		d.drained = drained
+	}
+	return drained
+}
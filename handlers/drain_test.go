@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Drainer", func() {
+	const drainTimeout = 30 * time.Second
+
+	var (
+		logger    *lagertest.TestLogger
+		fakeClock *fakeclock.FakeClock
+		drainer   handlers.Drainer
+		process   ifrit.Process
+
+		release chan struct{}
+		handled chan struct{}
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		drainer = handlers.NewDrainer(logger, drainTimeout, fakeClock)
+		process = ifrit.Invoke(drainer)
+
+		release = make(chan struct{})
+		handled = make(chan struct{})
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	handleRequest := func() {
+		blocking := drainer.Track(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+
+		go func() {
+			defer GinkgoRecover()
+			blocking.ServeHTTP(httptest.NewRecorder(), &http.Request{})
+			close(handled)
+		}()
+
+		Eventually(drainer.InFlight).Should(Equal(1))
+	}
+
+	It("exits straight away when no requests are in flight", func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(logger).To(gbytes.Say("draining.finished"))
+	})
+
+	It("waits for the requests in flight to finish", func() {
+		handleRequest()
+
+		process.Signal(os.Interrupt)
+		Eventually(logger).Should(gbytes.Say("draining.started.*\"in-flight\":1"))
+		Consistently(process.Wait()).ShouldNot(Receive())
+
+		close(release)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Eventually(handled).Should(BeClosed())
+		Expect(drainer.InFlight()).To(BeZero())
+	})
+
+	It("logs its progress, and gives up after the drain timeout", func() {
+		handleRequest()
+		defer close(release)
+
+		process.Signal(os.Interrupt)
+		Eventually(logger).Should(gbytes.Say("draining.started"))
+
+		fakeClock.Increment(5 * time.Second)
+		Eventually(logger).Should(gbytes.Say("draining.waiting.*\"in-flight\":1"))
+
+		fakeClock.Increment(drainTimeout)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(logger).To(gbytes.Say("draining.timed-out"))
+	})
+})
@@ -2,25 +2,73 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/cloudfoundry-incubator/bbs"
 	"github.com/cloudfoundry-incubator/stager"
+	"github.com/cloudfoundry-incubator/stager/authz"
 	"github.com/cloudfoundry-incubator/stager/backend"
-	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/health"
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/nats_emitter"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/cloudfoundry-incubator/stager/staging_logs"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
 	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
 	"github.com/tedsuo/rata"
 )
 
-func New(logger lager.Logger, ccClient cc_client.CcClient, bbsClient bbs.Client, backends map[string]backend.Backend, clock clock.Clock) http.Handler {
+// Config holds what the stager's handlers are built from.
+type Config struct {
+	Notifier          StagingCompletedNotifier
+	BBSClient         bbs.Client
+	TaskDomains       []string
+	Backends          map[string]backend.Backend
+	TaskCleaner       CompletedTaskCleaner
+	StagingHistory    history.History
+	Publisher         webhooks.Publisher
+	NatsEmitter       nats_emitter.Emitter
+	LogFetcher        staging_logs.Fetcher
+	CallbackTimeout   time.Duration
+	RestageController restage.Controller
+	Authorizer        authz.Authorizer
+	HealthChecks      map[string]health.Checker
+	// PendingRetries reports how many staging responses are waiting to be
+	// re-delivered to the CC.
+	PendingRetries func() int
+	Info           func() Info
+	Clock          clock.Clock
+}
 
-	stagingHandler := NewStagingHandler(logger, backends, ccClient, bbsClient)
-	stagingCompletedHandler := NewStagingCompletionHandler(logger, ccClient, backends, clock)
+func New(logger lager.Logger, config Config) http.Handler {
+	stagingHandler := NewStagingHandler(logger, config.Backends, config.Notifier, config.BBSClient, config.Publisher, config.Clock)
+	stagingCompletedHandler := NewStagingCompletionHandler(logger, config)
+	resendHandler := NewResendHandler(logger, config.BBSClient, config.TaskDomains, config.Backends, config.Notifier, config.CallbackTimeout)
+	restageHandler := NewRestageHandler(logger, config.RestageController)
+	stateHandler := NewStateHandler(config.RestageController, config.StagingHistory)
+	healthHandler := NewHealthHandler(logger, config.HealthChecks, config.PendingRetries)
+	infoHandler := NewInfoHandler(config.Info)
+	authorizer := config.Authorizer
 
 	actions := rata.Handlers{
 		stager.StageRoute:            http.HandlerFunc(stagingHandler.Stage),
 		stager.StopStagingRoute:      http.HandlerFunc(stagingHandler.StopStaging),
 		stager.StagingCompletedRoute: http.HandlerFunc(stagingCompletedHandler.StagingComplete),
+
+		stager.ResendStagingResponseRoute: authorizer.Require(authz.RoleOperator, http.HandlerFunc(resendHandler.ResendStagingResponse)),
+
+		stager.SubmitRestageCampaignRoute: authorizer.Require(authz.RoleOperator, http.HandlerFunc(restageHandler.SubmitCampaign)),
+		stager.RestageCampaignRoute:       authorizer.Require(authz.RoleViewer, http.HandlerFunc(restageHandler.CampaignProgress)),
+		stager.LifecycleChangesRoute:      authorizer.Require(authz.RoleViewer, http.HandlerFunc(restageHandler.LifecycleChanges)),
+
+		stager.ExportStateRoute:   authorizer.Require(authz.RoleOperator, http.HandlerFunc(stateHandler.Export)),
+		stager.HandOverStateRoute: authorizer.Require(authz.RoleOperator, http.HandlerFunc(stateHandler.HandOver)),
+
+		stager.InfoRoute: http.HandlerFunc(infoHandler.Info),
+
+		stager.HealthzRoute: http.HandlerFunc(healthHandler.Healthz),
+		stager.ReadyzRoute:  http.HandlerFunc(healthHandler.Readyz),
 	}
 
 	handler, err := rata.NewRouter(stager.Routes, actions)
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/cloudfoundry-incubator/stager/health"
+	"github.com/pivotal-golang/lager"
+)
+
+const healthCheckPassed = "ok"
+
+type HealthHandler interface {
+	Healthz(resp http.ResponseWriter, req *http.Request)
+	Readyz(resp http.ResponseWriter, req *http.Request)
+}
+
+type ReadinessResponse struct {
+	Ready          bool              `json:"ready"`
+	Checks         map[string]string `json:"checks"`
+	PendingRetries *int              `json:"pending_retries,omitempty"`
+}
+
+type healthHandler struct {
+	logger         lager.Logger
+	checks         map[string]health.Checker
+	pendingRetries func() int
+}
+
+// NewHealthHandler serves liveness and readiness probes. Readiness runs every
+// check; pendingRetries, if non-nil, reports how many staging responses are
+// waiting to be redelivered.
+func NewHealthHandler(logger lager.Logger, checks map[string]health.Checker, pendingRetries func() int) HealthHandler {
+	return &healthHandler{
+		logger:         logger.Session("health-handler"),
+		checks:         checks,
+		pendingRetries: pendingRetries,
+	}
+}
+
+func (handler *healthHandler) Healthz(resp http.ResponseWriter, req *http.Request) {
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (handler *healthHandler) Readyz(resp http.ResponseWriter, req *http.Request) {
+	response := ReadinessResponse{
+		Ready:  true,
+		Checks: map[string]string{},
+	}
+
+	names := make([]string, 0, len(handler.checks))
+	for name := range handler.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := handler.checks[name]()
+		if err != nil {
+			handler.logger.Info("check-failed", lager.Data{"check": name, "error": err.Error()})
+			response.Ready = false
+			response.Checks[name] = err.Error()
+			continue
+		}
+		response.Checks[name] = healthCheckPassed
+	}
+
+	if handler.pendingRetries != nil {
+		pending := handler.pendingRetries()
+		response.PendingRetries = &pending
+	}
+
+	if !response.Ready {
+		writeJSONWithStatus(resp, http.StatusServiceUnavailable, response)
+		return
+	}
+	writeJSON(resp, response)
+}
@@ -0,0 +1,135 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry-incubator/stager/health"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HealthHandler", func() {
+	var (
+		checks           map[string]health.Checker
+		pendingRetries   func() int
+		responseRecorder *httptest.ResponseRecorder
+		handler          handlers.HealthHandler
+	)
+
+	BeforeEach(func() {
+		checks = map[string]health.Checker{
+			"bbs": func() error { return nil },
+			"cc":  func() error { return nil },
+		}
+		pendingRetries = nil
+		responseRecorder = httptest.NewRecorder()
+	})
+
+	JustBeforeEach(func() {
+		handler = handlers.NewHealthHandler(lagertest.NewTestLogger("test"), checks, pendingRetries)
+	})
+
+	Describe("Healthz", func() {
+		It("responds with 200", func() {
+			handler.Healthz(responseRecorder, &http.Request{})
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("Readyz", func() {
+		JustBeforeEach(func() {
+			handler.Readyz(responseRecorder, &http.Request{})
+		})
+
+		Context("when every check passes", func() {
+			It("responds with 200 and the check results", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(MatchJSON(`{
+					"ready": true,
+					"checks": {"bbs": "ok", "cc": "ok"}
+				}`))
+			})
+		})
+
+		Context("when a check fails", func() {
+			BeforeEach(func() {
+				checks["cc"] = func() error { return errors.New("connection refused") }
+			})
+
+			It("responds with 503 and reports the failure", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(responseRecorder.Body.String()).To(MatchJSON(`{
+					"ready": false,
+					"checks": {"bbs": "ok", "cc": "connection refused"}
+				}`))
+			})
+		})
+
+		Context("when pending retries are reported", func() {
+			BeforeEach(func() {
+				pendingRetries = func() int { return 7 }
+			})
+
+			It("includes the queue depth", func() {
+				Expect(responseRecorder.Body.String()).To(MatchJSON(`{
+					"ready": true,
+					"checks": {"bbs": "ok", "cc": "ok"},
+					"pending_retries": 7
+				}`))
+			})
+		})
+
+		Context("when the CC client is waiting to retry a staging response", func() {
+			var (
+				fakeCC    *ghttp.Server
+				cancel    context.CancelFunc
+				delivered chan error
+			)
+
+			BeforeEach(func() {
+				fakeCC = ghttp.NewServer()
+				fakeCC.RouteToHandler("POST", "/internal/staging/the-staging-guid/completed", ghttp.RespondWith(503, `{}`))
+
+				ccClient := cc_client.NewCcClient(cc_client.Config{
+					BaseURI: fakeCC.URL(),
+					Retry:   cc_client.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Minute, MaxBackoff: time.Minute},
+					Clock:   fakeclock.NewFakeClock(time.Now()),
+				})
+
+				var ctx context.Context
+				ctx, cancel = context.WithCancel(context.Background())
+				delivered = make(chan error, 1)
+				go func() {
+					delivered <- ccClient.StagingComplete(ctx, "the-staging-guid", []byte(`{}`), lagertest.NewTestLogger("test"))
+				}()
+
+				Eventually(cc_client.PendingRetries).Should(Equal(1))
+				pendingRetries = cc_client.PendingRetries
+			})
+
+			AfterEach(func() {
+				cancel()
+				Eventually(delivered).Should(Receive())
+				fakeCC.Close()
+			})
+
+			It("reports it as pending", func() {
+				var response handlers.ReadinessResponse
+				Expect(json.Unmarshal(responseRecorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.PendingRetries).NotTo(BeNil())
+				Expect(*response.PendingRetries).To(Equal(1))
+			})
+		})
+	})
+})
@@ -0,0 +1,31 @@
+package handlers
+
+import "net/http"
+
+// Info describes the stager build and what it can stage, so that operators
+// can audit their stagers and the CC can tell what they support.
+type Info struct {
+	Version               string   `json:"version"`
+	GitSHA                string   `json:"git_sha"`
+	Lifecycles            []string `json:"lifecycles"`
+	LifecycleBundles      []string `json:"lifecycle_bundles"`
+	CompletionAPIVersions []string `json:"completion_api_versions"`
+}
+
+type InfoHandler interface {
+	Info(resp http.ResponseWriter, req *http.Request)
+}
+
+type infoHandler struct {
+	info func() Info
+}
+
+// NewInfoHandler serves the Info that info returns, which may change as the
+// stager's configuration is reloaded.
+func NewInfoHandler(info func() Info) InfoHandler {
+	return &infoHandler{info: info}
+}
+
+func (handler *infoHandler) Info(resp http.ResponseWriter, req *http.Request) {
+	writeJSON(resp, handler.info())
+}
@@ -0,0 +1,36 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/cloudfoundry-incubator/stager/handlers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InfoHandler", func() {
+	It("serves the stager's info as JSON", func() {
+		responseRecorder := httptest.NewRecorder()
+		handlers.NewInfoHandler(func() handlers.Info {
+			return handlers.Info{
+				Version:               "1.2.3",
+				GitSHA:                "abc123",
+				Lifecycles:            []string{"buildpack", "docker"},
+				LifecycleBundles:      []string{"buildpack/cflinuxfs2", "docker"},
+				CompletionAPIVersions: []string{"v2", "v3"},
+			}
+		}).Info(responseRecorder, &http.Request{})
+
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(responseRecorder.Body.String()).To(MatchJSON(`{
+			"version": "1.2.3",
+			"git_sha": "abc123",
+			"lifecycles": ["buildpack", "docker"],
+			"lifecycle_bundles": ["buildpack/cflinuxfs2", "docker"],
+			"completion_api_versions": ["v2", "v3"]
+		}`))
+	})
+})
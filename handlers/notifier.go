@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+// StagingCompletedNotifier is told the outcome of each staging task. The
+// handlers depend only on this interface, so embedders can deliver staging
+// responses somewhere other than the CC, such as a message bus. A
+// cc_client.CcClient is a StagingCompletedNotifier.
+type StagingCompletedNotifier interface {
+	StagingComplete(ctx context.Context, stagingGuid string, payload []byte, logger lager.Logger) error
+	BuildComplete(ctx context.Context, buildGuid string, payload []byte, logger lager.Logger) error
+}
+
+var _ StagingCompletedNotifier = cc_client.CcClient(nil)
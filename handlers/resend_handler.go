@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
+)
+
+type ResendHandler interface {
+	ResendStagingResponse(resp http.ResponseWriter, req *http.Request)
+}
+
+type resendHandler struct {
+	logger      lager.Logger
+	diegoClient bbs.Client
+	taskDomains []string
+	backends    map[string]backend.Backend
+	notifier    StagingCompletedNotifier
+	timeout     time.Duration
+}
+
+// NewResendHandler returns a handler that re-delivers the staging response
+// of a completed task to the CC, for apps left in "staging" when the CC
+// failed to process the original delivery. Only tasks in one of the
+// stager's task domains are re-delivered.
+func NewResendHandler(logger lager.Logger, bbsClient bbs.Client, taskDomains []string, backends map[string]backend.Backend, notifier StagingCompletedNotifier, timeout time.Duration) ResendHandler {
+	return &resendHandler{
+		logger:      logger.Session("resend-handler"),
+		diegoClient: bbsClient,
+		taskDomains: taskDomains,
+		backends:    backends,
+		notifier:    notifier,
+		timeout:     timeout,
+	}
+}
+
+func (handler *resendHandler) ResendStagingResponse(resp http.ResponseWriter, req *http.Request) {
+	stagingGuid := req.FormValue(":staging_guid")
+	logger := handler.logger.Session("resend-staging-response", lager.Data{"staging-guid": stagingGuid})
+
+	task, err := handler.diegoClient.TaskByGuid(stagingGuid)
+	if err != nil {
+		if models.ErrResourceNotFound.Equal(err) {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Error("failed-to-get-task", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Another stager sharing the BBS owns the task, and may have delivered
+	// its response to a different CC.
+	if !inTaskDomains(task, handler.taskDomains) {
+		logger.Info("rejecting-foreign-task", lager.Data{"domain": task.Domain, "expected-domains": handler.taskDomains})
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if task.State != models.Task_Completed && task.State != models.Task_Resolving {
+		logger.Info("task-not-completed", lager.Data{"state": task.State})
+		resp.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	var annotation cc_messages.StagingTaskAnnotation
+	err = json.Unmarshal([]byte(task.Annotation), &annotation)
+	if err != nil {
+		logger.Error("parsing-annotation-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	completionAPI := backend.CompletionAPIFor(task.CompletionCallbackUrl)
+
+	backend := handler.backends[annotation.Lifecycle]
+	if backend == nil {
+		logger.Error("backend-not-found", ErrBackendNotFound, lager.Data{"backend": annotation.Lifecycle})
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response, err := backend.BuildStagingResponse(&models.TaskCallbackResponse{
+		TaskGuid:      task.TaskGuid,
+		Failed:        task.Failed,
+		FailureReason: task.FailureReason,
+		Result:        task.Result,
+		Annotation:    task.Annotation,
+		CreatedAt:     task.CreatedAt,
+	})
+	if err != nil {
+		logger.Error("get-staging-response-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response = validatedStagingResponse(logger, response)
+
+	responseJson, err := marshalStagingResponse(completionAPI, annotation.Lifecycle, response)
+	if err != nil {
+		logger.Error("get-staging-response-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handler.timeout)
+	defer cancel()
+
+	err = deliverStagingResponse(ctx, handler.notifier, task.CompletionCallbackUrl, stagingGuid, responseJson, logger)
+	if err != nil {
+		logger.Error("cc-staging-complete-failed", err)
+		if responseErr, ok := err.(*cc_client.BadResponseError); ok {
+			resp.WriteHeader(responseErr.StatusCode)
+		} else {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	logger.Info("resent-staging-response")
+	resp.WriteHeader(http.StatusOK)
+}
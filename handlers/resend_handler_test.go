@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/fake_bbs"
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/backend"
+	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/cc_client/fakes"
+	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResendHandler", func() {
+	var (
+		fakeBBSClient    *fake_bbs.FakeClient
+		fakeBackend      *fake_backend.FakeBackend
+		fakeCCClient     *fakes.FakeCcClient
+		responseRecorder *httptest.ResponseRecorder
+		task             *models.Task
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeBackend = &fake_backend.FakeBackend{}
+		fakeCCClient = &fakes.FakeCcClient{}
+		responseRecorder = httptest.NewRecorder()
+
+		task = &models.Task{
+			TaskGuid: "the-staging-guid",
+			Domain:   "the-domain",
+			State:    models.Task_Completed,
+			Result:   `{"result": true}`,
+			TaskDefinition: &models.TaskDefinition{
+				Annotation: `{"lifecycle": "fake"}`,
+			},
+		}
+		fakeBBSClient.TaskByGuidReturns(task, nil)
+		fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{ExecutionMetadata: "metadata"}, nil)
+	})
+
+	JustBeforeEach(func() {
+		handler := handlers.NewResendHandler(lagertest.NewTestLogger("test"), fakeBBSClient, []string{"the-domain"}, map[string]backend.Backend{"fake": fakeBackend}, fakeCCClient, time.Minute)
+
+		req, err := http.NewRequest("POST", "/v1/staging/the-staging-guid/resend", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Form = url.Values{":staging_guid": {"the-staging-guid"}}
+
+		handler.ResendStagingResponse(responseRecorder, req)
+	})
+
+	It("rebuilds the staging response from the completed task", func() {
+		Expect(fakeBBSClient.TaskByGuidArgsForCall(0)).To(Equal("the-staging-guid"))
+
+		Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(1))
+		callback := fakeBackend.BuildStagingResponseArgsForCall(0)
+		Expect(callback.TaskGuid).To(Equal("the-staging-guid"))
+		Expect(callback.Result).To(Equal(`{"result": true}`))
+		Expect(callback.Annotation).To(Equal(`{"lifecycle": "fake"}`))
+	})
+
+	It("re-delivers it to the CC", func() {
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+		Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+		_, guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+		Expect(guid).To(Equal("the-staging-guid"))
+
+		var response cc_messages.StagingResponseForCC
+		Expect(json.Unmarshal(payload, &response)).To(Succeed())
+		Expect(response.ExecutionMetadata).To(Equal("metadata"))
+	})
+
+	Context("when the task does not exist", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TaskByGuidReturns(nil, models.ErrResourceNotFound)
+		})
+
+		It("responds with 404", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task belongs to another stager's task domain", func() {
+		BeforeEach(func() {
+			task.Domain = "another-domain"
+		})
+
+		It("responds with 404", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task has not completed", func() {
+		BeforeEach(func() {
+			task.State = models.Task_Running
+		})
+
+		It("responds with 409", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusConflict))
+			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the CC rejects the response", func() {
+		BeforeEach(func() {
+			fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: 500})
+		})
+
+		It("responds with the CC's status code", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Context("when the CC cannot be reached", func() {
+		BeforeEach(func() {
+			fakeCCClient.StagingCompleteReturns(errors.New("boom"))
+		})
+
+		It("responds with 503", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
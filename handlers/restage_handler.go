@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/pivotal-golang/lager"
+)
+
+type RestageHandler interface {
+	SubmitCampaign(resp http.ResponseWriter, req *http.Request)
+	CampaignProgress(resp http.ResponseWriter, req *http.Request)
+	LifecycleChanges(resp http.ResponseWriter, req *http.Request)
+}
+
+type restageHandler struct {
+	logger     lager.Logger
+	controller restage.Controller
+}
+
+type LifecycleChangesResponse struct {
+	ChangedLifecycles []string `json:"changed_lifecycles"`
+}
+
+func NewRestageHandler(logger lager.Logger, controller restage.Controller) RestageHandler {
+	return &restageHandler{
+		logger:     logger.Session("restage-handler"),
+		controller: controller,
+	}
+}
+
+func (handler *restageHandler) SubmitCampaign(resp http.ResponseWriter, req *http.Request) {
+	campaignId := req.FormValue(":campaign_id")
+	logger := handler.logger.Session("submit-campaign", lager.Data{"campaign-id": campaignId})
+
+	requestBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logger.Error("read-body-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var campaign restage.Campaign
+	err = json.Unmarshal(requestBody, &campaign)
+	if err != nil {
+		logger.Error("unmarshal-request-failed", err)
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	campaign.Id = campaignId
+
+	err = handler.controller.Submit(campaign)
+	switch err {
+	case nil:
+		resp.WriteHeader(http.StatusAccepted)
+	case restage.ErrCampaignExists:
+		resp.WriteHeader(http.StatusConflict)
+	case restage.ErrMissingCampaignId, restage.ErrUnknownLifecycle:
+		logger.Error("invalid-campaign", err)
+		resp.WriteHeader(http.StatusBadRequest)
+	default:
+		logger.Error("submit-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (handler *restageHandler) CampaignProgress(resp http.ResponseWriter, req *http.Request) {
+	campaignId := req.FormValue(":campaign_id")
+
+	progress, ok := handler.controller.Progress(campaignId)
+	if !ok {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeJSON(resp, progress)
+}
+
+func (handler *restageHandler) LifecycleChanges(resp http.ResponseWriter, req *http.Request) {
+	writeJSON(resp, LifecycleChangesResponse{
+		ChangedLifecycles: handler.controller.ChangedLifecycles(),
+	})
+}
+
+func writeJSON(resp http.ResponseWriter, body interface{}) {
+	writeJSONWithStatus(resp, http.StatusOK, body)
+}
+
+func writeJSONWithStatus(resp http.ResponseWriter, status int, body interface{}) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(payload)
+}
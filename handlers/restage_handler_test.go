@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	restage_fakes "github.com/cloudfoundry-incubator/stager/restage/fakes"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RestageHandler", func() {
+	var (
+		fakeController   *restage_fakes.FakeController
+		responseRecorder *httptest.ResponseRecorder
+		handler          handlers.RestageHandler
+	)
+
+	BeforeEach(func() {
+		fakeController = &restage_fakes.FakeController{}
+		responseRecorder = httptest.NewRecorder()
+		handler = handlers.NewRestageHandler(lagertest.NewTestLogger("test"), fakeController)
+	})
+
+	Describe("SubmitCampaign", func() {
+		var body []byte
+
+		BeforeEach(func() {
+			body = []byte(`{"lifecycle": "buildpack/cflinuxfs2", "apps": [{"staging_guid": "app-1"}]}`)
+		})
+
+		JustBeforeEach(func() {
+			req, err := http.NewRequest("PUT", "/v1/restage_campaigns/the-campaign", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			req.Form = url.Values{":campaign_id": {"the-campaign"}}
+
+			handler.SubmitCampaign(responseRecorder, req)
+		})
+
+		It("submits the campaign with the id from the path", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+			Expect(fakeController.SubmitCallCount()).To(Equal(1))
+
+			campaign := fakeController.SubmitArgsForCall(0)
+			Expect(campaign.Id).To(Equal("the-campaign"))
+			Expect(campaign.Lifecycle).To(Equal("buildpack/cflinuxfs2"))
+			Expect(campaign.Apps).To(HaveLen(1))
+			Expect(campaign.Apps[0].StagingGuid).To(Equal("app-1"))
+		})
+
+		Context("when the body is malformed", func() {
+			BeforeEach(func() {
+				body = []byte("{")
+			})
+
+			It("responds with 400", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+				Expect(fakeController.SubmitCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the lifecycle is unknown", func() {
+			BeforeEach(func() {
+				fakeController.SubmitReturns(restage.ErrUnknownLifecycle)
+			})
+
+			It("responds with 400", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("when the campaign already exists", func() {
+			BeforeEach(func() {
+				fakeController.SubmitReturns(restage.ErrCampaignExists)
+			})
+
+			It("responds with 409", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusConflict))
+			})
+		})
+	})
+
+	Describe("CampaignProgress", func() {
+		JustBeforeEach(func() {
+			req, err := http.NewRequest("GET", "/v1/restage_campaigns/the-campaign", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Form = url.Values{":campaign_id": {"the-campaign"}}
+
+			handler.CampaignProgress(responseRecorder, req)
+		})
+
+		Context("when the campaign exists", func() {
+			BeforeEach(func() {
+				fakeController.ProgressReturns(restage.Progress{Id: "the-campaign", Total: 3, Submitted: 1}, true)
+			})
+
+			It("returns the progress", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(fakeController.ProgressArgsForCall(0)).To(Equal("the-campaign"))
+
+				var progress restage.Progress
+				Expect(json.Unmarshal(responseRecorder.Body.Bytes(), &progress)).To(Succeed())
+				Expect(progress).To(Equal(restage.Progress{Id: "the-campaign", Total: 3, Submitted: 1}))
+			})
+		})
+
+		Context("when the campaign does not exist", func() {
+			It("responds with 404", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("LifecycleChanges", func() {
+		BeforeEach(func() {
+			fakeController.ChangedLifecyclesReturns([]string{"buildpack/cflinuxfs2"})
+		})
+
+		It("returns the changed lifecycles", func() {
+			req, err := http.NewRequest("GET", "/v1/lifecycle_changes", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.LifecycleChanges(responseRecorder, req)
+
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(responseRecorder.Body.String()).To(MatchJSON(`{"changed_lifecycles": ["buildpack/cflinuxfs2"]}`))
+		})
+	})
+})
@@ -5,13 +5,19 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/cloudfoundry-incubator/bbs"
 	"github.com/cloudfoundry-incubator/bbs/models"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 	"github.com/cloudfoundry-incubator/runtime-schema/metric"
 	"github.com/cloudfoundry-incubator/stager/backend"
 	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/nats_emitter"
+	"github.com/cloudfoundry-incubator/stager/staging_logs"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
 	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -22,23 +28,48 @@ const (
 	stagingFailureDuration = metric.Duration("StagingRequestFailedDuration")
 )
 
+// invalidStagingResultResponse is delivered when a task's result cannot be
+// turned into a staging response, so that the app doesn't stay "staging".
+var invalidStagingResultResponse = cc_messages.StagingResponseForCC{
+	Error: &cc_messages.StagingError{
+		Id:      backend.InvalidStagingResultErrorId,
+		Message: "staging result could not be parsed",
+	},
+}
+
 type CompletionHandler interface {
 	StagingComplete(resp http.ResponseWriter, req *http.Request)
 }
 
 type completionHandler struct {
-	ccClient cc_client.CcClient
-	backends map[string]backend.Backend
-	logger   lager.Logger
-	clock    clock.Clock
+	notifier       StagingCompletedNotifier
+	bbsClient      bbs.Client
+	taskDomains    []string
+	backends       map[string]backend.Backend
+	taskCleaner    CompletedTaskCleaner
+	stagingHistory history.History
+	publisher      webhooks.Publisher
+	natsEmitter    nats_emitter.Emitter
+	logFetcher     staging_logs.Fetcher
+	timeout        time.Duration
+	logger         lager.Logger
+	clock          clock.Clock
 }
 
-func NewStagingCompletionHandler(logger lager.Logger, ccClient cc_client.CcClient, backends map[string]backend.Backend, clock clock.Clock) CompletionHandler {
+func NewStagingCompletionHandler(logger lager.Logger, config Config) CompletionHandler {
 	return &completionHandler{
-		ccClient: ccClient,
-		backends: backends,
-		logger:   logger.Session("completion-handler"),
-		clock:    clock,
+		notifier:       config.Notifier,
+		bbsClient:      config.BBSClient,
+		taskDomains:    config.TaskDomains,
+		backends:       config.Backends,
+		taskCleaner:    config.TaskCleaner,
+		stagingHistory: config.StagingHistory,
+		publisher:      config.Publisher,
+		natsEmitter:    config.NatsEmitter,
+		logFetcher:     config.LogFetcher,
+		timeout:        config.CallbackTimeout,
+		logger:         logger.Session("completion-handler"),
+		clock:          config.Clock,
 	}
 }
 
@@ -48,6 +79,9 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		"guid": taskGuid,
 	})
 
+	ctx, cancel := handler.callbackContext(res)
+	defer cancel()
+
 	task := &models.TaskCallbackResponse{}
 	err := json.NewDecoder(req.Body).Decode(task)
 	if err != nil {
@@ -62,6 +96,20 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		return
 	}
 
+	if owned, status := handler.ownsTask(logger, taskGuid); !owned {
+		res.WriteHeader(status)
+		return
+	}
+
+	// The BBS replays callbacks whose response it didn't see, e.g. when this
+	// stager or the one it replaced stopped mid-callback.
+	if handler.stagingHistory.WasDelivered(taskGuid) {
+		logger.Info("staging-response-already-delivered")
+		res.WriteHeader(http.StatusOK)
+		handler.taskCleaner.Cleanup(logger, taskGuid)
+		return
+	}
+
 	var annotation cc_messages.StagingTaskAnnotation
 	err = json.Unmarshal([]byte(task.Annotation), &annotation)
 	if err != nil {
@@ -70,6 +118,8 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		return
 	}
 
+	completionAPI := backend.CompletionAPIFor(req.URL.String())
+
 	backend := handler.backends[annotation.Lifecycle]
 	if backend == nil {
 		res.WriteHeader(http.StatusNotFound)
@@ -79,12 +129,17 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 
 	response, err := backend.BuildStagingResponse(task)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("get-staging-response-failed", err)
-		return
+		response = invalidStagingResultResponse
 	}
 
-	responseJson, err := json.Marshal(response)
+	if response.Error != nil {
+		response.Error = handler.withLogExcerpt(logger, taskGuid, response.Error)
+	}
+
+	response = validatedStagingResponse(logger, response)
+
+	responseJson, err := marshalStagingResponse(completionAPI, annotation.Lifecycle, response)
 	if err != nil {
 		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("get-staging-response-failed", err)
@@ -98,12 +153,15 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 	}
 
 	logger.Info("posting-staging-complete", lager.Data{
-		"payload": responseJson,
+		"payload":        cc_client.RedactPayload(responseJson),
+		"completion-api": completionAPI,
 	})
 
-	err = handler.ccClient.StagingComplete(taskGuid, responseJson, logger)
+	handler.stagingHistory.Pending(taskGuid)
+
+	err = deliverStagingResponse(ctx, handler.notifier, req.URL.String(), taskGuid, responseJson, logger)
 	if err != nil {
-		logger.Error("cc-staging-complete-failed", err)
+		logger.Error("cc-staging-complete-failed", err, lager.Data{"context-error": ctx.Err()})
 		if responseErr, ok := err.(*cc_client.BadResponseError); ok {
 			res.WriteHeader(responseErr.StatusCode)
 		} else {
@@ -112,10 +170,102 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		return
 	}
 
+	handler.stagingHistory.Delivered(taskGuid)
+
 	handler.reportMetrics(task)
+	handler.publishCompletion(logger, taskGuid, annotation.Lifecycle, response)
+	handler.natsEmitter.EmitStagingFinished(logger, taskGuid, response)
 
 	logger.Info("posted-staging-complete")
 	res.WriteHeader(http.StatusOK)
+
+	handler.taskCleaner.Cleanup(logger, taskGuid)
+}
+
+// ownsTask reports whether the task is in one of this stager's task domains,
+// so that stagers sharing a BBS don't process each other's callbacks. If not,
+// it also returns the status to reject the callback with. The BBS retries
+// callbacks that fail with 503, giving the stager that owns the task's domain,
+// or a BBS that was briefly unavailable, another chance. A task that is no
+// longer in the BBS has nothing left to deliver, so its callback gets a 404.
+func (handler *completionHandler) ownsTask(logger lager.Logger, taskGuid string) (bool, int) {
+	task, err := handler.bbsClient.TaskByGuid(taskGuid)
+	if models.ErrResourceNotFound.Equal(err) {
+		logger.Info("task-not-found")
+		return false, http.StatusNotFound
+	}
+	if err != nil {
+		logger.Error("failed-to-get-task", err)
+		return false, http.StatusServiceUnavailable
+	}
+
+	if inTaskDomains(task, handler.taskDomains) {
+		return true, http.StatusOK
+	}
+
+	logger.Info("rejecting-foreign-task", lager.Data{"domain": task.Domain, "expected-domains": handler.taskDomains})
+	return false, http.StatusServiceUnavailable
+}
+
+func inTaskDomains(task *models.Task, taskDomains []string) bool {
+	for _, domain := range taskDomains {
+		if task.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// callbackContext bounds the processing of a single callback by the
+// handler's timeout, and cancels it early if the BBS goes away.
+func (handler *completionHandler) callbackContext(res http.ResponseWriter) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), handler.timeout)
+
+	if closeNotifier, ok := res.(http.CloseNotifier); ok {
+		closed := closeNotifier.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, cancel
+}
+
+// withLogExcerpt appends the tail of the staging log to the error shown to
+// the developer. Failing to fetch the log never fails the callback.
+func (handler *completionHandler) withLogExcerpt(logger lager.Logger, taskGuid string, stagingErr *cc_messages.StagingError) *cc_messages.StagingError {
+	excerpt, err := handler.logFetcher.Tail(taskGuid)
+	if err != nil {
+		logger.Error("fetch-staging-log-failed", err)
+		return stagingErr
+	}
+
+	if excerpt == "" {
+		return stagingErr
+	}
+
+	return &cc_messages.StagingError{
+		Id:      stagingErr.Id,
+		Message: stagingErr.Message + "\n" + excerpt,
+	}
+}
+
+func (handler *completionHandler) publishCompletion(logger lager.Logger, taskGuid, lifecycle string, response cc_messages.StagingResponseForCC) {
+	event := webhooks.Event{
+		Type:        webhooks.StagingSucceeded,
+		StagingGuid: taskGuid,
+		Lifecycle:   lifecycle,
+	}
+	if response.Error != nil {
+		event.Type = webhooks.StagingFailed
+		event.Error = response.Error
+	}
+
+	handler.publisher.Publish(logger, event)
 }
 
 func (handler *completionHandler) reportMetrics(task *models.TaskCallbackResponse) {
@@ -128,3 +278,38 @@ func (handler *completionHandler) reportMetrics(task *models.TaskCallbackRespons
 		stagingSuccessCounter.Increment()
 	}
 }
+
+// validatedStagingResponse replaces a response that the CC would reject with
+// an InvalidStagingResponse error, so that staging fails with a reason.
+func validatedStagingResponse(logger lager.Logger, response cc_messages.StagingResponseForCC) cc_messages.StagingResponseForCC {
+	err := backend.ValidateStagingResponse(response)
+	if err == nil {
+		return response
+	}
+
+	logger.Error("invalid-staging-response", err)
+	return cc_messages.StagingResponseForCC{
+		Error: backend.StagingErrorFor(err),
+	}
+}
+
+// marshalStagingResponse encodes response in the shape expected by the given
+// CC API.
+func marshalStagingResponse(completionAPI, lifecycle string, response cc_messages.StagingResponseForCC) ([]byte, error) {
+	if completionAPI == cc_client.APIVersionV3 {
+		return json.Marshal(cc_client.NewBuildCompletedForCC(lifecycle, response))
+	}
+	return json.Marshal(response)
+}
+
+// deliverStagingResponse reports a staging response to the CC API and target
+// recorded in the task's callback URL.
+func deliverStagingResponse(ctx context.Context, notifier StagingCompletedNotifier, callbackURL, stagingGuid string, payload []byte, logger lager.Logger) error {
+	ctx = cc_client.WithTarget(ctx, backend.CCTargetFor(callbackURL))
+
+	completionAPI := backend.CompletionAPIFor(callbackURL)
+	if completionAPI == cc_client.APIVersionV3 {
+		return notifier.BuildComplete(ctx, stagingGuid, payload, logger)
+	}
+	return notifier.StagingComplete(ctx, stagingGuid, payload, logger)
+}
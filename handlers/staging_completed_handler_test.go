@@ -8,9 +8,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/cloudfoundry-incubator/bbs/fake_bbs"
 	"github.com/cloudfoundry-incubator/bbs/models"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 	"github.com/cloudfoundry-incubator/stager/backend"
@@ -18,10 +20,17 @@ import (
 	"github.com/cloudfoundry-incubator/stager/cc_client"
 	"github.com/cloudfoundry-incubator/stager/cc_client/fakes"
 	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry-incubator/stager/history"
+	nats_fakes "github.com/cloudfoundry-incubator/stager/nats_emitter/fakes"
+	staging_logs_fakes "github.com/cloudfoundry-incubator/stager/staging_logs/fakes"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
+	webhook_fakes "github.com/cloudfoundry-incubator/stager/webhooks/fakes"
 	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	"github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/pivotal-golang/clock/fakeclock"
 	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+	"golang.org/x/net/context"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -34,17 +43,44 @@ var _ = Describe("StagingCompletedHandler", func() {
 		taskId string
 
 		fakeCCClient        *fakes.FakeCcClient
+		fakeBBSClient       *fake_bbs.FakeClient
+		fakePublisher       *webhook_fakes.FakePublisher
+		fakeNatsEmitter     *nats_fakes.FakeEmitter
+		fakeLogFetcher      *staging_logs_fakes.FakeFetcher
 		fakeBackend         *fake_backend.FakeBackend
 		backendResponse     cc_messages.StagingResponseForCC
 		backendError        error
 		fakeClock           *fakeclock.FakeClock
 		metricSender        *fake.FakeMetricSender
+		stagingHistory      history.History
 		stagingDurationNano time.Duration
 
+		callbackQuery    string
 		responseRecorder *httptest.ResponseRecorder
 		handler          handlers.CompletionHandler
+		cleanerProcesses []ifrit.Process
 	)
 
+	newHandler := func(cleanupPolicy string) handlers.CompletionHandler {
+		taskCleaner, err := handlers.NewCompletedTaskCleaner(fakeBBSClient, cleanupPolicy, time.Minute, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		cleanerProcesses = append(cleanerProcesses, ifrit.Invoke(taskCleaner))
+
+		return handlers.NewStagingCompletionHandler(logger, handlers.Config{
+			Notifier:        fakeCCClient,
+			BBSClient:       fakeBBSClient,
+			TaskDomains:     []string{"the-domain", "the-docker-domain"},
+			Backends:        map[string]backend.Backend{"fake": fakeBackend},
+			TaskCleaner:     taskCleaner,
+			StagingHistory:  stagingHistory,
+			Publisher:       fakePublisher,
+			NatsEmitter:     fakeNatsEmitter,
+			LogFetcher:      fakeLogFetcher,
+			CallbackTimeout: time.Minute,
+			Clock:           fakeClock,
+		})
+	}
+
 	BeforeEach(func() {
 		logger = lager.NewLogger("fakelogger")
 		logger.RegisterSink(lager.NewWriterSink(GinkgoWriter, lager.DEBUG))
@@ -57,24 +93,39 @@ var _ = Describe("StagingCompletedHandler", func() {
 		metrics.Initialize(metricSender, nil)
 
 		fakeCCClient = &fakes.FakeCcClient{}
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeBBSClient.TaskByGuidReturns(&models.Task{TaskGuid: "the-task-guid", Domain: "the-domain"}, nil)
+		fakePublisher = &webhook_fakes.FakePublisher{}
+		fakeNatsEmitter = &nats_fakes.FakeEmitter{}
+		fakeLogFetcher = &staging_logs_fakes.FakeFetcher{}
 		fakeBackend = &fake_backend.FakeBackend{}
 		backendError = nil
 
 		fakeClock = fakeclock.NewFakeClock(time.Now())
+		stagingHistory = history.New(history.DefaultSize, fakeClock)
 
+		callbackQuery = ""
 		responseRecorder = httptest.NewRecorder()
-		handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock)
+		handler = newHandler(handlers.TaskCleanupNone)
 	})
 
 	JustBeforeEach(func() {
 		fakeBackend.BuildStagingResponseReturns(backendResponse, backendError)
 	})
 
+	AfterEach(func() {
+		for _, process := range cleanerProcesses {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		}
+		cleanerProcesses = nil
+	})
+
 	postTask := func(task *models.TaskCallbackResponse) *http.Request {
 		taskJSON, err := json.Marshal(task)
 		Expect(err).NotTo(HaveOccurred())
 
-		request, err := http.NewRequest("POST", fmt.Sprintf("/v1/staging/%s/completed", task.TaskGuid), bytes.NewReader(taskJSON))
+		request, err := http.NewRequest("POST", fmt.Sprintf("/v1/staging/%s/completed%s", task.TaskGuid, callbackQuery), bytes.NewReader(taskJSON))
 		Expect(err).NotTo(HaveOccurred())
 
 		request.Form = url.Values{":staging_guid": {task.TaskGuid}}
@@ -113,6 +164,54 @@ var _ = Describe("StagingCompletedHandler", func() {
 			handler.StagingComplete(responseRecorder, postTask(taskResponse))
 		})
 
+		Context("when the task belongs to another of the stager's task domains", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TaskByGuidReturns(&models.Task{TaskGuid: "the-task-guid", Domain: "the-docker-domain"}, nil)
+			})
+
+			It("processes it", func() {
+				Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the task belongs to another task domain", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TaskByGuidReturns(&models.Task{TaskGuid: "the-task-guid", Domain: "another-domain"}, nil)
+			})
+
+			It("rejects the callback so the BBS retries it", func() {
+				Expect(fakeBBSClient.TaskByGuidArgsForCall(0)).To(Equal("the-task-guid"))
+				Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+			})
+
+			It("does not process it", func() {
+				Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(0))
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the task is no longer in the BBS", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TaskByGuidReturns(nil, models.ErrResourceNotFound)
+			})
+
+			It("responds not found so the BBS stops retrying", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the task cannot be looked up", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TaskByGuidReturns(nil, errors.New("boom"))
+			})
+
+			It("rejects the callback so the BBS retries it", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+			})
+		})
+
 		It("passes the task response to the matching response builder", func() {
 			Eventually(fakeBackend.BuildStagingResponseCallCount()).Should(Equal(1))
 			Expect(fakeBackend.BuildStagingResponseArgsForCall(0)).To(Equal(taskResponse))
@@ -188,8 +287,69 @@ var _ = Describe("StagingCompletedHandler", func() {
 				backendError = errors.New("build error")
 			})
 
-			It("returns a 400", func() {
-				Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+			It("reports an InvalidStagingResult error to the CC", func() {
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+				_, _, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+
+				var response cc_messages.StagingResponseForCC
+				Expect(json.Unmarshal(payload, &response)).To(Succeed())
+				Expect(response.Error).To(Equal(&cc_messages.StagingError{
+					Id:      backend.InvalidStagingResultErrorId,
+					Message: "staging result could not be parsed",
+				}))
+			})
+
+			It("returns a 200", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when the task reports to the v3 builds API", func() {
+			BeforeEach(func() {
+				callbackQuery = "?completion_api=v3"
+				backendResponse = cc_messages.StagingResponseForCC{
+					ExecutionMetadata:    "metadata",
+					DetectedStartCommand: map[string]string{"web": "./start"},
+				}
+			})
+
+			It("delivers a v3 build completion instead of a v2 staging response", func() {
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(0))
+				Expect(fakeCCClient.BuildCompleteCallCount()).To(Equal(1))
+
+				_, buildGuid, payload, _ := fakeCCClient.BuildCompleteArgsForCall(0)
+				Expect(buildGuid).To(Equal("the-task-guid"))
+				Expect(payload).To(MatchJSON(`{
+					"state": "STAGED",
+					"result": {
+						"process_types": {"web": "./start"},
+						"execution_metadata": "metadata",
+						"lifecycle_type": "fake"
+					}
+				}`))
+			})
+
+			It("returns a 200", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when the response would be rejected by the CC", func() {
+			BeforeEach(func() {
+				backendResponse = cc_messages.StagingResponseForCC{
+					DetectedStartCommand: map[string]string{"web": strings.Repeat("x", backend.MaxStartCommandLength+1)},
+				}
+			})
+
+			It("reports an InvalidStagingResponse error instead", func() {
+				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+
+				_, _, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+
+				var response cc_messages.StagingResponseForCC
+				Expect(json.Unmarshal(payload, &response)).To(Succeed())
+				Expect(response.DetectedStartCommand).To(BeEmpty())
+				Expect(response.Error.Id).To(Equal(backend.InvalidStagingResponseErrorId))
 			})
 		})
 
@@ -204,9 +364,16 @@ var _ = Describe("StagingCompletedHandler", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
 
+			It("bounds the CC request by the callback timeout", func() {
+				ctx, _, _, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+				deadline, ok := ctx.Deadline()
+				Expect(ok).To(BeTrue())
+				Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Minute), 5*time.Second))
+			})
+
 			It("posts the response builder's result to CC", func() {
 				Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
-				guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+				_, guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
 				Expect(guid).To(Equal("the-task-guid"))
 				Expect(payload).To(Equal(backendResponseJson))
 			})
@@ -227,6 +394,55 @@ var _ = Describe("StagingCompletedHandler", func() {
 				It("returns a 200", func() {
 					Expect(responseRecorder.Code).To(Equal(200))
 				})
+
+				It("emits the staging response on NATS", func() {
+					Expect(fakeNatsEmitter.EmitStagingFinishedCallCount()).To(Equal(1))
+					_, guid, response := fakeNatsEmitter.EmitStagingFinishedArgsForCall(0)
+					Expect(guid).To(Equal("the-task-guid"))
+					Expect(response).To(Equal(backendResponse))
+				})
+
+				It("publishes a staging succeeded event", func() {
+					Expect(fakePublisher.PublishCallCount()).To(Equal(1))
+					_, event := fakePublisher.PublishArgsForCall(0)
+					Expect(event).To(Equal(webhooks.Event{
+						Type:        webhooks.StagingSucceeded,
+						StagingGuid: "the-task-guid",
+						Lifecycle:   "fake",
+					}))
+				})
+
+				It("does not delete the task by default", func() {
+					Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+				})
+
+				It("records the delivery in the staging history", func() {
+					Expect(stagingHistory.WasDelivered("the-task-guid")).To(BeTrue())
+				})
+
+				Context("when the BBS replays the callback", func() {
+					It("acknowledges it without delivering the response again", func() {
+						replayRecorder := httptest.NewRecorder()
+						handler.StagingComplete(replayRecorder, postTask(taskResponse))
+
+						Expect(replayRecorder.Code).To(Equal(http.StatusOK))
+						Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+						Expect(fakePublisher.PublishCallCount()).To(Equal(1))
+					})
+				})
+
+				Context("when completed tasks are cleaned up immediately", func() {
+					BeforeEach(func() {
+						handler = newHandler(handlers.TaskCleanupImmediate)
+					})
+
+					It("resolves and deletes the task", func() {
+						Eventually(fakeBBSClient.DeleteTaskCallCount).Should(Equal(1))
+						Expect(fakeBBSClient.DeleteTaskArgsForCall(0)).To(Equal("the-task-guid"))
+						Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(1))
+						Expect(fakeBBSClient.ResolvingTaskArgsForCall(0)).To(Equal("the-task-guid"))
+					})
+				})
 			})
 
 			Context("when the CC request fails", func() {
@@ -239,6 +455,16 @@ var _ = Describe("StagingCompletedHandler", func() {
 				})
 			})
 
+			Context("when the CC request times out", func() {
+				BeforeEach(func() {
+					fakeCCClient.StagingCompleteReturns(context.DeadlineExceeded)
+				})
+
+				It("responds with a 503 so that the callback is retried", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+				})
+			})
+
 			Context("When an error occurs in making the CC request", func() {
 				BeforeEach(func() {
 					fakeCCClient.StagingCompleteReturns(errors.New("whoops"))
@@ -255,6 +481,30 @@ var _ = Describe("StagingCompletedHandler", func() {
 				It("does not update the staging duration", func() {
 					Expect(metricSender.GetValue("StagingRequestSucceededDuration")).To(Equal(fake.Metric{}))
 				})
+
+				It("does not publish a staging event", func() {
+					Expect(fakePublisher.PublishCallCount()).To(Equal(0))
+					Expect(fakeNatsEmitter.EmitStagingFinishedCallCount()).To(Equal(0))
+				})
+
+				It("records the response as pending in the staging history", func() {
+					Expect(stagingHistory.Entries()).To(ConsistOf(history.Entry{
+						StagingGuid: "the-task-guid",
+						State:       history.Pending,
+						UpdatedAt:   fakeClock.Now().UnixNano(),
+					}))
+				})
+
+				Context("when completed tasks are cleaned up immediately", func() {
+					BeforeEach(func() {
+						handler = newHandler(handlers.TaskCleanupImmediate)
+					})
+
+					It("does not delete the task", func() {
+						Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(0))
+						Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+					})
+				})
 			})
 		})
 	})
@@ -263,7 +513,9 @@ var _ = Describe("StagingCompletedHandler", func() {
 		var backendResponseJson []byte
 
 		BeforeEach(func() {
-			backendResponse = cc_messages.StagingResponseForCC{}
+			backendResponse = cc_messages.StagingResponseForCC{
+				Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+			}
 
 			var err error
 			backendResponseJson, err = json.Marshal(backendResponse)
@@ -292,7 +544,7 @@ var _ = Describe("StagingCompletedHandler", func() {
 
 		It("posts the result to CC as an error", func() {
 			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
-			guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+			_, guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
 			Expect(guid).To(Equal("the-task-guid"))
 			Expect(payload).To(Equal(backendResponseJson))
 		})
@@ -306,6 +558,13 @@ var _ = Describe("StagingCompletedHandler", func() {
 			Expect(metricSender.GetCounter("StagingRequestsFailed")).To(BeEquivalentTo(1))
 		})
 
+		It("publishes a staging failed event", func() {
+			Expect(fakePublisher.PublishCallCount()).To(Equal(1))
+			_, event := fakePublisher.PublishArgsForCall(0)
+			Expect(event.Type).To(Equal(webhooks.StagingFailed))
+			Expect(event.Error).To(Equal(backendResponse.Error))
+		})
+
 		It("emits the time it took to stage unsuccesfully", func() {
 			Expect(metricSender.GetValue("StagingRequestFailedDuration")).To(Equal(fake.Metric{
 				Value: 900900,
@@ -313,6 +572,35 @@ var _ = Describe("StagingCompletedHandler", func() {
 			}))
 
 		})
+
+		Context("when the staging log is available", func() {
+			BeforeEach(func() {
+				fakeLogFetcher.TailReturns("None of the buildpacks detected a compatible application", nil)
+			})
+
+			It("appends the log excerpt to the error message", func() {
+				Expect(fakeLogFetcher.TailArgsForCall(0)).To(Equal("the-task-guid"))
+
+				_, _, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+				var response cc_messages.StagingResponseForCC
+				Expect(json.Unmarshal(payload, &response)).To(Succeed())
+				Expect(response.Error).To(Equal(&cc_messages.StagingError{
+					Id:      cc_messages.STAGING_ERROR,
+					Message: "staging failed\nNone of the buildpacks detected a compatible application",
+				}))
+			})
+		})
+
+		Context("when fetching the staging log fails", func() {
+			BeforeEach(func() {
+				fakeLogFetcher.TailReturns("", errors.New("boom"))
+			})
+
+			It("still posts the error to CC", func() {
+				_, _, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+				Expect(payload).To(Equal(backendResponseJson))
+			})
+		})
 	})
 
 	Context("when a non-staging task is reported", func() {
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/cloudfoundry-incubator/bbs"
 	"github.com/cloudfoundry-incubator/bbs/models"
@@ -11,39 +13,55 @@ import (
 	"github.com/cloudfoundry-incubator/runtime-schema/metric"
 	"github.com/cloudfoundry-incubator/stager/backend"
 	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
+	"github.com/pivotal-golang/clock"
 	"github.com/pivotal-golang/lager"
+	"golang.org/x/net/context"
 )
 
 const (
 	StagingStartRequestsReceivedCounter = metric.Counter("StagingStartRequestsReceived")
 	StagingStopRequestsReceivedCounter  = metric.Counter("StagingStopRequestsReceived")
+	StagingConfigurationErrorsCounter   = metric.Counter("StagingConfigurationErrors")
+
+	maxRecipeBuildAttempts = 3
+	recipeBuildBackoff     = 500 * time.Millisecond
 )
 
+var ErrBackendNotFound = errors.New("backend not found")
+
 type StagingHandler interface {
 	Stage(resp http.ResponseWriter, req *http.Request)
+	StageRequest(logger lager.Logger, stagingGuid string, stagingRequest cc_messages.StagingRequestFromCC) error
 	StopStaging(resp http.ResponseWriter, req *http.Request)
 }
 
 type stagingHandler struct {
 	logger      lager.Logger
 	backends    map[string]backend.Backend
-	ccClient    cc_client.CcClient
+	notifier    StagingCompletedNotifier
 	diegoClient bbs.Client
+	publisher   webhooks.Publisher
+	clock       clock.Clock
 }
 
 func NewStagingHandler(
 	logger lager.Logger,
 	backends map[string]backend.Backend,
-	ccClient cc_client.CcClient,
+	notifier StagingCompletedNotifier,
 	bbsClient bbs.Client,
+	publisher webhooks.Publisher,
+	clock clock.Clock,
 ) StagingHandler {
 	logger = logger.Session("staging-handler")
 
 	return &stagingHandler{
 		logger:      logger,
 		backends:    backends,
-		ccClient:    ccClient,
+		notifier:    notifier,
 		diegoClient: bbsClient,
+		publisher:   publisher,
+		clock:       clock,
 	}
 }
 
@@ -66,22 +84,70 @@ func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request
 		return
 	}
 
-	backend, ok := handler.backends[stagingRequest.Lifecycle]
-	if !ok {
-		logger.Error("backend-not-found", err, lager.Data{"backend": stagingRequest.Lifecycle})
-		resp.WriteHeader(http.StatusNotFound)
+	completionAPI := req.URL.Query().Get(backend.CompletionAPIParam)
+	if completionAPI != "" && cc_client.ValidateAPIVersion(completionAPI) != nil {
+		logger.Error("invalid-completion-api", cc_client.ErrUnknownAPIVersion, lager.Data{"completion-api": completionAPI})
+		handler.doErrorResponse(resp, http.StatusBadRequest, backend.NewValidationError(backend.InvalidCompletionAPIErrorId, cc_client.ErrUnknownAPIVersion.Error()))
 		return
 	}
 
-	StagingStartRequestsReceivedCounter.Increment()
+	ccTarget := req.URL.Query().Get(backend.CCTargetParam)
+	if ccTarget != "" && !handler.hasCCTarget(ccTarget) {
+		logger.Error("unknown-cc-target", cc_client.ErrUnknownTarget, lager.Data{"cc-target": ccTarget})
+		handler.doErrorResponse(resp, http.StatusBadRequest, backend.NewValidationError(backend.UnknownCCTargetErrorId, cc_client.ErrUnknownTarget.Error()))
+		return
+	}
+
+	ctx, cancel := requestContext(resp)
+	defer cancel()
+
+	status, err := handler.stage(ctx, logger, stagingGuid, stagingRequest, completionAPI, ccTarget)
+	if err == ErrBackendNotFound {
+		resp.WriteHeader(status)
+		return
+	}
 
-	taskDef, guid, domain, err := backend.BuildRecipe(stagingGuid, stagingRequest)
 	if err != nil {
-		logger.Error("recipe-building-failed", err, lager.Data{"staging-request": stagingRequest})
-		handler.doErrorResponse(resp, err.Error())
+		handler.doErrorResponse(resp, status, err)
 		return
 	}
 
+	resp.WriteHeader(status)
+}
+
+// StageRequest runs a staging request through the same pipeline as Stage,
+// for callers that don't receive the request over HTTP.
+func (handler *stagingHandler) StageRequest(logger lager.Logger, stagingGuid string, stagingRequest cc_messages.StagingRequestFromCC) error {
+	_, err := handler.stage(context.Background(), logger, stagingGuid, stagingRequest, "", "")
+	return err
+}
+
+// stage desires the staging task for stagingRequest. A non-empty
+// completionAPI overrides the CC API that the backend reports completion to,
+// and a non-empty ccTarget picks the CC it is reported to. Recipe building
+// stops retrying once ctx is done.
+func (handler *stagingHandler) stage(ctx context.Context, logger lager.Logger, stagingGuid string, stagingRequest cc_messages.StagingRequestFromCC, completionAPI, ccTarget string) (int, error) {
+	stagingBackend, ok := handler.backends[stagingRequest.Lifecycle]
+	if !ok {
+		logger.Error("backend-not-found", ErrBackendNotFound, lager.Data{"backend": stagingRequest.Lifecycle})
+		return http.StatusNotFound, ErrBackendNotFound
+	}
+
+	StagingStartRequestsReceivedCounter.Increment()
+
+	taskDef, guid, domain, err := handler.buildRecipe(ctx, logger, stagingBackend, stagingGuid, stagingRequest)
+	if err != nil {
+		logger.Error("recipe-building-failed", err, lager.Data{"staging-request": backend.RedactStagingRequest(stagingRequest)})
+		return recipeErrorStatus(logger, err), err
+	}
+
+	if completionAPI != "" {
+		taskDef.CompletionCallbackUrl = backend.WithCompletionAPI(taskDef.CompletionCallbackUrl, completionAPI)
+	}
+	if ccTarget != "" {
+		taskDef.CompletionCallbackUrl = backend.WithCCTarget(taskDef.CompletionCallbackUrl, ccTarget)
+	}
+
 	logger.Info("desiring-task", lager.Data{
 		"task_guid":    guid,
 		"callback_url": taskDef.CompletionCallbackUrl,
@@ -93,21 +159,96 @@ func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request
 	}
 
 	if err != nil {
-		logger.Error("staging-failed", err, lager.Data{"staging-request": stagingRequest})
-		handler.doErrorResponse(resp, err.Error())
-		return
+		logger.Error("staging-failed", err, lager.Data{"staging-request": backend.RedactStagingRequest(stagingRequest)})
+		return http.StatusInternalServerError, err
 	}
 
-	resp.WriteHeader(http.StatusAccepted)
+	handler.publisher.Publish(logger, webhooks.Event{
+		Type:        webhooks.StagingStarted,
+		StagingGuid: stagingGuid,
+		AppId:       stagingRequest.AppId,
+		Lifecycle:   stagingRequest.Lifecycle,
+	})
+
+	return http.StatusAccepted, nil
+}
+
+// buildRecipe retries recipe building while the backend reports a retryable
+// error, such as a dependency being briefly unavailable. It waits
+// recipeBuildBackoff before the first retry, doubling the wait each time, and
+// gives up with the last error once ctx is done.
+func (handler *stagingHandler) buildRecipe(ctx context.Context, logger lager.Logger, stagingBackend backend.Backend, stagingGuid string, stagingRequest cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+	backoff := recipeBuildBackoff
+	for attempt := 1; ; attempt++ {
+		taskDef, guid, domain, err := stagingBackend.BuildRecipe(stagingGuid, stagingRequest)
+		if err == nil {
+			return taskDef, guid, domain, nil
+		}
+
+		backendErr, ok := err.(backend.Error)
+		if !ok || !backendErr.Retryable() || attempt == maxRecipeBuildAttempts {
+			return nil, "", "", err
+		}
+
+		logger.Info("retrying-recipe-building", lager.Data{"attempt": attempt, "backoff": backoff.String(), "error": err.Error()})
+		timer := handler.clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("recipe-building-cancelled", lager.Data{"attempt": attempt})
+			return nil, "", "", err
+		}
+		backoff *= 2
+	}
+}
+
+// requestContext is cancelled when the CC goes away before the request is
+// answered.
+func requestContext(resp http.ResponseWriter) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if closeNotifier, ok := resp.(http.CloseNotifier); ok {
+		closed := closeNotifier.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return ctx, cancel
+}
+
+func (handler *stagingHandler) hasCCTarget(name string) bool {
+	router, ok := handler.notifier.(cc_client.Router)
+	return ok && router.HasTarget(name)
+}
+
+func recipeErrorStatus(logger lager.Logger, err error) int {
+	switch err := err.(type) {
+	case *backend.ValidationError:
+		return http.StatusBadRequest
+	case *backend.ConfigurationError:
+		logger.Error("operator-action-required", err, lager.Data{"error-id": err.Id()})
+		StagingConfigurationErrorsCounter.Increment()
+		return http.StatusInternalServerError
+	case *backend.DependencyError:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, message string) {
+func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, status int, err error) {
 	response := cc_messages.StagingResponseForCC{
-		Error: backend.SanitizeErrorMessage(message),
+		Error: backend.StagingErrorFor(err),
 	}
 	responseJson, _ := json.Marshal(response)
 
-	resp.WriteHeader(http.StatusInternalServerError)
+	resp.WriteHeader(status)
 	resp.Write(responseJson)
 }
 
@@ -7,16 +7,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 
 	"github.com/cloudfoundry-incubator/bbs/fake_bbs"
 	"github.com/cloudfoundry-incubator/bbs/models"
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 	"github.com/cloudfoundry-incubator/stager/backend"
 	"github.com/cloudfoundry-incubator/stager/backend/fake_backend"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
 	"github.com/cloudfoundry-incubator/stager/cc_client/fakes"
 	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
+	webhook_fakes "github.com/cloudfoundry-incubator/stager/webhooks/fakes"
 	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/pivotal-golang/clock/fakeclock"
 	"github.com/pivotal-golang/lager"
 	"github.com/pivotal-golang/lager/lagertest"
 
@@ -34,6 +39,8 @@ var _ = Describe("StagingHandler", func() {
 		fakeDiegoClient *fake_bbs.FakeClient
 		fakeCcClient    *fakes.FakeCcClient
 		fakeBackend     *fake_backend.FakeBackend
+		fakePublisher   *webhook_fakes.FakePublisher
+		fakeClock       *fakeclock.FakeClock
 
 		responseRecorder *httptest.ResponseRecorder
 		handler          handlers.StagingHandler
@@ -51,18 +58,25 @@ var _ = Describe("StagingHandler", func() {
 		fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", nil)
 
 		fakeDiegoClient = &fake_bbs.FakeClient{}
+		fakePublisher = &webhook_fakes.FakePublisher{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
 
 		responseRecorder = httptest.NewRecorder()
-		handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeCcClient, fakeDiegoClient)
+		handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeCcClient, fakeDiegoClient, fakePublisher, fakeClock)
 	})
 
 	Describe("Stage", func() {
 		var (
 			stagingRequestJson []byte
+			stagingPath        string
 		)
 
+		BeforeEach(func() {
+			stagingPath = "/v1/staging/a-staging-guid"
+		})
+
 		JustBeforeEach(func() {
-			req, err := http.NewRequest("PUT", "/v1/staging/a-staging-guid", bytes.NewReader(stagingRequestJson))
+			req, err := http.NewRequest("PUT", stagingPath, bytes.NewReader(stagingRequestJson))
 			Expect(err).NotTo(HaveOccurred())
 
 			req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
@@ -100,6 +114,68 @@ var _ = Describe("StagingHandler", func() {
 				Expect(request).To(Equal(stagingRequest))
 			})
 
+			Context("when the request asks for completion through the v3 builds API", func() {
+				BeforeEach(func() {
+					stagingPath = "/v1/staging/a-staging-guid?completion_api=v3"
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{
+						CompletionCallbackUrl: "http://stager.example.com/v1/staging/a-staging-guid/completed",
+					}, "a-guid", "a-domain", nil)
+				})
+
+				It("records the API in the task's callback URL", func() {
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+					_, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+					Expect(taskDef.CompletionCallbackUrl).To(Equal("http://stager.example.com/v1/staging/a-staging-guid/completed?completion_api=v3"))
+				})
+			})
+
+			Context("when the request asks for an unknown completion API", func() {
+				BeforeEach(func() {
+					stagingPath = "/v1/staging/a-staging-guid?completion_api=v4"
+				})
+
+				It("rejects the request", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+
+					var response cc_messages.StagingResponseForCC
+					Expect(json.Unmarshal(responseRecorder.Body.Bytes(), &response)).To(Succeed())
+					Expect(response.Error.Id).To(Equal(backend.InvalidCompletionAPIErrorId))
+				})
+			})
+
+			Context("when the request asks for a configured CC target", func() {
+				BeforeEach(func() {
+					stagingPath = "/v1/staging/a-staging-guid?cc_target=other-cc"
+					router := cc_client.NewRouter(fakeCcClient, map[string]cc_client.CcClient{"other-cc": &fakes.FakeCcClient{}})
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, router, fakeDiegoClient, fakePublisher, fakeClock)
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{
+						CompletionCallbackUrl: "http://stager.example.com/v1/staging/a-staging-guid/completed",
+					}, "a-guid", "a-domain", nil)
+				})
+
+				It("records the target in the task's callback URL", func() {
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+					_, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+					Expect(taskDef.CompletionCallbackUrl).To(Equal("http://stager.example.com/v1/staging/a-staging-guid/completed?cc_target=other-cc"))
+				})
+			})
+
+			Context("when the request asks for an unknown CC target", func() {
+				BeforeEach(func() {
+					stagingPath = "/v1/staging/a-staging-guid?cc_target=other-cc"
+				})
+
+				It("rejects the request", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+
+					var response cc_messages.StagingResponseForCC
+					Expect(json.Unmarshal(responseRecorder.Body.Bytes(), &response)).To(Succeed())
+					Expect(response.Error.Id).To(Equal(backend.UnknownCCTargetErrorId))
+				})
+			})
+
 			Context("when the recipe was built successfully", func() {
 				var fakeTaskDef = &models.TaskDefinition{Annotation: "test annotation"}
 				BeforeEach(func() {
@@ -120,6 +196,17 @@ var _ = Describe("StagingHandler", func() {
 					It("does not send a staging failure response", func() {
 						Expect(fakeCcClient.StagingCompleteCallCount()).To(Equal(0))
 					})
+
+					It("publishes a staging started event", func() {
+						Expect(fakePublisher.PublishCallCount()).To(Equal(1))
+						_, event := fakePublisher.PublishArgsForCall(0)
+						Expect(event).To(Equal(webhooks.Event{
+							Type:        webhooks.StagingStarted,
+							StagingGuid: "a-staging-guid",
+							AppId:       "myapp",
+							Lifecycle:   "fake-backend",
+						}))
+					})
 				})
 
 				Context("when the task has already been created", func() {
@@ -152,6 +239,10 @@ var _ = Describe("StagingHandler", func() {
 						Expect(fakeCcClient.StagingCompleteCallCount()).To(Equal(0))
 					})
 
+					It("does not publish a staging started event", func() {
+						Expect(fakePublisher.PublishCallCount()).To(Equal(0))
+					})
+
 					Context("when the response builder succeeds", func() {
 						var responseForCC cc_messages.StagingResponseForCC
 
@@ -185,6 +276,22 @@ var _ = Describe("StagingHandler", func() {
 					Expect(logger).To(gbytes.Say("recipe-building-failed"))
 				})
 
+				Context("when the request carries credentials", func() {
+					BeforeEach(func() {
+						lifecycleData := json.RawMessage(`{"docker_image": "busybox", "docker_user": "user", "docker_password": "the-password"}`)
+						stagingRequest.LifecycleData = &lifecycleData
+
+						var err error
+						stagingRequestJson, err = json.Marshal(stagingRequest)
+						Expect(err).NotTo(HaveOccurred())
+					})
+
+					It("logs the request without them", func() {
+						Expect(logger).To(gbytes.Say("recipe-building-failed"))
+						Expect(logger).NotTo(gbytes.Say("the-password"))
+					})
+				})
+
 				It("returns an internal service error status code", func() {
 					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
 				})
@@ -211,6 +318,104 @@ var _ = Describe("StagingHandler", func() {
 						Expect(response).To(Equal(responseForCC))
 					})
 				})
+
+				It("does not retry", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when the recipe failed validation", func() {
+				BeforeEach(func() {
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", backend.ErrMissingAppId)
+				})
+
+				It("rejects the request", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+				})
+
+				It("reports the error id to the CC", func() {
+					var response cc_messages.StagingResponseForCC
+					Expect(json.NewDecoder(responseRecorder.Body).Decode(&response)).To(Succeed())
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      backend.MissingAppIdErrorId,
+						Message: backend.ErrMissingAppId.Error(),
+					}))
+				})
+
+				It("does not desire a task", func() {
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the stager is misconfigured for the recipe", func() {
+				BeforeEach(func() {
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", backend.ErrNoCompilerDefined)
+				})
+
+				It("returns an internal service error status code", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				})
+
+				It("alerts operators", func() {
+					Expect(logger).To(gbytes.Say("operator-action-required"))
+					Expect(fakeMetricSender.GetCounter("StagingConfigurationErrors")).To(Equal(uint64(1)))
+				})
+			})
+
+			Context("when a recipe dependency fails", func() {
+				var stopClock chan struct{}
+
+				BeforeEach(func() {
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", backend.ErrMissingDockerRegistry)
+
+					stopClock = make(chan struct{})
+					go func(clock *fakeclock.FakeClock, stop <-chan struct{}) {
+						for {
+							select {
+							case <-stop:
+								return
+							case <-time.After(time.Millisecond):
+								clock.Increment(100 * time.Millisecond)
+							}
+						}
+					}(fakeClock, stopClock)
+				})
+
+				AfterEach(func() {
+					close(stopClock)
+				})
+
+				It("retries building the recipe", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(3))
+				})
+
+				It("doubles the backoff between attempts", func() {
+					Expect(logger).To(gbytes.Say(`retrying-recipe-building.*"backoff":"500ms"`))
+					Expect(logger).To(gbytes.Say(`retrying-recipe-building.*"backoff":"1s"`))
+				})
+
+				It("returns service unavailable", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+				})
+
+				Context("and then recovers", func() {
+					BeforeEach(func() {
+						calls := 0
+						fakeBackend.BuildRecipeStub = func(string, cc_messages.StagingRequestFromCC) (*models.TaskDefinition, string, string, error) {
+							calls++
+							if calls == 1 {
+								return nil, "", "", backend.ErrMissingDockerRegistry
+							}
+							return &models.TaskDefinition{}, "a-guid", "a-domain", nil
+						}
+					})
+
+					It("desires the task", func() {
+						Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(2))
+						Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+						Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+					})
+				})
 			})
 		})
 
@@ -258,6 +463,94 @@ var _ = Describe("StagingHandler", func() {
 		})
 	})
 
+	Describe("StageRequest", func() {
+		var (
+			stagingRequest cc_messages.StagingRequestFromCC
+			stageErr       error
+		)
+
+		BeforeEach(func() {
+			stagingRequest = cc_messages.StagingRequestFromCC{
+				AppId:     "myapp",
+				Lifecycle: "fake-backend",
+			}
+		})
+
+		JustBeforeEach(func() {
+			stageErr = handler.StageRequest(logger, "a-staging-guid", stagingRequest)
+		})
+
+		It("desires the staging task", func() {
+			Expect(stageErr).NotTo(HaveOccurred())
+			Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+		})
+
+		Context("when the backend is unknown", func() {
+			BeforeEach(func() {
+				stagingRequest.Lifecycle = "unknown"
+			})
+
+			It("returns ErrBackendNotFound", func() {
+				Expect(stageErr).To(Equal(handlers.ErrBackendNotFound))
+				Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when desiring the task fails", func() {
+			BeforeEach(func() {
+				fakeDiegoClient.DesireTaskReturns(errors.New("boom"))
+			})
+
+			It("returns the error", func() {
+				Expect(stageErr).To(MatchError("boom"))
+			})
+		})
+
+		Context("when a recipe dependency fails", func() {
+			It("waits on the clock before retrying", func() {
+				fakeBackend.BuildRecipeReturns(nil, "", "", backend.ErrMissingDockerRegistry)
+
+				errs := make(chan error, 1)
+				go func() {
+					errs <- handler.StageRequest(logger, "another-staging-guid", stagingRequest)
+				}()
+
+				Eventually(fakeBackend.BuildRecipeCallCount).Should(Equal(2))
+				Consistently(fakeBackend.BuildRecipeCallCount).Should(Equal(2))
+
+				Eventually(func() int {
+					fakeClock.Increment(100 * time.Millisecond)
+					return fakeBackend.BuildRecipeCallCount()
+				}).Should(Equal(4))
+				Eventually(errs).Should(Receive(Equal(backend.ErrMissingDockerRegistry)))
+			})
+
+			It("stops retrying when the CC goes away", func() {
+				fakeBackend.BuildRecipeReturns(nil, "", "", backend.ErrMissingDockerRegistry)
+
+				requestJson, err := json.Marshal(stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+				req, err := http.NewRequest("PUT", "/v1/staging/a-staging-guid", bytes.NewReader(requestJson))
+				Expect(err).NotTo(HaveOccurred())
+				req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
+
+				recorder := &closeNotifyingRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool, 1)}
+				done := make(chan struct{})
+				go func() {
+					handler.Stage(recorder, req)
+					close(done)
+				}()
+
+				Eventually(fakeBackend.BuildRecipeCallCount).Should(Equal(1))
+				recorder.closed <- true
+
+				Eventually(done).Should(BeClosed())
+				Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(1))
+				Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+	})
+
 	Describe("StopStaging", func() {
 		BeforeEach(func() {
 			stagingTask := &models.Task{
@@ -336,3 +629,12 @@ var _ = Describe("StagingHandler", func() {
 		})
 	})
 })
+
+type closeNotifyingRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r *closeNotifyingRecorder) CloseNotify() <-chan bool {
+	return r.closed
+}
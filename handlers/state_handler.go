@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/cloudfoundry-incubator/stager/state"
+)
+
+type StateHandler interface {
+	Export(resp http.ResponseWriter, req *http.Request)
+	HandOver(resp http.ResponseWriter, req *http.Request)
+}
+
+type stateHandler struct {
+	restageController restage.Controller
+	stagingHistory    history.History
+}
+
+func NewStateHandler(restageController restage.Controller, stagingHistory history.History) StateHandler {
+	return &stateHandler{
+		restageController: restageController,
+		stagingHistory:    stagingHistory,
+	}
+}
+
+func (handler *stateHandler) Export(resp http.ResponseWriter, req *http.Request) {
+	writeJSON(resp, state.Export(handler.restageController, handler.stagingHistory))
+}
+
+func (handler *stateHandler) HandOver(resp http.ResponseWriter, req *http.Request) {
+	writeJSON(resp, state.HandOver(handler.restageController, handler.stagingHistory))
+}
@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/handlers"
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	restage_fakes "github.com/cloudfoundry-incubator/stager/restage/fakes"
+	"github.com/cloudfoundry-incubator/stager/state"
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StateHandler", func() {
+	var (
+		fakeController   *restage_fakes.FakeController
+		stagingHistory   history.History
+		responseRecorder *httptest.ResponseRecorder
+		handler          handlers.StateHandler
+	)
+
+	BeforeEach(func() {
+		snapshots := []restage.CampaignSnapshot{
+			{Campaign: restage.Campaign{Id: "campaign"}, Next: 1},
+		}
+		fakeController = &restage_fakes.FakeController{}
+		fakeController.SnapshotReturns(snapshots)
+		fakeController.HandOverReturns(snapshots)
+
+		stagingHistory = history.New(history.DefaultSize, fakeclock.NewFakeClock(time.Now()))
+		stagingHistory.Delivered("staging-guid")
+
+		responseRecorder = httptest.NewRecorder()
+		handler = handlers.NewStateHandler(fakeController, stagingHistory)
+	})
+
+	expectState := func() {
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+		var exported state.State
+		Expect(json.Unmarshal(responseRecorder.Body.Bytes(), &exported)).To(Succeed())
+		Expect(exported.Version).To(Equal(state.Version))
+		Expect(exported.RestageCampaigns).To(HaveLen(1))
+		Expect(exported.RestageCampaigns[0].Next).To(Equal(1))
+		Expect(exported.StagingHistory).To(HaveLen(1))
+		Expect(exported.StagingHistory[0].StagingGuid).To(Equal("staging-guid"))
+	}
+
+	Describe("Export", func() {
+		It("exports the stager state without handing over the restage campaigns", func() {
+			handler.Export(responseRecorder, &http.Request{})
+
+			expectState()
+			Expect(fakeController.HandOverCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("HandOver", func() {
+		It("hands over the restage campaigns and exports the stager state", func() {
+			handler.HandOver(responseRecorder, &http.Request{})
+
+			expectState()
+			Expect(fakeController.HandOverCallCount()).To(Equal(1))
+		})
+	})
+})
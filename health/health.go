@@ -0,0 +1,60 @@
+package health
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry/gunk/diegonats"
+)
+
+var (
+	ErrBBSUnreachable  = errors.New("bbs did not respond to ping")
+	ErrNATSUnreachable = errors.New("nats did not respond to ping")
+)
+
+// Checker reports whether a dependency of the stager is usable.
+type Checker func() error
+
+func BBSCheck(bbsClient bbs.Client) Checker {
+	return func() error {
+		if !bbsClient.Ping() {
+			return ErrBBSUnreachable
+		}
+		return nil
+	}
+}
+
+func NATSCheck(natsClient diegonats.NATSClient) Checker {
+	return func() error {
+		if !natsClient.Ping() {
+			return ErrNATSUnreachable
+		}
+		return nil
+	}
+}
+
+// TCPCheck succeeds when a connection can be opened to address, e.g. to
+// check that the CC is reachable without depending on any of its endpoints.
+func TCPCheck(address string, timeout time.Duration) Checker {
+	return func() error {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// CircuitBreakerCheck fails while the breaker around the CC client is open,
+// i.e. while staging responses are not being delivered.
+func CircuitBreakerCheck(breaker cc_client.CircuitBreaker) Checker {
+	return func() error {
+		if breaker.State() == cc_client.BreakerOpen {
+			return cc_client.ErrCircuitOpen
+		}
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package health_test
+
+import (
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-incubator/bbs/fake_bbs"
+	"github.com/cloudfoundry-incubator/stager/cc_client"
+	"github.com/cloudfoundry-incubator/stager/cc_client/fakes"
+	"github.com/cloudfoundry-incubator/stager/health"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"golang.org/x/net/context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checks", func() {
+	Describe("BBSCheck", func() {
+		var fakeBBSClient *fake_bbs.FakeClient
+
+		BeforeEach(func() {
+			fakeBBSClient = &fake_bbs.FakeClient{}
+		})
+
+		It("succeeds when the BBS responds to ping", func() {
+			fakeBBSClient.PingReturns(true)
+			Expect(health.BBSCheck(fakeBBSClient)()).To(Succeed())
+		})
+
+		It("fails when the BBS does not respond", func() {
+			fakeBBSClient.PingReturns(false)
+			Expect(health.BBSCheck(fakeBBSClient)()).To(Equal(health.ErrBBSUnreachable))
+		})
+	})
+
+	Describe("CircuitBreakerCheck", func() {
+		var (
+			fakeCcClient *fakes.FakeCcClient
+			breaker      cc_client.CircuitBreaker
+		)
+
+		BeforeEach(func() {
+			fakeCcClient = &fakes.FakeCcClient{}
+			breaker = cc_client.NewCircuitBreaker(fakeCcClient, cc_client.BreakerConfig{FailureThreshold: 1, ResetTimeout: time.Minute}, fakeclock.NewFakeClock(time.Now()))
+		})
+
+		It("succeeds while the breaker is closed", func() {
+			Expect(health.CircuitBreakerCheck(breaker)()).To(Succeed())
+		})
+
+		It("fails while the breaker is open", func() {
+			fakeCcClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: 503})
+			breaker.StagingComplete(context.Background(), "staging-guid", []byte(`{}`), lagertest.NewTestLogger("test"))
+
+			Expect(health.CircuitBreakerCheck(breaker)()).To(Equal(cc_client.ErrCircuitOpen))
+		})
+	})
+
+	Describe("TCPCheck", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("succeeds when the address accepts connections", func() {
+			Expect(health.TCPCheck(listener.Addr().String(), time.Second)()).To(Succeed())
+		})
+
+		It("fails when nothing is listening", func() {
+			address := listener.Addr().String()
+			listener.Close()
+			Expect(health.TCPCheck(address, time.Second)()).NotTo(Succeed())
+		})
+	})
+})
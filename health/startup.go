@@ -0,0 +1,64 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+// UnreachableError names the dependencies that were still failing when
+// WaitForDependencies gave up.
+type UnreachableError struct {
+	Dependencies []string
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("dependencies unreachable at startup: %v", e.Dependencies)
+}
+
+// WaitForDependencies runs checks until they all pass, retrying the failing
+// ones every interval up to attempts times, so that a dependency that is
+// still starting doesn't crash the stager and a misconfigured one is reported
+// at boot rather than at the first callback.
+func WaitForDependencies(logger lager.Logger, checks map[string]Checker, attempts int, interval time.Duration, clock clock.Clock) error {
+	logger = logger.Session("wait-for-dependencies")
+
+	pending := make(map[string]Checker, len(checks))
+	for name, check := range checks {
+		pending[name] = check
+	}
+
+	for attempt := 1; ; attempt++ {
+		for name, check := range pending {
+			err := check()
+			if err != nil {
+				logger.Info("unreachable", lager.Data{"dependency": name, "attempt": attempt, "error": err.Error()})
+				continue
+			}
+
+			logger.Info("reachable", lager.Data{"dependency": name})
+			delete(pending, name)
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if attempt >= attempts {
+			break
+		}
+
+		clock.Sleep(interval)
+	}
+
+	unreachable := make([]string, 0, len(pending))
+	for name := range pending {
+		unreachable = append(unreachable, name)
+	}
+	sort.Strings(unreachable)
+
+	return &UnreachableError{Dependencies: unreachable}
+}
@@ -0,0 +1,72 @@
+package health_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/health"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WaitForDependencies", func() {
+	var (
+		logger    *lagertest.TestLogger
+		fakeClock *fakeclock.FakeClock
+
+		bbsCalls int
+		checks   map[string]health.Checker
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		bbsCalls = 0
+		checks = map[string]health.Checker{
+			"bbs": func() error {
+				bbsCalls++
+				if bbsCalls < 3 {
+					return errors.New("not yet")
+				}
+				return nil
+			},
+			"cc": func() error { return nil },
+		}
+	})
+
+	waitForDependencies := func(attempts int) <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- health.WaitForDependencies(logger, checks, attempts, time.Second, fakeClock)
+		}()
+		return errCh
+	}
+
+	It("retries until every dependency is reachable", func() {
+		errCh := waitForDependencies(5)
+
+		Eventually(func() <-chan error {
+			fakeClock.Increment(time.Second)
+			return errCh
+		}).Should(Receive(BeNil()))
+
+		Expect(bbsCalls).To(Equal(3))
+		Expect(logger.LogMessages()).To(ContainElement("test.wait-for-dependencies.unreachable"))
+	})
+
+	It("gives up after the given attempts, naming the unreachable dependencies", func() {
+		errCh := waitForDependencies(2)
+
+		var err error
+		Eventually(func() <-chan error {
+			fakeClock.Increment(time.Second)
+			return errCh
+		}).Should(Receive(&err))
+
+		Expect(err).To(Equal(&health.UnreachableError{Dependencies: []string{"bbs"}}))
+	})
+})
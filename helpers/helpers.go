@@ -2,6 +2,9 @@ package helpers
 
 import (
 	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
 
 	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
 )
@@ -16,3 +19,59 @@ func BuildDockerStagingData(dockerImage string) (*json.RawMessage, error) {
 	jsonRawMessage := json.RawMessage(rawJsonBytes)
 	return &jsonRawMessage, nil
 }
+
+var ErrInvalidImageDigest = errors.New("invalid docker image digest")
+
+// imageDigestPattern is the digest grammar of the OCI image spec, e.g.
+// sha256:<64 hex digits>.
+var imageDigestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+// ValidateImageDigest checks that digest is an OCI digest, and that sha256
+// and sha512 digests have the length of their hashes.
+func ValidateImageDigest(digest string) error {
+	if !imageDigestPattern.MatchString(digest) {
+		return ErrInvalidImageDigest
+	}
+
+	parts := strings.SplitN(digest, ":", 2)
+	encodedLength := map[string]int{"sha256": 64, "sha512": 128}[parts[0]]
+	if encodedLength > 0 && (len(parts[1]) != encodedLength || strings.ToLower(parts[1]) != parts[1]) {
+		return ErrInvalidImageDigest
+	}
+	return nil
+}
+
+// BuildPinnedDockerStagingData pins the image to the digest it was staged
+// from, so that the app runs exactly that image even if its tag is pushed
+// again, and reports the digest as docker_image_digest, and the media type
+// of its manifest, if known, as docker_image_media_type. Without a digest it
+// is BuildDockerStagingData.
+func BuildPinnedDockerStagingData(dockerImage, digest, mediaType string) (*json.RawMessage, error) {
+	if digest == "" {
+		return BuildDockerStagingData(dockerImage)
+	}
+
+	err := ValidateImageDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(dockerImage, "@") {
+		dockerImage += "@" + digest
+	}
+
+	rawJsonBytes, err := json.Marshal(struct {
+		cc_messages.DockerStagingData
+		DockerImageDigest    string `json:"docker_image_digest"`
+		DockerImageMediaType string `json:"docker_image_media_type,omitempty"`
+	}{
+		DockerStagingData:    cc_messages.DockerStagingData{DockerImageUrl: dockerImage},
+		DockerImageDigest:    digest,
+		DockerImageMediaType: mediaType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	jsonRawMessage := json.RawMessage(rawJsonBytes)
+	return &jsonRawMessage, nil
+}
@@ -1,6 +1,8 @@
 package helpers_test
 
 import (
+	"strings"
+
 	"github.com/cloudfoundry-incubator/stager/helpers"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -19,4 +21,64 @@ var _ = Describe("Stager helpers", func() {
 		})
 	})
 
+	Describe("BuildPinnedDockerStagingData", func() {
+		const digest = "sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f"
+
+		It("pins the image to the digest", func() {
+			lifecycleData, err := helpers.BuildPinnedDockerStagingData("cloudfoundry/diego-docker-app:latest", digest, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect([]byte(*lifecycleData)).To(MatchJSON(`{
+				"docker_image": "cloudfoundry/diego-docker-app:latest@` + digest + `",
+				"docker_image_digest": "` + digest + `"
+			}`))
+		})
+
+		It("keeps images that are already pinned", func() {
+			lifecycleData, err := helpers.BuildPinnedDockerStagingData("cloudfoundry/diego-docker-app@"+digest, digest, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect([]byte(*lifecycleData)).To(MatchJSON(`{
+				"docker_image": "cloudfoundry/diego-docker-app@` + digest + `",
+				"docker_image_digest": "` + digest + `"
+			}`))
+		})
+
+		It("reports the media type of the image's manifest", func() {
+			lifecycleData, err := helpers.BuildPinnedDockerStagingData("cloudfoundry/diego-docker-app", digest, "application/vnd.oci.image.index.v1+json")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect([]byte(*lifecycleData)).To(MatchJSON(`{
+				"docker_image": "cloudfoundry/diego-docker-app@` + digest + `",
+				"docker_image_digest": "` + digest + `",
+				"docker_image_media_type": "application/vnd.oci.image.index.v1+json"
+			}`))
+		})
+
+		It("rejects invalid digests", func() {
+			_, err := helpers.BuildPinnedDockerStagingData("cloudfoundry/diego-docker-app", "sha256:abc", "")
+			Expect(err).To(Equal(helpers.ErrInvalidImageDigest))
+		})
+
+		It("leaves the image unpinned without a digest", func() {
+			lifecycleData, err := helpers.BuildPinnedDockerStagingData("cloudfoundry/diego-docker-app", "", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect([]byte(*lifecycleData)).To(MatchJSON(`{"docker_image":"cloudfoundry/diego-docker-app"}`))
+		})
+	})
+
+	Describe("ValidateImageDigest", func() {
+		It("accepts OCI digests", func() {
+			Expect(helpers.ValidateImageDigest("sha256:c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f")).To(Succeed())
+			Expect(helpers.ValidateImageDigest("sha512:" + strings.Repeat("ab", 64))).To(Succeed())
+			Expect(helpers.ValidateImageDigest("multihash+base58:QmRZxt2b1FVZPNqd8hsiykDL3TdBDeTSPX9Kv46HmX4Gx8")).To(Succeed())
+		})
+
+		It("rejects malformed digests", func() {
+			Expect(helpers.ValidateImageDigest("c5439d7db88ab5423999530349d327b04279ad3161d7596d2126dfb5b02bfd1f")).To(Equal(helpers.ErrInvalidImageDigest))
+			Expect(helpers.ValidateImageDigest("sha256:C5439D7DB88AB5423999530349D327B04279AD3161D7596D2126DFB5B02BFD1F")).To(Equal(helpers.ErrInvalidImageDigest))
+			Expect(helpers.ValidateImageDigest("sha512:abcd")).To(Equal(helpers.ErrInvalidImageDigest))
+		})
+	})
 })
@@ -0,0 +1,126 @@
+package history
+
+import (
+	"sync"
+
+	"github.com/pivotal-golang/clock"
+)
+
+// DefaultSize is how many stagings a History remembers by default.
+const DefaultSize = 10000
+
+type State string
+
+const (
+	// Pending stagings have completed in Diego, but their response has not
+	// been delivered to the CC yet.
+	Pending State = "pending"
+	// Delivered stagings have had their response accepted by the CC.
+	Delivered State = "delivered"
+)
+
+type Entry struct {
+	StagingGuid string `json:"staging_guid"`
+	State       State  `json:"state"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// History records the most recent stagings whose completion callback this
+// stager has received. Delivered entries are dedupe markers: a replayed
+// callback for them need not be delivered to the CC again.
+type History interface {
+	Pending(stagingGuid string)
+	Delivered(stagingGuid string)
+	WasDelivered(stagingGuid string) bool
+	Entries() []Entry
+	Restore(entries []Entry)
+}
+
+type history struct {
+	size  int
+	clock clock.Clock
+
+	lock    sync.Mutex
+	entries map[string]Entry
+	order   []string
+}
+
+// New returns a History remembering up to size stagings, forgetting the
+// least recently updated first.
+func New(size int, clock clock.Clock) History {
+	return &history{
+		size:    size,
+		clock:   clock,
+		entries: map[string]Entry{},
+	}
+}
+
+func (h *history) Pending(stagingGuid string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.entries[stagingGuid].State == Delivered {
+		return
+	}
+	h.record(Entry{StagingGuid: stagingGuid, State: Pending, UpdatedAt: h.clock.Now().UnixNano()})
+}
+
+func (h *history) Delivered(stagingGuid string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.record(Entry{StagingGuid: stagingGuid, State: Delivered, UpdatedAt: h.clock.Now().UnixNano()})
+}
+
+func (h *history) WasDelivered(stagingGuid string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.entries[stagingGuid].State == Delivered
+}
+
+// Entries returns the remembered stagings, least recently updated first.
+func (h *history) Entries() []Entry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	entries := make([]Entry, 0, len(h.order))
+	for _, guid := range h.order {
+		entries = append(entries, h.entries[guid])
+	}
+	return entries
+}
+
+// Restore records entries exported by another stager, in order.
+func (h *history) Restore(entries []Entry) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, entry := range entries {
+		h.record(entry)
+	}
+}
+
+func (h *history) record(entry Entry) {
+	if _, ok := h.entries[entry.StagingGuid]; ok {
+		h.remove(entry.StagingGuid)
+	}
+
+	h.entries[entry.StagingGuid] = entry
+	h.order = append(h.order, entry.StagingGuid)
+
+	for len(h.order) > h.size {
+		delete(h.entries, h.order[0])
+		h.order = h.order[1:]
+	}
+}
+
+func (h *history) remove(stagingGuid string) {
+	delete(h.entries, stagingGuid)
+	for i, guid := range h.order {
+		if guid == stagingGuid {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			return
+		}
+	}
+}
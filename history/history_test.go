@@ -0,0 +1,65 @@
+package history_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("History", func() {
+	var (
+		fakeClock      *fakeclock.FakeClock
+		stagingHistory history.History
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Unix(0, 100))
+		stagingHistory = history.New(2, fakeClock)
+	})
+
+	It("marks delivered stagings", func() {
+		stagingHistory.Pending("staging-guid")
+		Expect(stagingHistory.WasDelivered("staging-guid")).To(BeFalse())
+
+		stagingHistory.Delivered("staging-guid")
+		Expect(stagingHistory.WasDelivered("staging-guid")).To(BeTrue())
+		Expect(stagingHistory.Entries()).To(Equal([]history.Entry{
+			{StagingGuid: "staging-guid", State: history.Delivered, UpdatedAt: 100},
+		}))
+	})
+
+	It("does not mark a delivered staging as pending again", func() {
+		stagingHistory.Delivered("staging-guid")
+		stagingHistory.Pending("staging-guid")
+		Expect(stagingHistory.WasDelivered("staging-guid")).To(BeTrue())
+	})
+
+	It("forgets the least recently updated stagings", func() {
+		stagingHistory.Delivered("first")
+		stagingHistory.Pending("second")
+		stagingHistory.Delivered("first")
+		stagingHistory.Pending("third")
+
+		Expect(stagingHistory.WasDelivered("first")).To(BeTrue())
+		Expect(stagingHistory.Entries()).To(Equal([]history.Entry{
+			{StagingGuid: "first", State: history.Delivered, UpdatedAt: 100},
+			{StagingGuid: "third", State: history.Pending, UpdatedAt: 100},
+		}))
+	})
+
+	It("restores exported entries", func() {
+		entries := []history.Entry{
+			{StagingGuid: "delivered", State: history.Delivered, UpdatedAt: 1},
+			{StagingGuid: "pending", State: history.Pending, UpdatedAt: 2},
+		}
+		stagingHistory.Restore(entries)
+
+		Expect(stagingHistory.WasDelivered("delivered")).To(BeTrue())
+		Expect(stagingHistory.WasDelivered("pending")).To(BeFalse())
+		Expect(stagingHistory.Entries()).To(Equal(entries))
+	})
+})
@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotal-golang/lager"
+)
+
+// Log formats. JSON is lager's own, with timestamps in seconds since the
+// epoch. RFC3339 is lager's JSON with RFC 3339 timestamps, as in RFC 5424
+// syslog messages. Human is a line of text per entry.
+const (
+	FormatJSON    = "json"
+	FormatRFC3339 = "rfc3339"
+	FormatHuman   = "human"
+)
+
+var (
+	ErrLogFormatInvalid = errors.New("log format must be json, rfc3339 or human")
+	ErrLogLevelInvalid  = errors.New("log level must be debug, info, error or fatal")
+)
+
+var levelNames = map[lager.LogLevel]string{
+	lager.DEBUG: "debug",
+	lager.INFO:  "info",
+	lager.ERROR: "error",
+	lager.FATAL: "fatal",
+}
+
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatJSON, FormatRFC3339, FormatHuman:
+		return nil
+	default:
+		return ErrLogFormatInvalid
+	}
+}
+
+// ParseLevel parses a log level as given to -logLevel.
+func ParseLevel(level string) (lager.LogLevel, error) {
+	for logLevel, name := range levelNames {
+		if name == level {
+			return logLevel, nil
+		}
+	}
+	return lager.DEBUG, ErrLogLevelInvalid
+}
+
+type sink struct {
+	lock     sync.Mutex
+	writer   io.Writer
+	format   string
+	minLevel lager.LogLevel
+}
+
+// NewSink returns a sink that writes entries of at least minLevel to writer
+// in format, one entry per write.
+func NewSink(writer io.Writer, format string, minLevel lager.LogLevel) lager.Sink {
+	return &sink{
+		writer:   writer,
+		format:   format,
+		minLevel: minLevel,
+	}
+}
+
+func (s *sink) Log(level lager.LogLevel, payload []byte) {
+	if level < s.minLevel {
+		return
+	}
+
+	line, err := formatEntry(s.format, payload)
+	if err != nil {
+		line = payload
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writer.Write(append(line, '\n'))
+}
+
+func formatEntry(format string, payload []byte) ([]byte, error) {
+	if format != FormatRFC3339 && format != FormatHuman {
+		return payload, nil
+	}
+
+	var entry lager.LogFormat
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	err := decoder.Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := parseTimestamp(entry.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatRFC3339 {
+		entry.Timestamp = timestamp.Format(time.RFC3339Nano)
+		return json.Marshal(entry)
+	}
+	return humanEntry(timestamp, entry), nil
+}
+
+// parseTimestamp parses lager's timestamps, seconds since the epoch with
+// up to nine decimal places.
+func parseTimestamp(timestamp string) (time.Time, error) {
+	parts := strings.SplitN(timestamp, ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nanoseconds int64
+	if len(parts) == 2 {
+		fraction := (parts[1] + "000000000")[:9]
+		nanoseconds, err = strconv.ParseInt(fraction, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(seconds, nanoseconds).UTC(), nil
+}
+
+// humanEntry renders an entry as its timestamp, level and message followed
+// by its data as key=value pairs in key order.
+func humanEntry(timestamp time.Time, entry lager.LogFormat) []byte {
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%s [%s] %s", timestamp.Format(time.RFC3339Nano), levelNames[entry.LogLevel], entry.Message)
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := entry.Data[key].(string)
+		if !ok || strings.ContainsAny(value, " \t\n\"=") {
+			encoded, _ := json.Marshal(entry.Data[key])
+			value = string(encoded)
+		}
+		fmt.Fprintf(&line, " %s=%s", key, value)
+	}
+
+	return line.Bytes()
+}
@@ -0,0 +1,67 @@
+package logging_test
+
+import (
+	"github.com/cloudfoundry-incubator/stager/logging"
+	"github.com/pivotal-golang/lager"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Logging", func() {
+	const payload = `{"timestamp":"1444903200.123456789","source":"stager","message":"stager.staging-request.desired","log_level":1,"data":{"session":"2","staging-guid":"the-guid","error":"no such stack","memory-mb":1024}}`
+
+	var buffer *gbytes.Buffer
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+	})
+
+	Describe("NewSink", func() {
+		It("writes lager's JSON as is", func() {
+			logging.NewSink(buffer, logging.FormatJSON, lager.DEBUG).Log(lager.INFO, []byte(payload))
+			Expect(string(buffer.Contents())).To(Equal(payload + "\n"))
+		})
+
+		It("rewrites timestamps as RFC 3339", func() {
+			logging.NewSink(buffer, logging.FormatRFC3339, lager.DEBUG).Log(lager.INFO, []byte(payload))
+			Expect(buffer.Contents()).To(MatchJSON(`{
+				"timestamp": "2015-10-15T10:00:00.123456789Z",
+				"source": "stager",
+				"message": "stager.staging-request.desired",
+				"log_level": 1,
+				"data": {"session": "2", "staging-guid": "the-guid", "error": "no such stack", "memory-mb": 1024}
+			}`))
+		})
+
+		It("writes a line of text for humans", func() {
+			logging.NewSink(buffer, logging.FormatHuman, lager.DEBUG).Log(lager.INFO, []byte(payload))
+			Expect(string(buffer.Contents())).To(Equal(
+				`2015-10-15T10:00:00.123456789Z [info] stager.staging-request.desired error="no such stack" memory-mb=1024 session=2 staging-guid=the-guid` + "\n",
+			))
+		})
+
+		It("drops entries below its level", func() {
+			logging.NewSink(buffer, logging.FormatHuman, lager.INFO).Log(lager.DEBUG, []byte(payload))
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+
+	Describe("ValidateFormat", func() {
+		It("accepts the known formats only", func() {
+			Expect(logging.ValidateFormat(logging.FormatJSON)).To(Succeed())
+			Expect(logging.ValidateFormat(logging.FormatRFC3339)).To(Succeed())
+			Expect(logging.ValidateFormat(logging.FormatHuman)).To(Succeed())
+			Expect(logging.ValidateFormat("xml")).To(Equal(logging.ErrLogFormatInvalid))
+		})
+	})
+
+	Describe("ParseLevel", func() {
+		It("parses -logLevel's levels", func() {
+			Expect(logging.ParseLevel("error")).To(Equal(lager.ERROR))
+			_, err := logging.ParseLevel("loud")
+			Expect(err).To(Equal(logging.ErrLogLevelInvalid))
+		})
+	})
+})
@@ -0,0 +1,47 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/nats_emitter"
+	"github.com/pivotal-golang/lager"
+)
+
+type FakeEmitter struct {
+	EmitStagingFinishedStub        func(logger lager.Logger, stagingGuid string, response cc_messages.StagingResponseForCC)
+	emitStagingFinishedMutex       sync.RWMutex
+	emitStagingFinishedArgsForCall []struct {
+		logger      lager.Logger
+		stagingGuid string
+		response    cc_messages.StagingResponseForCC
+	}
+}
+
+func (fake *FakeEmitter) EmitStagingFinished(logger lager.Logger, stagingGuid string, response cc_messages.StagingResponseForCC) {
+	fake.emitStagingFinishedMutex.Lock()
+	fake.emitStagingFinishedArgsForCall = append(fake.emitStagingFinishedArgsForCall, struct {
+		logger      lager.Logger
+		stagingGuid string
+		response    cc_messages.StagingResponseForCC
+	}{logger, stagingGuid, response})
+	fake.emitStagingFinishedMutex.Unlock()
+	if fake.EmitStagingFinishedStub != nil {
+		fake.EmitStagingFinishedStub(logger, stagingGuid, response)
+	}
+}
+
+func (fake *FakeEmitter) EmitStagingFinishedCallCount() int {
+	fake.emitStagingFinishedMutex.RLock()
+	defer fake.emitStagingFinishedMutex.RUnlock()
+	return len(fake.emitStagingFinishedArgsForCall)
+}
+
+func (fake *FakeEmitter) EmitStagingFinishedArgsForCall(i int) (lager.Logger, string, cc_messages.StagingResponseForCC) {
+	fake.emitStagingFinishedMutex.RLock()
+	defer fake.emitStagingFinishedMutex.RUnlock()
+	return fake.emitStagingFinishedArgsForCall[i].logger, fake.emitStagingFinishedArgsForCall[i].stagingGuid, fake.emitStagingFinishedArgsForCall[i].response
+}
+
+var _ nats_emitter.Emitter = new(FakeEmitter)
@@ -0,0 +1,59 @@
+package nats_emitter
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry/gunk/diegonats"
+	"github.com/pivotal-golang/lager"
+)
+
+const StagingFinishedSubject = "diego.staging.finished"
+
+type StagingFinishedMessage struct {
+	StagingGuid string `json:"staging_guid"`
+	cc_messages.StagingResponseForCC
+}
+
+//go:generate counterfeiter -o fakes/fake_emitter.go . Emitter
+type Emitter interface {
+	EmitStagingFinished(logger lager.Logger, stagingGuid string, response cc_messages.StagingResponseForCC)
+}
+
+type natsEmitter struct {
+	natsClient diegonats.NATSClient
+}
+
+func New(natsClient diegonats.NATSClient) Emitter {
+	return &natsEmitter{natsClient: natsClient}
+}
+
+func (e *natsEmitter) EmitStagingFinished(logger lager.Logger, stagingGuid string, response cc_messages.StagingResponseForCC) {
+	logger = logger.Session("nats-emitter", lager.Data{"staging-guid": stagingGuid})
+
+	payload, err := json.Marshal(StagingFinishedMessage{
+		StagingGuid:          stagingGuid,
+		StagingResponseForCC: response,
+	})
+	if err != nil {
+		logger.Error("marshal-staging-finished-failed", err)
+		return
+	}
+
+	err = e.natsClient.Publish(StagingFinishedSubject, payload)
+	if err != nil {
+		logger.Error("publish-staging-finished-failed", err)
+		return
+	}
+
+	logger.Debug("published-staging-finished")
+}
+
+type noopEmitter struct{}
+
+// NewNoopEmitter is used when no NATS cluster is configured.
+func NewNoopEmitter() Emitter {
+	return noopEmitter{}
+}
+
+func (noopEmitter) EmitStagingFinished(lager.Logger, string, cc_messages.StagingResponseForCC) {}
@@ -0,0 +1,47 @@
+package nats_emitter_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/nats_emitter"
+	"github.com/cloudfoundry/gunk/diegonats"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NatsEmitter", func() {
+	var (
+		fakeNatsClient *diegonats.FakeNATSClient
+		emitter        nats_emitter.Emitter
+	)
+
+	BeforeEach(func() {
+		fakeNatsClient = diegonats.NewFakeClient()
+		emitter = nats_emitter.New(fakeNatsClient)
+	})
+
+	It("publishes the staging response on diego.staging.finished", func() {
+		response := cc_messages.StagingResponseForCC{
+			ExecutionMetadata:    "metadata",
+			DetectedStartCommand: map[string]string{"web": "./start"},
+		}
+
+		emitter.EmitStagingFinished(lagertest.NewTestLogger("test"), "the-guid", response)
+
+		messages := fakeNatsClient.PublishedMessages(nats_emitter.StagingFinishedSubject)
+		Expect(messages).To(HaveLen(1))
+
+		Expect(messages[0].Data).To(MatchJSON(`{
+			"staging_guid": "the-guid",
+			"execution_metadata": "metadata",
+			"detected_start_command": {"web": "./start"}
+		}`))
+
+		var message nats_emitter.StagingFinishedMessage
+		Expect(json.Unmarshal(messages[0].Data, &message)).To(Succeed())
+		Expect(message.StagingResponseForCC).To(Equal(response))
+	})
+})
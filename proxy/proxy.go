@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Func returns the proxy function for the stager's outbound requests. Without
+// a proxyURL it honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY, as
+// http.ProxyFromEnvironment does. With one, every request goes through
+// proxyURL except requests to loopback addresses, such as a local consul
+// agent.
+func Func(proxyURL *url.URL) func(*http.Request) (*url.URL, error) {
+	if proxyURL == nil {
+		return http.ProxyFromEnvironment
+	}
+
+	return func(request *http.Request) (*url.URL, error) {
+		if isLoopback(request.URL.Host) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+func isLoopback(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,40 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/cloudfoundry-incubator/stager/proxy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Func", func() {
+	var proxyURL *url.URL
+
+	BeforeEach(func() {
+		var err error
+		proxyURL, err = url.Parse("http://proxy.example.com:3128")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	proxyFor := func(target string) *url.URL {
+		request, err := http.NewRequest("GET", target, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		proxied, err := proxy.Func(proxyURL)(request)
+		Expect(err).NotTo(HaveOccurred())
+		return proxied
+	}
+
+	It("sends requests through the explicit proxy", func() {
+		Expect(proxyFor("https://cc.example.com/internal/staging/guid/completed")).To(Equal(proxyURL))
+	})
+
+	It("does not proxy requests to loopback addresses", func() {
+		Expect(proxyFor("http://127.0.0.1:8500/v1/catalog/service/docker-registry")).To(BeNil())
+		Expect(proxyFor("http://localhost:8500/v1/catalog/service/docker-registry")).To(BeNil())
+		Expect(proxyFor("http://[::1]:8500/v1/catalog/service/docker-registry")).To(BeNil())
+	})
+})
@@ -0,0 +1,298 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"os"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/restage"
+)
+
+type FakeController struct {
+	RunStub        func(signals <-chan os.Signal, ready chan<- struct{}) error
+	runMutex       sync.RWMutex
+	runArgsForCall []struct {
+		signals <-chan os.Signal
+		ready   chan<- struct{}
+	}
+	runReturns struct {
+		result1 error
+	}
+	SubmitStub        func(campaign restage.Campaign) error
+	submitMutex       sync.RWMutex
+	submitArgsForCall []struct {
+		campaign restage.Campaign
+	}
+	submitReturns struct {
+		result1 error
+	}
+	ProgressStub        func(campaignId string) (restage.Progress, bool)
+	progressMutex       sync.RWMutex
+	progressArgsForCall []struct {
+		campaignId string
+	}
+	progressReturns struct {
+		result1 restage.Progress
+		result2 bool
+	}
+	ChangedLifecyclesStub        func() []string
+	changedLifecyclesMutex       sync.RWMutex
+	changedLifecyclesArgsForCall []struct{}
+	changedLifecyclesReturns     struct {
+		result1 []string
+	}
+	ReloadStub        func(lifecycles map[string]string, changedLifecycles []string)
+	reloadMutex       sync.RWMutex
+	reloadArgsForCall []struct {
+		lifecycles        map[string]string
+		changedLifecycles []string
+	}
+	SnapshotStub        func() []restage.CampaignSnapshot
+	snapshotMutex       sync.RWMutex
+	snapshotArgsForCall []struct{}
+	snapshotReturns     struct {
+		result1 []restage.CampaignSnapshot
+	}
+	HandOverStub        func() []restage.CampaignSnapshot
+	handOverMutex       sync.RWMutex
+	handOverArgsForCall []struct{}
+	handOverReturns     struct {
+		result1 []restage.CampaignSnapshot
+	}
+	RestoreStub        func(snapshots []restage.CampaignSnapshot) error
+	restoreMutex       sync.RWMutex
+	restoreArgsForCall []struct {
+		snapshots []restage.CampaignSnapshot
+	}
+	restoreReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeController) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	fake.runMutex.Lock()
+	fake.runArgsForCall = append(fake.runArgsForCall, struct {
+		signals <-chan os.Signal
+		ready   chan<- struct{}
+	}{signals, ready})
+	fake.runMutex.Unlock()
+	if fake.RunStub != nil {
+		return fake.RunStub(signals, ready)
+	} else {
+		return fake.runReturns.result1
+	}
+}
+
+func (fake *FakeController) RunCallCount() int {
+	fake.runMutex.RLock()
+	defer fake.runMutex.RUnlock()
+	return len(fake.runArgsForCall)
+}
+
+func (fake *FakeController) RunArgsForCall(i int) (<-chan os.Signal, chan<- struct{}) {
+	fake.runMutex.RLock()
+	defer fake.runMutex.RUnlock()
+	return fake.runArgsForCall[i].signals, fake.runArgsForCall[i].ready
+}
+
+func (fake *FakeController) RunReturns(result1 error) {
+	fake.RunStub = nil
+	fake.runReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeController) Submit(campaign restage.Campaign) error {
+	fake.submitMutex.Lock()
+	fake.submitArgsForCall = append(fake.submitArgsForCall, struct {
+		campaign restage.Campaign
+	}{campaign})
+	fake.submitMutex.Unlock()
+	if fake.SubmitStub != nil {
+		return fake.SubmitStub(campaign)
+	} else {
+		return fake.submitReturns.result1
+	}
+}
+
+func (fake *FakeController) SubmitCallCount() int {
+	fake.submitMutex.RLock()
+	defer fake.submitMutex.RUnlock()
+	return len(fake.submitArgsForCall)
+}
+
+func (fake *FakeController) SubmitArgsForCall(i int) restage.Campaign {
+	fake.submitMutex.RLock()
+	defer fake.submitMutex.RUnlock()
+	return fake.submitArgsForCall[i].campaign
+}
+
+func (fake *FakeController) SubmitReturns(result1 error) {
+	fake.SubmitStub = nil
+	fake.submitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeController) Progress(campaignId string) (restage.Progress, bool) {
+	fake.progressMutex.Lock()
+	fake.progressArgsForCall = append(fake.progressArgsForCall, struct {
+		campaignId string
+	}{campaignId})
+	fake.progressMutex.Unlock()
+	if fake.ProgressStub != nil {
+		return fake.ProgressStub(campaignId)
+	} else {
+		return fake.progressReturns.result1, fake.progressReturns.result2
+	}
+}
+
+func (fake *FakeController) ProgressCallCount() int {
+	fake.progressMutex.RLock()
+	defer fake.progressMutex.RUnlock()
+	return len(fake.progressArgsForCall)
+}
+
+func (fake *FakeController) ProgressArgsForCall(i int) string {
+	fake.progressMutex.RLock()
+	defer fake.progressMutex.RUnlock()
+	return fake.progressArgsForCall[i].campaignId
+}
+
+func (fake *FakeController) ProgressReturns(result1 restage.Progress, result2 bool) {
+	fake.ProgressStub = nil
+	fake.progressReturns = struct {
+		result1 restage.Progress
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeController) ChangedLifecycles() []string {
+	fake.changedLifecyclesMutex.Lock()
+	fake.changedLifecyclesArgsForCall = append(fake.changedLifecyclesArgsForCall, struct{}{})
+	fake.changedLifecyclesMutex.Unlock()
+	if fake.ChangedLifecyclesStub != nil {
+		return fake.ChangedLifecyclesStub()
+	} else {
+		return fake.changedLifecyclesReturns.result1
+	}
+}
+
+func (fake *FakeController) ChangedLifecyclesCallCount() int {
+	fake.changedLifecyclesMutex.RLock()
+	defer fake.changedLifecyclesMutex.RUnlock()
+	return len(fake.changedLifecyclesArgsForCall)
+}
+
+func (fake *FakeController) ChangedLifecyclesReturns(result1 []string) {
+	fake.ChangedLifecyclesStub = nil
+	fake.changedLifecyclesReturns = struct {
+		result1 []string
+	}{result1}
+}
+
+func (fake *FakeController) Reload(lifecycles map[string]string, changedLifecycles []string) {
+	fake.reloadMutex.Lock()
+	fake.reloadArgsForCall = append(fake.reloadArgsForCall, struct {
+		lifecycles        map[string]string
+		changedLifecycles []string
+	}{lifecycles, changedLifecycles})
+	fake.reloadMutex.Unlock()
+	if fake.ReloadStub != nil {
+		fake.ReloadStub(lifecycles, changedLifecycles)
+	}
+}
+
+func (fake *FakeController) ReloadCallCount() int {
+	fake.reloadMutex.RLock()
+	defer fake.reloadMutex.RUnlock()
+	return len(fake.reloadArgsForCall)
+}
+
+func (fake *FakeController) ReloadArgsForCall(i int) (map[string]string, []string) {
+	fake.reloadMutex.RLock()
+	defer fake.reloadMutex.RUnlock()
+	return fake.reloadArgsForCall[i].lifecycles, fake.reloadArgsForCall[i].changedLifecycles
+}
+
+func (fake *FakeController) Snapshot() []restage.CampaignSnapshot {
+	fake.snapshotMutex.Lock()
+	fake.snapshotArgsForCall = append(fake.snapshotArgsForCall, struct{}{})
+	fake.snapshotMutex.Unlock()
+	if fake.SnapshotStub != nil {
+		return fake.SnapshotStub()
+	} else {
+		return fake.snapshotReturns.result1
+	}
+}
+
+func (fake *FakeController) SnapshotCallCount() int {
+	fake.snapshotMutex.RLock()
+	defer fake.snapshotMutex.RUnlock()
+	return len(fake.snapshotArgsForCall)
+}
+
+func (fake *FakeController) SnapshotReturns(result1 []restage.CampaignSnapshot) {
+	fake.SnapshotStub = nil
+	fake.snapshotReturns = struct {
+		result1 []restage.CampaignSnapshot
+	}{result1}
+}
+
+func (fake *FakeController) HandOver() []restage.CampaignSnapshot {
+	fake.handOverMutex.Lock()
+	fake.handOverArgsForCall = append(fake.handOverArgsForCall, struct{}{})
+	fake.handOverMutex.Unlock()
+	if fake.HandOverStub != nil {
+		return fake.HandOverStub()
+	} else {
+		return fake.handOverReturns.result1
+	}
+}
+
+func (fake *FakeController) HandOverCallCount() int {
+	fake.handOverMutex.RLock()
+	defer fake.handOverMutex.RUnlock()
+	return len(fake.handOverArgsForCall)
+}
+
+func (fake *FakeController) HandOverReturns(result1 []restage.CampaignSnapshot) {
+	fake.HandOverStub = nil
+	fake.handOverReturns = struct {
+		result1 []restage.CampaignSnapshot
+	}{result1}
+}
+
+func (fake *FakeController) Restore(snapshots []restage.CampaignSnapshot) error {
+	fake.restoreMutex.Lock()
+	fake.restoreArgsForCall = append(fake.restoreArgsForCall, struct {
+		snapshots []restage.CampaignSnapshot
+	}{snapshots})
+	fake.restoreMutex.Unlock()
+	if fake.RestoreStub != nil {
+		return fake.RestoreStub(snapshots)
+	} else {
+		return fake.restoreReturns.result1
+	}
+}
+
+func (fake *FakeController) RestoreCallCount() int {
+	fake.restoreMutex.RLock()
+	defer fake.restoreMutex.RUnlock()
+	return len(fake.restoreArgsForCall)
+}
+
+func (fake *FakeController) RestoreArgsForCall(i int) []restage.CampaignSnapshot {
+	fake.restoreMutex.RLock()
+	defer fake.restoreMutex.RUnlock()
+	return fake.restoreArgsForCall[i].snapshots
+}
+
+func (fake *FakeController) RestoreReturns(result1 error) {
+	fake.RestoreStub = nil
+	fake.restoreReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ restage.Controller = new(FakeController)
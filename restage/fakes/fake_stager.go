@@ -0,0 +1,59 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/pivotal-golang/lager"
+)
+
+type FakeStager struct {
+	StageRequestStub        func(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error
+	stageRequestMutex       sync.RWMutex
+	stageRequestArgsForCall []struct {
+		logger      lager.Logger
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+	}
+	stageRequestReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeStager) StageRequest(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error {
+	fake.stageRequestMutex.Lock()
+	fake.stageRequestArgsForCall = append(fake.stageRequestArgsForCall, struct {
+		logger      lager.Logger
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+	}{logger, stagingGuid, request})
+	fake.stageRequestMutex.Unlock()
+	if fake.StageRequestStub != nil {
+		return fake.StageRequestStub(logger, stagingGuid, request)
+	} else {
+		return fake.stageRequestReturns.result1
+	}
+}
+
+func (fake *FakeStager) StageRequestCallCount() int {
+	fake.stageRequestMutex.RLock()
+	defer fake.stageRequestMutex.RUnlock()
+	return len(fake.stageRequestArgsForCall)
+}
+
+func (fake *FakeStager) StageRequestArgsForCall(i int) (lager.Logger, string, cc_messages.StagingRequestFromCC) {
+	fake.stageRequestMutex.RLock()
+	defer fake.stageRequestMutex.RUnlock()
+	return fake.stageRequestArgsForCall[i].logger, fake.stageRequestArgsForCall[i].stagingGuid, fake.stageRequestArgsForCall[i].request
+}
+
+func (fake *FakeStager) StageRequestReturns(result1 error) {
+	fake.StageRequestStub = nil
+	fake.stageRequestReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ restage.Stager = new(FakeStager)
@@ -0,0 +1,87 @@
+package restage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// lifecycleState is what stateFile records: the bundle and the rootfs of
+// every lifecycle, by lifecycle key.
+type lifecycleState struct {
+	Lifecycles map[string]string `json:"lifecycles"`
+	RootFSes   map[string]string `json:"rootfses"`
+}
+
+// DetectLifecycleChanges compares the configured lifecycle bundles and
+// rootfses against those recorded in stateFile by a previous run, returning
+// the sorted keys of lifecycles that were added, now point at a different
+// bundle, or now stage on a different rootfs. rootFSes maps lifecycle keys,
+// e.g. buildpack/cflinuxfs2, to the rootfs they stage on. The current
+// lifecycles are then recorded in stateFile. On first run nothing is
+// reported as changed.
+func DetectLifecycleChanges(stateFile string, lifecycles, rootFSes map[string]string) ([]string, error) {
+	previous, firstRun, err := readLifecycleState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	if !firstRun {
+		for key, bundle := range lifecycles {
+			if previous.Lifecycles[key] != bundle {
+				changed = append(changed, key)
+				continue
+			}
+			// State files written before rootfses were recorded don't
+			// report rootfs changes.
+			if previous.RootFSes != nil && previous.RootFSes[key] != rootFSes[key] {
+				changed = append(changed, key)
+			}
+		}
+		sort.Strings(changed)
+	}
+
+	payload, err := json.Marshal(lifecycleState{Lifecycles: lifecycles, RootFSes: rootFSes})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(stateFile, payload, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return changed, nil
+}
+
+// readLifecycleState reads stateFile, which may also hold just the bundles
+// by lifecycle key, as it did before rootfses were recorded.
+func readLifecycleState(stateFile string) (lifecycleState, bool, error) {
+	payload, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return lifecycleState{}, true, nil
+	}
+	if err != nil {
+		return lifecycleState{}, false, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(payload, &fields)
+	if err != nil {
+		return lifecycleState{}, false, err
+	}
+
+	var state lifecycleState
+	if _, ok := fields["lifecycles"]; ok {
+		err = json.Unmarshal(payload, &state)
+	} else {
+		err = json.Unmarshal(payload, &state.Lifecycles)
+	}
+	if err != nil {
+		return lifecycleState{}, false, err
+	}
+
+	return state, false, nil
+}
@@ -0,0 +1,103 @@
+package restage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry-incubator/stager/restage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DetectLifecycleChanges", func() {
+	var (
+		tmpDir    string
+		stateFile string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle-state")
+		Expect(err).NotTo(HaveOccurred())
+
+		stateFile = filepath.Join(tmpDir, "lifecycles.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Context("when there is no previous state", func() {
+		It("reports no changes and records the lifecycles", func() {
+			changed, err := restage.DetectLifecycleChanges(stateFile, map[string]string{"buildpack/cflinuxfs2": "v1.tgz"}, map[string]string{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeEmpty())
+			Expect(stateFile).To(BeAnExistingFile())
+		})
+	})
+
+	Context("when there is previous state", func() {
+		BeforeEach(func() {
+			_, err := restage.DetectLifecycleChanges(stateFile, map[string]string{
+				"buildpack/cflinuxfs2": "v1.tgz",
+				"docker":               "docker.tgz",
+			}, map[string]string{
+				"buildpack/cflinuxfs2": "preloaded:cflinuxfs2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports added and updated lifecycles", func() {
+			changed, err := restage.DetectLifecycleChanges(stateFile, map[string]string{
+				"buildpack/cflinuxfs2": "v2.tgz",
+				"buildpack/windows":    "windows.tgz",
+				"docker":               "docker.tgz",
+			}, map[string]string{
+				"buildpack/cflinuxfs2": "preloaded:cflinuxfs2",
+				"buildpack/windows":    "preloaded:windows",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(Equal([]string{"buildpack/cflinuxfs2", "buildpack/windows"}))
+		})
+
+		It("reports lifecycles whose rootfs changed", func() {
+			changed, err := restage.DetectLifecycleChanges(stateFile, map[string]string{
+				"buildpack/cflinuxfs2": "v1.tgz",
+				"docker":               "docker.tgz",
+			}, map[string]string{
+				"buildpack/cflinuxfs2": "docker:///cloudfoundry/cflinuxfs2#v2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(Equal([]string{"buildpack/cflinuxfs2"}))
+		})
+	})
+
+	Context("when the previous state has no rootfses", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(stateFile, []byte(`{"buildpack/cflinuxfs2": "v1.tgz"}`), 0644)).To(Succeed())
+		})
+
+		It("compares the bundles only", func() {
+			changed, err := restage.DetectLifecycleChanges(stateFile, map[string]string{
+				"buildpack/cflinuxfs2": "v1.tgz",
+			}, map[string]string{
+				"buildpack/cflinuxfs2": "preloaded:cflinuxfs2",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).To(BeEmpty())
+		})
+	})
+
+	Context("when the state file is corrupt", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(stateFile, []byte("{"), 0644)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			_, err := restage.DetectLifecycleChanges(stateFile, map[string]string{}, map[string]string{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,344 @@
+package restage
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+var (
+	ErrMissingCampaignId = errors.New("restage campaign id is required")
+	ErrCampaignExists    = errors.New("restage campaign already exists")
+	ErrUnknownLifecycle  = errors.New("restage campaign lifecycle is not configured on this stager")
+	ErrInvalidSnapshot   = errors.New("restage campaign snapshot's next app is out of range")
+	ErrInvalidInterval   = errors.New("restage interval must be positive")
+)
+
+type App struct {
+	StagingGuid string                           `json:"staging_guid"`
+	Request     cc_messages.StagingRequestFromCC `json:"staging_request"`
+}
+
+// Campaign is a CC-provided list of apps to restage, typically because the
+// lifecycle bundle or rootfs for Lifecycle (e.g. "buildpack/cflinuxfs2") was
+// updated.
+type Campaign struct {
+	Id        string `json:"id"`
+	Reason    string `json:"reason,omitempty"`
+	Lifecycle string `json:"lifecycle,omitempty"`
+	Apps      []App  `json:"apps"`
+}
+
+// CampaignSnapshot captures a campaign's remaining work so that it can be
+// handed over to another stager.
+type CampaignSnapshot struct {
+	Campaign Campaign `json:"campaign"`
+	Next     int      `json:"next"`
+	Progress Progress `json:"progress"`
+}
+
+type Progress struct {
+	Id         string            `json:"id"`
+	Total      int               `json:"total"`
+	Submitted  int               `json:"submitted"`
+	Failed     int               `json:"failed"`
+	Done       bool              `json:"done"`
+	HandedOver bool              `json:"handed_over,omitempty"`
+	Failures   map[string]string `json:"failures,omitempty"`
+}
+
+//go:generate counterfeiter -o fakes/fake_stager.go . Stager
+
+// Stager runs a single staging request through the normal staging pipeline.
+type Stager interface {
+	StageRequest(logger lager.Logger, stagingGuid string, request cc_messages.StagingRequestFromCC) error
+}
+
+//go:generate counterfeiter -o fakes/fake_controller.go . Controller
+type Controller interface {
+	ifrit.Runner
+	Submit(campaign Campaign) error
+	Progress(campaignId string) (Progress, bool)
+	ChangedLifecycles() []string
+	Reload(lifecycles map[string]string, changedLifecycles []string)
+	Snapshot() []CampaignSnapshot
+	HandOver() []CampaignSnapshot
+	Restore(snapshots []CampaignSnapshot) error
+}
+
+type campaignState struct {
+	campaign Campaign
+	next     int
+	progress Progress
+}
+
+func (s *campaignState) copyProgress() Progress {
+	progress := s.progress
+	if len(s.progress.Failures) > 0 {
+		progress.Failures = make(map[string]string, len(s.progress.Failures))
+		for guid, reason := range s.progress.Failures {
+			progress.Failures[guid] = reason
+		}
+	}
+	return progress
+}
+
+type controller struct {
+	logger            lager.Logger
+	stager            Stager
+	lifecycles        map[string]string
+	changedLifecycles []string
+	interval          time.Duration
+	clock             clock.Clock
+
+	lock      sync.Mutex
+	campaigns map[string]*campaignState
+	order     []string
+	queue     []string
+}
+
+func ValidateInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInvalidInterval
+	}
+	return nil
+}
+
+// NewController returns a Controller that stages one app from the queued
+// campaigns every interval, so mass restages don't flood Diego or the CC.
+func NewController(logger lager.Logger, stager Stager, lifecycles map[string]string, changedLifecycles []string, interval time.Duration, clock clock.Clock) Controller {
+	return &controller{
+		logger:            logger.Session("restage-controller"),
+		stager:            stager,
+		lifecycles:        lifecycles,
+		changedLifecycles: changedLifecycles,
+		interval:          interval,
+		clock:             clock,
+		campaigns:         map[string]*campaignState{},
+	}
+}
+
+func (c *controller) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C():
+			c.stageNext()
+		}
+	}
+}
+
+func (c *controller) Submit(campaign Campaign) error {
+	if campaign.Id == "" {
+		return ErrMissingCampaignId
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if campaign.Lifecycle != "" {
+		if _, ok := c.lifecycles[campaign.Lifecycle]; !ok {
+			return ErrUnknownLifecycle
+		}
+	}
+
+	if _, ok := c.campaigns[campaign.Id]; ok {
+		return ErrCampaignExists
+	}
+
+	c.add(&campaignState{
+		campaign: campaign,
+		progress: Progress{
+			Id:    campaign.Id,
+			Total: len(campaign.Apps),
+			Done:  len(campaign.Apps) == 0,
+		},
+	})
+
+	c.logger.Info("campaign-submitted", lager.Data{
+		"campaign-id": campaign.Id,
+		"reason":      campaign.Reason,
+		"lifecycle":   campaign.Lifecycle,
+		"apps":        len(campaign.Apps),
+	})
+
+	return nil
+}
+
+func (c *controller) Progress(campaignId string) (Progress, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	state, ok := c.campaigns[campaignId]
+	if !ok {
+		return Progress{}, false
+	}
+
+	return state.copyProgress(), true
+}
+
+// Snapshot returns every campaign in submission order, including finished
+// ones so that their progress remains visible after a handover.
+func (c *controller) Snapshot() []CampaignSnapshot {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	snapshots := make([]CampaignSnapshot, 0, len(c.order))
+	for _, id := range c.order {
+		state := c.campaigns[id]
+		snapshots = append(snapshots, CampaignSnapshot{
+			Campaign: state.campaign,
+			Next:     state.next,
+			Progress: state.copyProgress(),
+		})
+	}
+	return snapshots
+}
+
+// HandOver snapshots every campaign, like Snapshot, and stops staging the
+// unfinished ones so that they are only carried on by the stager restoring
+// the snapshots. Their progress here reports them as HandedOver.
+func (c *controller) HandOver() []CampaignSnapshot {
+	snapshots := c.Snapshot()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	handedOver := 0
+	for _, snapshot := range snapshots {
+		state := c.campaigns[snapshot.Campaign.Id]
+		if !state.progress.Done && !state.progress.HandedOver {
+			state.progress.HandedOver = true
+			handedOver++
+		}
+	}
+	c.queue = nil
+
+	c.logger.Info("campaigns-handed-over", lager.Data{"campaigns": handedOver})
+	return snapshots
+}
+
+// Restore queues the remaining work of campaigns snapshotted by another
+// stager. Apps already submitted by that stager are not staged again.
+func (c *controller) Restore(snapshots []CampaignSnapshot) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, snapshot := range snapshots {
+		if _, ok := c.campaigns[snapshot.Campaign.Id]; ok {
+			return ErrCampaignExists
+		}
+		if snapshot.Next < 0 || snapshot.Next > len(snapshot.Campaign.Apps) {
+			return ErrInvalidSnapshot
+		}
+	}
+
+	for _, snapshot := range snapshots {
+		progress := snapshot.Progress
+		progress.HandedOver = false
+		if snapshot.Next == len(snapshot.Campaign.Apps) {
+			progress.Done = true
+		}
+
+		c.add(&campaignState{
+			campaign: snapshot.Campaign,
+			next:     snapshot.Next,
+			progress: progress,
+		})
+	}
+
+	c.logger.Info("campaigns-restored", lager.Data{"campaigns": len(snapshots)})
+	return nil
+}
+
+func (c *controller) add(state *campaignState) {
+	c.campaigns[state.campaign.Id] = state
+	c.order = append(c.order, state.campaign.Id)
+	if !state.progress.Done {
+		c.queue = append(c.queue, state.campaign.Id)
+	}
+}
+
+func (c *controller) ChangedLifecycles() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.changedLifecycles
+}
+
+// Reload replaces the lifecycles that campaigns may restage, after the
+// configuration is reloaded, and adds the lifecycles that changed with it to
+// those changed since the stager started.
+func (c *controller) Reload(lifecycles map[string]string, changedLifecycles []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.lifecycles = lifecycles
+
+	changed := map[string]bool{}
+	for _, key := range c.changedLifecycles {
+		changed[key] = true
+	}
+
+	merged := append([]string{}, c.changedLifecycles...)
+	for _, key := range changedLifecycles {
+		if !changed[key] {
+			changed[key] = true
+			merged = append(merged, key)
+		}
+	}
+	sort.Strings(merged)
+	c.changedLifecycles = merged
+}
+
+func (c *controller) stageNext() {
+	c.lock.Lock()
+	for len(c.queue) > 0 && (c.campaigns[c.queue[0]].progress.Done || c.campaigns[c.queue[0]].progress.HandedOver) {
+		c.queue = c.queue[1:]
+	}
+	if len(c.queue) == 0 {
+		c.lock.Unlock()
+		return
+	}
+
+	state := c.campaigns[c.queue[0]]
+	app := state.campaign.Apps[state.next]
+	state.next++
+	c.lock.Unlock()
+
+	logger := c.logger.Session("restage", lager.Data{"campaign-id": state.campaign.Id, "staging-guid": app.StagingGuid})
+	err := c.stager.StageRequest(logger, app.StagingGuid, app.Request)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	state.progress.Submitted++
+	if err != nil {
+		state.progress.Failed++
+		if state.progress.Failures == nil {
+			state.progress.Failures = map[string]string{}
+		}
+		state.progress.Failures[app.StagingGuid] = err.Error()
+	}
+
+	if state.next == len(state.campaign.Apps) {
+		state.progress.Done = true
+		logger.Info("campaign-finished", lager.Data{
+			"submitted": state.progress.Submitted,
+			"failed":    state.progress.Failed,
+		})
+	}
+}
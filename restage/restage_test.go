@@ -0,0 +1,254 @@
+package restage_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/cloudfoundry-incubator/stager/restage/fakes"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Controller", func() {
+	const interval = time.Second
+
+	var (
+		fakeStager *fakes.FakeStager
+		fakeClock  *fakeclock.FakeClock
+		controller restage.Controller
+		process    ifrit.Process
+	)
+
+	newCampaign := func(id string, guids ...string) restage.Campaign {
+		campaign := restage.Campaign{Id: id, Lifecycle: "buildpack/cflinuxfs2"}
+		for _, guid := range guids {
+			campaign.Apps = append(campaign.Apps, restage.App{
+				StagingGuid: guid,
+				Request:     cc_messages.StagingRequestFromCC{AppId: guid, Lifecycle: "buildpack"},
+			})
+		}
+		return campaign
+	}
+
+	BeforeEach(func() {
+		fakeStager = &fakes.FakeStager{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		lifecycles := map[string]string{"buildpack/cflinuxfs2": "lifecycle.tgz"}
+		controller = restage.NewController(lagertest.NewTestLogger("test"), fakeStager, lifecycles, []string{"buildpack/cflinuxfs2"}, interval, fakeClock)
+		process = ifrit.Invoke(controller)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("reports the changed lifecycles", func() {
+		Expect(controller.ChangedLifecycles()).To(ConsistOf("buildpack/cflinuxfs2"))
+	})
+
+	Describe("Reload", func() {
+		BeforeEach(func() {
+			controller.Reload(map[string]string{
+				"buildpack/cflinuxfs2": "lifecycle.tgz",
+				"buildpack/cflinuxfs3": "lifecycle.tgz",
+			}, []string{"buildpack/cflinuxfs3", "buildpack/cflinuxfs2"})
+		})
+
+		It("adds the lifecycles changed by the reload", func() {
+			Expect(controller.ChangedLifecycles()).To(Equal([]string{"buildpack/cflinuxfs2", "buildpack/cflinuxfs3"}))
+		})
+
+		It("accepts campaigns for the reloaded lifecycles", func() {
+			campaign := newCampaign("campaign", "app-1")
+			campaign.Lifecycle = "buildpack/cflinuxfs3"
+			Expect(controller.Submit(campaign)).To(Succeed())
+		})
+	})
+
+	Describe("Submit", func() {
+		It("rejects campaigns without an id", func() {
+			Expect(controller.Submit(newCampaign(""))).To(Equal(restage.ErrMissingCampaignId))
+		})
+
+		It("rejects campaigns for an unconfigured lifecycle", func() {
+			campaign := newCampaign("campaign")
+			campaign.Lifecycle = "buildpack/unknown"
+			Expect(controller.Submit(campaign)).To(Equal(restage.ErrUnknownLifecycle))
+		})
+
+		It("rejects duplicate campaigns", func() {
+			Expect(controller.Submit(newCampaign("campaign", "app-1"))).To(Succeed())
+			Expect(controller.Submit(newCampaign("campaign", "app-1"))).To(Equal(restage.ErrCampaignExists))
+		})
+	})
+
+	Describe("pacing", func() {
+		BeforeEach(func() {
+			Expect(controller.Submit(newCampaign("first", "app-1", "app-2"))).To(Succeed())
+			Expect(controller.Submit(newCampaign("second", "app-3"))).To(Succeed())
+		})
+
+		It("stages one app per interval, in submission order", func() {
+			Consistently(fakeStager.StageRequestCallCount).Should(Equal(0))
+
+			for i, guid := range []string{"app-1", "app-2", "app-3"} {
+				fakeClock.Increment(interval)
+				Eventually(fakeStager.StageRequestCallCount).Should(Equal(i + 1))
+
+				_, stagingGuid, request := fakeStager.StageRequestArgsForCall(i)
+				Expect(stagingGuid).To(Equal(guid))
+				Expect(request.AppId).To(Equal(guid))
+			}
+		})
+
+		It("reports progress", func() {
+			progress, ok := controller.Progress("first")
+			Expect(ok).To(BeTrue())
+			Expect(progress).To(Equal(restage.Progress{Id: "first", Total: 2}))
+
+			fakeClock.Increment(interval)
+			Eventually(func() int {
+				progress, _ := controller.Progress("first")
+				return progress.Submitted
+			}).Should(Equal(1))
+
+			fakeClock.Increment(interval)
+			Eventually(func() bool {
+				progress, _ := controller.Progress("first")
+				return progress.Done
+			}).Should(BeTrue())
+		})
+
+		Context("when staging an app fails", func() {
+			BeforeEach(func() {
+				fakeStager.StageRequestReturns(errors.New("boom"))
+			})
+
+			It("records the failure and carries on", func() {
+				fakeClock.Increment(interval)
+				Eventually(fakeStager.StageRequestCallCount).Should(Equal(1))
+				fakeClock.Increment(interval)
+				Eventually(fakeStager.StageRequestCallCount).Should(Equal(2))
+
+				Eventually(func() restage.Progress {
+					progress, _ := controller.Progress("first")
+					return progress
+				}).Should(Equal(restage.Progress{
+					Id:        "first",
+					Total:     2,
+					Submitted: 2,
+					Failed:    2,
+					Done:      true,
+					Failures:  map[string]string{"app-1": "boom", "app-2": "boom"},
+				}))
+			})
+		})
+	})
+
+	Describe("Snapshot and Restore", func() {
+		It("hands the remaining work over to another controller", func() {
+			Expect(controller.Submit(newCampaign("campaign", "app-1", "app-2"))).To(Succeed())
+
+			fakeClock.Increment(interval)
+			Eventually(func() int {
+				progress, _ := controller.Progress("campaign")
+				return progress.Submitted
+			}).Should(Equal(1))
+
+			snapshots := controller.Snapshot()
+			Expect(snapshots).To(HaveLen(1))
+			Expect(snapshots[0].Next).To(Equal(1))
+
+			replacementStager := &fakes.FakeStager{}
+			replacementClock := fakeclock.NewFakeClock(time.Now())
+			lifecycles := map[string]string{"buildpack/cflinuxfs2": "lifecycle.tgz"}
+			replacement := restage.NewController(lagertest.NewTestLogger("test"), replacementStager, lifecycles, nil, interval, replacementClock)
+			Expect(replacement.Restore(snapshots)).To(Succeed())
+
+			replacementProcess := ifrit.Invoke(replacement)
+			defer func() {
+				replacementProcess.Signal(os.Interrupt)
+				Eventually(replacementProcess.Wait()).Should(Receive())
+			}()
+
+			replacementClock.Increment(interval)
+			Eventually(replacementStager.StageRequestCallCount).Should(Equal(1))
+			_, stagingGuid, _ := replacementStager.StageRequestArgsForCall(0)
+			Expect(stagingGuid).To(Equal("app-2"))
+
+			Eventually(func() restage.Progress {
+				progress, _ := replacement.Progress("campaign")
+				return progress
+			}).Should(Equal(restage.Progress{Id: "campaign", Total: 2, Submitted: 2, Done: true}))
+		})
+
+		It("stops staging campaigns once they are handed over", func() {
+			Expect(controller.Submit(newCampaign("campaign", "app-1", "app-2"))).To(Succeed())
+
+			snapshots := controller.HandOver()
+			Expect(snapshots).To(HaveLen(1))
+			Expect(snapshots[0].Next).To(Equal(0))
+			Expect(snapshots[0].Progress.HandedOver).To(BeFalse())
+
+			fakeClock.Increment(interval)
+			Consistently(fakeStager.StageRequestCallCount).Should(Equal(0))
+
+			progress, ok := controller.Progress("campaign")
+			Expect(ok).To(BeTrue())
+			Expect(progress.HandedOver).To(BeTrue())
+			Expect(progress.Done).To(BeFalse())
+		})
+
+		It("refuses to restore campaigns that already exist", func() {
+			Expect(controller.Submit(newCampaign("campaign", "app-1"))).To(Succeed())
+			Expect(controller.Restore(controller.Snapshot())).To(Equal(restage.ErrCampaignExists))
+		})
+
+		It("refuses to restore snapshots whose next app is out of range", func() {
+			campaign := newCampaign("campaign", "app-1")
+			Expect(controller.Restore([]restage.CampaignSnapshot{{Campaign: campaign, Next: 2}})).To(Equal(restage.ErrInvalidSnapshot))
+			Expect(controller.Restore([]restage.CampaignSnapshot{{Campaign: campaign, Next: -1}})).To(Equal(restage.ErrInvalidSnapshot))
+
+			_, ok := controller.Progress("campaign")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("restores a snapshot with no apps left as done", func() {
+			campaign := newCampaign("campaign", "app-1")
+			Expect(controller.Restore([]restage.CampaignSnapshot{{Campaign: campaign, Next: 1, Progress: restage.Progress{Id: "campaign", Total: 1, Submitted: 1}}})).To(Succeed())
+
+			fakeClock.Increment(interval)
+			Consistently(fakeStager.StageRequestCallCount).Should(Equal(0))
+
+			progress, _ := controller.Progress("campaign")
+			Expect(progress.Done).To(BeTrue())
+		})
+	})
+
+	Describe("Progress", func() {
+		It("returns false for unknown campaigns", func() {
+			_, ok := controller.Progress("unknown")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("ValidateInterval", func() {
+	It("accepts positive intervals", func() {
+		Expect(restage.ValidateInterval(time.Second)).To(Succeed())
+	})
+
+	It("rejects intervals the controller can't tick at", func() {
+		Expect(restage.ValidateInterval(0)).To(Equal(restage.ErrInvalidInterval))
+		Expect(restage.ValidateInterval(-time.Second)).To(Equal(restage.ErrInvalidInterval))
+	})
+})
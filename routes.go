@@ -6,10 +6,38 @@ const (
 	StageRoute            = "Stage"
 	StopStagingRoute      = "StopStaging"
 	StagingCompletedRoute = "StagingCompleted"
+
+	ResendStagingResponseRoute = "ResendStagingResponse"
+
+	SubmitRestageCampaignRoute = "SubmitRestageCampaign"
+	RestageCampaignRoute       = "RestageCampaign"
+	LifecycleChangesRoute      = "LifecycleChanges"
+
+	ExportStateRoute   = "ExportState"
+	HandOverStateRoute = "HandOverState"
+
+	InfoRoute = "Info"
+
+	HealthzRoute = "Healthz"
+	ReadyzRoute  = "Readyz"
 )
 
 var Routes = rata.Routes{
 	{Path: "/v1/staging/:staging_guid", Method: "PUT", Name: StageRoute},
 	{Path: "/v1/staging/:staging_guid", Method: "DELETE", Name: StopStagingRoute},
 	{Path: "/v1/staging/:staging_guid/completed", Method: "POST", Name: StagingCompletedRoute},
+
+	{Path: "/v1/staging/:staging_guid/resend", Method: "POST", Name: ResendStagingResponseRoute},
+
+	{Path: "/v1/restage_campaigns/:campaign_id", Method: "PUT", Name: SubmitRestageCampaignRoute},
+	{Path: "/v1/restage_campaigns/:campaign_id", Method: "GET", Name: RestageCampaignRoute},
+	{Path: "/v1/lifecycle_changes", Method: "GET", Name: LifecycleChangesRoute},
+
+	{Path: "/v1/state/export", Method: "GET", Name: ExportStateRoute},
+	{Path: "/v1/state/handover", Method: "POST", Name: HandOverStateRoute},
+
+	{Path: "/v1/info", Method: "GET", Name: InfoRoute},
+
+	{Path: "/healthz", Method: "GET", Name: HealthzRoute},
+	{Path: "/readyz", Method: "GET", Name: ReadyzRoute},
 }
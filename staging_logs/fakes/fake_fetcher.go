@@ -0,0 +1,55 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/staging_logs"
+)
+
+type FakeFetcher struct {
+	TailStub        func(stagingGuid string) (string, error)
+	tailMutex       sync.RWMutex
+	tailArgsForCall []struct {
+		stagingGuid string
+	}
+	tailReturns struct {
+		result1 string
+		result2 error
+	}
+}
+
+func (fake *FakeFetcher) Tail(stagingGuid string) (string, error) {
+	fake.tailMutex.Lock()
+	fake.tailArgsForCall = append(fake.tailArgsForCall, struct {
+		stagingGuid string
+	}{stagingGuid})
+	fake.tailMutex.Unlock()
+	if fake.TailStub != nil {
+		return fake.TailStub(stagingGuid)
+	} else {
+		return fake.tailReturns.result1, fake.tailReturns.result2
+	}
+}
+
+func (fake *FakeFetcher) TailCallCount() int {
+	fake.tailMutex.RLock()
+	defer fake.tailMutex.RUnlock()
+	return len(fake.tailArgsForCall)
+}
+
+func (fake *FakeFetcher) TailArgsForCall(i int) string {
+	fake.tailMutex.RLock()
+	defer fake.tailMutex.RUnlock()
+	return fake.tailArgsForCall[i].stagingGuid
+}
+
+func (fake *FakeFetcher) TailReturns(result1 string, result2 error) {
+	fake.TailStub = nil
+	fake.tailReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+var _ staging_logs.Fetcher = new(FakeFetcher)
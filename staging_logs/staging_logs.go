@@ -0,0 +1,87 @@
+package staging_logs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const fetchTimeout = 2 * time.Second
+
+//go:generate counterfeiter -o fakes/fake_fetcher.go . Fetcher
+
+// Fetcher retrieves the tail of a staging task's log so that it can be shown
+// to developers alongside a staging failure.
+type Fetcher interface {
+	Tail(stagingGuid string) (string, error)
+}
+
+type BadResponseError struct {
+	StatusCode int
+}
+
+func (b *BadResponseError) Error() string {
+	return fmt.Sprintf("Staging log request failed with %d", b.StatusCode)
+}
+
+type fetcher struct {
+	urlTemplate string
+	maxBytes    int
+	httpClient  *http.Client
+}
+
+// NewFetcher returns a Fetcher that GETs the log from urlTemplate, with its
+// %s placeholder replaced by the staging guid, keeping at most maxBytes of
+// whole lines from its end.
+func NewFetcher(urlTemplate string, maxBytes int) Fetcher {
+	return &fetcher{
+		urlTemplate: urlTemplate,
+		maxBytes:    maxBytes,
+		httpClient:  &http.Client{Timeout: fetchTimeout},
+	}
+}
+
+func (f *fetcher) Tail(stagingGuid string) (string, error) {
+	response, err := f.httpClient.Get(fmt.Sprintf(f.urlTemplate, stagingGuid))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", &BadResponseError{response.StatusCode}
+	}
+
+	log, err := ioutil.ReadAll(response.Body)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return string(tail(log, f.maxBytes)), nil
+}
+
+func tail(log []byte, maxBytes int) []byte {
+	log = bytes.TrimRight(log, "\n")
+	if len(log) <= maxBytes {
+		return log
+	}
+
+	log = log[len(log)-maxBytes:]
+	if newline := bytes.IndexByte(log, '\n'); newline >= 0 {
+		log = log[newline+1:]
+	}
+	return log
+}
+
+type noopFetcher struct{}
+
+func NewNoopFetcher() Fetcher {
+	return noopFetcher{}
+}
+
+func (noopFetcher) Tail(string) (string, error) {
+	return "", nil
+}
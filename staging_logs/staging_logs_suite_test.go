@@ -0,0 +1,13 @@
+package staging_logs_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagingLogs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Staging Logs Suite")
+}
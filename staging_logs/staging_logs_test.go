@@ -0,0 +1,59 @@
+package staging_logs_test
+
+import (
+	"github.com/cloudfoundry-incubator/stager/staging_logs"
+	"github.com/onsi/gomega/ghttp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fetcher", func() {
+	var (
+		logServer *ghttp.Server
+		fetcher   staging_logs.Fetcher
+	)
+
+	BeforeEach(func() {
+		logServer = ghttp.NewServer()
+		fetcher = staging_logs.NewFetcher(logServer.URL()+"/logs/%s", 20)
+	})
+
+	AfterEach(func() {
+		logServer.Close()
+	})
+
+	Context("when the log fits", func() {
+		BeforeEach(func() {
+			logServer.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/logs/the-guid"),
+				ghttp.RespondWith(200, "-----> Detecting\n"),
+			))
+		})
+
+		It("returns the whole log", func() {
+			Expect(fetcher.Tail("the-guid")).To(Equal("-----> Detecting"))
+		})
+	})
+
+	Context("when the log is longer than the limit", func() {
+		BeforeEach(func() {
+			logServer.AppendHandlers(ghttp.RespondWith(200, "first line\nsecond line\nno buildpack\n"))
+		})
+
+		It("returns the whole lines that fit from the end", func() {
+			Expect(fetcher.Tail("the-guid")).To(Equal("no buildpack"))
+		})
+	})
+
+	Context("when the log endpoint fails", func() {
+		BeforeEach(func() {
+			logServer.AppendHandlers(ghttp.RespondWith(404, ""))
+		})
+
+		It("returns an error", func() {
+			_, err := fetcher.Tail("the-guid")
+			Expect(err).To(Equal(&staging_logs.BadResponseError{StatusCode: 404}))
+		})
+	})
+})
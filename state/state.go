@@ -0,0 +1,84 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/restage"
+)
+
+const Version = 1
+
+var ErrUnsupportedVersion = errors.New("unsupported stager state version")
+
+// State is the in-flight work a stager hands over to its replacement during
+// an upgrade.
+type State struct {
+	Version          int                        `json:"version"`
+	RestageCampaigns []restage.CampaignSnapshot `json:"restage_campaigns"`
+	// StagingHistory holds the dedupe markers of the staging responses
+	// already delivered to the CC. Responses still pending delivery aren't
+	// included: the history records only their guids, not the responses.
+	StagingHistory []history.Entry `json:"staging_history"`
+}
+
+// Export returns the stager's state without changing what it is doing.
+func Export(controller restage.Controller, stagingHistory history.History) State {
+	return State{
+		Version:          Version,
+		RestageCampaigns: controller.Snapshot(),
+		StagingHistory:   delivered(stagingHistory.Entries()),
+	}
+}
+
+// HandOver hands the stager's work over: its unfinished restage campaigns
+// are no longer staged once handed over.
+func HandOver(controller restage.Controller, stagingHistory history.History) State {
+	return State{
+		Version:          Version,
+		RestageCampaigns: controller.HandOver(),
+		StagingHistory:   delivered(stagingHistory.Entries()),
+	}
+}
+
+func Import(controller restage.Controller, stagingHistory history.History, state State) error {
+	if state.Version != Version {
+		return ErrUnsupportedVersion
+	}
+
+	err := controller.Restore(state.RestageCampaigns)
+	if err != nil {
+		return err
+	}
+
+	stagingHistory.Restore(delivered(state.StagingHistory))
+	return nil
+}
+
+func delivered(entries []history.Entry) []history.Entry {
+	deliveredEntries := []history.Entry{}
+	for _, entry := range entries {
+		if entry.State == history.Delivered {
+			deliveredEntries = append(deliveredEntries, entry)
+		}
+	}
+	return deliveredEntries
+}
+
+func Load(path string) (State, error) {
+	var state State
+
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		return State{}, err
+	}
+
+	err = json.Unmarshal(payload, &state)
+	if err != nil {
+		return State{}, err
+	}
+
+	return state, nil
+}
@@ -0,0 +1,111 @@
+package state_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/history"
+	"github.com/cloudfoundry-incubator/stager/restage"
+	"github.com/cloudfoundry-incubator/stager/restage/fakes"
+	"github.com/cloudfoundry-incubator/stager/state"
+	"github.com/pivotal-golang/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State", func() {
+	var (
+		fakeController *fakes.FakeController
+		stagingHistory history.History
+		snapshots      []restage.CampaignSnapshot
+		entries        []history.Entry
+	)
+
+	BeforeEach(func() {
+		fakeController = &fakes.FakeController{}
+		stagingHistory = history.New(history.DefaultSize, fakeclock.NewFakeClock(time.Unix(0, 100)))
+		snapshots = []restage.CampaignSnapshot{
+			{Campaign: restage.Campaign{Id: "campaign"}, Next: 1, Progress: restage.Progress{Id: "campaign", Total: 2, Submitted: 1}},
+		}
+		entries = []history.Entry{
+			{StagingGuid: "delivered", State: history.Delivered, UpdatedAt: 100},
+			{StagingGuid: "pending", State: history.Pending, UpdatedAt: 100},
+		}
+	})
+
+	Describe("Export", func() {
+		It("snapshots the restage campaigns and includes the delivered staging history", func() {
+			fakeController.SnapshotReturns(snapshots)
+			stagingHistory.Delivered("delivered")
+			stagingHistory.Pending("pending")
+
+			Expect(state.Export(fakeController, stagingHistory)).To(Equal(state.State{
+				Version:          state.Version,
+				RestageCampaigns: snapshots,
+				StagingHistory:   entries[:1],
+			}))
+			Expect(fakeController.SnapshotCallCount()).To(Equal(1))
+			Expect(fakeController.HandOverCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("HandOver", func() {
+		It("hands over the restage campaigns and includes the delivered staging history", func() {
+			fakeController.HandOverReturns(snapshots)
+			stagingHistory.Delivered("delivered")
+			stagingHistory.Pending("pending")
+
+			Expect(state.HandOver(fakeController, stagingHistory)).To(Equal(state.State{
+				Version:          state.Version,
+				RestageCampaigns: snapshots,
+				StagingHistory:   entries[:1],
+			}))
+			Expect(fakeController.HandOverCallCount()).To(Equal(1))
+		})
+	})
+
+	Describe("Import", func() {
+		It("restores the restage campaigns and the delivered staging history", func() {
+			err := state.Import(fakeController, stagingHistory, state.State{Version: state.Version, RestageCampaigns: snapshots, StagingHistory: entries})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeController.RestoreCallCount()).To(Equal(1))
+			Expect(fakeController.RestoreArgsForCall(0)).To(Equal(snapshots))
+			Expect(stagingHistory.Entries()).To(Equal(entries[:1]))
+			Expect(stagingHistory.WasDelivered("delivered")).To(BeTrue())
+		})
+
+		It("rejects other versions", func() {
+			err := state.Import(fakeController, stagingHistory, state.State{Version: state.Version + 1})
+			Expect(err).To(Equal(state.ErrUnsupportedVersion))
+			Expect(fakeController.RestoreCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("Load", func() {
+		var path string
+
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "state")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.WriteString(`{"version": 1, "restage_campaigns": [{"campaign": {"id": "campaign", "apps": []}, "next": 0, "progress": {"id": "campaign", "total": 0}}]}`)
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+			path = file.Name()
+		})
+
+		AfterEach(func() {
+			os.Remove(path)
+		})
+
+		It("reads an exported state", func() {
+			loaded, err := state.Load(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loaded.Version).To(Equal(1))
+			Expect(loaded.RestageCampaigns).To(HaveLen(1))
+			Expect(loaded.RestageCampaigns[0].Campaign.Id).To(Equal("campaign"))
+		})
+	})
+})
@@ -0,0 +1,104 @@
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stager/backend"
+)
+
+const Redacted = "[REDACTED]"
+
+var sensitiveFlagWords = []string{"password", "secret", "token", "key"}
+
+// File is a single entry in a support bundle.
+type File struct {
+	Name     string
+	Contents []byte
+}
+
+// RedactedConfig returns the effective value of every flag in flags, with
+// the values of credential-like flags replaced by Redacted, and the
+// passwords of URLs, e.g. of -httpProxy, redacted.
+func RedactedConfig(flags *flag.FlagSet) map[string]string {
+	config := map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value != "" && sensitive(f.Name) {
+			value = Redacted
+		}
+		config[f.Name] = redactURLs(value)
+	})
+	return config
+}
+
+// redactURLs redacts the URLs in a flag's value, which for repeatable flags
+// is a comma-separated list, e.g. of name=url pairs.
+func redactURLs(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		redacted := backend.RedactURL(part)
+		if redacted == part {
+			if pair := strings.SplitN(part, "=", 2); len(pair) == 2 {
+				redacted = pair[0] + "=" + backend.RedactURL(pair[1])
+			}
+		}
+		parts[i] = redacted
+	}
+	return strings.Join(parts, ",")
+}
+
+func sensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveFlagWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteBundle writes files to w as a gzipped tarball, in name order.
+func WriteBundle(w io.Writer, files []File, modTime time.Time) error {
+	sorted := make([]File, len(files))
+	copy(sorted, files)
+	sort.Sort(byName(sorted))
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, file := range sorted {
+		err := tarWriter.WriteHeader(&tar.Header{
+			Name:    file.Name,
+			Mode:    0644,
+			Size:    int64(len(file.Contents)),
+			ModTime: modTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tarWriter.Write(file.Contents)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+type byName []File
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Less(i, j int) bool { return f[i].Name < f[j].Name }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
@@ -0,0 +1,13 @@
+package support_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSupport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Support Suite")
+}
@@ -0,0 +1,72 @@
+package support
+
+import (
+	"encoding/json"
+
+	"github.com/cloudfoundry-incubator/bbs/models"
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+)
+
+// TaskSummary is what a support bundle records of a staging task. The task's
+// definition isn't recorded, as its actions and environment can carry
+// credentials.
+type TaskSummary struct {
+	TaskGuid      string `json:"task_guid"`
+	Domain        string `json:"domain"`
+	State         string `json:"state"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	Lifecycle     string `json:"lifecycle,omitempty"`
+}
+
+// Stats counts staging tasks by domain, state and lifecycle.
+type Stats struct {
+	Tasks       int                       `json:"tasks"`
+	ByDomain    map[string]map[string]int `json:"by_domain"`
+	ByLifecycle map[string]int            `json:"by_lifecycle"`
+	Failed      int                       `json:"failed"`
+}
+
+func SummarizeTasks(tasks []*models.Task) []TaskSummary {
+	summaries := make([]TaskSummary, 0, len(tasks))
+	for _, task := range tasks {
+		summary := TaskSummary{
+			TaskGuid:      task.TaskGuid,
+			Domain:        task.Domain,
+			State:         task.State.String(),
+			FailureReason: task.FailureReason,
+		}
+
+		if task.TaskDefinition != nil {
+			var annotation cc_messages.StagingTaskAnnotation
+			if json.Unmarshal([]byte(task.Annotation), &annotation) == nil {
+				summary.Lifecycle = annotation.Lifecycle
+			}
+		}
+
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+func TaskStats(summaries []TaskSummary) Stats {
+	stats := Stats{
+		Tasks:       len(summaries),
+		ByDomain:    map[string]map[string]int{},
+		ByLifecycle: map[string]int{},
+	}
+
+	for _, summary := range summaries {
+		if stats.ByDomain[summary.Domain] == nil {
+			stats.ByDomain[summary.Domain] = map[string]int{}
+		}
+		stats.ByDomain[summary.Domain][summary.State]++
+
+		if summary.Lifecycle != "" {
+			stats.ByLifecycle[summary.Lifecycle]++
+		}
+		if summary.FailureReason != "" {
+			stats.Failed++
+		}
+	}
+	return stats
+}
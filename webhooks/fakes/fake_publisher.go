@@ -0,0 +1,44 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-incubator/stager/webhooks"
+	"github.com/pivotal-golang/lager"
+)
+
+type FakePublisher struct {
+	PublishStub        func(logger lager.Logger, event webhooks.Event)
+	publishMutex       sync.RWMutex
+	publishArgsForCall []struct {
+		logger lager.Logger
+		event  webhooks.Event
+	}
+}
+
+func (fake *FakePublisher) Publish(logger lager.Logger, event webhooks.Event) {
+	fake.publishMutex.Lock()
+	fake.publishArgsForCall = append(fake.publishArgsForCall, struct {
+		logger lager.Logger
+		event  webhooks.Event
+	}{logger, event})
+	fake.publishMutex.Unlock()
+	if fake.PublishStub != nil {
+		fake.PublishStub(logger, event)
+	}
+}
+
+func (fake *FakePublisher) PublishCallCount() int {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return len(fake.publishArgsForCall)
+}
+
+func (fake *FakePublisher) PublishArgsForCall(i int) (lager.Logger, webhooks.Event) {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return fake.publishArgsForCall[i].logger, fake.publishArgsForCall[i].event
+}
+
+var _ webhooks.Publisher = new(FakePublisher)
@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/pivotal-golang/clock"
+	"github.com/pivotal-golang/lager"
+)
+
+const (
+	StagingStarted   = "staging.started"
+	StagingSucceeded = "staging.succeeded"
+	StagingFailed    = "staging.failed"
+
+	webhookRequestTimeout = 5 * time.Second
+)
+
+type Event struct {
+	Type        string                    `json:"type"`
+	StagingGuid string                    `json:"staging_guid"`
+	AppId       string                    `json:"app_id,omitempty"`
+	Lifecycle   string                    `json:"lifecycle,omitempty"`
+	Error       *cc_messages.StagingError `json:"error,omitempty"`
+	Timestamp   int64                     `json:"timestamp"`
+}
+
+//go:generate counterfeiter -o fakes/fake_publisher.go . Publisher
+type Publisher interface {
+	Publish(logger lager.Logger, event Event)
+}
+
+type publisher struct {
+	urls       []string
+	clock      clock.Clock
+	httpClient *http.Client
+}
+
+// NewPublisher returns a Publisher that POSTs every event to each of the
+// given URLs. Delivery is best effort: it happens in the background and
+// failures are only logged, so webhooks can never hold up staging.
+func NewPublisher(urls []string, clock clock.Clock) Publisher {
+	return &publisher{
+		urls:       urls,
+		clock:      clock,
+		httpClient: &http.Client{Timeout: webhookRequestTimeout},
+	}
+}
+
+func (p *publisher) Publish(logger lager.Logger, event Event) {
+	if len(p.urls) == 0 {
+		return
+	}
+
+	logger = logger.Session("webhooks", lager.Data{"type": event.Type, "staging-guid": event.StagingGuid})
+
+	event.Timestamp = p.clock.Now().UnixNano()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("marshal-event-failed", err)
+		return
+	}
+
+	for _, url := range p.urls {
+		go p.post(logger, url, payload)
+	}
+}
+
+func (p *publisher) post(logger lager.Logger, url string, payload []byte) {
+	response, err := p.httpClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		logger.Error("post-failed", err, lager.Data{"url": url})
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		logger.Info("post-rejected", lager.Data{"url": url, "status": response.StatusCode})
+	}
+}
@@ -0,0 +1,87 @@
+package webhooks_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-incubator/runtime-schema/cc_messages"
+	"github.com/cloudfoundry-incubator/stager/webhooks"
+	"github.com/pivotal-golang/clock/fakeclock"
+	"github.com/pivotal-golang/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Publisher", func() {
+	var (
+		firstHook  *ghttp.Server
+		secondHook *ghttp.Server
+		fakeClock  *fakeclock.FakeClock
+		logger     *lagertest.TestLogger
+		publisher  webhooks.Publisher
+	)
+
+	BeforeEach(func() {
+		firstHook = ghttp.NewServer()
+		secondHook = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Unix(0, 123))
+		logger = lagertest.NewTestLogger("test")
+
+		publisher = webhooks.NewPublisher([]string{firstHook.URL() + "/hook", secondHook.URL() + "/hook"}, fakeClock)
+	})
+
+	AfterEach(func() {
+		firstHook.Close()
+		secondHook.Close()
+	})
+
+	It("posts the event to every webhook", func() {
+		expectedJSON := `{
+			"type": "staging.failed",
+			"staging_guid": "the-guid",
+			"lifecycle": "buildpack",
+			"error": {"id": "StagingError", "message": "staging failed"},
+			"timestamp": 123
+		}`
+
+		for _, server := range []*ghttp.Server{firstHook, secondHook} {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/hook"),
+				ghttp.VerifyContentType("application/json"),
+				ghttp.VerifyJSON(expectedJSON),
+			))
+		}
+
+		publisher.Publish(logger, webhooks.Event{
+			Type:        webhooks.StagingFailed,
+			StagingGuid: "the-guid",
+			Lifecycle:   "buildpack",
+			Error:       &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+		})
+
+		Eventually(firstHook.ReceivedRequests).Should(HaveLen(1))
+		Eventually(secondHook.ReceivedRequests).Should(HaveLen(1))
+	})
+
+	It("logs webhooks that reject the event", func() {
+		firstHook.AppendHandlers(ghttp.RespondWith(500, ""))
+		secondHook.AppendHandlers(ghttp.RespondWith(200, ""))
+
+		publisher.Publish(logger, webhooks.Event{Type: webhooks.StagingStarted, StagingGuid: "the-guid"})
+
+		Eventually(logger).Should(gbytes.Say("post-rejected"))
+	})
+
+	Context("when no webhooks are configured", func() {
+		BeforeEach(func() {
+			publisher = webhooks.NewPublisher(nil, fakeClock)
+		})
+
+		It("does nothing", func() {
+			publisher.Publish(logger, webhooks.Event{Type: webhooks.StagingStarted, StagingGuid: "the-guid"})
+			Consistently(firstHook.ReceivedRequests).Should(BeEmpty())
+		})
+	})
+})